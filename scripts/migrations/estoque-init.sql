@@ -20,13 +20,18 @@ CREATE INDEX IF NOT EXISTS idx_produtos_ativo ON produtos(ativo);
 CREATE TABLE IF NOT EXISTS reservas_estoque (
     id UUID PRIMARY KEY,
     nota_id UUID NOT NULL,
+    solicitacao_id UUID,
     produto_id UUID NOT NULL REFERENCES produtos(id) ON DELETE RESTRICT,
     quantidade INT NOT NULL CHECK (quantidade > 0),
     status VARCHAR(20) NOT NULL CHECK (status IN ('RESERVADO', 'CANCELADO')),
     data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+-- bancos criados antes da coluna: a liberacao da reserva e por solicitacao
+ALTER TABLE reservas_estoque ADD COLUMN IF NOT EXISTS solicitacao_id UUID;
+
 CREATE INDEX IF NOT EXISTS idx_reservas_nota_id ON reservas_estoque(nota_id);
+CREATE INDEX IF NOT EXISTS idx_reservas_solicitacao_id ON reservas_estoque(solicitacao_id);
 CREATE INDEX IF NOT EXISTS idx_reservas_produto_id ON reservas_estoque(produto_id);
 CREATE INDEX IF NOT EXISTS idx_reservas_status ON reservas_estoque(status);
 
@@ -38,9 +43,16 @@ CREATE TABLE IF NOT EXISTS eventos_outbox (
     payload JSONB NOT NULL,
     data_ocorrencia TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     data_publicacao TIMESTAMPTZ,
-    tentativas_envio INT NOT NULL DEFAULT 0
+    tentativas_envio INT NOT NULL DEFAULT 0,
+    id_correlacao VARCHAR(100),
+    id_causacao VARCHAR(100)
 );
 
+-- bancos criados antes das colunas: as respostas ao Faturamento levam a
+-- correlacao e a causacao do evento que as pediu
+ALTER TABLE eventos_outbox ADD COLUMN IF NOT EXISTS id_correlacao VARCHAR(100);
+ALTER TABLE eventos_outbox ADD COLUMN IF NOT EXISTS id_causacao VARCHAR(100);
+
 CREATE INDEX IF NOT EXISTS idx_outbox_pendentes 
     ON eventos_outbox (data_publicacao) 
     WHERE data_publicacao IS NULL;
@@ -1,3 +1,4 @@
+using System.Text.Json;
 using Microsoft.AspNetCore.Mvc;
 using Microsoft.EntityFrameworkCore;
 using ServicoEstoque.Aplicacao.DTOs;
@@ -55,10 +56,70 @@ public class ProdutosController : ControllerBase
         var produto = new Produto(request.Sku, request.Nome, request.Saldo);
 
         _ctx.Produtos.Add(produto);
+        _ctx.EventosOutbox.Add(EventoProduto("Estoque.ProdutoCriado", produto));
         await _ctx.SaveChangesAsync();
 
         _logger.LogInformation("Produto criado: {Sku}", produto.Sku);
 
         return CreatedAtAction(nameof(Buscar), new { id = produto.Id }, produto);
     }
+
+    [HttpPost("{id:guid}/desativar")]
+    public Task<ActionResult> Desativar(Guid id) => AlterarAtivo(id, ativo: false);
+
+    [HttpPost("{id:guid}/ativar")]
+    public Task<ActionResult> Ativar(Guid id) => AlterarAtivo(id, ativo: true);
+
+    // republica o estado de todos os produtos para reconstruir projecoes
+    // (ex: catalogo do Faturamento) que subiram depois dos cadastros
+    [HttpPost("publicar-catalogo")]
+    public async Task<ActionResult> PublicarCatalogo()
+    {
+        var produtos = await _ctx.Produtos
+            .AsNoTracking()
+            .ToListAsync();
+
+        foreach (var produto in produtos)
+            _ctx.EventosOutbox.Add(EventoProduto("Estoque.ProdutoAtualizado", produto));
+
+        await _ctx.SaveChangesAsync();
+
+        _logger.LogInformation("Catalogo republicado: {Quantidade} produtos", produtos.Count);
+
+        return Accepted(new { produtos = produtos.Count });
+    }
+
+    private async Task<ActionResult> AlterarAtivo(Guid id, bool ativo)
+    {
+        var produto = await _ctx.Produtos
+            .FirstOrDefaultAsync(p => p.Id == id);
+
+        if (produto == null)
+            return NotFound(new { mensagem = "Produto não encontrado" });
+
+        if (ativo) produto.Ativar();
+        else produto.Desativar();
+
+        _ctx.EventosOutbox.Add(EventoProduto("Estoque.ProdutoAtualizado", produto));
+        await _ctx.SaveChangesAsync();
+
+        _logger.LogInformation("Produto {Sku} ativo={Ativo}", produto.Sku, produto.Ativo);
+
+        return Ok(produto);
+    }
+
+    // payload consumido pelo catalogo do Faturamento
+    private static EventoOutbox EventoProduto(string tipo, Produto produto) => new()
+    {
+        TipoEvento = tipo,
+        IdAgregado = produto.Id,
+        Payload = JsonSerializer.Serialize(new
+        {
+            produtoId = produto.Id,
+            sku = produto.Sku,
+            nome = produto.Nome,
+            ativo = produto.Ativo
+        }),
+        DataOcorrencia = DateTime.UtcNow
+    };
 }
\ No newline at end of file
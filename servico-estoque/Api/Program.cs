@@ -30,6 +30,7 @@ builder.Services.AddDbContext<ContextoBancoDados>(opts =>
 
 // handlers
 builder.Services.AddScoped<ReservarEstoqueHandler>();
+builder.Services.AddScoped<LiberarReservaHandler>();
 
 // background services: outbox publisher + rabbitmq consumer
 builder.Services.AddHostedService<PublicadorOutbox>();
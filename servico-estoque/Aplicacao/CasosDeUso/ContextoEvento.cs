@@ -0,0 +1,8 @@
+namespace ServicoEstoque.Aplicacao.CasosDeUso;
+
+// correlacao do evento recebido do Faturamento e o id dele, que vira a
+// causacao dos eventos publicados em resposta
+public record ContextoEvento(
+    string? IdCorrelacao,
+    string? IdCausacao
+);
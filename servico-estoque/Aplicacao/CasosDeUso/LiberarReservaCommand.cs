@@ -0,0 +1,9 @@
+namespace ServicoEstoque.Aplicacao.CasosDeUso;
+
+// compensacao pedida pelo Faturamento (Faturamento.LiberarReserva) quando a
+// reserva de uma tentativa de impressao nao vai fechar a nota
+public record LiberarReservaCommand(
+    Guid NotaId,
+    Guid SolicitacaoId,
+    string? Motivo
+);
@@ -0,0 +1,80 @@
+using Microsoft.EntityFrameworkCore;
+using Microsoft.Extensions.Logging;
+using ServicoEstoque.Dominio.Entidades;
+using ServicoEstoque.Infraestrutura.Persistencia;
+
+namespace ServicoEstoque.Aplicacao.CasosDeUso;
+
+public sealed class LiberarReservaHandler
+{
+    private readonly ContextoBancoDados _ctx;
+    private readonly ILogger<LiberarReservaHandler> _logger;
+
+    public LiberarReservaHandler(ContextoBancoDados ctx, ILogger<LiberarReservaHandler> logger)
+    {
+        _ctx = ctx;
+        _logger = logger;
+    }
+
+    // Devolve ao saldo o que a solicitacao reservou e cancela as reservas.
+    // Idempotente por solicitacao: o Faturamento pode pedir a mesma liberacao
+    // mais de uma vez (varredor de timeout e resposta atrasada), e a segunda
+    // nao encontra mais reservas RESERVADO. Os itens do evento nao sao usados:
+    // devolve-se o que foi de fato reservado.
+    public async Task<Resultado> Executar(LiberarReservaCommand cmd, CancellationToken ct = default)
+    {
+        await using var tx = await _ctx.Database.BeginTransactionAsync(ct);
+        try
+        {
+            // FOR UPDATE: duas liberacoes concorrentes da mesma solicitacao
+            // esperam uma pela outra e a segunda ja ve as reservas canceladas
+            var reservas = await _ctx.ReservasEstoque
+                .FromSqlInterpolated($@"SELECT * FROM reservas_estoque
+                    WHERE nota_id = {cmd.NotaId} AND solicitacao_id = {cmd.SolicitacaoId} AND status = 'RESERVADO'
+                    FOR UPDATE")
+                .AsTracking()
+                .ToListAsync(ct);
+
+            if (reservas.Count == 0)
+            {
+                await tx.RollbackAsync(ct);
+                _logger.LogInformation("[LiberarReserva] Nenhuma reserva ativa para NotaId={NotaId}, SolicitacaoId={SolicitacaoId}; nada a liberar",
+                    cmd.NotaId, cmd.SolicitacaoId);
+                return Resultado.Sucesso();
+            }
+
+            foreach (var reserva in reservas)
+            {
+                var produto = await _ctx.Produtos
+                    .AsTracking()
+                    .FirstOrDefaultAsync(p => p.Id == reserva.ProdutoId, ct);
+
+                if (produto is null)
+                {
+                    throw new InvalidOperationException($"Produto {reserva.ProdutoId} nao encontrado.");
+                }
+
+                var resultadoCredito = produto.CreditarEstoque(reserva.Quantidade);
+                if (resultadoCredito.Falhou)
+                {
+                    throw new InvalidOperationException(resultadoCredito.Mensagem);
+                }
+                reserva.Status = "CANCELADO";
+            }
+
+            await _ctx.SaveChangesAsync(ct);
+            await tx.CommitAsync(ct);
+
+            _logger.LogInformation("[LiberarReserva] {Qtd} reservas liberadas para NotaId={NotaId}, SolicitacaoId={SolicitacaoId}: {Motivo}",
+                reservas.Count, cmd.NotaId, cmd.SolicitacaoId, cmd.Motivo);
+            return Resultado.Sucesso();
+        }
+        catch (Exception ex)
+        {
+            await tx.RollbackAsync(ct);
+            _ctx.ChangeTracker.Clear();
+            _logger.LogError(ex, "[LiberarReserva] Erro ao liberar reservas da NotaId={NotaId}", cmd.NotaId);
+            return Resultado.Falha($"Erro ao liberar reserva: {ex.Message}");
+        }
+    }
+}
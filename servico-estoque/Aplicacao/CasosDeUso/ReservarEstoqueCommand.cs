@@ -6,9 +6,14 @@ public record ReservarEstoqueCommand(
     int Quantidade
 );
 
+// SolicitacaoId vem do Faturamento e volta nas respostas: cada tentativa de
+// impressao so aceita a reserva que ela mesma pediu. Contexto liga as
+// respostas ao evento que pediu a reserva.
 public record ReservarEstoqueLoteCommand(
     Guid NotaId,
-    IReadOnlyCollection<ReservarEstoqueItem> Itens
+    IReadOnlyCollection<ReservarEstoqueItem> Itens,
+    Guid? SolicitacaoId = null,
+    ContextoEvento? Contexto = null
 );
 
 public record ReservarEstoqueItem(
@@ -104,7 +104,7 @@ public sealed class ReservarEstoqueHandler
             await tx.RollbackAsync(ct);
             _ctx.ChangeTracker.Clear();
             _logger.LogWarning(ex, "[ReservarEstoque] Conflito de concorrencia ao reservar estoque");
-            await PublicarRejeicaoAsync(cmd.NotaId, "Conflito de concorrencia", ct);
+            await PublicarRejeicaoAsync(cmd.NotaId, null, null, "Conflito de concorrencia", ct);
             return Resultado<ReservaEstoque>.Falha("Produto modificado. Tente novamente.");
         }
         catch (Exception ex)
@@ -112,7 +112,7 @@ public sealed class ReservarEstoqueHandler
             await tx.RollbackAsync(ct);
             _ctx.ChangeTracker.Clear();
             _logger.LogError(ex, "[ReservarEstoque] Erro ao processar reserva para NotaId={NotaId}", cmd.NotaId);
-            await PublicarRejeicaoAsync(cmd.NotaId, ex.Message, ct);
+            await PublicarRejeicaoAsync(cmd.NotaId, null, null, ex.Message, ct);
             return Resultado<ReservaEstoque>.Falha($"Erro ao processar reserva: {ex.Message}");
         }
     }
@@ -145,7 +145,7 @@ public sealed class ReservarEstoqueHandler
                 if (resultadoDebito.Falhou)
                 {
                     await tx.RollbackAsync(ct);
-                    await PublicarRejeicaoAsync(cmd.NotaId, resultadoDebito.Mensagem!, ct);
+                    await PublicarRejeicaoAsync(cmd.NotaId, cmd.SolicitacaoId, cmd.Contexto, resultadoDebito.Mensagem!, ct);
                     return Resultado.Falha(resultadoDebito.Mensagem!);
                 }
 
@@ -153,6 +153,7 @@ public sealed class ReservarEstoqueHandler
                 {
                     Id = Guid.NewGuid(),
                     NotaId = cmd.NotaId,
+                    SolicitacaoId = cmd.SolicitacaoId,
                     ProdutoId = item.ProdutoId,
                     Quantidade = item.Quantidade,
                     Status = "RESERVADO",
@@ -176,9 +177,12 @@ public sealed class ReservarEstoqueHandler
                 Payload = JsonSerializer.Serialize(new
                 {
                     notaId = cmd.NotaId,
+                    solicitacaoId = cmd.SolicitacaoId,
                     itens = cmd.Itens.Select(i => new { produtoId = i.ProdutoId, quantidade = i.Quantidade })
                 }),
-                DataOcorrencia = DateTime.UtcNow
+                DataOcorrencia = DateTime.UtcNow,
+                IdCorrelacao = cmd.Contexto?.IdCorrelacao,
+                IdCausacao = cmd.Contexto?.IdCausacao
             };
 
             _ctx.EventosOutbox.Add(eventoSucesso);
@@ -193,7 +197,7 @@ public sealed class ReservarEstoqueHandler
             await tx.RollbackAsync(ct);
             _ctx.ChangeTracker.Clear();
             _logger.LogWarning(ex, "[ReservarEstoque] Conflito de concorrencia ao reservar lote");
-            await PublicarRejeicaoAsync(cmd.NotaId, "Conflito de concorrencia", ct);
+            await PublicarRejeicaoAsync(cmd.NotaId, cmd.SolicitacaoId, cmd.Contexto, "Conflito de concorrencia", ct);
             return Resultado.Falha("Produto modificado. Tente novamente.");
         }
         catch (Exception ex)
@@ -201,12 +205,12 @@ public sealed class ReservarEstoqueHandler
             await tx.RollbackAsync(ct);
             _ctx.ChangeTracker.Clear();
             _logger.LogError(ex, "[ReservarEstoque] Erro ao processar lote para NotaId={NotaId}", cmd.NotaId);
-            await PublicarRejeicaoAsync(cmd.NotaId, ex.Message, ct);
+            await PublicarRejeicaoAsync(cmd.NotaId, cmd.SolicitacaoId, cmd.Contexto, ex.Message, ct);
             return Resultado.Falha($"Erro ao processar reserva: {ex.Message}");
         }
     }
 
-    private async Task PublicarRejeicaoAsync(Guid notaId, string motivo, CancellationToken ct)
+    private async Task PublicarRejeicaoAsync(Guid notaId, Guid? solicitacaoId, ContextoEvento? contexto, string motivo, CancellationToken ct)
     {
         await using var tx = await _ctx.Database.BeginTransactionAsync(ct);
         try
@@ -218,9 +222,12 @@ public sealed class ReservarEstoqueHandler
                 Payload = JsonSerializer.Serialize(new
                 {
                     notaId,
+                    solicitacaoId,
                     motivo
                 }),
-                DataOcorrencia = DateTime.UtcNow
+                DataOcorrencia = DateTime.UtcNow,
+                IdCorrelacao = contexto?.IdCorrelacao,
+                IdCausacao = contexto?.IdCausacao
             };
 
             _ctx.EventosOutbox.Add(evt);
@@ -9,4 +9,7 @@ public class EventoOutbox
     public DateTime DataOcorrencia { get; set; }
     public DateTime? DataPublicacao { get; set; }
     public int TentativasEnvio { get; set; }
+    // correlacao e causacao do evento que originou este (headers cloudEvents:)
+    public string? IdCorrelacao { get; set; }
+    public string? IdCausacao { get; set; }
 }
\ No newline at end of file
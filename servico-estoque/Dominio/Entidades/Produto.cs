@@ -41,6 +41,16 @@ public sealed class Produto
         return Resultado.Sucesso();
     }
 
+    // devolve ao saldo o que uma reserva cancelada tinha debitado
+    public Resultado CreditarEstoque(int qtd)
+    {
+        if (qtd <= 0)
+            return Resultado.Falha("Quantidade deve ser positiva");
+
+        Saldo += qtd;
+        return Resultado.Sucesso();
+    }
+
     public void AtualizarSaldo(int novoSaldo)
     {
         if (novoSaldo < 0) throw new InvalidOperationException("Saldo negativo");
@@ -4,6 +4,7 @@ public class ReservaEstoque
 {
     public Guid Id { get; set; }
     public Guid NotaId { get; set; }
+    public Guid? SolicitacaoId { get; set; } // tentativa de impressao do Faturamento que pediu a reserva
     public Guid ProdutoId { get; set; }
     public int Quantidade { get; set; }
     public string Status { get; set; } = null!; // RESERVADO, CANCELADO
@@ -0,0 +1,29 @@
+using System.Text;
+using RabbitMQ.Client;
+
+namespace ServicoEstoque.Infraestrutura.Mensageria;
+
+/// <summary>
+/// Headers do binding AMQP do CloudEvents usados pelo Faturamento para ligar
+/// os eventos de uma mesma saga
+/// </summary>
+internal static class CabecalhosCloudEvents
+{
+    public const string Id = "cloudEvents:id";
+    public const string Correlacao = "cloudEvents:correlationid";
+    public const string Causacao = "cloudEvents:causationid";
+
+    // o cliente entrega headers de texto como byte[]
+    public static string? Ler(IBasicProperties props, string nome)
+    {
+        if (props.Headers is null || !props.Headers.TryGetValue(nome, out var valor))
+            return null;
+
+        return valor switch
+        {
+            byte[] bytes => Encoding.UTF8.GetString(bytes),
+            string texto => texto,
+            _ => null
+        };
+    }
+}
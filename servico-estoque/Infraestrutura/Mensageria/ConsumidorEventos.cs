@@ -12,6 +12,7 @@ namespace ServicoEstoque.Infraestrutura.Mensageria;
 
 /// <summary>
 /// Consumidor de eventos do RabbitMQ para processar solicitacoes de reserva vindas do Faturamento
+/// e as compensacoes que devolvem essas reservas
 /// Implementa idempotencia e processamento transacional
 /// </summary>
 public class ConsumidorEventos : BackgroundService
@@ -115,7 +116,14 @@ public class ConsumidorEventos : BackgroundService
             routingKey: "Faturamento.ImpressaoSolicitada"
         );
 
-        _logger.LogInformation("Escutando: Faturamento.ImpressaoSolicitada");
+        // bind: compensacao da saga de impressao (reserva que nao vai fechar a nota)
+        _canal.QueueBind(
+            queue: nomeFila,
+            exchange: "faturamento-eventos",
+            routingKey: "Faturamento.LiberarReserva"
+        );
+
+        _logger.LogInformation("Escutando: Faturamento.ImpressaoSolicitada, Faturamento.LiberarReserva");
 
         // QoS: processar 1 mensagem por vez (evita concorrencia interna)
         _canal.BasicQos(prefetchSize: 0, prefetchCount: 1, global: false);
@@ -148,7 +156,6 @@ public class ConsumidorEventos : BackgroundService
     {
         using var escopo = _serviceProvider.CreateScope();
         var contexto = escopo.ServiceProvider.GetRequiredService<ContextoBancoDados>();
-        var handler = escopo.ServiceProvider.GetRequiredService<ReservarEstoqueHandler>();
 
         // idempotencia: usar MessageId unico do RabbitMQ
         var idMensagem = args.BasicProperties.MessageId ?? $"delivery-{args.DeliveryTag}";
@@ -163,12 +170,31 @@ public class ConsumidorEventos : BackgroundService
             return;
         }
 
-        // deserializar payload JSON
         var corpo = Encoding.UTF8.GetString(args.Body.ToArray());
-        var evento = JsonSerializer.Deserialize<EventoSolicitacaoImpressao>(corpo, new JsonSerializerOptions
+        if (args.RoutingKey == "Faturamento.LiberarReserva")
+        {
+            await ProcessarLiberacao(escopo.ServiceProvider, corpo);
+        }
+        else
+        {
+            await ProcessarSolicitacao(escopo.ServiceProvider, corpo, ContextoDoEvento(args.BasicProperties, idMensagem));
+        }
+
+        // marcar mensagem como processada (idempotencia)
+        contexto.MensagensProcessadas.Add(new MensagemProcessada
         {
-            PropertyNameCaseInsensitive = true
+            IDMensagem = idMensagem,
+            DataProcessada = DateTime.UtcNow
         });
+        await contexto.SaveChangesAsync();
+    }
+
+    private async Task ProcessarSolicitacao(IServiceProvider servicos, string corpo, ContextoEvento contextoEvento)
+    {
+        var handler = servicos.GetRequiredService<ReservarEstoqueHandler>();
+
+        // deserializar payload JSON
+        var evento = JsonSerializer.Deserialize<EventoSolicitacaoImpressao>(corpo, OpcoesJson);
 
         if (evento is null || evento.Itens is null || evento.Itens.Count == 0)
         {
@@ -177,8 +203,8 @@ public class ConsumidorEventos : BackgroundService
         }
 
         _logger.LogInformation(
-            "Processando solicitacao de reserva para nota {NotaId} com {QtdItens} itens",
-            evento.NotaId, evento.Itens.Count
+            "Processando solicitacao de reserva para nota {NotaId} com {QtdItens} itens (correlacao {Correlacao})",
+            evento.NotaId, evento.Itens.Count, contextoEvento.IdCorrelacao
         );
 
         // processar todos os itens em lote (transacao unica)
@@ -186,17 +212,9 @@ public class ConsumidorEventos : BackgroundService
             .Select(i => new ReservarEstoqueItem(i.ProdutoId, i.Quantidade))
             .ToList();
 
-        var lote = new ReservarEstoqueLoteCommand(evento.NotaId, itensComando);
+        var lote = new ReservarEstoqueLoteCommand(evento.NotaId, itensComando, evento.SolicitacaoId, contextoEvento);
         var resultadoLote = await handler.ExecutarLote(lote, simularFalha: false);
 
-        // marcar mensagem como processada (idempotencia)
-        contexto.MensagensProcessadas.Add(new MensagemProcessada
-        {
-            IDMensagem = idMensagem,
-            DataProcessada = DateTime.UtcNow
-        });
-        await contexto.SaveChangesAsync();
-
         if (resultadoLote.Falhou)
         {
             _logger.LogWarning("Falha ao processar nota {NotaId}: {Motivo}", evento.NotaId, resultadoLote.Mensagem);
@@ -207,6 +225,48 @@ public class ConsumidorEventos : BackgroundService
         }
     }
 
+    private async Task ProcessarLiberacao(IServiceProvider servicos, string corpo)
+    {
+        var handler = servicos.GetRequiredService<LiberarReservaHandler>();
+
+        var evento = JsonSerializer.Deserialize<EventoLiberarReserva>(corpo, OpcoesJson);
+        if (evento is null)
+        {
+            _logger.LogError("Falha ao deserializar evento de liberacao: {Corpo}", corpo);
+            return;
+        }
+
+        // sem a solicitacao nao da para saber qual reserva da nota devolver
+        // (pode haver outra tentativa que fechou a nota); reservas antigas,
+        // sem solicitacao_id, ficam para ajuste manual
+        if (evento.SolicitacaoId is null)
+        {
+            _logger.LogWarning("Liberacao da nota {NotaId} sem solicitacaoId, ignorando: {Motivo}", evento.NotaId, evento.Motivo);
+            return;
+        }
+
+        var resultado = await handler.Executar(new LiberarReservaCommand(evento.NotaId, evento.SolicitacaoId.Value, evento.Motivo));
+        if (resultado.Falhou)
+        {
+            // nao marca a mensagem como processada
+            throw new InvalidOperationException(resultado.Mensagem);
+        }
+    }
+
+    // a resposta herda a correlacao da saga e tem como causa o evento recebido;
+    // sem os headers cai no CorrelationId e no MessageId da mensagem
+    private static ContextoEvento ContextoDoEvento(IBasicProperties props, string idMensagem)
+    {
+        var correlacao = CabecalhosCloudEvents.Ler(props, CabecalhosCloudEvents.Correlacao) ?? props.CorrelationId;
+        var causacao = CabecalhosCloudEvents.Ler(props, CabecalhosCloudEvents.Id) ?? idMensagem;
+        return new ContextoEvento(correlacao, causacao);
+    }
+
+    private static readonly JsonSerializerOptions OpcoesJson = new()
+    {
+        PropertyNameCaseInsensitive = true
+    };
+
     public override void Dispose()
     {
         _canal?.Close();
@@ -218,6 +278,7 @@ public class ConsumidorEventos : BackgroundService
 // DTOs internos para deserializar eventos (payload vem do Go)
 internal record EventoSolicitacaoImpressao(
     Guid NotaId,
+    Guid? SolicitacaoId,
     List<ItemEventoImpressao> Itens
 );
 
@@ -225,3 +286,9 @@ internal record ItemEventoImpressao(
     Guid ProdutoId,
     int Quantidade
 );
+
+internal record EventoLiberarReserva(
+    Guid NotaId,
+    Guid? SolicitacaoId,
+    string? Motivo
+);
@@ -77,6 +77,18 @@ public class PublicadorOutbox : BackgroundService
                     ((DateTimeOffset)evento.DataOcorrencia).ToUnixTimeSeconds()
                 );
 
+                // o Faturamento liga a resposta a saga pela correlacao
+                props.Headers = new Dictionary<string, object>();
+                if (evento.IdCorrelacao is not null)
+                {
+                    props.CorrelationId = evento.IdCorrelacao;
+                    props.Headers[CabecalhosCloudEvents.Correlacao] = evento.IdCorrelacao;
+                }
+                if (evento.IdCausacao is not null)
+                {
+                    props.Headers[CabecalhosCloudEvents.Causacao] = evento.IdCausacao;
+                }
+
                 channel.BasicPublish(
                     exchange: "estoque-eventos",
                     routingKey: evento.TipoEvento,
@@ -63,6 +63,7 @@ public class ContextoBancoDados : DbContext
 
             r.Property(x => x.Id).HasColumnName("id");
             r.Property(x => x.NotaId).HasColumnName("nota_id").IsRequired();
+            r.Property(x => x.SolicitacaoId).HasColumnName("solicitacao_id");
             r.Property(x => x.ProdutoId).HasColumnName("produto_id").IsRequired();
             r.Property(x => x.Quantidade).HasColumnName("quantidade").IsRequired();
             r.Property(x => x.Status).HasColumnName("status").HasMaxLength(20).IsRequired();
@@ -72,6 +73,8 @@ public class ContextoBancoDados : DbContext
                 .WithMany()
                 .HasForeignKey(x => x.ProdutoId)
                 .OnDelete(DeleteBehavior.Restrict);
+
+            r.HasIndex(x => x.SolicitacaoId).HasDatabaseName("idx_reservas_solicitacao_id");
         });
 
         builder.Entity<EventoOutbox>(e =>
@@ -86,6 +89,8 @@ public class ContextoBancoDados : DbContext
             e.Property(x => x.DataOcorrencia).HasColumnName("data_ocorrencia").IsRequired();
             e.Property(x => x.DataPublicacao).HasColumnName("data_publicacao");
             e.Property(x => x.TentativasEnvio).HasColumnName("tentativas_envio").HasDefaultValue(0);
+            e.Property(x => x.IdCorrelacao).HasColumnName("id_correlacao").HasMaxLength(100);
+            e.Property(x => x.IdCausacao).HasColumnName("id_causacao").HasMaxLength(100);
 
             e.HasIndex(x => x.DataPublicacao)
                 .HasFilter("data_publicacao IS NULL")
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"servico-faturamento/internal/arquivamento"
+	"servico-faturamento/internal/config"
+	"servico-faturamento/internal/repositorio"
+)
+
+const usoArquivar = `uso: servico-faturamento arquivar [meses]
+  move para as particoes de arquivo as notas FECHADA emitidas ha mais de
+  meses meses, com seus itens (padrao: ARQUIVAMENTO_MESES, 12)`
+
+// executarArquivar trata o subcomando `arquivar` e termina o processo
+func executarArquivar(args []string) {
+	cfg := arquivamento.ConfigDoAmbiente()
+	if len(args) > 0 {
+		meses, err := strconv.Atoi(args[0])
+		if err != nil || meses < 1 {
+			fmt.Fprintf(os.Stderr, "meses invalido: %q\n%s\n", args[0], usoArquivar)
+			os.Exit(2)
+		}
+		cfg.Meses = meses
+	}
+
+	db, err := config.ConectarDB()
+	if err != nil {
+		log.Fatalf("Erro ao conectar DB: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	arquivador := &arquivamento.Arquivador{Banco: repositorio.NovoBancoPostgres(db), Config: cfg}
+	res, err := arquivador.Arquivar(context.Background(), time.Now())
+	if err != nil {
+		log.Fatalf("Erro ao arquivar notas (%d ja arquivadas): %v", res.NotasArquivadas, err)
+	}
+	fmt.Printf("%d notas e %d itens arquivados em %s\n", res.NotasArquivadas, res.ItensArquivados, res.Duracao.Round(time.Millisecond))
+}
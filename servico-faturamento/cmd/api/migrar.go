@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"servico-faturamento/internal/config"
+	"servico-faturamento/internal/migracao"
+)
+
+const usoMigrar = `uso: servico-faturamento migrar [up | down [passos] | status]
+  up       aplica as migrations pendentes (padrao)
+  down     reverte as ultimas migrations aplicadas (padrao: 1)
+  status   lista as migrations e quando cada uma foi aplicada`
+
+// executarMigrar trata o subcomando `migrar` e termina o processo
+func executarMigrar(args []string) {
+	db, err := config.ConectarDB()
+	if err != nil {
+		log.Fatalf("Erro ao conectar DB: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	migrador, err := migracao.Novo(db)
+	if err != nil {
+		log.Fatalf("Erro ao carregar migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	acao := "up"
+	if len(args) > 0 {
+		acao = args[0]
+	}
+
+	switch acao {
+	case "up":
+		aplicadas, err := migrador.Aplicar(ctx)
+		if err != nil {
+			log.Fatalf("Erro ao aplicar migrations: %v", err)
+		}
+		fmt.Printf("%d migrations aplicadas\n", len(aplicadas))
+
+	case "down":
+		passos := 1
+		if len(args) > 1 {
+			passos, err = strconv.Atoi(args[1])
+			if err != nil || passos < 1 {
+				log.Fatalf("passos invalido: %q\n%s", args[1], usoMigrar)
+			}
+		}
+		revertidas, err := migrador.Reverter(ctx, passos)
+		if err != nil {
+			log.Fatalf("Erro ao reverter migrations: %v", err)
+		}
+		fmt.Printf("%d migrations revertidas\n", len(revertidas))
+
+	case "status":
+		estados, err := migrador.Status(ctx)
+		if err != nil {
+			log.Fatalf("Erro ao consultar migrations: %v", err)
+		}
+		for _, e := range estados {
+			aplicada := "pendente"
+			if e.AplicadaEm != nil {
+				aplicada = e.AplicadaEm.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s  %s\n", e.Versao, e.Nome, aplicada)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, usoMigrar)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,119 @@
+// Package arquivamento mantem as particoes mensais de notas_fiscais e
+// itens_nota e move as notas antigas ja fechadas para as particoes de arquivo.
+package arquivamento
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"servico-faturamento/internal/config"
+	"servico-faturamento/internal/repositorio"
+)
+
+// Config define o que o arquivamento move e quantas particoes correntes
+// ficam criadas adiante
+type Config struct {
+	Meses          int // notas FECHADA emitidas ha mais de Meses meses
+	TamanhoLote    int
+	MesesAdiante   int // particoes correntes alem do mes atual
+	IntervaloCheck time.Duration
+}
+
+// ConfigDoAmbiente le ARQUIVAMENTO_* com defaults conservadores
+func ConfigDoAmbiente() Config {
+	return Config{
+		Meses:          config.InteiroEnv("ARQUIVAMENTO_MESES", 12),
+		TamanhoLote:    config.InteiroEnv("ARQUIVAMENTO_LOTE", 500),
+		MesesAdiante:   config.InteiroEnv("PARTICOES_MESES_ADIANTE", 3),
+		IntervaloCheck: config.DuracaoEnv("PARTICOES_INTERVALO", 24*time.Hour),
+	}
+}
+
+// Resultado resume uma execucao do arquivamento
+type Resultado struct {
+	NotasArquivadas int64         `json:"notasArquivadas"`
+	ItensArquivados int64         `json:"itensArquivados"`
+	Duracao         time.Duration `json:"duracao"`
+}
+
+type Arquivador struct {
+	Banco  repositorio.Banco
+	Config Config
+}
+
+// IniciarParticoes garante as particoes correntes na subida e depois a cada
+// IntervaloCheck, para a virada do mes nunca encontrar a particao faltando.
+// Nao ha particao DEFAULT: sem a do mes as notas nao entram, entao a falha na
+// subida volta como erro para o servico nao subir sem ela. As seguintes so
+// sao logadas; a particao do mes ja existe e as adiante dao MesesAdiante
+// meses para corrigir.
+func IniciarParticoes(banco repositorio.Banco, cfg Config) (*Arquivador, error) {
+	a := &Arquivador{Banco: banco, Config: cfg}
+	if err := a.GarantirParticoes(context.Background(), time.Now()); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			time.Sleep(cfg.IntervaloCheck)
+			if err := a.GarantirParticoes(context.Background(), time.Now()); err != nil {
+				log.Printf("[arquivamento] falha ao criar particoes: %v", err)
+			}
+		}
+	}()
+	return a, nil
+}
+
+// GarantirParticoes cria, se faltarem, as particoes correntes do mes de agora
+// e dos MesesAdiante seguintes
+func (a *Arquivador) GarantirParticoes(ctx context.Context, agora time.Time) error {
+	for i := 0; i <= a.Config.MesesAdiante; i++ {
+		mes := inicioDoMes(agora).AddDate(0, i, 0)
+		if err := a.Banco.Notas().GarantirParticoes(ctx, mes); err != nil {
+			return fmt.Errorf("falha ao criar particoes de %s: %w", mes.Format("2006-01"), err)
+		}
+	}
+	return nil
+}
+
+// Arquivar move para as particoes de arquivo, lote a lote, as notas FECHADA
+// emitidas antes de agora - Meses, com seus itens
+func (a *Arquivador) Arquivar(ctx context.Context, agora time.Time) (Resultado, error) {
+	inicio := time.Now()
+	var res Resultado
+	corte := agora.AddDate(0, -a.Config.Meses, 0)
+
+	// a DDL das particoes de arquivo fica fora das transacoes dos lotes
+	if err := a.Banco.Notas().PrepararArquivamento(ctx, corte); err != nil {
+		return res, fmt.Errorf("falha ao criar particoes de arquivo: %w", err)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		var notas, itens int64
+		err := a.Banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+			var err error
+			notas, itens, err = r.Notas().Arquivar(ctx, corte, a.Config.TamanhoLote)
+			return err
+		})
+		if err != nil {
+			return res, fmt.Errorf("falha ao arquivar notas: %w", err)
+		}
+
+		res.NotasArquivadas += notas
+		res.ItensArquivados += itens
+		if notas < int64(a.Config.TamanhoLote) {
+			res.Duracao = time.Since(inicio)
+			return res, nil
+		}
+	}
+}
+
+func inicioDoMes(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,77 @@
+package arquivamento_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/arquivamento"
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+)
+
+func TestArquivador_Arquivar(t *testing.T) {
+	db := bdteste.Abrir(t)
+	banco := repositorio.NovoBancoPostgres(db)
+	ctx := context.Background()
+
+	agora := time.Now()
+	antiga := agora.AddDate(-2, 0, 0)
+
+	criar := func(numero, status string, data time.Time) dominio.NotaFiscal {
+		t.Helper()
+		nota := dominio.NotaFiscal{
+			Numero:      numero,
+			Status:      status,
+			DataCriacao: data,
+			Itens: []dominio.ItemNota{
+				{ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 10},
+				{ProdutoID: uuid.New(), Quantidade: 2, PrecoUnitario: 5},
+			},
+		}
+		if err := banco.Notas().Criar(ctx, &nota); err != nil {
+			t.Fatalf("erro ao criar nota %s: %v", numero, err)
+		}
+		return nota
+	}
+
+	// 3 fechadas antigas, 1 fechada recente, 1 aberta antiga
+	var arquivaveis []dominio.NotaFiscal
+	for _, numero := range []string{"NF-1", "NF-2", "NF-3"} {
+		arquivaveis = append(arquivaveis, criar(numero, dominio.StatusNotaFechada, antiga))
+	}
+	criar("NF-4", dominio.StatusNotaFechada, agora)
+	criar("NF-5", dominio.StatusNotaAberta, antiga)
+
+	arquivador := &arquivamento.Arquivador{Banco: banco, Config: arquivamento.Config{Meses: 12, TamanhoLote: 2}}
+	res, err := arquivador.Arquivar(ctx, agora)
+	if err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+	if res.NotasArquivadas != 3 || res.ItensArquivados != 6 {
+		t.Errorf("esperava 3 notas e 6 itens arquivados, obteve %d e %d", res.NotasArquivadas, res.ItensArquivados)
+	}
+
+	correntes, _ := banco.Notas().Listar(ctx, repositorio.FiltroNotas{})
+	if len(correntes) != 2 {
+		t.Errorf("listagem padrao deveria trazer so as 2 notas correntes, obteve %d", len(correntes))
+	}
+	todas, _ := banco.Notas().Listar(ctx, repositorio.FiltroNotas{Arquivadas: true})
+	if len(todas) != 5 {
+		t.Errorf("com Arquivadas deveria trazer as 5 notas, obteve %d", len(todas))
+	}
+
+	// arquivada continua acessivel pelo id, com os itens
+	nota, err := banco.Notas().Buscar(ctx, arquivaveis[0].ID)
+	if err != nil || !nota.Arquivada || len(nota.Itens) != 2 {
+		t.Errorf("esperava a nota arquivada com 2 itens, obteve %+v (erro %v)", nota, err)
+	}
+
+	// nada mais a arquivar numa segunda execucao
+	if res, _ := arquivador.Arquivar(ctx, agora); res.NotasArquivadas != 0 {
+		t.Errorf("segunda execucao nao deveria arquivar nada, arquivou %d", res.NotasArquivadas)
+	}
+}
@@ -0,0 +1,88 @@
+package arquivamento_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/arquivamento"
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Particoes e triggers da 0006/0010 no PostgreSQL de TESTE_POSTGRES_DSN
+func TestParticoes_Postgres(t *testing.T) {
+	db := bdteste.AbrirPostgres(t)
+	banco := repositorio.NovoBancoPostgres(db)
+	ctx := context.Background()
+	agora := time.Now()
+
+	arquivador := &arquivamento.Arquivador{Banco: banco, Config: arquivamento.Config{Meses: 12, TamanhoLote: 10, MesesAdiante: 1}}
+	if err := arquivador.GarantirParticoes(ctx, agora); err != nil {
+		t.Fatalf("falha ao garantir particoes: %v", err)
+	}
+
+	// sem particao DEFAULT: nota de um mes sem particao nao entra
+	futura := dominio.NotaFiscal{Numero: "NF-FUTURA", DataCriacao: agora.AddDate(5, 0, 0)}
+	if err := banco.Notas().Criar(ctx, &futura); err == nil {
+		t.Error("nota fora das particoes deveria falhar")
+	}
+
+	antiga := agora.AddDate(-2, 0, 0)
+	if err := db.Exec("SELECT faturamento_criar_particoes(?, false)", antiga.Format("2006-01-02")).Error; err != nil {
+		t.Fatalf("falha ao criar particao antiga: %v", err)
+	}
+	criar := func(numero string, data time.Time) dominio.NotaFiscal {
+		t.Helper()
+		nota := dominio.NotaFiscal{
+			Numero: numero, Status: dominio.StatusNotaFechada, DataCriacao: data,
+			Itens: []dominio.ItemNota{{ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 10}},
+		}
+		if err := banco.Notas().Criar(ctx, &nota); err != nil {
+			t.Fatalf("erro ao criar nota %s: %v", numero, err)
+		}
+		sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-" + numero}
+		if err := banco.Solicitacoes().Criar(ctx, &sol); err != nil {
+			t.Fatalf("erro ao criar solicitacao de %s: %v", numero, err)
+		}
+		return nota
+	}
+	arquivavel := criar("NF-ANTIGA", antiga)
+	corrente := criar("NF-CORRENTE", agora)
+
+	// item sem nota e barrado pelo trigger no lugar da FK
+	orfao := dominio.ItemNota{NotaID: uuid.New(), ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 1, DataNota: agora}
+	if err := db.Create(&orfao).Error; !errors.Is(err, gorm.ErrForeignKeyViolated) {
+		t.Errorf("item sem nota deveria violar a integridade, obteve %v", err)
+	}
+
+	// mudar de particao nao e apagar: itens e solicitacao ficam
+	if res, err := arquivador.Arquivar(ctx, agora); err != nil || res.NotasArquivadas != 1 || res.ItensArquivados != 1 {
+		t.Fatalf("esperava arquivar 1 nota e 1 item, obteve %+v (erro %v)", res, err)
+	}
+	nota, err := banco.Notas().Buscar(ctx, arquivavel.ID)
+	if err != nil || !nota.Arquivada || len(nota.Itens) != 1 {
+		t.Errorf("nota arquivada deveria manter o item, obteve %+v (erro %v)", nota, err)
+	}
+	var solicitacoes int64
+	db.Model(&dominio.SolicitacaoImpressao{}).Where("nota_id = ?", arquivavel.ID).Count(&solicitacoes)
+	if solicitacoes != 1 {
+		t.Errorf("nota arquivada deveria manter a solicitacao, obteve %d", solicitacoes)
+	}
+
+	// apagar a nota apaga os dependentes, como o ON DELETE CASCADE
+	if err := db.Where("id = ?", corrente.ID).Delete(&dominio.NotaFiscal{}).Error; err != nil {
+		t.Fatalf("falha ao apagar nota: %v", err)
+	}
+	var itens int64
+	db.Model(&dominio.ItemNota{}).Where("nota_id = ?", corrente.ID).Count(&itens)
+	db.Model(&dominio.SolicitacaoImpressao{}).Where("nota_id = ?", corrente.ID).Count(&solicitacoes)
+	if itens != 0 || solicitacoes != 0 {
+		t.Errorf("esperava itens e solicitacoes apagados com a nota, restaram %d e %d", itens, solicitacoes)
+	}
+}
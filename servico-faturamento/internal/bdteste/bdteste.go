@@ -0,0 +1,52 @@
+// Package bdteste abre um banco SQLite em memoria com o schema do servico,
+// para testes que precisam de persistencia sem subir PostgreSQL.
+package bdteste
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"servico-faturamento/internal/dominio"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// abertos distingue bancos abertos no mesmo teste (ex.: primario e replica)
+var abertos atomic.Uint64
+
+// Abrir cria um banco isolado a cada chamada, fechado automaticamente no fim
+// do teste
+func Abrir(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	nome := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", nome, abertos.Add(1))
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatalf("falha ao abrir sqlite: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("falha ao obter conexao: %v", err)
+	}
+	// uma conexao so: transacoes concorrentes esperam em vez de dar SQLITE_BUSY
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	// as migrations SQL sao especificas do PostgreSQL; aqui o schema sai dos
+	// modelos, e migracao_test garante que os dois nao divergem
+	if err := db.AutoMigrate(dominio.Modelos()...); err != nil {
+		t.Fatalf("falha ao migrar schema: %v", err)
+	}
+
+	return db
+}
@@ -0,0 +1,89 @@
+package bdteste
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"servico-faturamento/internal/migracao"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// VariavelPostgres aponta o PostgreSQL dos testes de integracao
+const VariavelPostgres = "TESTE_POSTGRES_DSN"
+
+// AbrirPostgres abre o PostgreSQL de TESTE_POSTGRES_DSN num schema proprio,
+// com as migrations SQL aplicadas, e apaga o schema no fim do teste. Sem a
+// variavel o teste e pulado: particoes, triggers e busca textual so existem
+// no PostgreSQL.
+func AbrirPostgres(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv(VariavelPostgres)
+	if dsn == "" {
+		t.Skipf("%s nao definido; teste de integracao com PostgreSQL pulado", VariavelPostgres)
+	}
+
+	config := &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	}
+	admin, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		t.Fatalf("falha ao abrir postgres: %v", err)
+	}
+	adminDB, err := admin.DB()
+	if err != nil {
+		t.Fatalf("falha ao obter conexao: %v", err)
+	}
+
+	schema := "teste_" + uuid.NewString()[:8]
+	if err := admin.Exec(fmt.Sprintf("CREATE SCHEMA %s", schema)).Error; err != nil {
+		adminDB.Close()
+		t.Fatalf("falha ao criar schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+		adminDB.Close()
+	})
+
+	// o search_path vai no DSN para valer em todas as conexoes do pool, e
+	// os testes podem abrir transacoes concorrentes
+	db, err := gorm.Open(postgres.Open(comSearchPath(dsn, schema+",public")), config)
+	if err != nil {
+		t.Fatalf("falha ao abrir schema: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("falha ao obter conexao: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrador, err := migracao.Novo(db)
+	if err != nil {
+		t.Fatalf("falha ao carregar migrations: %v", err)
+	}
+	if _, err := migrador.Aplicar(context.Background()); err != nil {
+		t.Fatalf("falha ao aplicar migrations: %v", err)
+	}
+	return db
+}
+
+// comSearchPath acrescenta search_path ao DSN, em URL ou chave=valor
+func comSearchPath(dsn, searchPath string) string {
+	if !strings.Contains(dsn, "://") {
+		return fmt.Sprintf("%s search_path=%s", dsn, searchPath)
+	}
+	separador := "?"
+	if strings.Contains(dsn, "?") {
+		separador = "&"
+	}
+	return dsn + separador + "search_path=" + url.QueryEscape(searchPath)
+}
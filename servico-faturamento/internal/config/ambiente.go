@@ -0,0 +1,30 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DuracaoEnv le uma duracao no formato Go (ex: 30s, 5m, 168h)
+func DuracaoEnv(nome string, padrao time.Duration) time.Duration {
+	if v := os.Getenv(nome); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("[config] valor invalido para %s=%q, usando %s", nome, v, padrao)
+	}
+	return padrao
+}
+
+// InteiroEnv le um inteiro positivo
+func InteiroEnv(nome string, padrao int) int {
+	if v := os.Getenv(nome); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("[config] valor invalido para %s=%q, usando %d", nome, v, padrao)
+	}
+	return padrao
+}
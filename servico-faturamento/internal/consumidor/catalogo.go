@@ -0,0 +1,52 @@
+package consumidor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+)
+
+// processarProduto atualiza a projecao do catalogo. Eventos mais antigos que o
+// estado gravado (reentregas fora de ordem) nao sobrescrevem o catalogo.
+func (c *Consumidor) processarProduto(ctx context.Context, r repositorio.Repositorios, env dominio.Envelope) error {
+	var evento dominio.PayloadProduto
+	if err := json.Unmarshal(env.Dados, &evento); err != nil {
+		return fmt.Errorf("falha ao fazer unmarshal: %w", err)
+	}
+
+	produtoID, err := uuid.Parse(evento.ProdutoID)
+	if err != nil {
+		return fmt.Errorf("produtoId invalido: %w", err)
+	}
+	if evento.Sku == "" || evento.Nome == "" {
+		return fmt.Errorf("evento de produto %s sem sku ou nome", produtoID)
+	}
+
+	dataEvento := env.Data
+	if dataEvento.IsZero() {
+		dataEvento = time.Now()
+	}
+
+	produto := dominio.ProdutoCatalogo{
+		ID:         produtoID,
+		Sku:        evento.Sku,
+		Nome:       evento.Nome,
+		Ativo:      evento.Ativo,
+		DataEvento: dataEvento,
+		IDEvento:   env.ID,
+	}
+
+	if err := r.Produtos().Projetar(ctx, &produto); err != nil {
+		return fmt.Errorf("falha ao atualizar catalogo: %w", err)
+	}
+
+	log.Printf("Catalogo: produto %s (%s) ativo=%t", produtoID, evento.Sku, evento.Ativo)
+	return nil
+}
@@ -0,0 +1,442 @@
+package consumidor_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/consumidor"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func entregaReservado(notaID uuid.UUID, itens []dominio.ItemReserva) mensageria.Entrega {
+	corpo, _ := json.Marshal(map[string]interface{}{"notaId": notaID.String(), "itens": itens})
+	return mensageria.Entrega{Mensagem: mensageria.Mensagem{
+		ID:         uuid.NewString(),
+		RoutingKey: dominio.EventoEstoqueReservado,
+		Corpo:      corpo,
+	}}
+}
+
+// entregaReservadoPara e a resposta do Estoque que devolve a solicitacao
+func entregaReservadoPara(notaID, solicitacaoID uuid.UUID, itens []dominio.ItemReserva) mensageria.Entrega {
+	corpo, _ := json.Marshal(map[string]interface{}{"notaId": notaID.String(), "solicitacaoId": solicitacaoID.String(), "itens": itens})
+	return mensageria.Entrega{Mensagem: mensageria.Mensagem{
+		ID:         uuid.NewString(),
+		RoutingKey: dominio.EventoEstoqueReservado,
+		Corpo:      corpo,
+	}}
+}
+
+func TestProcessarMensagem_ReservaDeTentativaEncerradaNaoFechaRetentativa(t *testing.T) {
+	db := bdteste.Abrir(t)
+	c := &consumidor.Consumidor{Banco: repositorio.NovoBancoPostgres(db)}
+
+	nota := dominio.NotaFiscal{Numero: "NF-RETENTATIVA"}
+	db.Create(&nota)
+	item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 3, PrecoUnitario: 10}
+	db.Create(&item)
+
+	// a primeira tentativa expirou reservando 2; a retentativa pede 3
+	expirada := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-1", Status: dominio.StatusSolicitacaoFalhou}
+	db.Create(&expirada)
+	prazo := time.Now().Add(time.Minute)
+	retentativa := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-2", PrazoExpiracao: &prazo, SolicitacaoOrigemID: &expirada.ID, Tentativa: 2}
+	db.Create(&retentativa)
+
+	antigos := []dominio.ItemReserva{{ProdutoID: item.ProdutoID.String(), Quantidade: 2}}
+	if err := c.ProcessarMensagem(entregaReservadoPara(nota.ID, expirada.ID, antigos)); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	db.First(&nota, "id = ?", nota.ID)
+	db.First(&retentativa, "id = ?", retentativa.ID)
+	if nota.Status != dominio.StatusNotaAberta || retentativa.Status != dominio.StatusSolicitacaoPendente {
+		t.Fatalf("reserva da tentativa expirada nao deveria mexer na retentativa: nota %s, retentativa %s", nota.Status, retentativa.Status)
+	}
+	var liberacoes []dominio.EventoOutbox
+	db.Where("tipo_evento = ?", dominio.EventoLiberarReserva).Find(&liberacoes)
+	if len(liberacoes) != 1 {
+		t.Fatalf("esperava 1 compensacao, obteve %d", len(liberacoes))
+	}
+	var payload dominio.PayloadLiberarReserva
+	json.Unmarshal([]byte(liberacoes[0].Payload), &payload)
+	if payload.SolicitacaoID != expirada.ID.String() || len(payload.Itens) != 1 || payload.Itens[0].Quantidade != 2 {
+		t.Errorf("esperava liberar so a reserva da tentativa expirada, obteve %+v", payload)
+	}
+
+	atuais := []dominio.ItemReserva{{ProdutoID: item.ProdutoID.String(), Quantidade: 3}}
+	if err := c.ProcessarMensagem(entregaReservadoPara(nota.ID, retentativa.ID, atuais)); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+	db.First(&nota, "id = ?", nota.ID)
+	if nota.Status != dominio.StatusNotaFechada {
+		t.Errorf("a reserva da retentativa deveria fechar a nota, status %s", nota.Status)
+	}
+	var total int64
+	db.Model(&dominio.EventoOutbox{}).Where("tipo_evento = ?", dominio.EventoLiberarReserva).Count(&total)
+	if total != 1 {
+		t.Errorf("a reserva da retentativa nao deveria ser liberada, %d compensacoes", total)
+	}
+}
+
+func TestProcessarMensagem_ReservaAposTimeoutELiberada(t *testing.T) {
+	db := bdteste.Abrir(t)
+	c := &consumidor.Consumidor{Banco: repositorio.NovoBancoPostgres(db)}
+
+	nota := dominio.NotaFiscal{Numero: "NF-ATRASADA"}
+	db.Create(&nota)
+	item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 10}
+	db.Create(&item)
+
+	vencido := time.Now().Add(-time.Second)
+	sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-atrasada", PrazoExpiracao: &vencido}
+	db.Create(&sol)
+
+	itens := []dominio.ItemReserva{{ProdutoID: item.ProdutoID.String(), Quantidade: 1}}
+	if err := c.ProcessarMensagem(entregaReservado(nota.ID, itens)); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	db.First(&nota, "id = ?", nota.ID)
+	if nota.Status != dominio.StatusNotaAberta {
+		t.Errorf("reserva atrasada nao deveria fechar a nota, status %s", nota.Status)
+	}
+	db.First(&sol, "id = ?", sol.ID)
+	if sol.Status != dominio.StatusSolicitacaoFalhou {
+		t.Errorf("esperava solicitacao FALHOU, obteve %s", sol.Status)
+	}
+
+	var liberacao dominio.EventoOutbox
+	if err := db.Where("tipo_evento = ?", dominio.EventoLiberarReserva).First(&liberacao).Error; err != nil {
+		t.Fatalf("esperava evento de compensacao no outbox: %v", err)
+	}
+	var payload dominio.PayloadLiberarReserva
+	json.Unmarshal([]byte(liberacao.Payload), &payload)
+	if payload.SolicitacaoID != sol.ID.String() || len(payload.Itens) != 1 {
+		t.Errorf("payload de compensacao inesperado: %+v", payload)
+	}
+}
+
+func TestProcessarMensagem_ReservaOrfaELiberada(t *testing.T) {
+	casos := []struct {
+		nome string
+		// preparar devolve a nota alvo e, se houver, a solicitacao que deve
+		// receber a compensacao
+		preparar  func(t *testing.T, db *gorm.DB) (uuid.UUID, *dominio.SolicitacaoImpressao)
+		motivo    string
+		solFalhou bool
+		// statusNota esperado depois da mensagem; vazio quando a nota nao existe
+		statusNota string
+	}{
+		{
+			nome: "nota inexistente",
+			preparar: func(t *testing.T, db *gorm.DB) (uuid.UUID, *dominio.SolicitacaoImpressao) {
+				return uuid.New(), nil
+			},
+			motivo: "Nota nao encontrada",
+		},
+		{
+			nome: "nota fechada",
+			preparar: func(t *testing.T, db *gorm.DB) (uuid.UUID, *dominio.SolicitacaoImpressao) {
+				nota := dominio.NotaFiscal{Numero: "NF-FECHADA", Status: dominio.StatusNotaFechada}
+				db.Create(&nota)
+				db.Create(&dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 10})
+				sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-fechada", Status: dominio.StatusSolicitacaoConcluida}
+				db.Create(&sol)
+				return nota.ID, &sol
+			},
+			motivo:     "Nota com status FECHADA nao aceita reserva",
+			statusNota: dominio.StatusNotaFechada,
+		},
+		{
+			nome: "nota sem itens",
+			preparar: func(t *testing.T, db *gorm.DB) (uuid.UUID, *dominio.SolicitacaoImpressao) {
+				nota := dominio.NotaFiscal{Numero: "NF-VAZIA"}
+				db.Create(&nota)
+				sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-vazia"}
+				db.Create(&sol)
+				return nota.ID, &sol
+			},
+			motivo:     "Nota sem itens nao pode ser fechada",
+			solFalhou:  true,
+			statusNota: dominio.StatusNotaAberta,
+		},
+		{
+			nome: "sem solicitacao pendente",
+			preparar: func(t *testing.T, db *gorm.DB) (uuid.UUID, *dominio.SolicitacaoImpressao) {
+				nota := dominio.NotaFiscal{Numero: "NF-SEM-PENDENTE"}
+				db.Create(&nota)
+				db.Create(&dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 10})
+				return nota.ID, nil
+			},
+			motivo:     "Reserva recebida sem solicitacao pendente",
+			statusNota: dominio.StatusNotaAberta,
+		},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nome, func(t *testing.T) {
+			db := bdteste.Abrir(t)
+			c := &consumidor.Consumidor{Banco: repositorio.NovoBancoPostgres(db)}
+			notaID, sol := caso.preparar(t, db)
+
+			itens := []dominio.ItemReserva{{ProdutoID: uuid.NewString(), Quantidade: 2}}
+			if err := c.ProcessarMensagem(entregaReservado(notaID, itens)); err != nil {
+				t.Fatalf("erro inesperado: %v", err)
+			}
+
+			var liberacao dominio.EventoOutbox
+			if err := db.Where("tipo_evento = ?", dominio.EventoLiberarReserva).First(&liberacao).Error; err != nil {
+				t.Fatalf("esperava evento de compensacao no outbox: %v", err)
+			}
+			var payload dominio.PayloadLiberarReserva
+			json.Unmarshal([]byte(liberacao.Payload), &payload)
+			if payload.NotaID != notaID.String() || payload.Motivo != caso.motivo {
+				t.Errorf("esperava compensacao da nota %s com motivo %q, obteve %+v", notaID, caso.motivo, payload)
+			}
+			if len(payload.Itens) != 1 || payload.Itens[0] != itens[0] {
+				t.Errorf("esperava os itens reservados na compensacao, obteve %+v", payload.Itens)
+			}
+
+			if sol != nil {
+				if payload.SolicitacaoID != sol.ID.String() {
+					t.Errorf("esperava compensacao ligada a solicitacao %s, obteve %q", sol.ID, payload.SolicitacaoID)
+				}
+				db.First(sol, "id = ?", sol.ID)
+				if caso.solFalhou && sol.Status != dominio.StatusSolicitacaoFalhou {
+					t.Errorf("esperava solicitacao FALHOU, obteve %s", sol.Status)
+				}
+			} else if payload.SolicitacaoID != "" {
+				t.Errorf("compensacao sem solicitacao nao deveria apontar para %s", payload.SolicitacaoID)
+			}
+
+			if caso.statusNota != "" {
+				var nota dominio.NotaFiscal
+				db.First(&nota, "id = ?", notaID)
+				if nota.Status != caso.statusNota {
+					t.Errorf("esperava nota %s, obteve %s", caso.statusNota, nota.Status)
+				}
+			}
+
+			var processadas int64
+			db.Model(&dominio.MensagemProcessada{}).Count(&processadas)
+			if processadas != 1 {
+				t.Errorf("esperava mensagem marcada como processada, obteve %d", processadas)
+			}
+		})
+	}
+}
+
+func TestProcessarMensagem_ReservaDivergenteFalhaSolicitacao(t *testing.T) {
+	db := bdteste.Abrir(t)
+	c := &consumidor.Consumidor{Banco: repositorio.NovoBancoPostgres(db)}
+
+	nota := dominio.NotaFiscal{Numero: "NF-DIVERGENTE"}
+	db.Create(&nota)
+	item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 3, PrecoUnitario: 10}
+	db.Create(&item)
+	sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-divergente"}
+	db.Create(&sol)
+
+	// reserva para o conjunto antigo de itens: quantidade menor e um produto removido
+	itens := []dominio.ItemReserva{
+		{ProdutoID: item.ProdutoID.String(), Quantidade: 2},
+		{ProdutoID: uuid.NewString(), Quantidade: 1},
+	}
+	if err := c.ProcessarMensagem(entregaReservado(nota.ID, itens)); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	db.First(&nota, "id = ?", nota.ID)
+	if nota.Status != dominio.StatusNotaAberta {
+		t.Errorf("reserva divergente nao deveria fechar a nota, status %s", nota.Status)
+	}
+	db.First(&sol, "id = ?", sol.ID)
+	if sol.Status != dominio.StatusSolicitacaoFalhou || sol.MensagemErro == nil ||
+		!strings.HasPrefix(*sol.MensagemErro, dominio.MotivoReservaDivergente) {
+		t.Fatalf("esperava solicitacao FALHOU com relatorio, obteve %s %v", sol.Status, sol.MensagemErro)
+	}
+	if !strings.Contains(*sol.MensagemErro, item.ProdutoID.String()) {
+		t.Errorf("relatorio deveria citar o produto divergente: %s", *sol.MensagemErro)
+	}
+
+	var falhou dominio.EventoOutbox
+	if err := db.Where("tipo_evento = ?", dominio.EventoImpressaoFalhou).First(&falhou).Error; err != nil {
+		t.Fatalf("esperava Faturamento.ImpressaoFalhou no outbox: %v", err)
+	}
+	var payload dominio.PayloadImpressaoFalhou
+	json.Unmarshal([]byte(falhou.Payload), &payload)
+	if len(payload.Divergencias) != 2 {
+		t.Errorf("esperava 2 divergencias no evento, obteve %+v", payload.Divergencias)
+	}
+
+	var liberacao dominio.EventoOutbox
+	if err := db.Where("tipo_evento = ?", dominio.EventoLiberarReserva).First(&liberacao).Error; err != nil {
+		t.Fatalf("esperava a reserva divergente liberada: %v", err)
+	}
+}
+
+func TestProcessarMensagem_ProdutoForaDeOrdemNaoRegride(t *testing.T) {
+	db := bdteste.Abrir(t)
+	c := &consumidor.Consumidor{Banco: repositorio.NovoBancoPostgres(db)}
+	produto := uuid.New()
+
+	entregaProduto := func(tipo string, ativo bool, quando time.Time) mensageria.Entrega {
+		corpo, _ := json.Marshal(dominio.PayloadProduto{ProdutoID: produto.String(), Sku: "SKU-1", Nome: "Caneta", Ativo: ativo})
+		return mensageria.Entrega{Mensagem: mensageria.Mensagem{
+			ID:         uuid.NewString(),
+			RoutingKey: tipo,
+			Timestamp:  quando,
+			Corpo:      corpo,
+		}}
+	}
+
+	agora := time.Now()
+	if err := c.ProcessarMensagem(entregaProduto(dominio.EventoProdutoAtualizado, false, agora)); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+	// criacao reentregue depois da desativacao
+	if err := c.ProcessarMensagem(entregaProduto(dominio.EventoProdutoCriado, true, agora.Add(-time.Minute))); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	var catalogo dominio.ProdutoCatalogo
+	if err := db.First(&catalogo, "id = ?", produto).Error; err != nil {
+		t.Fatalf("esperava produto no catalogo: %v", err)
+	}
+	if catalogo.Ativo || catalogo.Sku != "SKU-1" || catalogo.Nome != "Caneta" {
+		t.Errorf("evento antigo nao deveria sobrescrever o catalogo, obteve %+v", catalogo)
+	}
+}
+
+func TestProcessarMensagem_FechaNotaEmMemoria(t *testing.T) {
+	banco := repositorio.NovoBancoMemoria()
+	c := &consumidor.Consumidor{Banco: banco}
+	ctx := context.Background()
+
+	nota := dominio.NotaFiscal{Numero: "NF-MEMORIA", Status: dominio.StatusNotaAberta}
+	banco.Notas().Criar(ctx, &nota)
+	item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 2, PrecoUnitario: 10}
+	banco.Notas().AdicionarItem(ctx, &item)
+	sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-memoria"}
+	banco.Solicitacoes().Criar(ctx, &sol)
+
+	entrega := entregaReservado(nota.ID, []dominio.ItemReserva{{ProdutoID: item.ProdutoID.String(), Quantidade: 2}})
+	for i := 0; i < 2; i++ { // a reentrega e deduplicada
+		if err := c.ProcessarMensagem(entrega); err != nil {
+			t.Fatalf("erro inesperado: %v", err)
+		}
+	}
+
+	fechada, _ := banco.Notas().Buscar(ctx, nota.ID)
+	if fechada.Status != dominio.StatusNotaFechada {
+		t.Errorf("esperava nota FECHADA, obteve %s", fechada.Status)
+	}
+	concluida, _ := banco.Solicitacoes().Buscar(ctx, sol.ID)
+	if concluida.Status != dominio.StatusSolicitacaoConcluida {
+		t.Errorf("esperava solicitacao CONCLUIDA, obteve %s", concluida.Status)
+	}
+	eventos, _ := banco.Outbox().Listar(ctx, repositorio.FiltroOutbox{Tipo: dominio.EventoNotaFechada})
+	if len(eventos) != 1 {
+		t.Errorf("esperava 1 Faturamento.NotaFechada, obteve %d", len(eventos))
+	}
+	instancia, err := banco.Sagas().BuscarPorSolicitacao(ctx, sol.ID)
+	if err != nil || instancia.Estado != dominio.EstadoSagaConcluida {
+		t.Errorf("esperava saga CONCLUIDA, obteve %+v (%v)", instancia, err)
+	}
+}
+
+func TestProcessarMensagem_EmpresaDaNota(t *testing.T) {
+	banco := repositorio.NovoBancoMemoria()
+	c := &consumidor.Consumidor{Banco: banco}
+	ctx := repositorio.ComEmpresa(context.Background(), "filial")
+
+	nota := dominio.NotaFiscal{Numero: "NF-EMPRESA", Status: dominio.StatusNotaAberta}
+	banco.Notas().Criar(ctx, &nota)
+	item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 10}
+	banco.Notas().AdicionarItem(ctx, &item)
+	sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-empresa"}
+	banco.Solicitacoes().Criar(ctx, &sol)
+
+	// a mensagem nao traz o cabecalho de empresa: vale a da nota
+	entrega := entregaReservado(nota.ID, []dominio.ItemReserva{{ProdutoID: item.ProdutoID.String(), Quantidade: 1}})
+	if err := c.ProcessarMensagem(entrega); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	eventos, _ := banco.Outbox().Listar(context.Background(), repositorio.FiltroOutbox{Tipo: dominio.EventoNotaFechada})
+	if len(eventos) != 1 || eventos[0].EmpresaID != "filial" {
+		t.Fatalf("esperava 1 Faturamento.NotaFechada da filial, obteve %+v", eventos)
+	}
+	if env := eventos[0].Envelope(); env.Empresa != "filial" {
+		t.Errorf("envelope deveria carregar a empresa, obteve %q", env.Empresa)
+	}
+}
+
+func TestProcessarMensagem_RegistroNaEmpresaDaNota(t *testing.T) {
+	db := bdteste.Abrir(t)
+	c := &consumidor.Consumidor{Banco: repositorio.NovoBancoPostgres(db)}
+
+	nota := dominio.NotaFiscal{EmpresaID: "filial", Numero: "NF-REGISTRO"}
+	db.Create(&nota)
+
+	// a nota nao tem solicitacao pendente: a mensagem e registrada como ignorada
+	entrega := entregaReservado(nota.ID, []dominio.ItemReserva{{ProdutoID: uuid.NewString(), Quantidade: 1}})
+	if err := c.ProcessarMensagem(entrega); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	var registro dominio.MensagemProcessada
+	if err := db.First(&registro, "id_mensagem = ?", entrega.ID).Error; err != nil {
+		t.Fatalf("mensagem deveria estar registrada: %v", err)
+	}
+	if registro.EmpresaID != "filial" {
+		t.Errorf("registro da mensagem deveria ficar na empresa da nota, obteve %q", registro.EmpresaID)
+	}
+}
+
+func TestProcessarMensagem_FechaComItensCongelados(t *testing.T) {
+	db := bdteste.Abrir(t)
+	banco := repositorio.NovoBancoPostgres(db)
+	c := &consumidor.Consumidor{Banco: banco}
+	ctx := context.Background()
+
+	nota := dominio.NotaFiscal{Numero: "NF-CONGELADA"}
+	banco.Notas().Criar(ctx, &nota)
+	item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 2, PrecoUnitario: 10}
+	banco.Notas().AdicionarItem(ctx, &item)
+	sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-congelada", Itens: dominio.CongelarItens([]dominio.ItemNota{item})}
+	if err := banco.Solicitacoes().Criar(ctx, &sol); err != nil {
+		t.Fatalf("erro ao criar solicitacao: %v", err)
+	}
+
+	// item gravado por fora depois do pedido: nao foi reservado
+	banco.Notas().AdicionarItem(ctx, &dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 99})
+
+	entrega := entregaReservado(nota.ID, []dominio.ItemReserva{{ProdutoID: item.ProdutoID.String(), Quantidade: 2}})
+	if err := c.ProcessarMensagem(entrega); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	if fechada, _ := banco.Notas().Buscar(ctx, nota.ID); fechada.Status != dominio.StatusNotaFechada {
+		t.Fatalf("reserva dos itens congelados deveria fechar a nota, status %s", fechada.Status)
+	}
+	var evento dominio.EventoOutbox
+	if err := db.Where("tipo_evento = ?", dominio.EventoNotaFechada).First(&evento).Error; err != nil {
+		t.Fatalf("esperava Faturamento.NotaFechada no outbox: %v", err)
+	}
+	var payload dominio.PayloadNotaFechada
+	json.Unmarshal([]byte(evento.Payload), &payload)
+	if payload.ValorTotal != 20 || len(payload.Itens) != 1 {
+		t.Errorf("NotaFechada deveria trazer so o item congelado (total 20), obteve %+v", payload)
+	}
+}
@@ -0,0 +1,129 @@
+package consumidor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/repositorio"
+)
+
+// MaxTentativasPadrao e quantas vezes uma entrega falha antes de sair da fila
+const MaxTentativasPadrao = 5
+
+// EsperaRetentativaPadrao e quanto uma entrega que falhou espera para voltar
+const EsperaRetentativaPadrao = 10 * time.Second
+
+// registrarFalha grava a falha da entrega em mensagens_quarentena e indica se
+// ela esgotou as tentativas e deve sair da fila
+func (c *Consumidor) registrarFalha(msg mensageria.Entrega, causa error) (bool, error) {
+	maxTentativas := c.MaxTentativas
+	if maxTentativas <= 0 {
+		maxTentativas = MaxTentativasPadrao
+	}
+
+	cabecalhos, err := cabecalhosJSON(msg.Cabecalhos)
+	if err != nil {
+		return false, err
+	}
+
+	chave := chaveQuarentena(msg.Mensagem)
+	agora := time.Now()
+	quarentenar := false
+
+	ctx := context.Background()
+	err = c.Banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+		registro, err := r.Quarentena().BuscarPorMensagem(ctx, chave)
+		if err != nil && !errors.Is(err, repositorio.ErrNaoEncontrado) {
+			return fmt.Errorf("falha ao buscar quarentena: %w", err)
+		}
+
+		// depois de resolvida (recuperada, reprocessada ou descartada), uma nova
+		// falha da mesma mensagem comeca outra contagem em vez de herdar a anterior
+		if registro.Resolvida() {
+			registro.Tentativas = 0
+			registro.DataPrimeira = agora
+		}
+		registro.Tentativas++
+		quarentenar = registro.Tentativas >= maxTentativas
+		status := dominio.StatusQuarentenaRetentando
+		if quarentenar {
+			status = dominio.StatusQuarentena
+		}
+
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
+			registro = dominio.MensagemQuarentena{
+				EmpresaID:     dominio.EnvelopeDeCabecalhos(msg.Cabecalhos, chave, msg.RoutingKey, nil).Empresa,
+				IDMensagem:    chave,
+				RoutingKey:    msg.RoutingKey,
+				CorrelationID: msg.CorrelationID,
+				Cabecalhos:    cabecalhos,
+				Corpo:         string(msg.Corpo),
+				Erro:          causa.Error(),
+				Tentativas:    registro.Tentativas,
+				Status:        status,
+				DataPrimeira:  agora,
+			}
+			return r.Quarentena().Criar(ctx, &registro)
+		}
+
+		registro.Erro = causa.Error()
+		registro.Status = status
+		registro.DataUltima = agora
+		registro.DataResolucao = nil
+		return r.Quarentena().Atualizar(ctx, &registro)
+	})
+	if err != nil {
+		return false, fmt.Errorf("falha ao registrar quarentena: %w", err)
+	}
+	return quarentenar, nil
+}
+
+// registrarRecuperacao fecha o registro de uma entrega que falhou antes e
+// passou numa reentrega
+func (c *Consumidor) registrarRecuperacao(msg mensageria.Entrega) error {
+	ctx := context.Background()
+	registro, err := c.Banco.Quarentena().BuscarPorMensagem(ctx, chaveQuarentena(msg.Mensagem))
+	if errors.Is(err, repositorio.ErrNaoEncontrado) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.Banco.Quarentena().Resolver(ctx, registro.ID, dominio.StatusQuarentenaRetentando, dominio.StatusQuarentenaRecuperada, nil, time.Now())
+	return err
+}
+
+// chaveQuarentena identifica a mensagem entre reentregas. Sem message id usa
+// o hash do conteudo, ja que a delivery tag muda a cada reentrega.
+func chaveQuarentena(msg mensageria.Mensagem) string {
+	if msg.ID != "" {
+		return msg.ID
+	}
+	soma := sha256.Sum256(append([]byte(msg.RoutingKey+"\n"), msg.Corpo...))
+	return "sha256:" + hex.EncodeToString(soma[:])
+}
+
+// cabecalhosJSON serializa os headers AMQP; valores binarios viram texto para
+// o envelope continuar legivel depois de reprocessado
+func cabecalhosJSON(cab map[string]interface{}) (string, error) {
+	normalizados := make(map[string]interface{}, len(cab))
+	for k, v := range cab {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		normalizados[k] = v
+	}
+
+	dados, err := json.Marshal(normalizados)
+	if err != nil {
+		return "", fmt.Errorf("falha ao serializar cabecalhos: %w", err)
+	}
+	return string(dados), nil
+}
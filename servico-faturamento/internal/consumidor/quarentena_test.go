@@ -0,0 +1,269 @@
+package consumidor_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/consumidor"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/manipulador"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ambienteQuarentena sobe o consumidor no broker em memoria e as rotas de
+// administracao da quarentena
+type ambienteQuarentena struct {
+	t      *testing.T
+	db     *gorm.DB
+	broker *mensageria.BrokerMemoria
+	router *gin.Engine
+}
+
+func novoAmbienteQuarentena(t *testing.T, espera time.Duration) *ambienteQuarentena {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CONSUMIDOR_MAX_TENTATIVAS", "3")
+	t.Setenv("CONSUMIDOR_ESPERA_RETENTATIVA", espera.String())
+
+	db := bdteste.Abrir(t)
+	broker := mensageria.NovoBrokerMemoria()
+	banco := repositorio.NovoBancoPostgres(db)
+	handlers := &manipulador.Handlers{Banco: banco}
+	handlers.Processador = &consumidor.Consumidor{Banco: banco}
+
+	if err := consumidor.IniciarConsumidor(banco, broker); err != nil {
+		t.Fatalf("falha ao iniciar consumidor: %v", err)
+	}
+
+	r := gin.New()
+	r.GET("/admin/quarentena", handlers.ListarQuarentena)
+	r.GET("/admin/quarentena/:id", handlers.BuscarQuarentena)
+	r.PUT("/admin/quarentena/:id", handlers.EditarQuarentena)
+	r.POST("/admin/quarentena/:id/reprocessar", handlers.ReprocessarQuarentena)
+	r.POST("/admin/quarentena/:id/descartar", handlers.DescartarQuarentena)
+
+	return &ambienteQuarentena{t: t, db: db, broker: broker, router: r}
+}
+
+func (a *ambienteQuarentena) requisitar(metodo, rota string, corpo interface{}) *httptest.ResponseRecorder {
+	a.t.Helper()
+
+	body, _ := json.Marshal(corpo)
+	req := httptest.NewRequest(metodo, rota, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	return w
+}
+
+// publicarVeneno envia um Estoque.Reservado que nao e JSON valido e espera
+// o consumidor desistir dele
+func (a *ambienteQuarentena) publicarVeneno(id string) dominio.MensagemQuarentena {
+	a.t.Helper()
+
+	env := dominio.Envelope{ID: id, Tipo: dominio.EventoEstoqueReservado, IDCorrelacao: "corr-" + id}
+	a.broker.Publicar(context.Background(), mensageria.ExchangeEstoque, mensageria.Mensagem{
+		ID:         id,
+		RoutingKey: dominio.EventoEstoqueReservado,
+		Cabecalhos: env.Cabecalhos(),
+		Corpo:      []byte(`{"notaId": `),
+	})
+
+	return a.aguardarQuarentena(id, dominio.StatusQuarentena)
+}
+
+// aguardarQuarentena espera o registro da mensagem chegar ao status
+func (a *ambienteQuarentena) aguardarQuarentena(id, status string) dominio.MensagemQuarentena {
+	a.t.Helper()
+
+	limite := time.Now().Add(5 * time.Second)
+	for time.Now().Before(limite) {
+		var registro dominio.MensagemQuarentena
+		if err := a.db.Where("id_mensagem = ?", id).Limit(1).Find(&registro).Error; err != nil {
+			a.t.Fatalf("erro ao buscar quarentena: %v", err)
+		}
+		if registro.Status == status {
+			return registro
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	a.t.Fatalf("mensagem %s nao chegou a %s", id, status)
+	return dominio.MensagemQuarentena{}
+}
+
+func TestQuarentena_EditarEReprocessar(t *testing.T) {
+	amb := novoAmbienteQuarentena(t, 10*time.Millisecond)
+
+	registro := amb.publicarVeneno("msg-veneno-1")
+	if registro.Tentativas != 3 || registro.Erro == "" || registro.Corpo != `{"notaId": ` {
+		t.Errorf("registro de quarentena inesperado: %+v", registro)
+	}
+
+	// fora da fila: nao deve haver novas tentativas
+	time.Sleep(50 * time.Millisecond)
+	amb.db.First(&registro, "id = ?", registro.ID)
+	if registro.Tentativas != 3 {
+		t.Errorf("mensagem em quarentena continuou sendo reentregue: %d tentativas", registro.Tentativas)
+	}
+
+	w := amb.requisitar(http.MethodGet, "/admin/quarentena?status="+dominio.StatusQuarentena, nil)
+	var lista []dominio.MensagemQuarentena
+	json.Unmarshal(w.Body.Bytes(), &lista)
+	if w.Code != http.StatusOK || len(lista) != 1 || lista[0].ID != registro.ID {
+		t.Fatalf("esperava a mensagem na listagem, obteve %d: %s", w.Code, w.Body.String())
+	}
+
+	w = amb.requisitar(http.MethodGet, "/admin/quarentena/"+registro.ID.String(), nil)
+	var detalhe dominio.MensagemQuarentena
+	json.Unmarshal(w.Body.Bytes(), &detalhe)
+	var cabecalhos map[string]interface{}
+	json.Unmarshal([]byte(detalhe.Cabecalhos), &cabecalhos)
+	if cabecalhos[dominio.CabecalhoCorrelacao] != "corr-msg-veneno-1" {
+		t.Errorf("esperava headers preservados, obteve %s", detalhe.Cabecalhos)
+	}
+
+	nota := dominio.NotaFiscal{Numero: "NF-QUARENTENA"}
+	amb.db.Create(&nota)
+	item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 2, PrecoUnitario: 10}
+	amb.db.Create(&item)
+	sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-quarentena"}
+	amb.db.Create(&sol)
+
+	corrigido, _ := json.Marshal(map[string]interface{}{
+		"notaId": nota.ID.String(),
+		"itens":  []dominio.ItemReserva{{ProdutoID: item.ProdutoID.String(), Quantidade: 2}},
+	})
+	w = amb.requisitar(http.MethodPut, "/admin/quarentena/"+registro.ID.String(), map[string]string{"corpo": string(corrigido)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200 ao editar, obteve %d: %s", w.Code, w.Body.String())
+	}
+
+	w = amb.requisitar(http.MethodPost, "/admin/quarentena/"+registro.ID.String()+"/reprocessar", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200 ao reprocessar, obteve %d: %s", w.Code, w.Body.String())
+	}
+	json.Unmarshal(w.Body.Bytes(), &registro)
+	if registro.Status != dominio.StatusQuarentenaReprocessada || !registro.Editada || registro.DataResolucao == nil {
+		t.Errorf("esperava mensagem REPROCESSADA e editada, obteve %+v", registro)
+	}
+
+	amb.db.First(&nota, "id = ?", nota.ID)
+	if nota.Status != dominio.StatusNotaFechada {
+		t.Errorf("esperava nota fechada pelo reprocessamento, obteve %s", nota.Status)
+	}
+
+	w = amb.requisitar(http.MethodPost, "/admin/quarentena/"+registro.ID.String()+"/reprocessar", nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("esperava 409 ao reprocessar mensagem resolvida, obteve %d", w.Code)
+	}
+}
+
+func TestQuarentena_ReprocessarSemCorrecaoEDescartar(t *testing.T) {
+	amb := novoAmbienteQuarentena(t, 10*time.Millisecond)
+
+	registro := amb.publicarVeneno("msg-veneno-2")
+	rota := "/admin/quarentena/" + registro.ID.String()
+
+	w := amb.requisitar(http.MethodPost, rota+"/reprocessar", nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("esperava 422 ao reprocessar sem correcao, obteve %d", w.Code)
+	}
+	amb.db.First(&registro, "id = ?", registro.ID)
+	if registro.Status != dominio.StatusQuarentena || registro.Tentativas != 4 {
+		t.Errorf("esperava mensagem ainda em quarentena com 4 tentativas, obteve %+v", registro)
+	}
+
+	w = amb.requisitar(http.MethodPost, rota+"/descartar", map[string]string{})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("esperava 400 ao descartar sem motivo, obteve %d", w.Code)
+	}
+
+	w = amb.requisitar(http.MethodPost, rota+"/descartar", map[string]string{"motivo": "payload corrompido na origem"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200 ao descartar, obteve %d: %s", w.Code, w.Body.String())
+	}
+	json.Unmarshal(w.Body.Bytes(), &registro)
+	if registro.Status != dominio.StatusQuarentenaDescartada || registro.MotivoDescarte == nil ||
+		*registro.MotivoDescarte != "payload corrompido na origem" {
+		t.Errorf("esperava mensagem DESCARTADA com motivo, obteve %+v", registro)
+	}
+
+	w = amb.requisitar(http.MethodPut, rota, map[string]string{"corpo": "{}"})
+	if w.Code != http.StatusConflict {
+		t.Errorf("esperava 409 ao editar mensagem descartada, obteve %d", w.Code)
+	}
+}
+
+func TestQuarentena_ReprocessarFalhaAoGravarTentativa(t *testing.T) {
+	amb := novoAmbienteQuarentena(t, 10*time.Millisecond)
+
+	registro := amb.publicarVeneno("msg-veneno-4")
+	amb.db.Callback().Update().Before("gorm:update").Register("teste:falhar_quarentena", func(tx *gorm.DB) {
+		if tx.Statement.Table == "mensagens_quarentena" {
+			tx.AddError(errors.New("disco cheio"))
+		}
+	})
+
+	w := amb.requisitar(http.MethodPost, "/admin/quarentena/"+registro.ID.String()+"/reprocessar", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("esperava 500 quando a tentativa nao e gravada, obteve %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestQuarentena_ReentregaAdiadaERecuperada(t *testing.T) {
+	amb := novoAmbienteQuarentena(t, 300*time.Millisecond)
+
+	corpo, _ := json.Marshal(dominio.PayloadProduto{ProdutoID: uuid.NewString(), Sku: "SKU-ADIADO", Nome: "Caderno", Ativo: true})
+	publicar := func() {
+		amb.broker.Publicar(context.Background(), mensageria.ExchangeEstoque, mensageria.Mensagem{
+			ID:         "msg-adiada",
+			RoutingKey: dominio.EventoProdutoCriado,
+			Corpo:      corpo,
+		})
+	}
+	// sem a tabela do catalogo o evento falha ate ela voltar
+	catalogoFora := func() { amb.db.Exec("ALTER TABLE produtos_catalogo RENAME TO produtos_catalogo_fora") }
+	catalogoDeVolta := func() { amb.db.Exec("ALTER TABLE produtos_catalogo_fora RENAME TO produtos_catalogo") }
+
+	catalogoFora()
+	publicar()
+	registro := amb.aguardarQuarentena("msg-adiada", dominio.StatusQuarentenaRetentando)
+
+	// a reentrega espera CONSUMIDOR_ESPERA_RETENTATIVA em vez de voltar na hora
+	time.Sleep(100 * time.Millisecond)
+	amb.db.First(&registro, "id = ?", registro.ID)
+	if registro.Tentativas != 1 {
+		t.Errorf("mensagem reentregue antes da espera: %d tentativas", registro.Tentativas)
+	}
+
+	catalogoDeVolta()
+	registro = amb.aguardarQuarentena("msg-adiada", dominio.StatusQuarentenaRecuperada)
+	if registro.Tentativas != 1 {
+		t.Errorf("esperava recuperacao na segunda entrega, obteve %d tentativas", registro.Tentativas)
+	}
+
+	// a retencao apagou o registro de processada e a mensagem volta a falhar:
+	// a contagem recomeca em vez de herdar a da recuperacao
+	amb.db.Where("id_mensagem = ?", "msg-adiada").Delete(&dominio.MensagemProcessada{})
+	catalogoFora()
+	publicar()
+	registro = amb.aguardarQuarentena("msg-adiada", dominio.StatusQuarentenaRetentando)
+	if registro.Tentativas != 1 {
+		t.Errorf("esperava contagem nova apos a recuperacao, obteve %d tentativas", registro.Tentativas)
+	}
+	catalogoDeVolta()
+}
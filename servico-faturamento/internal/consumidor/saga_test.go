@@ -0,0 +1,457 @@
+package consumidor_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/consumidor"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/manipulador"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/publicador"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ambienteSaga sobe Faturamento (HTTP + outbox + consumidor) e um Estoque
+// falso sobre o broker em memoria
+type ambienteSaga struct {
+	t      *testing.T
+	db     *gorm.DB
+	broker *mensageria.BrokerMemoria
+	pub    *publicador.PublicadorOutbox
+	router *gin.Engine
+}
+
+func novoAmbienteSaga(t *testing.T, saldo map[uuid.UUID]int) *ambienteSaga {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db := bdteste.Abrir(t)
+	broker := mensageria.NovoBrokerMemoria()
+	banco := repositorio.NovoBancoPostgres(db)
+	handlers := &manipulador.Handlers{Banco: banco}
+
+	if err := consumidor.IniciarConsumidor(banco, broker); err != nil {
+		t.Fatalf("falha ao iniciar consumidor: %v", err)
+	}
+	iniciarEstoqueFalso(t, broker, saldo)
+
+	r := gin.New()
+	r.POST("/notas", handlers.CriarNota)
+	r.POST("/notas/:id/itens", handlers.AdicionarItem)
+	r.POST("/notas/:id/imprimir", handlers.ImprimirNota)
+	r.GET("/solicitacoes-impressao/:id", handlers.ConsultarStatusImpressao)
+	r.POST("/solicitacoes-impressao/:id/reprocessar", handlers.ReprocessarImpressao)
+	r.GET("/sagas", handlers.ListarSagas)
+	r.GET("/sagas/:id", handlers.BuscarSaga)
+
+	amb := &ambienteSaga{
+		t:      t,
+		db:     db,
+		broker: broker,
+		pub:    &publicador.PublicadorOutbox{Banco: banco, Broker: broker},
+		router: r,
+	}
+	for produto := range saldo {
+		amb.publicarProduto(dominio.EventoProdutoCriado, produto, true)
+	}
+	return amb
+}
+
+// publicarProduto emite um evento de produto como o Estoque e espera o
+// catalogo do Faturamento refletir o estado
+func (a *ambienteSaga) publicarProduto(tipo string, produto uuid.UUID, ativo bool) {
+	a.t.Helper()
+
+	corpo, _ := json.Marshal(dominio.PayloadProduto{
+		ProdutoID: produto.String(),
+		Sku:       "SKU-" + produto.String()[:8],
+		Nome:      "Produto " + produto.String()[:8],
+		Ativo:     ativo,
+	})
+	a.broker.Publicar(context.Background(), mensageria.ExchangeEstoque, mensageria.Mensagem{
+		ID:         uuid.NewString(),
+		RoutingKey: tipo,
+		Timestamp:  time.Now(),
+		Corpo:      corpo,
+	})
+
+	limite := time.Now().Add(5 * time.Second)
+	for time.Now().Before(limite) {
+		var catalogo dominio.ProdutoCatalogo
+		a.db.Limit(1).Find(&catalogo, "id = ?", produto)
+		if catalogo.ID == produto && catalogo.Ativo == ativo {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.t.Fatalf("catalogo nao recebeu o produto %s", produto)
+}
+
+// iniciarEstoqueFalso reserva se houver saldo para todos os itens e propaga
+// o envelope como o servico real deveria fazer
+func iniciarEstoqueFalso(t *testing.T, broker *mensageria.BrokerMemoria, saldo map[uuid.UUID]int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	entregas, err := broker.Consumir(ctx, mensageria.Assinatura{
+		Exchange:    mensageria.ExchangeFaturamento,
+		Fila:        mensageria.FilaEstoque,
+		RoutingKeys: []string{dominio.EventoImpressaoSolicitada},
+	})
+	if err != nil {
+		t.Fatalf("falha ao iniciar estoque falso: %v", err)
+	}
+
+	go func() {
+		for entrega := range entregas {
+			var pedido struct {
+				NotaID        string `json:"notaId"`
+				SolicitacaoID string `json:"solicitacaoId"`
+				Itens         []struct {
+					ProdutoID  uuid.UUID `json:"produtoId"`
+					Quantidade int       `json:"quantidade"`
+				} `json:"itens"`
+			}
+			json.Unmarshal(entrega.Corpo, &pedido)
+
+			routingKey := dominio.EventoEstoqueReservado
+			var corpo interface{} = map[string]interface{}{"notaId": pedido.NotaID, "solicitacaoId": pedido.SolicitacaoID, "itens": pedido.Itens}
+			for _, item := range pedido.Itens {
+				if saldo[item.ProdutoID] < item.Quantidade {
+					routingKey = dominio.EventoReservaRejeitada
+					corpo = map[string]string{"notaId": pedido.NotaID, "solicitacaoId": pedido.SolicitacaoID, "motivo": "Saldo insuficiente"}
+				}
+			}
+
+			recebido := dominio.EnvelopeDeCabecalhos(entrega.Cabecalhos, entrega.ID, entrega.RoutingKey, entrega.Corpo)
+			resposta := dominio.Envelope{
+				ID:           uuid.NewString(),
+				Tipo:         routingKey,
+				Origem:       "servico-estoque",
+				VersaoSpec:   dominio.VersaoSpecEnvelope,
+				VersaoSchema: dominio.VersaoSchemaPadrao,
+				Data:         time.Now(),
+				IDCorrelacao: recebido.Causado().IDCorrelacao,
+				IDCausacao:   recebido.Causado().IDCausacao,
+			}
+
+			body, _ := json.Marshal(corpo)
+			broker.Publicar(ctx, mensageria.ExchangeEstoque, mensageria.Mensagem{
+				ID:         resposta.ID,
+				RoutingKey: routingKey,
+				Cabecalhos: resposta.Cabecalhos(),
+				Corpo:      body,
+			})
+			entrega.Ack()
+		}
+	}()
+}
+
+func (a *ambienteSaga) requisitar(metodo, rota string, corpo interface{}, cabecalhos map[string]string) *httptest.ResponseRecorder {
+	a.t.Helper()
+
+	body, _ := json.Marshal(corpo)
+	req := httptest.NewRequest(metodo, rota, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range cabecalhos {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	return w
+}
+
+// criarNotaComItem cria a nota via HTTP e devolve seu id
+func (a *ambienteSaga) criarNotaComItem(numero string, produtoID uuid.UUID, quantidade int) uuid.UUID {
+	a.t.Helper()
+
+	w := a.requisitar(http.MethodPost, "/notas", map[string]string{"numero": numero}, nil)
+	if w.Code != http.StatusCreated {
+		a.t.Fatalf("esperava 201 ao criar nota, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var nota dominio.NotaFiscal
+	json.Unmarshal(w.Body.Bytes(), &nota)
+
+	w = a.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/itens", map[string]interface{}{
+		"produtoId":     produtoID.String(),
+		"quantidade":    quantidade,
+		"precoUnitario": 10.0,
+	}, nil)
+	if w.Code != http.StatusCreated {
+		a.t.Fatalf("esperava 201 ao adicionar item, obteve %d: %s", w.Code, w.Body.String())
+	}
+
+	return nota.ID
+}
+
+// aguardarSolicitacao roda o publicador ate a solicitacao sair de PENDENTE
+func (a *ambienteSaga) aguardarSolicitacao(id uuid.UUID) dominio.SolicitacaoImpressao {
+	a.t.Helper()
+
+	limite := time.Now().Add(5 * time.Second)
+	for time.Now().Before(limite) {
+		if _, err := a.pub.PublicarPendentes(context.Background()); err != nil {
+			a.t.Fatalf("erro ao publicar outbox: %v", err)
+		}
+
+		var sol dominio.SolicitacaoImpressao
+		if err := a.db.First(&sol, "id = ?", id).Error; err != nil {
+			a.t.Fatalf("erro ao buscar solicitacao: %v", err)
+		}
+		if sol.Status != dominio.StatusSolicitacaoPendente {
+			a.pub.PublicarPendentes(context.Background())
+			return sol
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	a.t.Fatalf("solicitacao %s continuou PENDENTE", id)
+	return dominio.SolicitacaoImpressao{}
+}
+
+func (a *ambienteSaga) publicada(routingKey string) (mensageria.Mensagem, bool) {
+	for _, msg := range a.broker.Publicadas() {
+		if msg.RoutingKey == routingKey {
+			return msg, true
+		}
+	}
+	return mensageria.Mensagem{}, false
+}
+
+func TestSaga_ImpressaoComEstoqueReservado(t *testing.T) {
+	produto := uuid.New()
+	amb := novoAmbienteSaga(t, map[uuid.UUID]int{produto: 10})
+
+	notaID := amb.criarNotaComItem("NF-SAGA-1", produto, 3)
+
+	w := amb.requisitar(http.MethodPost, "/notas/"+notaID.String()+"/imprimir", nil, map[string]string{
+		"Idempotency-Key":  "chave-saga-1",
+		"X-Correlation-ID": "corr-saga-1",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201 ao imprimir, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var sol dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &sol)
+
+	sol = amb.aguardarSolicitacao(sol.ID)
+	if sol.Status != dominio.StatusSolicitacaoConcluida {
+		t.Fatalf("esperava solicitacao CONCLUIDA, obteve %s", sol.Status)
+	}
+
+	var nota dominio.NotaFiscal
+	amb.db.First(&nota, "id = ?", notaID)
+	if nota.Status != dominio.StatusNotaFechada {
+		t.Errorf("esperava nota FECHADA, obteve %s", nota.Status)
+	}
+
+	fechada, ok := amb.publicada(dominio.EventoNotaFechada)
+	if !ok {
+		t.Fatal("esperava Faturamento.NotaFechada publicado")
+	}
+	env := dominio.EnvelopeDeCabecalhos(fechada.Cabecalhos, fechada.ID, fechada.RoutingKey, fechada.Corpo)
+	if env.IDCorrelacao != "corr-saga-1" {
+		t.Errorf("esperava correlacao propagada corr-saga-1, obteve %s", env.IDCorrelacao)
+	}
+	reservado, _ := amb.publicada(dominio.EventoEstoqueReservado)
+	if env.IDCausacao != reservado.ID {
+		t.Errorf("esperava causacao %s (Estoque.Reservado), obteve %s", reservado.ID, env.IDCausacao)
+	}
+
+	instancia := amb.buscarSaga(sol.ID)
+	if instancia.Estado != dominio.EstadoSagaConcluida || instancia.DataFim == nil {
+		t.Errorf("esperava saga CONCLUIDA e finalizada, obteve %s", instancia.Estado)
+	}
+	passos := []string{dominio.EventoImpressaoSolicitada, dominio.EventoEstoqueReservado, dominio.EventoNotaFechada}
+	if len(instancia.Passos) != len(passos) {
+		t.Fatalf("esperava %d passos, obteve %+v", len(passos), instancia.Passos)
+	}
+	for i, nome := range passos {
+		if instancia.Passos[i].Nome != nome {
+			t.Errorf("passo %d: esperava %s, obteve %s", i, nome, instancia.Passos[i].Nome)
+		}
+	}
+	if instancia.Passos[1].Direcao != dominio.DirecaoRecebida || instancia.Passos[1].IDMensagem != reservado.ID {
+		t.Errorf("esperava Estoque.Reservado registrado como recebido, obteve %+v", instancia.Passos[1])
+	}
+}
+
+// buscarSaga usa a API de consulta: lista por solicitacao e busca os detalhes
+func (a *ambienteSaga) buscarSaga(solicitacaoID uuid.UUID) dominio.SagaFaturamento {
+	a.t.Helper()
+
+	w := a.requisitar(http.MethodGet, "/sagas?solicitacaoId="+solicitacaoID.String(), nil, nil)
+	var lista []dominio.SagaFaturamento
+	json.Unmarshal(w.Body.Bytes(), &lista)
+	if w.Code != http.StatusOK || len(lista) != 1 {
+		a.t.Fatalf("esperava 1 saga para a solicitacao, obteve %d: %s", w.Code, w.Body.String())
+	}
+
+	w = a.requisitar(http.MethodGet, "/sagas/"+lista[0].ID.String(), nil, nil)
+	var instancia dominio.SagaFaturamento
+	json.Unmarshal(w.Body.Bytes(), &instancia)
+	if w.Code != http.StatusOK {
+		a.t.Fatalf("esperava 200 ao buscar saga, obteve %d: %s", w.Code, w.Body.String())
+	}
+	return instancia
+}
+
+func TestSaga_ImpressaoComReservaRejeitada(t *testing.T) {
+	produto := uuid.New()
+	amb := novoAmbienteSaga(t, map[uuid.UUID]int{produto: 1})
+
+	notaID := amb.criarNotaComItem("NF-SAGA-2", produto, 5)
+
+	w := amb.requisitar(http.MethodPost, "/notas/"+notaID.String()+"/imprimir", nil, map[string]string{
+		"Idempotency-Key": "chave-saga-2",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201 ao imprimir, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var sol dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &sol)
+
+	sol = amb.aguardarSolicitacao(sol.ID)
+	if sol.Status != dominio.StatusSolicitacaoFalhou {
+		t.Fatalf("esperava solicitacao FALHOU, obteve %s", sol.Status)
+	}
+	if sol.MensagemErro == nil || *sol.MensagemErro != "Saldo insuficiente" {
+		t.Errorf("esperava motivo da rejeicao na solicitacao, obteve %v", sol.MensagemErro)
+	}
+
+	var nota dominio.NotaFiscal
+	amb.db.First(&nota, "id = ?", notaID)
+	if nota.Status != dominio.StatusNotaAberta {
+		t.Errorf("esperava nota ABERTA, obteve %s", nota.Status)
+	}
+
+	if _, ok := amb.publicada(dominio.EventoImpressaoFalhou); !ok {
+		t.Error("esperava Faturamento.ImpressaoFalhou publicado")
+	}
+
+	instancia := amb.buscarSaga(sol.ID)
+	if instancia.Estado != dominio.EstadoSagaFalhou || instancia.PassoAtual != dominio.EventoImpressaoFalhou {
+		t.Errorf("esperava saga FALHOU em ImpressaoFalhou, obteve %s em %s", instancia.Estado, instancia.PassoAtual)
+	}
+}
+
+func TestSaga_ReprocessarSolicitacaoFalhou(t *testing.T) {
+	produto := uuid.New()
+	amb := novoAmbienteSaga(t, map[uuid.UUID]int{produto: 1})
+
+	notaID := amb.criarNotaComItem("NF-SAGA-3", produto, 5)
+
+	w := amb.requisitar(http.MethodPost, "/notas/"+notaID.String()+"/imprimir", nil, map[string]string{
+		"Idempotency-Key":  "chave-saga-3",
+		"X-Correlation-ID": "corr-saga-3",
+	})
+	var original dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &original)
+	original = amb.aguardarSolicitacao(original.ID)
+	if original.Status != dominio.StatusSolicitacaoFalhou {
+		t.Fatalf("esperava solicitacao FALHOU, obteve %s", original.Status)
+	}
+
+	w = amb.requisitar(http.MethodPost, "/solicitacoes-impressao/"+original.ID.String()+"/reprocessar", nil, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201 ao reprocessar, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var segunda dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &segunda)
+	if segunda.SolicitacaoOrigemID == nil || *segunda.SolicitacaoOrigemID != original.ID || segunda.Tentativa != 2 {
+		t.Errorf("esperava tentativa 2 ligada a %s, obteve %+v", original.ID, segunda)
+	}
+	if segunda.IDCorrelacao != "corr-saga-3" {
+		t.Errorf("esperava a tentativa na correlacao da original, obteve %s", segunda.IDCorrelacao)
+	}
+
+	// a original ja tem uma tentativa mais recente
+	w = amb.requisitar(http.MethodPost, "/solicitacoes-impressao/"+original.ID.String()+"/reprocessar", nil, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("esperava 409 ao reprocessar a original de novo, obteve %d", w.Code)
+	}
+
+	// enquanto pendente a tentativa nao pode ser reprocessada
+	w = amb.requisitar(http.MethodPost, "/solicitacoes-impressao/"+segunda.ID.String()+"/reprocessar", nil, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("esperava 409 ao reprocessar tentativa pendente, obteve %d", w.Code)
+	}
+
+	segunda = amb.aguardarSolicitacao(segunda.ID)
+	if segunda.Status != dominio.StatusSolicitacaoFalhou {
+		t.Fatalf("esperava segunda tentativa FALHOU, obteve %s", segunda.Status)
+	}
+
+	solicitadas := 0
+	for _, msg := range amb.broker.Publicadas() {
+		if msg.RoutingKey == dominio.EventoImpressaoSolicitada {
+			solicitadas++
+		}
+	}
+	if solicitadas != 2 {
+		t.Errorf("esperava ImpressaoSolicitada reenviada, obteve %d publicacoes", solicitadas)
+	}
+
+	w = amb.requisitar(http.MethodGet, "/solicitacoes-impressao/"+original.ID.String(), nil, nil)
+	var historico dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &historico)
+	if historico.Tentativas != 2 || len(historico.Retentativas) != 1 || historico.Retentativas[0].ID != segunda.ID {
+		t.Errorf("esperava original com 2 tentativas e historico, obteve %+v", historico)
+	}
+	if historico.Status != dominio.StatusSolicitacaoFalhou || historico.MensagemErro == nil {
+		t.Errorf("esperava original preservada como FALHOU, obteve %+v", historico)
+	}
+
+	if instancia := amb.buscarSaga(segunda.ID); instancia.Estado != dominio.EstadoSagaFalhou {
+		t.Errorf("esperava saga propria da tentativa em FALHOU, obteve %s", instancia.Estado)
+	}
+}
+
+func TestSaga_AdicionarItemValidaCatalogo(t *testing.T) {
+	produto := uuid.New()
+	amb := novoAmbienteSaga(t, map[uuid.UUID]int{produto: 10})
+
+	w := amb.requisitar(http.MethodPost, "/notas", map[string]string{"numero": "NF-CATALOGO"}, nil)
+	var nota dominio.NotaFiscal
+	json.Unmarshal(w.Body.Bytes(), &nota)
+	rota := "/notas/" + nota.ID.String() + "/itens"
+
+	w = amb.requisitar(http.MethodPost, rota, map[string]interface{}{
+		"produtoId": produto.String(), "quantidade": 1, "precoUnitario": 10.0,
+	}, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201 para produto ativo, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var item dominio.ItemNota
+	json.Unmarshal(w.Body.Bytes(), &item)
+	if item.Sku != "SKU-"+produto.String()[:8] || item.NomeProduto != "Produto "+produto.String()[:8] {
+		t.Errorf("esperava sku e nome preenchidos pelo catalogo, obteve %+v", item)
+	}
+
+	w = amb.requisitar(http.MethodPost, rota, map[string]interface{}{
+		"produtoId": uuid.NewString(), "quantidade": 1, "precoUnitario": 10.0,
+	}, nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("esperava 422 para produto desconhecido, obteve %d", w.Code)
+	}
+
+	amb.publicarProduto(dominio.EventoProdutoAtualizado, produto, false)
+	w = amb.requisitar(http.MethodPost, rota, map[string]interface{}{
+		"produtoId": produto.String(), "quantidade": 1, "precoUnitario": 10.0,
+	}, nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("esperava 422 para produto inativo, obteve %d", w.Code)
+	}
+}
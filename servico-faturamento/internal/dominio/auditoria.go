@@ -0,0 +1,155 @@
+package dominio
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Operacoes registradas na trilha de auditoria
+const (
+	OperacaoAuditoriaInsercao  = "INSERT"
+	OperacaoAuditoriaAlteracao = "UPDATE"
+	OperacaoAuditoriaRemocao   = "DELETE"
+)
+
+// AtorSistema assina as escritas que nao vem de um usuario (consumidor,
+// varredor de timeout)
+const AtorSistema = "sistema"
+
+// Autoria identifica quem fez uma escrita e a requisicao (ou mensagem) que a
+// causou
+type Autoria struct {
+	Ator         string
+	IDRequisicao string
+}
+
+// Auditavel e implementado pelas entidades com trilha de auditoria
+type Auditavel interface {
+	TableName() string
+	// ReferenciaAuditoria identifica o registro, a nota a que ele pertence e
+	// a empresa dona dos dois
+	ReferenciaAuditoria() (registroID, notaID uuid.UUID, empresaID string)
+}
+
+func (n *NotaFiscal) ReferenciaAuditoria() (uuid.UUID, uuid.UUID, string) {
+	return n.ID, n.ID, n.EmpresaID
+}
+
+func (i *ItemNota) ReferenciaAuditoria() (uuid.UUID, uuid.UUID, string) {
+	return i.ID, i.NotaID, i.EmpresaID
+}
+
+func (s *SolicitacaoImpressao) ReferenciaAuditoria() (uuid.UUID, uuid.UUID, string) {
+	return s.ID, s.NotaID, s.EmpresaID
+}
+
+// AlteracaoCampo e o valor de um campo antes e depois da escrita; na insercao
+// Antes e nulo e na remocao Depois e nulo
+type AlteracaoCampo struct {
+	Antes  interface{} `json:"antes"`
+	Depois interface{} `json:"depois"`
+}
+
+// RegistroAuditoria e uma escrita em notas_fiscais, itens_nota ou
+// solicitacoes_impressao: quem fez, quando e o que mudou em cada campo.
+// Alteracoes guarda um objeto JSON campo -> AlteracaoCampo.
+type RegistroAuditoria struct {
+	ID           int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	EmpresaID    string    `gorm:"not null" json:"empresaId"`
+	Tabela       string    `gorm:"not null" json:"tabela"`
+	RegistroID   uuid.UUID `gorm:"type:uuid;not null;index:idx_auditoria_registro" json:"registroId"`
+	NotaID       uuid.UUID `gorm:"type:uuid;not null;index:idx_auditoria_nota" json:"notaId"`
+	Operacao     string    `gorm:"not null" json:"operacao"`
+	Ator         string    `gorm:"not null" json:"ator"`
+	IDRequisicao string    `json:"idRequisicao,omitempty"`
+	Alteracoes   string    `gorm:"type:jsonb;not null" json:"alteracoes"`
+	DataRegistro time.Time `gorm:"not null" json:"dataRegistro"`
+}
+
+func (RegistroAuditoria) TableName() string {
+	return "auditoria"
+}
+
+// NovoRegistroAuditoria compara antes e depois campo a campo (pelos nomes do
+// JSON da API). antes nil e uma insercao e depois nil uma remocao. Retorna
+// false se a escrita nao mudou nenhum campo, e entao nao ha o que registrar.
+func NovoRegistroAuditoria(antes, depois Auditavel, autoria Autoria, quando time.Time) (RegistroAuditoria, bool, error) {
+	registro := RegistroAuditoria{
+		Ator:         autoria.Ator,
+		IDRequisicao: autoria.IDRequisicao,
+		DataRegistro: quando,
+	}
+	if registro.Ator == "" {
+		registro.Ator = AtorSistema
+	}
+
+	referencia := depois
+	switch {
+	case antes == nil:
+		registro.Operacao = OperacaoAuditoriaInsercao
+	case depois == nil:
+		registro.Operacao = OperacaoAuditoriaRemocao
+		referencia = antes
+	default:
+		registro.Operacao = OperacaoAuditoriaAlteracao
+	}
+	registro.Tabela = referencia.TableName()
+	registro.RegistroID, registro.NotaID, registro.EmpresaID = referencia.ReferenciaAuditoria()
+
+	camposAntes, err := camposAuditados(antes)
+	if err != nil {
+		return registro, false, err
+	}
+	camposDepois, err := camposAuditados(depois)
+	if err != nil {
+		return registro, false, err
+	}
+
+	alteracoes := map[string]AlteracaoCampo{}
+	for campo, valor := range camposAntes {
+		if !reflect.DeepEqual(valor, camposDepois[campo]) {
+			alteracoes[campo] = AlteracaoCampo{Antes: valor, Depois: camposDepois[campo]}
+		}
+	}
+	for campo, valor := range camposDepois {
+		if _, ok := camposAntes[campo]; !ok {
+			alteracoes[campo] = AlteracaoCampo{Depois: valor}
+		}
+	}
+	if len(alteracoes) == 0 {
+		return registro, false, nil
+	}
+
+	corpo, err := json.Marshal(alteracoes)
+	if err != nil {
+		return registro, false, err
+	}
+	registro.Alteracoes = string(corpo)
+	return registro, true, nil
+}
+
+// camposAuditados serializa a entidade como a API a devolve. Listas sao
+// relacoes (itens, retentativas), auditadas nas proprias tabelas.
+func camposAuditados(entidade Auditavel) (map[string]interface{}, error) {
+	campos := map[string]interface{}{}
+	if entidade == nil {
+		return campos, nil
+	}
+
+	corpo, err := json.Marshal(entidade)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(corpo, &campos); err != nil {
+		return nil, err
+	}
+	for campo, valor := range campos {
+		if _, lista := valor.([]interface{}); lista {
+			delete(campos, campo)
+		}
+	}
+	return campos, nil
+}
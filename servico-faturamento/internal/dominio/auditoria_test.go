@@ -0,0 +1,77 @@
+package dominio_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+
+	"github.com/google/uuid"
+)
+
+func alteracoes(t *testing.T, registro dominio.RegistroAuditoria) map[string]dominio.AlteracaoCampo {
+	t.Helper()
+	var campos map[string]dominio.AlteracaoCampo
+	if err := json.Unmarshal([]byte(registro.Alteracoes), &campos); err != nil {
+		t.Fatalf("alteracoes nao sao JSON: %v", err)
+	}
+	return campos
+}
+
+func TestNovoRegistroAuditoria_Insercao(t *testing.T) {
+	nota := dominio.NotaFiscal{ID: uuid.New(), Numero: "NF-1", Status: dominio.StatusNotaAberta}
+	item := dominio.ItemNota{ID: uuid.New(), NotaID: nota.ID, Quantidade: 2}
+
+	registro, mudou, err := dominio.NovoRegistroAuditoria(nil, &item, dominio.Autoria{IDRequisicao: "req-1"}, time.Now())
+	if err != nil || !mudou {
+		t.Fatalf("insercao deveria gerar registro, obteve %v %v", mudou, err)
+	}
+	if registro.Operacao != dominio.OperacaoAuditoriaInsercao || registro.Tabela != "itens_nota" ||
+		registro.RegistroID != item.ID || registro.NotaID != nota.ID {
+		t.Errorf("referencia errada: %+v", registro)
+	}
+	if registro.Ator != dominio.AtorSistema || registro.IDRequisicao != "req-1" {
+		t.Errorf("sem ator a escrita e do sistema, obteve %q %q", registro.Ator, registro.IDRequisicao)
+	}
+	if campo := alteracoes(t, registro)["quantidade"]; campo.Antes != nil || campo.Depois != float64(2) {
+		t.Errorf("esperava quantidade nula -> 2, obteve %+v", campo)
+	}
+}
+
+func TestNovoRegistroAuditoria_AlteracaoSoCamposMudados(t *testing.T) {
+	antes := dominio.NotaFiscal{ID: uuid.New(), Numero: "NF-2", Status: dominio.StatusNotaAberta,
+		Itens: []dominio.ItemNota{{ID: uuid.New(), Quantidade: 1}}}
+	depois := antes
+	if err := depois.Fechar(); err != nil {
+		t.Fatalf("falha ao fechar: %v", err)
+	}
+
+	registro, mudou, err := dominio.NovoRegistroAuditoria(&antes, &depois, dominio.Autoria{Ator: "ana"}, time.Now())
+	if err != nil || !mudou {
+		t.Fatalf("alteracao deveria gerar registro, obteve %v %v", mudou, err)
+	}
+	campos := alteracoes(t, registro)
+	if len(campos) != 2 || campos["status"].Antes != dominio.StatusNotaAberta || campos["status"].Depois != dominio.StatusNotaFechada {
+		t.Errorf("esperava so status e dataFechada, obteve %+v", campos)
+	}
+	if _, ok := campos["dataFechada"]; !ok || registro.Operacao != dominio.OperacaoAuditoriaAlteracao || registro.Ator != "ana" {
+		t.Errorf("registro inesperado: %+v", registro)
+	}
+
+	if _, mudou, _ := dominio.NovoRegistroAuditoria(&depois, &depois, dominio.Autoria{}, time.Now()); mudou {
+		t.Error("escrita sem mudanca nao deveria gerar registro")
+	}
+}
+
+func TestNovoRegistroAuditoria_Remocao(t *testing.T) {
+	sol := dominio.SolicitacaoImpressao{ID: uuid.New(), NotaID: uuid.New(), Status: dominio.StatusSolicitacaoPendente}
+
+	registro, mudou, err := dominio.NovoRegistroAuditoria(&sol, nil, dominio.Autoria{}, time.Now())
+	if err != nil || !mudou || registro.Operacao != dominio.OperacaoAuditoriaRemocao || registro.NotaID != sol.NotaID {
+		t.Fatalf("remocao deveria gerar registro da nota %s, obteve %+v %v", sol.NotaID, registro, err)
+	}
+	if campo := alteracoes(t, registro)["status"]; campo.Antes != dominio.StatusSolicitacaoPendente || campo.Depois != nil {
+		t.Errorf("esperava status PENDENTE -> nulo, obteve %+v", campo)
+	}
+}
@@ -0,0 +1,189 @@
+package dominio
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Metadados fixos do envelope (estilo CloudEvents)
+const (
+	OrigemFaturamento   = "servico-faturamento"
+	VersaoSpecEnvelope  = "1.0"
+	VersaoSchemaPadrao  = "1"
+	prefixoCabecalhoCE  = "cloudEvents:"
+	CabecalhoCorrelacao = prefixoCabecalhoCE + "correlationid"
+	CabecalhoCausacao   = prefixoCabecalhoCE + "causationid"
+	// CabecalhoEmpresa e a extensao com a empresa (tenant) dona do evento
+	CabecalhoEmpresa = prefixoCabecalhoCE + "empresa"
+)
+
+// Envelope carrega os metadados de rastreio de um evento. No outbox fica em
+// colunas proprias; no broker vai nos headers AMQP (modo binario do
+// CloudEvents), mantendo o body com o payload puro que o Estoque ja entende.
+type Envelope struct {
+	ID           string          `json:"id"`
+	Tipo         string          `json:"type"`
+	Origem       string          `json:"source"`
+	VersaoSpec   string          `json:"specversion"`
+	VersaoSchema string          `json:"schemaversion"`
+	Data         time.Time       `json:"time"`
+	IDCorrelacao string          `json:"correlationid"`
+	IDCausacao   string          `json:"causationid,omitempty"`
+	Empresa      string          `json:"empresa,omitempty"`
+	Dados        json.RawMessage `json:"data,omitempty"`
+}
+
+// ContextoEvento identifica a saga (correlacao) e a mensagem que originou o
+// proximo evento (causacao)
+type ContextoEvento struct {
+	IDCorrelacao string
+	IDCausacao   string
+}
+
+// NovoContextoEvento inicia uma nova cadeia de eventos. Se a requisicao ja
+// trouxe um id de correlacao ele e reaproveitado.
+func NovoContextoEvento(idCorrelacao string) ContextoEvento {
+	if idCorrelacao == "" {
+		idCorrelacao = uuid.NewString()
+	}
+	return ContextoEvento{IDCorrelacao: idCorrelacao}
+}
+
+// Causado retorna o contexto para eventos disparados por este envelope
+func (e Envelope) Causado() ContextoEvento {
+	correlacao := e.IDCorrelacao
+	if correlacao == "" {
+		correlacao = e.ID
+	}
+	return ContextoEvento{IDCorrelacao: correlacao, IDCausacao: e.ID}
+}
+
+// NovoEventoOutbox serializa o payload e preenche o envelope do evento
+func NovoEventoOutbox(tipo string, idAgregado uuid.UUID, payload interface{}, ctx ContextoEvento) (EventoOutbox, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return EventoOutbox{}, fmt.Errorf("falha ao serializar payload: %w", err)
+	}
+
+	if ctx.IDCorrelacao == "" {
+		ctx = NovoContextoEvento("")
+	}
+
+	return EventoOutbox{
+		IDEvento:       uuid.New(),
+		TipoEvento:     tipo,
+		IdAgregado:     idAgregado,
+		Payload:        string(payloadJSON),
+		DataOcorrencia: time.Now(),
+		Origem:         OrigemFaturamento,
+		VersaoSchema:   VersaoSchemaPadrao,
+		IDCorrelacao:   ctx.IDCorrelacao,
+		IDCausacao:     ctx.IDCausacao,
+	}, nil
+}
+
+// Envelope monta o envelope a partir das colunas do outbox
+func (e *EventoOutbox) Envelope() Envelope {
+	id := e.IDEvento.String()
+	if e.IDEvento == uuid.Nil {
+		// eventos gravados antes do envelope existir
+		id = fmt.Sprintf("%d", e.ID)
+	}
+
+	origem := e.Origem
+	if origem == "" {
+		origem = OrigemFaturamento
+	}
+	versao := e.VersaoSchema
+	if versao == "" {
+		versao = VersaoSchemaPadrao
+	}
+	correlacao := e.IDCorrelacao
+	if correlacao == "" {
+		correlacao = id
+	}
+
+	return Envelope{
+		ID:           id,
+		Tipo:         e.TipoEvento,
+		Origem:       origem,
+		VersaoSpec:   VersaoSpecEnvelope,
+		VersaoSchema: versao,
+		Data:         e.DataOcorrencia,
+		IDCorrelacao: correlacao,
+		IDCausacao:   e.IDCausacao,
+		Empresa:      e.EmpresaID,
+		Dados:        json.RawMessage(e.Payload),
+	}
+}
+
+// Cabecalhos converte o envelope para headers no formato do binding AMQP do CloudEvents
+func (e Envelope) Cabecalhos() map[string]interface{} {
+	cab := map[string]interface{}{
+		prefixoCabecalhoCE + "id":            e.ID,
+		prefixoCabecalhoCE + "type":          e.Tipo,
+		prefixoCabecalhoCE + "source":        e.Origem,
+		prefixoCabecalhoCE + "specversion":   e.VersaoSpec,
+		prefixoCabecalhoCE + "schemaversion": e.VersaoSchema,
+		prefixoCabecalhoCE + "time":          e.Data.UTC().Format(time.RFC3339Nano),
+		CabecalhoCorrelacao:                  e.IDCorrelacao,
+	}
+	if e.IDCausacao != "" {
+		cab[CabecalhoCausacao] = e.IDCausacao
+	}
+	if e.Empresa != "" {
+		cab[CabecalhoEmpresa] = e.Empresa
+	}
+	return cab
+}
+
+// EnvelopeDeCabecalhos reconstroi o envelope de uma mensagem recebida. Headers
+// ausentes caem nos valores de fallback (id da mensagem e routing key): o
+// Estoque so envia correlacao e causacao. Sem correlacao ela fica vazia para
+// o consumidor resolver pela saga.
+func EnvelopeDeCabecalhos(cab map[string]interface{}, idMensagem, routingKey string, corpo []byte) Envelope {
+	texto := func(chave string) string {
+		if v, ok := cab[prefixoCabecalhoCE+chave]; ok {
+			switch s := v.(type) {
+			case string:
+				return s
+			case []byte:
+				return string(s)
+			}
+		}
+		return ""
+	}
+
+	env := Envelope{
+		ID:           texto("id"),
+		Tipo:         texto("type"),
+		Origem:       texto("source"),
+		VersaoSpec:   texto("specversion"),
+		VersaoSchema: texto("schemaversion"),
+		IDCorrelacao: texto("correlationid"),
+		IDCausacao:   texto("causationid"),
+		Empresa:      texto("empresa"),
+		Dados:        json.RawMessage(corpo),
+	}
+
+	if env.ID == "" {
+		env.ID = idMensagem
+	}
+	if env.Tipo == "" {
+		env.Tipo = routingKey
+	}
+	if env.VersaoSpec == "" {
+		env.VersaoSpec = VersaoSpecEnvelope
+	}
+	if env.VersaoSchema == "" {
+		env.VersaoSchema = VersaoSchemaPadrao
+	}
+	if t, err := time.Parse(time.RFC3339Nano, texto("time")); err == nil {
+		env.Data = t
+	}
+
+	return env
+}
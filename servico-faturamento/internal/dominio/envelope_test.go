@@ -0,0 +1,65 @@
+package dominio_test
+
+import (
+	"servico-faturamento/internal/dominio"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEnvelope_IdaEVoltaPelosCabecalhos(t *testing.T) {
+	ctx := dominio.NovoContextoEvento("corr-123")
+	evento, err := dominio.NovoEventoOutbox("Faturamento.ImpressaoSolicitada", uuid.New(), map[string]string{"notaId": "x"}, ctx)
+	if err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	evento.EmpresaID = "filial-sp"
+
+	env := evento.Envelope()
+	recebido := dominio.EnvelopeDeCabecalhos(env.Cabecalhos(), "ignorado", "ignorada", []byte(evento.Payload))
+
+	if recebido.ID != evento.IDEvento.String() {
+		t.Errorf("esperava id %s, obteve %s", evento.IDEvento, recebido.ID)
+	}
+	if recebido.Tipo != "Faturamento.ImpressaoSolicitada" {
+		t.Errorf("esperava tipo do evento, obteve %s", recebido.Tipo)
+	}
+	if recebido.Origem != dominio.OrigemFaturamento {
+		t.Errorf("esperava origem %s, obteve %s", dominio.OrigemFaturamento, recebido.Origem)
+	}
+	if recebido.IDCorrelacao != "corr-123" {
+		t.Errorf("esperava correlacao corr-123, obteve %s", recebido.IDCorrelacao)
+	}
+	if recebido.Empresa != "filial-sp" {
+		t.Errorf("esperava empresa filial-sp, obteve %s", recebido.Empresa)
+	}
+	if !recebido.Data.Equal(evento.DataOcorrencia) {
+		t.Errorf("esperava data %v, obteve %v", evento.DataOcorrencia, recebido.Data)
+	}
+}
+
+func TestEnvelope_Causado(t *testing.T) {
+	t.Run("deve propagar correlacao e usar id como causacao", func(t *testing.T) {
+		env := dominio.Envelope{ID: "msg-1", IDCorrelacao: "saga-1"}
+
+		ctx := env.Causado()
+
+		if ctx.IDCorrelacao != "saga-1" || ctx.IDCausacao != "msg-1" {
+			t.Errorf("contexto inesperado: %+v", ctx)
+		}
+	})
+
+	t.Run("deve usar id como correlacao quando produtor nao enviou headers", func(t *testing.T) {
+		env := dominio.EnvelopeDeCabecalhos(nil, "42", "Estoque.Reservado", []byte(`{}`))
+
+		ctx := env.Causado()
+
+		if env.Tipo != "Estoque.Reservado" {
+			t.Errorf("esperava tipo da routing key, obteve %s", env.Tipo)
+		}
+		if ctx.IDCorrelacao != "42" || ctx.IDCausacao != "42" {
+			t.Errorf("contexto inesperado: %+v", ctx)
+		}
+	})
+}
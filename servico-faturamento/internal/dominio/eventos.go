@@ -4,22 +4,90 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Tipos de evento (routing keys)
+const (
+	EventoImpressaoSolicitada = "Faturamento.ImpressaoSolicitada"
+	EventoNotaFechada         = "Faturamento.NotaFechada"
+	EventoImpressaoFalhou     = "Faturamento.ImpressaoFalhou"
+	EventoLiberarReserva      = "Faturamento.LiberarReserva"
+	EventoEstoqueReservado    = "Estoque.Reservado"
+	EventoReservaRejeitada    = "Estoque.ReservaRejeitada"
+	EventoProdutoCriado       = "Estoque.ProdutoCriado"
+	EventoProdutoAtualizado   = "Estoque.ProdutoAtualizado"
 )
 
 type EventoOutbox struct {
 	ID             int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	EmpresaID      string     `gorm:"not null;index:idx_faturamento_outbox_empresa" json:"empresaId"`
+	IDEvento       uuid.UUID  `gorm:"type:uuid;index:idx_faturamento_outbox_id_evento" json:"idEvento"`
 	TipoEvento     string     `gorm:"not null" json:"tipoEvento"`
-	IdAgregado     uuid.UUID  `gorm:"type:uuid;not null" json:"idAgregado"`
+	IdAgregado     uuid.UUID  `gorm:"type:uuid;not null;index:idx_faturamento_outbox_agregado" json:"idAgregado"`
 	Payload        string     `gorm:"type:jsonb;not null" json:"payload"`
 	DataOcorrencia time.Time  `gorm:"not null" json:"dataOcorrencia"`
 	DataPublicacao *time.Time `json:"dataPublicacao,omitempty"`
+	Origem         string     `json:"origem"`
+	VersaoSchema   string     `json:"versaoSchema"`
+	IDCorrelacao   string     `gorm:"index:idx_faturamento_outbox_correlacao" json:"idCorrelacao"`
+	IDCausacao     string     `json:"idCausacao,omitempty"`
+
+	// Controle de publicacao: falhas aumentam Tentativas e adiam o proximo
+	// envio; um evento estacionado sai do ciclo ate ser republicado a mao
+	Tentativas           int        `gorm:"not null;default:0" json:"tentativas"`
+	UltimoErro           *string    `gorm:"type:text" json:"ultimoErro,omitempty"`
+	ProximaTentativa     *time.Time `gorm:"index:idx_faturamento_outbox_proxima" json:"proximaTentativa,omitempty"`
+	DataEstacionamento   *time.Time `json:"dataEstacionamento,omitempty"`
+	MotivoEstacionamento *string    `json:"motivoEstacionamento,omitempty"`
 }
 
+// Situacoes de um evento do outbox, usadas nos filtros da administracao
+const (
+	SituacaoOutboxPendente    = "PENDENTE"
+	SituacaoOutboxFalhou      = "FALHOU"
+	SituacaoOutboxEstacionado = "ESTACIONADO"
+	SituacaoOutboxPublicado   = "PUBLICADO"
+)
+
 type MensagemProcessada struct {
 	IDMensagem     string    `gorm:"primaryKey" json:"idMensagem"`
+	EmpresaID      string    `gorm:"not null" json:"empresaId"`
 	DataProcessada time.Time `gorm:"not null" json:"dataProcessada"`
 }
 
+func (e *EventoOutbox) BeforeCreate(tx *gorm.DB) error {
+	if e.IDEvento == uuid.Nil {
+		e.IDEvento = uuid.New()
+	}
+	if e.DataOcorrencia.IsZero() {
+		e.DataOcorrencia = time.Now()
+	}
+	if e.Origem == "" {
+		e.Origem = OrigemFaturamento
+	}
+	if e.VersaoSchema == "" {
+		e.VersaoSchema = VersaoSchemaPadrao
+	}
+	if e.IDCorrelacao == "" {
+		e.IDCorrelacao = e.IDEvento.String()
+	}
+	return nil
+}
+
+// Situacao resume o estado de publicacao do evento
+func (e *EventoOutbox) Situacao() string {
+	switch {
+	case e.DataPublicacao != nil:
+		return SituacaoOutboxPublicado
+	case e.DataEstacionamento != nil:
+		return SituacaoOutboxEstacionado
+	case e.Tentativas > 0:
+		return SituacaoOutboxFalhou
+	}
+	return SituacaoOutboxPendente
+}
+
 func (EventoOutbox) TableName() string {
 	return "eventos_outbox"
 }
@@ -27,3 +95,124 @@ func (EventoOutbox) TableName() string {
 func (MensagemProcessada) TableName() string {
 	return "mensagens_processadas"
 }
+
+// ItemNotaFechada e o item como sai no evento Faturamento.NotaFechada
+type ItemNotaFechada struct {
+	ProdutoID     string  `json:"produtoId"`
+	Quantidade    int     `json:"quantidade"`
+	PrecoUnitario float64 `json:"precoUnitario"`
+	Subtotal      float64 `json:"subtotal"`
+}
+
+// PayloadImpressaoSolicitada e o contrato do evento
+// Faturamento.ImpressaoSolicitada. O Estoque devolve SolicitacaoID em
+// Estoque.Reservado e Estoque.ReservaRejeitada: a resposta vale so para a
+// tentativa que a pediu.
+type PayloadImpressaoSolicitada struct {
+	NotaID        string        `json:"notaId"`
+	SolicitacaoID string        `json:"solicitacaoId"`
+	Itens         []ItemReserva `json:"itens"`
+}
+
+// PayloadNotaFechada e o contrato do evento Faturamento.NotaFechada
+type PayloadNotaFechada struct {
+	NotaID          string            `json:"notaId"`
+	Numero          string            `json:"numero"`
+	SolicitacaoID   string            `json:"solicitacaoId,omitempty"`
+	DataFechada     time.Time         `json:"dataFechada"`
+	QuantidadeItens int               `json:"quantidadeItens"`
+	ValorTotal      float64           `json:"valorTotal"`
+	Itens           []ItemNotaFechada `json:"itens"`
+}
+
+// PayloadImpressaoFalhou e o contrato do evento Faturamento.ImpressaoFalhou
+type PayloadImpressaoFalhou struct {
+	SolicitacaoID string               `json:"solicitacaoId"`
+	NotaID        string               `json:"notaId"`
+	Motivo        string               `json:"motivo"`
+	DataFalha     time.Time            `json:"dataFalha"`
+	Divergencias  []DivergenciaReserva `json:"divergencias,omitempty"`
+}
+
+// ItemReserva e o par produto/quantidade trocado com o Estoque
+type ItemReserva struct {
+	ProdutoID  string `json:"produtoId"`
+	Quantidade int    `json:"quantidade"`
+}
+
+// PayloadLiberarReserva e o contrato do evento de compensacao
+// Faturamento.LiberarReserva: pede ao Estoque que devolva o que reservou
+type PayloadLiberarReserva struct {
+	NotaID        string        `json:"notaId"`
+	SolicitacaoID string        `json:"solicitacaoId,omitempty"`
+	Motivo        string        `json:"motivo"`
+	Itens         []ItemReserva `json:"itens"`
+}
+
+// NovoPayloadNotaFechada monta o evento a partir da nota ja fechada
+func NovoPayloadNotaFechada(nota *NotaFiscal, solicitacaoID uuid.UUID) PayloadNotaFechada {
+	payload := PayloadNotaFechada{
+		NotaID:     nota.ID.String(),
+		Numero:     nota.Numero,
+		ValorTotal: nota.CalcularTotal(),
+		Itens:      make([]ItemNotaFechada, 0, len(nota.Itens)),
+	}
+	if solicitacaoID != uuid.Nil {
+		payload.SolicitacaoID = solicitacaoID.String()
+	}
+	if nota.DataFechada != nil {
+		payload.DataFechada = *nota.DataFechada
+	}
+
+	for _, item := range nota.Itens {
+		payload.QuantidadeItens += item.Quantidade
+		payload.Itens = append(payload.Itens, ItemNotaFechada{
+			ProdutoID:     item.ProdutoID.String(),
+			Quantidade:    item.Quantidade,
+			PrecoUnitario: item.PrecoUnitario,
+			Subtotal:      item.CalcularSubtotal(),
+		})
+	}
+
+	return payload
+}
+
+// EventoOutboxArquivado guarda eventos ja publicados removidos pela retencao
+type EventoOutboxArquivado struct {
+	ID               int64      `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	EmpresaID        string     `gorm:"not null" json:"empresaId"`
+	IDEvento         uuid.UUID  `gorm:"type:uuid" json:"idEvento"`
+	TipoEvento       string     `gorm:"not null" json:"tipoEvento"`
+	IdAgregado       uuid.UUID  `gorm:"type:uuid;not null" json:"idAgregado"`
+	Payload          string     `gorm:"type:jsonb;not null" json:"payload"`
+	DataOcorrencia   time.Time  `gorm:"not null" json:"dataOcorrencia"`
+	DataPublicacao   *time.Time `json:"dataPublicacao,omitempty"`
+	Origem           string     `json:"origem"`
+	VersaoSchema     string     `json:"versaoSchema"`
+	IDCorrelacao     string     `json:"idCorrelacao"`
+	IDCausacao       string     `json:"idCausacao,omitempty"`
+	DataArquivamento time.Time  `gorm:"not null" json:"dataArquivamento"`
+}
+
+// NovoEventoOutboxArquivado copia o evento para a tabela de arquivo
+func NovoEventoOutboxArquivado(e EventoOutbox, agora time.Time) EventoOutboxArquivado {
+	return EventoOutboxArquivado{
+		ID:               e.ID,
+		EmpresaID:        e.EmpresaID,
+		IDEvento:         e.IDEvento,
+		TipoEvento:       e.TipoEvento,
+		IdAgregado:       e.IdAgregado,
+		Payload:          e.Payload,
+		DataOcorrencia:   e.DataOcorrencia,
+		DataPublicacao:   e.DataPublicacao,
+		Origem:           e.Origem,
+		VersaoSchema:     e.VersaoSchema,
+		IDCorrelacao:     e.IDCorrelacao,
+		IDCausacao:       e.IDCausacao,
+		DataArquivamento: agora,
+	}
+}
+
+func (EventoOutboxArquivado) TableName() string {
+	return "eventos_outbox_arquivo"
+}
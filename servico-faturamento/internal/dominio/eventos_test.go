@@ -0,0 +1,45 @@
+package dominio_test
+
+import (
+	"servico-faturamento/internal/dominio"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNovoPayloadNotaFechada(t *testing.T) {
+	agora := time.Now()
+	solicitacaoID := uuid.New()
+	nota := &dominio.NotaFiscal{
+		ID:          uuid.New(),
+		Numero:      "NF-010",
+		Status:      dominio.StatusNotaFechada,
+		DataFechada: &agora,
+		Itens: []dominio.ItemNota{
+			{ID: uuid.New(), ProdutoID: uuid.New(), Quantidade: 2, PrecoUnitario: 10.0},
+			{ID: uuid.New(), ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 5.5},
+		},
+	}
+
+	payload := dominio.NovoPayloadNotaFechada(nota, solicitacaoID)
+
+	if payload.NotaID != nota.ID.String() || payload.Numero != "NF-010" {
+		t.Errorf("identificacao inesperada: %+v", payload)
+	}
+	if payload.SolicitacaoID != solicitacaoID.String() {
+		t.Errorf("esperava solicitacao %s, obteve %s", solicitacaoID, payload.SolicitacaoID)
+	}
+	if payload.ValorTotal != 25.5 {
+		t.Errorf("esperava total 25.50, obteve %.2f", payload.ValorTotal)
+	}
+	if payload.QuantidadeItens != 3 || len(payload.Itens) != 2 {
+		t.Errorf("esperava 3 unidades em 2 itens, obteve %d em %d", payload.QuantidadeItens, len(payload.Itens))
+	}
+	if payload.Itens[0].Subtotal != 20.0 {
+		t.Errorf("esperava subtotal 20.00, obteve %.2f", payload.Itens[0].Subtotal)
+	}
+	if !payload.DataFechada.Equal(agora) {
+		t.Errorf("esperava data de fechamento preenchida")
+	}
+}
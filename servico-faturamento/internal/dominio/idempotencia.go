@@ -0,0 +1,52 @@
+package dominio
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Operacoes protegidas por Idempotency-Key
+const (
+	OperacaoImprimir    = "IMPRIMIR"
+	OperacaoReprocessar = "REPROCESSAR"
+)
+
+// ChaveIdempotencia liga a Idempotency-Key de uma empresa ao alvo da primeira
+// requisicao que a usou (nota e operacao) e a solicitacao que ela criou. O
+// hash da requisicao so e gravado onde o alvo nao basta para distinguir uma
+// repeticao (reprocessar). A retencao remove as chaves antigas; depois disso
+// a chave pode ser reusada.
+type ChaveIdempotencia struct {
+	EmpresaID      string    `gorm:"primaryKey" json:"empresaId"`
+	Chave          string    `gorm:"primaryKey" json:"chave"`
+	NotaID         uuid.UUID `gorm:"type:uuid;not null" json:"notaId"`
+	Operacao       string    `gorm:"not null" json:"operacao"`
+	HashRequisicao string    `gorm:"not null;default:''" json:"hashRequisicao"`
+	SolicitacaoID  uuid.UUID `gorm:"type:uuid;not null" json:"solicitacaoId"`
+	DataCriacao    time.Time `gorm:"not null;index:idx_chaves_idempotencia_data" json:"dataCriacao"`
+}
+
+func (c *ChaveIdempotencia) BeforeCreate(tx *gorm.DB) error {
+	if c.DataCriacao.IsZero() {
+		c.DataCriacao = time.Now()
+	}
+	return nil
+}
+
+// MesmoAlvo diz se a requisicao repete a que gravou a chave; outro alvo com a
+// mesma chave e erro do cliente, nao repeticao
+func (c ChaveIdempotencia) MesmoAlvo(notaID uuid.UUID, operacao string) bool {
+	return c.NotaID == notaID && c.Operacao == operacao
+}
+
+// MesmaRequisicao diz se a requisicao no mesmo alvo e a que gravou a chave.
+// Chaves gravadas sem hash aceitam qualquer requisicao do alvo.
+func (c ChaveIdempotencia) MesmaRequisicao(hash string) bool {
+	return c.HashRequisicao == "" || c.HashRequisicao == hash
+}
+
+func (c ChaveIdempotencia) TableName() string {
+	return "chaves_idempotencia"
+}
@@ -0,0 +1,22 @@
+package dominio
+
+// Modelos lista as entidades persistidas pelo GORM. O schema de producao vem
+// das migrations em internal/migracao; esta lista serve aos testes (SQLite) e
+// a verificacao de que as migrations cobrem toda coluna mapeada.
+func Modelos() []interface{} {
+	return []interface{}{
+		&NotaFiscal{},
+		&ItemNota{},
+		&SolicitacaoImpressao{},
+		&ItemSolicitacao{},
+		&ChaveIdempotencia{},
+		&EventoOutbox{},
+		&EventoOutboxArquivado{},
+		&MensagemProcessada{},
+		&SagaFaturamento{},
+		&PassoSaga{},
+		&MensagemQuarentena{},
+		&ProdutoCatalogo{},
+		&RegistroAuditoria{},
+	}
+}
@@ -16,19 +16,32 @@ const (
 
 type NotaFiscal struct {
 	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
-	Numero      string    `gorm:"unique;not null" json:"numero"`
+	EmpresaID   string    `gorm:"not null;uniqueIndex:idx_notas_empresa_numero,priority:1" json:"empresaId"`
+	// no PostgreSQL o numero unico por empresa fica em numeros_notas_fiscais,
+	// ja que notas_fiscais e particionada
+	Numero      string    `gorm:"not null;uniqueIndex:idx_notas_empresa_numero,priority:2" json:"numero"`
+	Destinatario string   `gorm:"size:200;not null;default:''" json:"destinatario,omitempty"`
 	Status      string    `gorm:"not null" json:"status"` // ABERTA, FECHADA
 	DataCriacao time.Time `gorm:"not null" json:"dataCriacao"`
 	DataFechada *time.Time `json:"dataFechada,omitempty"`
+	// Arquivada: movida para as particoes de arquivo (`servico-faturamento
+	// arquivar`); fora das listagens, mas ainda encontrada pelo id
+	Arquivada   bool       `gorm:"not null;default:false" json:"arquivada,omitempty"`
 	Itens       []ItemNota `gorm:"foreignKey:NotaID" json:"itens,omitempty"`
 }
 
 type ItemNota struct {
 	ID            uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	EmpresaID     string     `gorm:"not null" json:"empresaId"`
 	NotaID        uuid.UUID  `gorm:"type:uuid;not null" json:"notaId"`
 	ProdutoID     uuid.UUID  `gorm:"type:uuid;not null" json:"produtoId"`
 	Quantidade    int        `gorm:"not null" json:"quantidade"`
 	PrecoUnitario float64    `gorm:"type:decimal(10,2);not null" json:"precoUnitario"`
+	Sku           string     `json:"sku,omitempty"`
+	NomeProduto   string     `json:"nomeProduto,omitempty"`
+	// particao do item: acompanha a da nota
+	Arquivada     bool       `gorm:"not null;default:false" json:"-"`
+	DataNota      time.Time  `gorm:"not null" json:"-"`
 }
 
 func (n *NotaFiscal) BeforeCreate(tx *gorm.DB) error {
@@ -0,0 +1,33 @@
+package dominio
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ProdutoCatalogo e a projecao local, somente leitura, dos produtos do
+// Estoque. So o consumidor escreve aqui, a partir dos eventos de produto.
+// EmpresaID vazio e um produto compartilhado por todas as empresas (eventos
+// sem o cabecalho de empresa).
+type ProdutoCatalogo struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	EmpresaID  string    `gorm:"not null;default:''" json:"empresaId,omitempty"`
+	Sku        string    `gorm:"not null;index:idx_produtos_catalogo_sku" json:"sku"`
+	Nome       string    `gorm:"not null" json:"nome"`
+	Ativo      bool      `gorm:"not null" json:"ativo"`
+	DataEvento time.Time `gorm:"not null" json:"dataEvento"`
+	IDEvento   string    `json:"idEvento"`
+}
+
+// PayloadProduto e o corpo de Estoque.ProdutoCriado e Estoque.ProdutoAtualizado
+type PayloadProduto struct {
+	ProdutoID string `json:"produtoId"`
+	Sku       string `json:"sku"`
+	Nome      string `json:"nome"`
+	Ativo     bool   `json:"ativo"`
+}
+
+func (ProdutoCatalogo) TableName() string {
+	return "produtos_catalogo"
+}
@@ -0,0 +1,69 @@
+package dominio
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Status de uma mensagem na quarentena
+const (
+	// StatusQuarentenaRetentando: falhou mas ainda volta para a fila
+	StatusQuarentenaRetentando = "EM_RETENTATIVA"
+	// StatusQuarentena: esgotou as tentativas e saiu da fila, aguarda o operador
+	StatusQuarentena = "QUARENTENA"
+	// StatusQuarentenaRecuperada: voltou a fila e foi processada sozinha
+	StatusQuarentenaRecuperada   = "RECUPERADA"
+	StatusQuarentenaReprocessada = "REPROCESSADA"
+	StatusQuarentenaDescartada   = "DESCARTADA"
+)
+
+// MensagemQuarentena guarda uma entrega que o consumidor nao conseguiu
+// processar, com o necessario para inspecionar, corrigir e reprocessar
+type MensagemQuarentena struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	EmpresaID      string     `gorm:"not null;index:idx_quarentena_empresa" json:"empresaId"`
+	IDMensagem     string     `gorm:"not null;uniqueIndex:idx_quarentena_id_mensagem" json:"idMensagem"`
+	RoutingKey     string     `gorm:"not null;index:idx_quarentena_routing_key" json:"routingKey"`
+	CorrelationID  string     `json:"correlationId,omitempty"`
+	Cabecalhos     string     `gorm:"type:jsonb;not null" json:"cabecalhos"`
+	Corpo          string     `gorm:"type:text;not null" json:"corpo"`
+	Erro           string     `gorm:"type:text;not null" json:"erro"`
+	Tentativas     int        `gorm:"not null" json:"tentativas"`
+	Status         string     `gorm:"not null;index:idx_quarentena_status" json:"status"`
+	MotivoDescarte *string    `json:"motivoDescarte,omitempty"`
+	Editada        bool       `gorm:"not null;default:false" json:"editada"`
+	DataPrimeira   time.Time  `gorm:"not null" json:"dataPrimeiraFalha"`
+	DataUltima     time.Time  `gorm:"not null" json:"dataUltimaFalha"`
+	DataResolucao  *time.Time `json:"dataResolucao,omitempty"`
+}
+
+func (m *MensagemQuarentena) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	if m.DataPrimeira.IsZero() {
+		m.DataPrimeira = time.Now()
+	}
+	if m.DataUltima.IsZero() {
+		m.DataUltima = m.DataPrimeira
+	}
+	if m.Status == "" {
+		m.Status = StatusQuarentenaRetentando
+	}
+	return nil
+}
+
+// Resolvida indica se a mensagem ja saiu da quarentena
+func (m *MensagemQuarentena) Resolvida() bool {
+	switch m.Status {
+	case StatusQuarentenaRecuperada, StatusQuarentenaReprocessada, StatusQuarentenaDescartada:
+		return true
+	}
+	return false
+}
+
+func (MensagemQuarentena) TableName() string {
+	return "mensagens_quarentena"
+}
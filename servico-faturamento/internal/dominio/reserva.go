@@ -0,0 +1,113 @@
+package dominio
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Tipos de divergencia entre a reserva do Estoque e os itens da nota
+const (
+	DivergenciaNaoReservado = "PRODUTO_NAO_RESERVADO"
+	DivergenciaForaDaNota   = "PRODUTO_FORA_DA_NOTA"
+	DivergenciaQuantidade   = "QUANTIDADE_DIVERGENTE"
+)
+
+// MotivoReservaDivergente abre o relatorio gravado em mensagem_erro
+const MotivoReservaDivergente = "Reserva divergente dos itens da nota"
+
+// DivergenciaReserva descreve um produto cuja quantidade reservada nao
+// corresponde a quantidade da nota
+type DivergenciaReserva struct {
+	ProdutoID           string `json:"produtoId"`
+	Tipo                string `json:"tipo"`
+	QuantidadeNota      int    `json:"quantidadeNota"`
+	QuantidadeReservada int    `json:"quantidadeReservada"`
+}
+
+// ConciliarReserva compara, por produto, as quantidades reservadas com as da
+// nota. Linhas repetidas do mesmo produto sao somadas dos dois lados.
+func ConciliarReserva(itens []ItemNota, reservados []ItemReserva) []DivergenciaReserva {
+	naNota := make(map[string]int)
+	for _, item := range itens {
+		naNota[item.ProdutoID.String()] += item.Quantidade
+	}
+
+	naReserva := make(map[string]int)
+	for _, item := range reservados {
+		naReserva[normalizarProduto(item.ProdutoID)] += item.Quantidade
+	}
+
+	var divergencias []DivergenciaReserva
+	for produto, qtdNota := range naNota {
+		qtdReservada, ok := naReserva[produto]
+		switch {
+		case !ok:
+			divergencias = append(divergencias, DivergenciaReserva{
+				ProdutoID:      produto,
+				Tipo:           DivergenciaNaoReservado,
+				QuantidadeNota: qtdNota,
+			})
+		case qtdReservada != qtdNota:
+			divergencias = append(divergencias, DivergenciaReserva{
+				ProdutoID:           produto,
+				Tipo:                DivergenciaQuantidade,
+				QuantidadeNota:      qtdNota,
+				QuantidadeReservada: qtdReservada,
+			})
+		}
+	}
+	for produto, qtdReservada := range naReserva {
+		if _, ok := naNota[produto]; !ok {
+			divergencias = append(divergencias, DivergenciaReserva{
+				ProdutoID:           produto,
+				Tipo:                DivergenciaForaDaNota,
+				QuantidadeReservada: qtdReservada,
+			})
+		}
+	}
+
+	sort.Slice(divergencias, func(i, j int) bool {
+		return divergencias[i].ProdutoID < divergencias[j].ProdutoID
+	})
+	return divergencias
+}
+
+// ItensReserva converte itens da nota nos pares trocados com o Estoque
+func ItensReserva(itens []ItemNota) []ItemReserva {
+	resultado := make([]ItemReserva, 0, len(itens))
+	for _, item := range itens {
+		resultado = append(resultado, ItemReserva{
+			ProdutoID:  item.ProdutoID.String(),
+			Quantidade: item.Quantidade,
+		})
+	}
+	return resultado
+}
+
+// RelatorioDivergencias monta o texto legivel gravado na solicitacao
+func RelatorioDivergencias(divergencias []DivergenciaReserva) string {
+	partes := make([]string, 0, len(divergencias))
+	for _, d := range divergencias {
+		switch d.Tipo {
+		case DivergenciaNaoReservado:
+			partes = append(partes, fmt.Sprintf("produto %s: %d na nota, nao reservado", d.ProdutoID, d.QuantidadeNota))
+		case DivergenciaForaDaNota:
+			partes = append(partes, fmt.Sprintf("produto %s: %d reservado, fora da nota", d.ProdutoID, d.QuantidadeReservada))
+		default:
+			partes = append(partes, fmt.Sprintf("produto %s: %d na nota, %d reservado", d.ProdutoID, d.QuantidadeNota, d.QuantidadeReservada))
+		}
+	}
+	return MotivoReservaDivergente + ": " + strings.Join(partes, "; ")
+}
+
+// normalizarProduto deixa o id no mesmo formato de uuid.UUID.String(); ids
+// que nao sao UUID seguem como vieram e sempre divergem
+func normalizarProduto(id string) string {
+	if parsed, err := uuid.Parse(id); err == nil {
+		return parsed.String()
+	}
+	return id
+}
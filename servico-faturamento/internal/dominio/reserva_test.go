@@ -0,0 +1,69 @@
+package dominio_test
+
+import (
+	"strings"
+	"testing"
+
+	"servico-faturamento/internal/dominio"
+
+	"github.com/google/uuid"
+)
+
+func TestConciliarReserva_Confere(t *testing.T) {
+	produtoA, produtoB := uuid.New(), uuid.New()
+	itens := []dominio.ItemNota{
+		{ProdutoID: produtoA, Quantidade: 2},
+		{ProdutoID: produtoB, Quantidade: 1},
+		{ProdutoID: produtoA, Quantidade: 3},
+	}
+	reservados := []dominio.ItemReserva{
+		{ProdutoID: strings.ToUpper(produtoA.String()), Quantidade: 5},
+		{ProdutoID: produtoB.String(), Quantidade: 1},
+	}
+
+	if divergencias := dominio.ConciliarReserva(itens, reservados); len(divergencias) != 0 {
+		t.Errorf("esperava reserva conciliada, obteve %+v", divergencias)
+	}
+}
+
+func TestConciliarReserva_Divergencias(t *testing.T) {
+	faltando, quantidade, extra := uuid.New(), uuid.New(), uuid.New()
+	itens := []dominio.ItemNota{
+		{ProdutoID: faltando, Quantidade: 1},
+		{ProdutoID: quantidade, Quantidade: 4},
+	}
+	reservados := []dominio.ItemReserva{
+		{ProdutoID: quantidade.String(), Quantidade: 3},
+		{ProdutoID: extra.String(), Quantidade: 2},
+	}
+
+	divergencias := dominio.ConciliarReserva(itens, reservados)
+	if len(divergencias) != 3 {
+		t.Fatalf("esperava 3 divergencias, obteve %+v", divergencias)
+	}
+
+	porProduto := make(map[string]dominio.DivergenciaReserva)
+	for _, d := range divergencias {
+		porProduto[d.ProdutoID] = d
+	}
+	esperado := map[uuid.UUID]dominio.DivergenciaReserva{
+		faltando:   {ProdutoID: faltando.String(), Tipo: dominio.DivergenciaNaoReservado, QuantidadeNota: 1},
+		quantidade: {ProdutoID: quantidade.String(), Tipo: dominio.DivergenciaQuantidade, QuantidadeNota: 4, QuantidadeReservada: 3},
+		extra:      {ProdutoID: extra.String(), Tipo: dominio.DivergenciaForaDaNota, QuantidadeReservada: 2},
+	}
+	for produto, d := range esperado {
+		if porProduto[produto.String()] != d {
+			t.Errorf("produto %s: esperava %+v, obteve %+v", produto, d, porProduto[produto.String()])
+		}
+	}
+
+	relatorio := dominio.RelatorioDivergencias(divergencias)
+	if !strings.HasPrefix(relatorio, dominio.MotivoReservaDivergente) {
+		t.Errorf("relatorio sem o motivo: %s", relatorio)
+	}
+	for produto := range esperado {
+		if !strings.Contains(relatorio, produto.String()) {
+			t.Errorf("relatorio sem o produto %s: %s", produto, relatorio)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package dominio
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Estados da saga de impressao
+const (
+	EstadoSagaAguardandoEstoque = "AGUARDANDO_ESTOQUE"
+	EstadoSagaConcluida         = "CONCLUIDA"
+	EstadoSagaFalhou            = "FALHOU"
+)
+
+// Direcao de um passo em relacao ao Faturamento
+const (
+	DirecaoEnviada  = "ENVIADA"
+	DirecaoRecebida = "RECEBIDA"
+)
+
+const TipoSagaImpressao = "IMPRESSAO_NOTA"
+
+// SagaFaturamento e uma instancia da saga de impressao, uma por solicitacao
+type SagaFaturamento struct {
+	ID              uuid.UUID   `gorm:"type:uuid;primary_key" json:"id"`
+	EmpresaID       string      `gorm:"not null;index" json:"empresaId"`
+	Tipo            string      `gorm:"not null" json:"tipo"`
+	SolicitacaoID   uuid.UUID   `gorm:"type:uuid;not null;uniqueIndex" json:"solicitacaoId"`
+	NotaID          uuid.UUID   `gorm:"type:uuid;not null;index" json:"notaId"`
+	IDCorrelacao    string      `gorm:"index" json:"idCorrelacao"`
+	Estado          string      `gorm:"not null;index" json:"estado"`
+	PassoAtual      string      `json:"passoAtual"`
+	DataInicio      time.Time   `gorm:"not null;index" json:"dataInicio"`
+	DataAtualizacao time.Time   `gorm:"not null" json:"dataAtualizacao"`
+	DataFim         *time.Time  `json:"dataFim,omitempty"`
+	Passos          []PassoSaga `gorm:"foreignKey:SagaID" json:"passos,omitempty"`
+}
+
+// PassoSaga registra uma mensagem enviada ou recebida pela saga
+type PassoSaga struct {
+	ID               int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	EmpresaID        string    `gorm:"not null" json:"empresaId"`
+	SagaID           uuid.UUID `gorm:"type:uuid;not null;index" json:"sagaId"`
+	Nome             string    `gorm:"not null" json:"nome"`
+	Direcao          string    `gorm:"not null" json:"direcao"`
+	IDMensagem       string    `json:"idMensagem,omitempty"`
+	IDCausacao       string    `json:"idCausacao,omitempty"`
+	Detalhe          string    `json:"detalhe,omitempty"`
+	EstadoResultante string    `json:"estadoResultante"`
+	Data             time.Time `gorm:"not null" json:"data"`
+}
+
+func (s *SagaFaturamento) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	if s.Tipo == "" {
+		s.Tipo = TipoSagaImpressao
+	}
+	if s.DataInicio.IsZero() {
+		s.DataInicio = time.Now()
+	}
+	if s.DataAtualizacao.IsZero() {
+		s.DataAtualizacao = s.DataInicio
+	}
+	return nil
+}
+
+// Terminal indica se a saga nao espera mais nenhuma mensagem
+func (s *SagaFaturamento) Terminal() bool {
+	return s.Estado == EstadoSagaConcluida || s.Estado == EstadoSagaFalhou
+}
+
+// PassoEnviado descreve um evento gravado no outbox pela saga
+func PassoEnviado(evento *EventoOutbox, detalhe string) PassoSaga {
+	return PassoSaga{
+		Nome:       evento.TipoEvento,
+		Direcao:    DirecaoEnviada,
+		IDMensagem: evento.IDEvento.String(),
+		IDCausacao: evento.IDCausacao,
+		Detalhe:    detalhe,
+	}
+}
+
+// PassoRecebido descreve uma mensagem consumida pela saga
+func PassoRecebido(env Envelope, detalhe string) PassoSaga {
+	return PassoSaga{
+		Nome:       env.Tipo,
+		Direcao:    DirecaoRecebida,
+		IDMensagem: env.ID,
+		IDCausacao: env.IDCausacao,
+		Detalhe:    detalhe,
+	}
+}
+
+func (SagaFaturamento) TableName() string {
+	return "sagas_faturamento"
+}
+
+func (PassoSaga) TableName() string {
+	return "passos_saga_faturamento"
+}
@@ -7,14 +7,92 @@ import (
 	"gorm.io/gorm"
 )
 
+// Constantes de status da solicitacao de impressao
+const (
+	StatusSolicitacaoPendente  = "PENDENTE"
+	StatusSolicitacaoConcluida = "CONCLUIDA"
+	StatusSolicitacaoFalhou    = "FALHOU"
+)
+
+// TimeoutImpressaoPadrao e quanto a saga espera a resposta do Estoque
+const TimeoutImpressaoPadrao = 5 * time.Minute
+
+// MotivoTimeoutImpressao e gravado em mensagem_erro quando a saga expira
+const MotivoTimeoutImpressao = "Tempo limite excedido aguardando reserva de estoque"
+
+// SolicitacaoImpressao e um pedido de impressao da nota. Cada nota tem no
+// maximo uma PENDENTE (idx_solicitacoes_nota_pendente): outra reservaria o
+// estoque de novo.
 type SolicitacaoImpressao struct {
 	ID                uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
-	NotaID            uuid.UUID  `gorm:"type:uuid;not null" json:"notaId"`
+	EmpresaID         string     `gorm:"not null" json:"empresaId"`
+	NotaID            uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_solicitacoes_nota_pendente,where:status = 'PENDENTE'" json:"notaId"`
 	Status            string     `gorm:"not null" json:"status"` // PENDENTE, CONCLUIDA, FALHOU
 	MensagemErro      *string    `json:"mensagemErro,omitempty"`
-	ChaveIdempotencia string     `gorm:"unique" json:"chaveIdempotencia"`
+	ChaveIdempotencia string     `gorm:"not null;index:idx_solicitacoes_chave" json:"chaveIdempotencia"` // unicidade em chaves_idempotencia
+	IDCorrelacao      string     `gorm:"index:idx_solicitacoes_correlacao" json:"idCorrelacao,omitempty"`
 	DataCriacao       time.Time  `gorm:"not null" json:"dataCriacao"`
 	DataConclusao     *time.Time `json:"dataConclusao,omitempty"`
+	PrazoExpiracao    *time.Time `gorm:"index:idx_solicitacoes_prazo" json:"prazoExpiracao,omitempty"`
+
+	// Reprocessamento: cada nova tentativa aponta para a solicitacao original,
+	// que guarda o contador e o historico das tentativas
+	SolicitacaoOrigemID *uuid.UUID             `gorm:"type:uuid;index:idx_solicitacoes_origem" json:"solicitacaoOrigemId,omitempty"`
+	Tentativa           int                    `gorm:"not null;default:1" json:"tentativa"`
+	Tentativas          int                    `gorm:"not null;default:1" json:"tentativas"`
+	Retentativas        []SolicitacaoImpressao `gorm:"foreignKey:SolicitacaoOrigemID" json:"retentativas,omitempty"`
+
+	// Itens congelados no pedido: o que o Estoque reserva e o que a nota
+	// fecha, mesmo que a nota mude depois
+	Itens []ItemSolicitacao `gorm:"foreignKey:SolicitacaoID" json:"itens,omitempty"`
+}
+
+// ItemSolicitacao e a copia de um item da nota no momento do pedido de impressao
+type ItemSolicitacao struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key" json:"-"`
+	EmpresaID     string    `gorm:"not null" json:"-"`
+	SolicitacaoID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	ItemID        uuid.UUID `gorm:"type:uuid;not null" json:"itemId"`
+	ProdutoID     uuid.UUID `gorm:"type:uuid;not null" json:"produtoId"`
+	Quantidade    int       `gorm:"not null" json:"quantidade"`
+	PrecoUnitario float64   `gorm:"type:decimal(10,2);not null" json:"precoUnitario"`
+	Sku           string    `json:"sku,omitempty"`
+	NomeProduto   string    `json:"nomeProduto,omitempty"`
+}
+
+// CongelarItens copia os itens da nota para a solicitacao
+func CongelarItens(itens []ItemNota) []ItemSolicitacao {
+	congelados := make([]ItemSolicitacao, len(itens))
+	for i, item := range itens {
+		congelados[i] = ItemSolicitacao{
+			ItemID:        item.ID,
+			ProdutoID:     item.ProdutoID,
+			Quantidade:    item.Quantidade,
+			PrecoUnitario: item.PrecoUnitario,
+			Sku:           item.Sku,
+			NomeProduto:   item.NomeProduto,
+		}
+	}
+	return congelados
+}
+
+// ItensNota devolve os itens congelados como itens da nota, para
+// conciliar a reserva e fechar a nota com eles
+func (s *SolicitacaoImpressao) ItensNota() []ItemNota {
+	itens := make([]ItemNota, len(s.Itens))
+	for i, item := range s.Itens {
+		itens[i] = ItemNota{
+			ID:            item.ItemID,
+			EmpresaID:     s.EmpresaID,
+			NotaID:        s.NotaID,
+			ProdutoID:     item.ProdutoID,
+			Quantidade:    item.Quantidade,
+			PrecoUnitario: item.PrecoUnitario,
+			Sku:           item.Sku,
+			NomeProduto:   item.NomeProduto,
+		}
+	}
+	return itens
 }
 
 func (s *SolicitacaoImpressao) BeforeCreate(tx *gorm.DB) error {
@@ -25,11 +103,46 @@ func (s *SolicitacaoImpressao) BeforeCreate(tx *gorm.DB) error {
 		s.DataCriacao = time.Now()
 	}
 	if s.Status == "" {
-		s.Status = "PENDENTE"
+		s.Status = StatusSolicitacaoPendente
+	}
+	if s.Tentativa == 0 {
+		s.Tentativa = 1
+	}
+	if s.Tentativas == 0 {
+		s.Tentativas = 1
 	}
 	return nil
 }
 
+func (i *ItemSolicitacao) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+// Expirada indica se a solicitacao ainda pendente passou do prazo
+func (s *SolicitacaoImpressao) Expirada(agora time.Time) bool {
+	return s.Status == StatusSolicitacaoPendente && s.PrazoExpiracao != nil && agora.After(*s.PrazoExpiracao)
+}
+
+// Reprocessavel indica se a solicitacao pode gerar uma nova tentativa
+func (s *SolicitacaoImpressao) Reprocessavel() bool {
+	return s.Status == StatusSolicitacaoFalhou
+}
+
+// IDRaiz retorna a solicitacao original da cadeia de tentativas
+func (s *SolicitacaoImpressao) IDRaiz() uuid.UUID {
+	if s.SolicitacaoOrigemID != nil {
+		return *s.SolicitacaoOrigemID
+	}
+	return s.ID
+}
+
 func (s *SolicitacaoImpressao) TableName() string {
 	return "solicitacoes_impressao"
 }
+
+func (i *ItemSolicitacao) TableName() string {
+	return "itens_solicitacao_impressao"
+}
@@ -0,0 +1,41 @@
+package exportacao
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+type planilhaCSV struct {
+	w *csv.Writer
+}
+
+// NovaCSV escreve CSV separado por virgula; o destino recebe os dados a cada
+// buffer de 4 KB do encoding/csv
+func NovaCSV(w io.Writer) Planilha {
+	return &planilhaCSV{w: csv.NewWriter(w)}
+}
+
+func (p *planilhaCSV) Linha(celulas ...any) error {
+	campos := make([]string, len(celulas))
+	for i, c := range celulas {
+		campos[i] = celulaCSV(c)
+	}
+	return p.w.Write(campos)
+}
+
+// celulaCSV neutraliza textos que o Excel e o LibreOffice abririam como
+// formula (injecao de CSV) prefixando um apostrofo. Numeros ficam como estao:
+// -5 e um valor, nao uma formula.
+func celulaCSV(celula any) string {
+	s := texto(celula)
+	if _, ok := celula.(string); ok && s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (p *planilhaCSV) Fechar() error {
+	p.w.Flush()
+	return p.w.Error()
+}
@@ -0,0 +1,64 @@
+// Package exportacao escreve planilhas linha a linha direto no destino, sem
+// montar o arquivo inteiro em memoria: CSV e XLSX (Office Open XML minimo,
+// uma aba, sem estilos).
+package exportacao
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Planilha recebe as linhas na ordem em que devem aparecer. As celulas podem
+// ser string, int ou float64; numeros viram celulas numericas no XLSX.
+// Fechar completa o arquivo e deve ser chamado mesmo sem linhas.
+type Planilha interface {
+	Linha(celulas ...any) error
+	Fechar() error
+}
+
+// Formato descreve um formato de exportacao aceito
+type Formato struct {
+	Nome        string
+	ContentType string
+	Extensao    string
+	Nova        func(w io.Writer) Planilha
+}
+
+var formatos = map[string]Formato{
+	"csv": {
+		Nome:        "csv",
+		ContentType: "text/csv; charset=utf-8",
+		Extensao:    ".csv",
+		Nova:        NovaCSV,
+	},
+	"xlsx": {
+		Nome:        "xlsx",
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Extensao:    ".xlsx",
+		Nova:        NovaXLSX,
+	},
+}
+
+// BuscarFormato retorna o formato pelo nome (csv, xlsx)
+func BuscarFormato(nome string) (Formato, bool) {
+	f, ok := formatos[nome]
+	return f, ok
+}
+
+// texto converte a celula para o CSV; numeros com ponto decimal e sem
+// notacao cientifica
+func texto(celula any) string {
+	switch v := celula.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
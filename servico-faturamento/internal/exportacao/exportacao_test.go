@@ -0,0 +1,140 @@
+package exportacao_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"testing"
+
+	"servico-faturamento/internal/exportacao"
+)
+
+func escrever(t *testing.T, formato string, linhas [][]any) []byte {
+	t.Helper()
+
+	f, ok := exportacao.BuscarFormato(formato)
+	if !ok {
+		t.Fatalf("formato %s nao encontrado", formato)
+	}
+	var buf bytes.Buffer
+	p := f.Nova(&buf)
+	for _, l := range linhas {
+		if err := p.Linha(l...); err != nil {
+			t.Fatalf("falha ao escrever linha: %v", err)
+		}
+	}
+	if err := p.Fechar(); err != nil {
+		t.Fatalf("falha ao fechar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+var linhasTeste = [][]any{
+	{"numero", "quantidade", "valor"},
+	{"NF-1, \"a\"", 3, 10.5},
+	{"<&>", 0, 1234567.89},
+}
+
+func TestCSV(t *testing.T) {
+	saida := string(escrever(t, "csv", linhasTeste))
+
+	esperado := "numero,quantidade,valor\n\"NF-1, \"\"a\"\"\",3,10.5\n<&>,0,1234567.89\n"
+	if saida != esperado {
+		t.Errorf("csv inesperado:\n%s", saida)
+	}
+}
+
+func TestCSV_NeutralizaFormulas(t *testing.T) {
+	saida := string(escrever(t, "csv", [][]any{
+		{"=HYPERLINK(\"http://x\")", "+1", "-2+3", "@SOMA(A1)", "\tcmd", "\rcmd"},
+		{"NF-1", "a=b", -5, -1.5, "", nil},
+	}))
+
+	esperado := "\"'=HYPERLINK(\"\"http://x\"\")\",'+1,'-2+3,'@SOMA(A1),'\tcmd,\"'\rcmd\"\n" +
+		"NF-1,a=b,-5,-1.5,,\n"
+	if saida != esperado {
+		t.Errorf("csv inesperado:\n%q", saida)
+	}
+}
+
+// celulaXLSX le tanto celulas numericas (v) quanto de texto (is/t)
+type celulaXLSX struct {
+	Tipo  string `xml:"t,attr"`
+	Valor string `xml:"v"`
+	Texto string `xml:"is>t"`
+}
+
+type abaXLSX struct {
+	Linhas []struct {
+		Celulas []celulaXLSX `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func lerXLSX(t *testing.T, dados []byte) abaXLSX {
+	t.Helper()
+
+	z, err := zip.NewReader(bytes.NewReader(dados), int64(len(dados)))
+	if err != nil {
+		t.Fatalf("xlsx nao e um zip valido: %v", err)
+	}
+	partes := map[string]*zip.File{}
+	for _, f := range z.File {
+		partes[f.Name] = f
+	}
+	for _, nome := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if partes[nome] == nil {
+			t.Errorf("parte %s ausente", nome)
+		}
+	}
+	f := partes["xl/worksheets/sheet1.xml"]
+	if f == nil {
+		t.Fatal("aba ausente")
+	}
+	r, _ := f.Open()
+	conteudo, _ := io.ReadAll(r)
+
+	var aba abaXLSX
+	if err := xml.Unmarshal(conteudo, &aba); err != nil {
+		t.Fatalf("aba com XML invalido: %v", err)
+	}
+	return aba
+}
+
+func TestXLSX(t *testing.T) {
+	aba := lerXLSX(t, escrever(t, "xlsx", linhasTeste))
+
+	var lidas [][]string
+	for _, l := range aba.Linhas {
+		var celulas []string
+		for _, c := range l.Celulas {
+			if c.Tipo == "inlineStr" {
+				celulas = append(celulas, "s:"+c.Texto)
+			} else {
+				celulas = append(celulas, "n:"+c.Valor)
+			}
+		}
+		lidas = append(lidas, celulas)
+	}
+	esperado := [][]string{
+		{"s:numero", "s:quantidade", "s:valor"},
+		{"s:NF-1, \"a\"", "n:3", "n:10.5"},
+		{"s:<&>", "n:0", "n:1234567.89"},
+	}
+	if !reflect.DeepEqual(lidas, esperado) {
+		t.Errorf("esperava %v, obteve %v", esperado, lidas)
+	}
+}
+
+func TestXLSX_SemLinhas(t *testing.T) {
+	if aba := lerXLSX(t, escrever(t, "xlsx", nil)); len(aba.Linhas) != 0 {
+		t.Errorf("esperava aba vazia, obteve %d linhas", len(aba.Linhas))
+	}
+}
+
+func TestBuscarFormato_Desconhecido(t *testing.T) {
+	if _, ok := exportacao.BuscarFormato("pdf"); ok {
+		t.Error("pdf nao deveria ser aceito")
+	}
+}
@@ -0,0 +1,115 @@
+package exportacao
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// partes fixas do pacote; a aba vem por ultimo para ser escrita aos poucos
+var partesXLSX = []struct{ nome, conteudo string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Planilha1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+const (
+	inicioAba = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	fimAba = `</sheetData></worksheet>`
+)
+
+type planilhaXLSX struct {
+	zip    *zip.Writer
+	aba    io.Writer
+	linhas int
+	err    error // primeiro erro de escrita; as chamadas seguintes o repetem
+}
+
+// NovaXLSX escreve um XLSX de uma aba. O zip e comprimido conforme as linhas
+// chegam; so o diretorio central, escrito em Fechar, depende do arquivo todo.
+func NovaXLSX(w io.Writer) Planilha {
+	return &planilhaXLSX{zip: zip.NewWriter(w)}
+}
+
+func (p *planilhaXLSX) iniciar() error {
+	if p.aba != nil || p.err != nil {
+		return p.err
+	}
+	for _, parte := range partesXLSX {
+		f, err := p.zip.Create(parte.nome)
+		if err != nil {
+			p.err = err
+			return err
+		}
+		if _, err := io.WriteString(f, parte.conteudo); err != nil {
+			p.err = err
+			return err
+		}
+	}
+	aba, err := p.zip.Create("xl/worksheets/sheet1.xml")
+	if err == nil {
+		_, err = io.WriteString(aba, inicioAba)
+	}
+	p.aba, p.err = aba, err
+	return err
+}
+
+func (p *planilhaXLSX) Linha(celulas ...any) error {
+	if err := p.iniciar(); err != nil {
+		return err
+	}
+	p.linhas++
+
+	var b strings.Builder
+	b.WriteString(`<row r="`)
+	b.WriteString(strconv.Itoa(p.linhas))
+	b.WriteString(`">`)
+	for _, c := range celulas {
+		switch v := c.(type) {
+		case int:
+			b.WriteString(`<c><v>` + strconv.Itoa(v) + `</v></c>`)
+		case float64:
+			b.WriteString(`<c><v>` + strconv.FormatFloat(v, 'f', -1, 64) + `</v></c>`)
+		default:
+			b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			// EscapeText tambem troca caracteres invalidos em XML
+			xml.EscapeText(&b, []byte(texto(c)))
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+
+	if _, err := io.WriteString(p.aba, b.String()); err != nil {
+		p.err = err
+	}
+	return p.err
+}
+
+func (p *planilhaXLSX) Fechar() error {
+	if err := p.iniciar(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(p.aba, fimAba); err != nil {
+		return err
+	}
+	return p.zip.Close()
+}
@@ -0,0 +1,84 @@
+package manipulador
+
+import (
+	"errors"
+	"net/http"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CabecalhoUsuario identifica o ator das escritas; preenchido pelo gateway
+// que autentica o usuario
+const CabecalhoUsuario = "X-Usuario"
+
+// CabecalhoRequisicao identifica a requisicao na trilha de auditoria; sem ele
+// o servico gera um ID e o devolve no mesmo cabecalho
+const CabecalhoRequisicao = "X-Request-ID"
+
+// AtorAnonimo assina as escritas de requisicoes sem X-Usuario
+const AtorAnonimo = "anonimo"
+
+// Autoria anota no contexto quem fez a requisicao, para os repositorios
+// gravarem na trilha de auditoria
+func Autoria() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		autoria := dominio.Autoria{
+			Ator:         c.GetHeader(CabecalhoUsuario),
+			IDRequisicao: c.GetHeader(CabecalhoRequisicao),
+		}
+		if autoria.Ator == "" {
+			autoria.Ator = AtorAnonimo
+		}
+		if autoria.IDRequisicao == "" {
+			autoria.IDRequisicao = uuid.NewString()
+		}
+		c.Header(CabecalhoRequisicao, autoria.IDRequisicao)
+
+		c.Request = c.Request.WithContext(repositorio.ComAutoria(c.Request.Context(), autoria))
+		c.Next()
+	}
+}
+
+// tabelasAuditadas sao os valores aceitos em ?tabela=
+var tabelasAuditadas = map[string]bool{
+	(&dominio.NotaFiscal{}).TableName():           true,
+	(&dominio.ItemNota{}).TableName():             true,
+	(&dominio.SolicitacaoImpressao{}).TableName(): true,
+}
+
+// GET /api/v1/notas/:id/auditoria?tabela=&limite=&offset=
+func (h *Handlers) ListarAuditoria(c *gin.Context) {
+	notaID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "ID invalido"})
+		return
+	}
+
+	filtro := repositorio.FiltroAuditoria{Tabela: c.Query("tabela")}
+	if filtro.Tabela != "" && !tabelasAuditadas[filtro.Tabela] {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "tabela invalida: use notas_fiscais, itens_nota ou solicitacoes_impressao"})
+		return
+	}
+	filtro.Limite, filtro.Offset = paginacao(c)
+
+	ctx := c.Request.Context()
+	registros, err := h.Banco.Auditoria().Listar(ctx, notaID, filtro)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao listar auditoria"})
+		return
+	}
+
+	// a trilha sobrevive a nota; so e 404 se nunca houve nada com esse ID
+	if len(registros) == 0 && filtro.Offset == 0 && filtro.Tabela == "" {
+		if _, err := h.Banco.Notas().Buscar(ctx, notaID); errors.Is(err, repositorio.ErrNaoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"erro": "Nota nao encontrada"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, registros)
+}
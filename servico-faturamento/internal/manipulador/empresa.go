@@ -0,0 +1,104 @@
+package manipulador
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CabecalhoEmpresa identifica a empresa (tenant) da requisicao; o gateway o
+// preenche a partir da credencial do usuario autenticado
+const CabecalhoEmpresa = "X-Empresa"
+
+// CabecalhoAssinaturaEmpresa prova que X-Empresa veio do gateway e nao do
+// cliente: HMAC-SHA256 do valor com a chave compartilhada, em hex
+const CabecalhoAssinaturaEmpresa = "X-Empresa-Assinatura"
+
+// TodasEmpresas e o escopo explicito das rotas de administracao que
+// enxergam o banco inteiro; so vale assinado pelo gateway
+const TodasEmpresas = "*"
+
+// tamanhoMaximoEmpresa acompanha o VARCHAR(50) de empresa_id
+const tamanhoMaximoEmpresa = 50
+
+// AssinarEmpresa calcula o valor de X-Empresa-Assinatura, como o gateway faz
+func AssinarEmpresa(chave []byte, empresa string) string {
+	return hex.EncodeToString(assinaturaBruta(chave, empresa))
+}
+
+// Empresa escopa a requisicao na empresa de X-Empresa, ou em padrao quando o
+// cabecalho nao vem (instalacoes de uma empresa so). O cabecalho so e aceito
+// com a assinatura do gateway feita com chave; sem chave configurada ele e
+// recusado. Sem empresa responde 400: as rotas de negocio nunca enxergam o
+// banco inteiro.
+func Empresa(padrao string, chave []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		empresa, ok := empresaDaRequisicao(c, padrao, chave)
+		if !ok {
+			return
+		}
+		if empresa == TodasEmpresas {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"erro": "Escopo de todas as empresas so vale na administracao"})
+			return
+		}
+		escoparEmpresa(c, empresa)
+	}
+}
+
+// EmpresaAdmin escopa a administracao do outbox e da quarentena como Empresa,
+// mas aceita o escopo TodasEmpresas para enxergar o banco inteiro. O escopo
+// e sempre explicito: sem empresa responde 400.
+func EmpresaAdmin(padrao string, chave []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		empresa, ok := empresaDaRequisicao(c, padrao, chave)
+		if !ok {
+			return
+		}
+		if empresa == TodasEmpresas {
+			c.Next()
+			return
+		}
+		escoparEmpresa(c, empresa)
+	}
+}
+
+// empresaDaRequisicao le a empresa assinada de X-Empresa ou cai no padrao.
+// Responde o erro e devolve false quando a requisicao nao pode seguir.
+func empresaDaRequisicao(c *gin.Context, padrao string, chave []byte) (string, bool) {
+	empresa := c.GetHeader(CabecalhoEmpresa)
+	if empresa == "" {
+		if padrao == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"erro": "Header X-Empresa obrigatorio"})
+			return "", false
+		}
+		return padrao, true
+	}
+
+	if len(empresa) > tamanhoMaximoEmpresa {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"erro": "X-Empresa invalido"})
+		return "", false
+	}
+	// sem chave nao ha como saber se o cabecalho veio do gateway
+	assinatura, err := hex.DecodeString(c.GetHeader(CabecalhoAssinaturaEmpresa))
+	if len(chave) == 0 || err != nil || !hmac.Equal(assinatura, assinaturaBruta(chave, empresa)) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"erro": "X-Empresa sem assinatura valida do gateway"})
+		return "", false
+	}
+	return empresa, true
+}
+
+func assinaturaBruta(chave []byte, empresa string) []byte {
+	mac := hmac.New(sha256.New, chave)
+	mac.Write([]byte(empresa))
+	return mac.Sum(nil)
+}
+
+func escoparEmpresa(c *gin.Context, empresa string) {
+	c.Request = c.Request.WithContext(repositorio.ComEmpresa(c.Request.Context(), empresa))
+	c.Next()
+}
@@ -0,0 +1,88 @@
+package manipulador
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/exportacao"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+)
+
+const formatoDataExportacao = "2006-01-02 15:04:05"
+
+var cabecalhoExportacao = []any{
+	"numero", "destinatario", "status", "data_criacao", "data_fechada",
+	"sku", "produto", "quantidade", "preco_unitario", "subtotal", "total_nota",
+}
+
+// GET /api/v1/notas/exportar?formato=csv|xlsx&status=&arquivadas=
+// Mesmos filtros de ListarNotas. Uma linha por item e, fechando cada nota,
+// uma linha com o total (CalcularTotal); a ultima linha traz o total geral.
+// As notas sao lidas em lotes e escritas conforme chegam.
+func (h *Handlers) ExportarNotas(c *gin.Context) {
+	formato, ok := exportacao.BuscarFormato(c.DefaultQuery("formato", "csv"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "Formato invalido: use csv ou xlsx"})
+		return
+	}
+	filtro := repositorio.FiltroNotas{
+		Status:     c.Query("status"),
+		Arquivadas: c.Query("arquivadas") == "true",
+	}
+
+	c.Header("Content-Type", formato.ContentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="notas-%s%s"`, time.Now().Format("20060102"), formato.Extensao))
+	c.Status(http.StatusOK)
+
+	planilha := formato.Nova(c.Writer)
+	ctx := c.Request.Context()
+	err := planilha.Linha(cabecalhoExportacao...)
+	var totalGeral float64
+	if err == nil {
+		err = h.Banco.Leitura(ctx).Notas().Percorrer(ctx, filtro, func(nota dominio.NotaFiscal) error {
+			totalGeral += nota.CalcularTotal()
+			return escreverNota(planilha, nota)
+		})
+	}
+	if err == nil {
+		err = planilha.Linha("TOTAL GERAL", "", "", "", "", "", "", "", "", "", totalGeral)
+	}
+	if err == nil {
+		err = planilha.Fechar()
+	}
+	if err == nil {
+		return
+	}
+
+	log.Printf("[exportacao] Falha ao exportar notas em %s: %v", formato.Nome, err)
+	if !c.Writer.Written() {
+		// nada saiu ainda: da para responder o erro no lugar do arquivo
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao exportar notas"})
+		return
+	}
+	// o arquivo ja comecou a sair: o cliente recebe um arquivo truncado, sem
+	// a linha de total geral
+}
+
+func escreverNota(planilha exportacao.Planilha, nota dominio.NotaFiscal) error {
+	fechada := ""
+	if nota.DataFechada != nil {
+		fechada = nota.DataFechada.Format(formatoDataExportacao)
+	}
+	criada := nota.DataCriacao.Format(formatoDataExportacao)
+
+	for _, item := range nota.Itens {
+		if err := planilha.Linha(nota.Numero, nota.Destinatario, nota.Status, criada, fechada,
+			item.Sku, item.NomeProduto, item.Quantidade, item.PrecoUnitario, item.CalcularSubtotal(), ""); err != nil {
+			return err
+		}
+	}
+	return planilha.Linha(nota.Numero, nota.Destinatario, nota.Status, criada, fechada,
+		"", "TOTAL DA NOTA", "", "", "", nota.CalcularTotal())
+}
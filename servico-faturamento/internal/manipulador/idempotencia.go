@@ -0,0 +1,89 @@
+package manipulador
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// errChaveEmUso indica que outra requisicao gravou a mesma Idempotency-Key
+// entre a verificacao e a transacao
+var errChaveEmUso = errors.New("idempotency-key gravada por outra requisicao")
+
+// requisicaoIdempotente e uma requisicao com Idempotency-Key: a chave vale
+// uma vez por empresa e fica ligada ao alvo (nota e operacao). hash, quando
+// preenchido, distingue requisicoes no mesmo alvo.
+type requisicaoIdempotente struct {
+	chave    string
+	notaID   uuid.UUID
+	operacao string
+	hash     string
+}
+
+// hashRequisicao cobre metodo e caminho da requisicao
+func hashRequisicao(c *gin.Context) string {
+	h := sha256.Sum256([]byte(c.Request.Method + "\n" + c.Request.URL.Path))
+	return hex.EncodeToString(h[:])
+}
+
+// responderChaveUsada responde pela Idempotency-Key ja registrada: 200 com a
+// solicitacao que ela criou quando a requisicao e a mesma, 422 quando a chave
+// foi usada em outro alvo ou em outra requisicao. false se a chave esta livre.
+func (h *Handlers) responderChaveUsada(c *gin.Context, req requisicaoIdempotente) bool {
+	ctx := c.Request.Context()
+	registro, err := h.Banco.Idempotencia().Buscar(ctx, req.chave)
+	if errors.Is(err, repositorio.ErrNaoEncontrado) {
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao verificar Idempotency-Key"})
+		return true
+	}
+
+	if !registro.MesmoAlvo(req.notaID, req.operacao) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"erro":     "Idempotency-Key ja usada em outra requisicao",
+			"notaId":   registro.NotaID,
+			"operacao": registro.Operacao,
+		})
+		return true
+	}
+	if !registro.MesmaRequisicao(req.hash) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"erro":          "Idempotency-Key ja usada em outra requisicao",
+			"solicitacaoId": registro.SolicitacaoID,
+		})
+		return true
+	}
+
+	sol, err := h.Banco.Solicitacoes().Buscar(ctx, registro.SolicitacaoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar solicitacao"})
+		return true
+	}
+	c.JSON(http.StatusOK, sol)
+	return true
+}
+
+// registrarChave grava a Idempotency-Key na transacao que criou sol
+func registrarChave(ctx context.Context, r repositorio.Repositorios, req requisicaoIdempotente, sol *dominio.SolicitacaoImpressao) error {
+	err := r.Idempotencia().Registrar(ctx, &dominio.ChaveIdempotencia{
+		Chave:          req.chave,
+		NotaID:         req.notaID,
+		Operacao:       req.operacao,
+		HashRequisicao: req.hash,
+		SolicitacaoID:  sol.ID,
+	})
+	if errors.Is(err, repositorio.ErrDuplicado) {
+		return errChaveEmUso
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package manipulador
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CookiePrimario guarda, em unix segundos, ate quando as leituras do cliente
+// vao para o primario
+const CookiePrimario = "faturamento_primario"
+
+// CabecalhoLerPrimario permite a clientes sem cookie (outros servicos, chamadas
+// cross-origin) pedir uma leitura no primario
+const CabecalhoLerPrimario = "X-Ler-Primario"
+
+// LeituraConsistente garante read-your-writes quando ha replicas: toda
+// requisicao que escreve fixa o cliente no primario por `janela`, e as
+// leituras dentro dela nao caem numa replica que ainda nao recebeu a escrita
+func LeituraConsistente(janela time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agora := time.Now()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if primarioFixado(c, agora) {
+				c.Request = c.Request.WithContext(repositorio.FixarPrimario(c.Request.Context()))
+			}
+		default:
+			ate := agora.Add(janela)
+			maxAge := int(math.Ceil(janela.Seconds()))
+			c.SetCookie(CookiePrimario, strconv.FormatInt(ate.Unix(), 10), maxAge, "/", "", false, true)
+		}
+
+		c.Next()
+	}
+}
+
+func primarioFixado(c *gin.Context, agora time.Time) bool {
+	if c.GetHeader(CabecalhoLerPrimario) == "true" {
+		return true
+	}
+	valor, err := c.Cookie(CookiePrimario)
+	if err != nil {
+		return false
+	}
+	// o Max-Age ja expira o cookie no navegador; o prazo no valor cobre
+	// clientes que reenviam cookies vencidos
+	ate, err := strconv.ParseInt(valor, 10, 64)
+	return err == nil && agora.Unix() <= ate
+}
@@ -0,0 +1,61 @@
+package manipulador_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/manipulador"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLeituraConsistente(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(manipulador.LeituraConsistente(5 * time.Second))
+	r.POST("/notas", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	r.GET("/notas", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"primario": repositorio.PrimarioFixado(c.Request.Context())})
+	})
+
+	ler := func(preparar func(req *http.Request)) string {
+		req := httptest.NewRequest(http.MethodGet, "/notas", nil)
+		preparar(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notas", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != manipulador.CookiePrimario || cookies[0].MaxAge != 5 {
+		t.Fatalf("escrita deveria fixar o primario por 5s, obteve %+v", cookies)
+	}
+
+	casos := []struct {
+		nome     string
+		preparar func(req *http.Request)
+		esperado string
+	}{
+		{"sem cookie", func(req *http.Request) {}, `{"primario":false}`},
+		{"logo apos escrever", func(req *http.Request) { req.AddCookie(cookies[0]) }, `{"primario":true}`},
+		{"cookie vencido", func(req *http.Request) {
+			vencido := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+			req.AddCookie(&http.Cookie{Name: manipulador.CookiePrimario, Value: vencido})
+		}, `{"primario":false}`},
+		{"cabecalho", func(req *http.Request) { req.Header.Set(manipulador.CabecalhoLerPrimario, "true") }, `{"primario":true}`},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nome, func(t *testing.T) {
+			if corpo := ler(caso.preparar); corpo != caso.esperado {
+				t.Errorf("esperava %s, obteve %s", caso.esperado, corpo)
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package manipulador
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -9,21 +9,35 @@ import (
 	"time"
 
 	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+	"servico-faturamento/internal/saga"
+	"servico-faturamento/internal/saude"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type Handlers struct {
-	DB *gorm.DB
+	Banco repositorio.Banco
+
+	// TimeoutImpressao e o prazo da saga de impressao; zero usa o padrao
+	TimeoutImpressao time.Duration
+
+	// Processador reprocessa mensagens da quarentena (o consumidor)
+	Processador ProcessadorMensagens
+
+	// Outbox republica eventos a pedido da administracao (o publicador)
+	Outbox RepublicadorOutbox
+
+	// Saude verifica as dependencias para o readiness
+	Saude *saude.Verificador
 }
 
 // POST /api/v1/notas
 func (h *Handlers) CriarNota(c *gin.Context) {
 	var req struct {
-		Numero string `json:"numero" binding:"required"`
+		Numero       string `json:"numero" binding:"required,max=20"`
+		Destinatario string `json:"destinatario" binding:"max=200"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -32,11 +46,16 @@ func (h *Handlers) CriarNota(c *gin.Context) {
 	}
 
 	nota := dominio.NotaFiscal{
-		Numero: req.Numero,
-		Status: dominio.StatusNotaAberta,
+		Numero:       req.Numero,
+		Destinatario: req.Destinatario,
+		Status:       dominio.StatusNotaAberta,
 	}
 
-	if err := h.DB.Create(&nota).Error; err != nil {
+	if err := h.Banco.Notas().Criar(c.Request.Context(), &nota); err != nil {
+		if errors.Is(err, repositorio.ErrDuplicado) {
+			c.JSON(http.StatusConflict, gin.H{"erro": "Ja existe nota com esse numero"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao criar nota"})
 		return
 	}
@@ -44,22 +63,45 @@ func (h *Handlers) CriarNota(c *gin.Context) {
 	c.JSON(http.StatusCreated, nota)
 }
 
-// GET /api/v1/notas
+// GET /api/v1/notas?status=&arquivadas=true
+// Notas arquivadas so entram com arquivadas=true
 func (h *Handlers) ListarNotas(c *gin.Context) {
-	var notas []dominio.NotaFiscal
+	ctx := c.Request.Context()
+	notas, err := h.Banco.Leitura(ctx).Notas().Listar(ctx, repositorio.FiltroNotas{
+		Status:     c.Query("status"),
+		Arquivadas: c.Query("arquivadas") == "true",
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao listar notas"})
+		return
+	}
 
-	query := h.DB.Preload("Itens")
+	c.JSON(http.StatusOK, notas)
+}
 
-	if status := c.Query("status"); status != "" {
-		query = query.Where("status = ?", status)
+// GET /api/v1/notas/busca?q=&limite=&offset=
+// Busca por numero, destinatario e SKU/nome dos itens. O destaque nao e
+// escapado: quem renderiza HTML escapa o texto e so entao troca os marcadores.
+func (h *Handlers) PesquisarNotas(c *gin.Context) {
+	texto := c.Query("q")
+	if len(repositorio.TermosPesquisa(texto)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "Parametro q obrigatorio"})
+		return
 	}
 
-	if err := query.Find(&notas).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao listar notas"})
+	limite, offset := paginacao(c)
+	ctx := c.Request.Context()
+	resultados, err := h.Banco.Leitura(ctx).Notas().Pesquisar(ctx, repositorio.FiltroPesquisa{
+		Texto:  texto,
+		Limite: limite,
+		Offset: offset,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao pesquisar notas"})
 		return
 	}
 
-	c.JSON(http.StatusOK, notas)
+	c.JSON(http.StatusOK, resultados)
 }
 
 // GET /api/v1/notas/:id
@@ -70,9 +112,10 @@ func (h *Handlers) BuscarNota(c *gin.Context) {
 		return
 	}
 
-	var nota dominio.NotaFiscal
-	if err := h.DB.Preload("Itens").First(&nota, "id = ?", id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	ctx := c.Request.Context()
+	nota, err := h.Banco.Leitura(ctx).Notas().Buscar(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
 			c.JSON(http.StatusNotFound, gin.H{"erro": "Nota nao encontrada"})
 			return
 		}
@@ -108,9 +151,9 @@ func (h *Handlers) AdicionarItem(c *gin.Context) {
 		return
 	}
 
-	var nota dominio.NotaFiscal
-	if err := h.DB.First(&nota, "id = ?", notaID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	nota, err := h.Banco.Notas().Buscar(c.Request.Context(), notaID)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
 			c.JSON(http.StatusNotFound, gin.H{"erro": "Nota nao encontrada"})
 			return
 		}
@@ -123,14 +166,44 @@ func (h *Handlers) AdicionarItem(c *gin.Context) {
 		return
 	}
 
+	// catalogo local, projetado dos eventos de produto do Estoque
+	produto, err := h.Banco.Produtos().Buscar(c.Request.Context(), prodID)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"erro": "Produto nao encontrado no catalogo"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar produto"})
+		return
+	}
+
+	if !produto.Ativo {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"erro": "Produto inativo"})
+		return
+	}
+
 	item := dominio.ItemNota{
 		NotaID:        notaID,
 		ProdutoID:     prodID,
 		Quantidade:    req.Quantidade,
 		PrecoUnitario: req.PrecoUnitario,
+		Sku:           produto.Sku,
+		NomeProduto:   produto.Nome,
 	}
 
-	if err := h.DB.Create(&item).Error; err != nil {
+	// a nota travada serializa com ImprimirNota: ou o item entra antes do
+	// congelamento, ou a nota ja tem impressao pendente e recusa o item
+	ctx := c.Request.Context()
+	err = h.Banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+		if _, err := notaEditavel(ctx, r, notaID); err != nil {
+			return err
+		}
+		return r.Notas().AdicionarItem(ctx, &item)
+	})
+	if err != nil {
+		if responderNotaNaoEditavel(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao adicionar item"})
 		return
 	}
@@ -139,6 +212,9 @@ func (h *Handlers) AdicionarItem(c *gin.Context) {
 }
 
 // POST /api/v1/notas/:id/imprimir
+// Uma impressao pendente por nota: outra chave recebe 409 com a solicitacaoId
+// da pendente. A Idempotency-Key vale para esta nota e operacao; reusada em
+// outro alvo recebe 422.
 func (h *Handlers) ImprimirNota(c *gin.Context) {
 	notaID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -152,15 +228,16 @@ func (h *Handlers) ImprimirNota(c *gin.Context) {
 		return
 	}
 
-	var solExistente dominio.SolicitacaoImpressao
-	if err := h.DB.Where("chave_idempotencia = ?", chaveIdem).First(&solExistente).Error; err == nil {
-		c.JSON(http.StatusOK, solExistente)
+	reqIdem := requisicaoIdempotente{chave: chaveIdem, notaID: notaID, operacao: dominio.OperacaoImprimir}
+	if h.responderChaveUsada(c, reqIdem) {
 		return
 	}
 
-	var nota dominio.NotaFiscal
-	if err := h.DB.First(&nota, "id = ?", notaID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	ctx := c.Request.Context()
+
+	nota, err := h.Banco.Notas().Buscar(ctx, notaID)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
 			c.JSON(http.StatusNotFound, gin.H{"erro": "Nota nao encontrada"})
 			return
 		}
@@ -173,81 +250,73 @@ func (h *Handlers) ImprimirNota(c *gin.Context) {
 		return
 	}
 
-	var itens []dominio.ItemNota
-	if err := h.DB.Where("nota_id = ?", notaID).Find(&itens).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar itens"})
-		return
-	}
-
-	if len(itens) == 0 {
+	if len(nota.Itens) == 0 {
 		c.JSON(http.StatusConflict, gin.H{"erro": "Nota sem itens nao pode ser impressa"})
 		return
 	}
 
-	err = h.DB.Transaction(func(tx *gorm.DB) error {
-		sol := dominio.SolicitacaoImpressao{
-			NotaID:            notaID,
-			Status:            "PENDENTE",
-			ChaveIdempotencia: chaveIdem,
-		}
+	ctxEvento := dominio.NovoContextoEvento(c.GetHeader("X-Correlation-ID"))
+	prazo := h.prazoImpressao()
 
-		if err := tx.Create(&sol).Error; err != nil {
-			if errors.Is(err, gorm.ErrDuplicatedKey) {
-				return nil
+	var sol dominio.SolicitacaoImpressao
+	err = h.Banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+		// com a nota travada nenhum item entra entre a leitura e o congelamento
+		nota, err := notaImprimivel(ctx, r, notaID)
+		if err != nil {
+			// a mesma chave, gravada por uma requisicao paralela, e repeticao
+			var pendente errImpressaoPendente
+			if errors.As(err, &pendente) && pendente.chave == chaveIdem {
+				return errChaveEmUso
 			}
 			return err
 		}
 
-		type itemEvento struct {
-			ProdutoID  string `json:"produtoId"`
-			Quantidade int    `json:"quantidade"`
-		}
-
-		type payloadEvento struct {
-			NotaID string       `json:"notaId"`
-			Itens  []itemEvento `json:"itens"`
+		sol = dominio.SolicitacaoImpressao{
+			NotaID:            notaID,
+			Status:            dominio.StatusSolicitacaoPendente,
+			ChaveIdempotencia: chaveIdem,
+			IDCorrelacao:      ctxEvento.IDCorrelacao,
+			PrazoExpiracao:    &prazo,
+			Itens:             dominio.CongelarItens(nota.Itens),
 		}
 
-		var itensEvento []itemEvento
-		for _, item := range itens {
-			itensEvento = append(itensEvento, itemEvento{
-				ProdutoID:  item.ProdutoID.String(),
-				Quantidade: item.Quantidade,
-			})
+		if err := r.Solicitacoes().Criar(ctx, &sol); err != nil {
+			return err
 		}
-
-		payload := payloadEvento{
-			NotaID: notaID.String(),
-			Itens:  itensEvento,
+		if err := registrarChave(ctx, r, reqIdem, &sol); err != nil {
+			return err
 		}
 
-		payloadJSON, err := json.Marshal(payload)
+		eventoOutbox, err := novoEventoImpressao(&sol, ctxEvento)
 		if err != nil {
-			return fmt.Errorf("falha ao serializar payload: %w", err)
+			return err
 		}
 
-		eventoOutbox := dominio.EventoOutbox{
-			TipoEvento:     "Faturamento.ImpressaoSolicitada",
-			IdAgregado:     notaID,
-			Payload:        string(payloadJSON),
-			DataOcorrencia: time.Now(),
+		if err := r.Outbox().Adicionar(ctx, &eventoOutbox); err != nil {
+			return fmt.Errorf("falha ao criar evento outbox: %w", err)
 		}
 
-		if err := tx.Create(&eventoOutbox).Error; err != nil {
-			return fmt.Errorf("falha ao criar evento outbox: %w", err)
+		if err := saga.Iniciar(ctx, r, &sol, &eventoOutbox); err != nil {
+			return err
 		}
 
-		log.Printf("[outbox] Evento de impressao criado: %s para nota %s", eventoOutbox.TipoEvento, notaID)
+		log.Printf("[outbox] Evento de impressao criado: %s para nota %s (correlacao %s)", eventoOutbox.TipoEvento, notaID, ctxEvento.IDCorrelacao)
 		return nil
 	})
 
 	if err != nil {
+		if errors.Is(err, errChaveEmUso) && h.responderChaveUsada(c, reqIdem) {
+			return
+		}
+		if responderNotaNaoEditavel(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"erro": fmt.Sprintf("Falha ao processar: %v", err)})
 		return
 	}
 
-	var solCriada dominio.SolicitacaoImpressao
-	if err := h.DB.Where("chave_idempotencia = ?", chaveIdem).First(&solCriada).Error; err != nil {
+	solCriada, err := h.Banco.Solicitacoes().Buscar(ctx, sol.ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar solicitacao"})
 		return
 	}
@@ -255,6 +324,99 @@ func (h *Handlers) ImprimirNota(c *gin.Context) {
 	c.JSON(http.StatusCreated, solCriada)
 }
 
+var (
+	errNotaNaoAberta = errors.New("nota nao esta aberta")
+	errNotaSemItens  = errors.New("nota sem itens")
+)
+
+// errImpressaoPendente: os itens da nota estao congelados numa solicitacao
+// que o Estoque ainda nao respondeu
+type errImpressaoPendente struct {
+	solicitacaoID uuid.UUID
+	chave         string
+}
+
+func (e errImpressaoPendente) Error() string {
+	return fmt.Sprintf("nota com impressao pendente (solicitacao %s)", e.solicitacaoID)
+}
+
+// notaEditavel trava a nota na transacao e confirma que ela aceita itens:
+// aberta e sem impressao pendente
+func notaEditavel(ctx context.Context, r repositorio.Repositorios, notaID uuid.UUID) (dominio.NotaFiscal, error) {
+	nota, err := r.Notas().BuscarParaAtualizar(ctx, notaID)
+	if err != nil {
+		return nota, err
+	}
+	if nota.Status != dominio.StatusNotaAberta {
+		return nota, errNotaNaoAberta
+	}
+
+	pendentes, err := r.Solicitacoes().PendentesParaAtualizar(ctx, notaID)
+	if err != nil {
+		return nota, fmt.Errorf("falha ao buscar solicitacoes: %w", err)
+	}
+	if len(pendentes) > 0 {
+		return nota, errImpressaoPendente{pendentes[0].ID, pendentes[0].ChaveIdempotencia}
+	}
+	return nota, nil
+}
+
+// notaImprimivel trava a nota na transacao e confirma que ela pode ser
+// impressa: editavel, ja que uma segunda impressao pendente reservaria o
+// estoque de novo, e com itens para congelar
+func notaImprimivel(ctx context.Context, r repositorio.Repositorios, notaID uuid.UUID) (dominio.NotaFiscal, error) {
+	nota, err := notaEditavel(ctx, r, notaID)
+	if err != nil {
+		return nota, err
+	}
+	if len(nota.Itens) == 0 {
+		return nota, errNotaSemItens
+	}
+	return nota, nil
+}
+
+// responderNotaNaoEditavel responde 404/409 para os erros de notaEditavel e
+// notaImprimivel; false se err e outro
+func responderNotaNaoEditavel(c *gin.Context, err error) bool {
+	var pendente errImpressaoPendente
+	switch {
+	case errors.Is(err, repositorio.ErrNaoEncontrado):
+		c.JSON(http.StatusNotFound, gin.H{"erro": "Nota nao encontrada"})
+	case errors.Is(err, errNotaNaoAberta):
+		c.JSON(http.StatusConflict, gin.H{"erro": "Nota nao esta aberta"})
+	case errors.Is(err, errNotaSemItens):
+		c.JSON(http.StatusConflict, gin.H{"erro": "Nota sem itens nao pode ser impressa"})
+	case errors.As(err, &pendente):
+		c.JSON(http.StatusConflict, gin.H{
+			"erro":          "Nota com impressao pendente",
+			"solicitacaoId": pendente.solicitacaoID,
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// prazoImpressao calcula ate quando a saga espera a resposta do Estoque
+func (h *Handlers) prazoImpressao() time.Time {
+	timeout := h.TimeoutImpressao
+	if timeout <= 0 {
+		timeout = dominio.TimeoutImpressaoPadrao
+	}
+	return time.Now().Add(timeout)
+}
+
+// novoEventoImpressao monta Faturamento.ImpressaoSolicitada com os itens
+// congelados na solicitacao
+func novoEventoImpressao(sol *dominio.SolicitacaoImpressao, ctx dominio.ContextoEvento) (dominio.EventoOutbox, error) {
+	payload := dominio.PayloadImpressaoSolicitada{
+		NotaID:        sol.NotaID.String(),
+		SolicitacaoID: sol.ID.String(),
+		Itens:         dominio.ItensReserva(sol.ItensNota()),
+	}
+	return dominio.NovoEventoOutbox(dominio.EventoImpressaoSolicitada, sol.NotaID, payload, ctx)
+}
+
 // GET /api/v1/solicitacoes-impressao/:id
 func (h *Handlers) ConsultarStatusImpressao(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("id"))
@@ -263,9 +425,10 @@ func (h *Handlers) ConsultarStatusImpressao(c *gin.Context) {
 		return
 	}
 
-	var sol dominio.SolicitacaoImpressao
-	if err := h.DB.First(&sol, "id = ?", id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	ctx := c.Request.Context()
+	sol, err := h.Banco.Leitura(ctx).Solicitacoes().BuscarComRetentativas(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
 			c.JSON(http.StatusNotFound, gin.H{"erro": "Solicitacao nao encontrada"})
 			return
 		}
@@ -275,44 +438,3 @@ func (h *Handlers) ConsultarStatusImpressao(c *gin.Context) {
 
 	c.JSON(http.StatusOK, sol)
 }
-
-// FecharNota e chamado pelo consumidor quando recebe "Estoque.Reservado"
-func (h *Handlers) FecharNota(notaID uuid.UUID) error {
-	return h.DB.Transaction(func(tx *gorm.DB) error {
-		var nota dominio.NotaFiscal
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-			First(&nota, "id = ?", notaID).Error; err != nil {
-			return err
-		}
-
-		if err := nota.Fechar(); err != nil {
-			return err
-		}
-
-		if err := tx.Save(&nota).Error; err != nil {
-			return err
-		}
-
-		agora := time.Now()
-		if err := tx.Model(&dominio.SolicitacaoImpressao{}).
-			Where("nota_id = ? AND status = ?", notaID, "PENDENTE").
-			Updates(map[string]interface{}{
-				"status":         "CONCLUIDA",
-				"data_conclusao": agora,
-			}).Error; err != nil {
-			return err
-		}
-
-		return nil
-	})
-}
-
-// MarcarFalha e chamado quando recebe "Estoque.ReservaRejeitada"
-func (h *Handlers) MarcarFalha(notaID uuid.UUID, motivo string) error {
-	return h.DB.Model(&dominio.SolicitacaoImpressao{}).
-		Where("nota_id = ? AND status = ?", notaID, "PENDENTE").
-		Updates(map[string]interface{}{
-			"status":        "FALHOU",
-			"mensagem_erro": motivo,
-		}).Error
-}
@@ -0,0 +1,526 @@
+package manipulador_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/manipulador"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ambienteNotas sobe as rotas de notas sobre o banco em memoria
+type ambienteNotas struct {
+	t      *testing.T
+	banco  *repositorio.BancoMemoria
+	router *gin.Engine
+}
+
+// chaveGateway assina X-Empresa nos testes, como o gateway
+var chaveGateway = []byte("chave-teste")
+
+// comEmpresa monta os cabecalhos de empresa que o gateway injeta
+func comEmpresa(empresa string) map[string]string {
+	return map[string]string{
+		manipulador.CabecalhoEmpresa:           empresa,
+		manipulador.CabecalhoAssinaturaEmpresa: manipulador.AssinarEmpresa(chaveGateway, empresa),
+	}
+}
+
+func novoAmbienteNotas(t *testing.T) *ambienteNotas {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	banco := repositorio.NovoBancoMemoria()
+	handlers := &manipulador.Handlers{Banco: banco}
+
+	r := gin.New()
+	r.Use(manipulador.Autoria(), manipulador.Empresa("padrao", chaveGateway))
+	r.POST("/notas", handlers.CriarNota)
+	r.GET("/notas", handlers.ListarNotas)
+	r.GET("/notas/busca", handlers.PesquisarNotas)
+	r.GET("/notas/exportar", handlers.ExportarNotas)
+	r.GET("/notas/:id", handlers.BuscarNota)
+	r.POST("/notas/:id/itens", handlers.AdicionarItem)
+	r.POST("/notas/:id/imprimir", handlers.ImprimirNota)
+	r.GET("/notas/:id/auditoria", handlers.ListarAuditoria)
+	r.GET("/solicitacoes-impressao/:id", handlers.ConsultarStatusImpressao)
+
+	return &ambienteNotas{t: t, banco: banco, router: r}
+}
+
+func (a *ambienteNotas) requisitar(metodo, rota string, corpo interface{}, cabecalhos map[string]string) *httptest.ResponseRecorder {
+	a.t.Helper()
+
+	body, _ := json.Marshal(corpo)
+	req := httptest.NewRequest(metodo, rota, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range cabecalhos {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	return w
+}
+
+func (a *ambienteNotas) criarNota(numero string) dominio.NotaFiscal {
+	a.t.Helper()
+
+	w := a.requisitar(http.MethodPost, "/notas", map[string]string{"numero": numero}, nil)
+	if w.Code != http.StatusCreated {
+		a.t.Fatalf("esperava 201 ao criar nota, obteve %d: %s", w.Code, w.Body)
+	}
+	var nota dominio.NotaFiscal
+	json.Unmarshal(w.Body.Bytes(), &nota)
+	return nota
+}
+
+func (a *ambienteNotas) produto(ativo bool) uuid.UUID {
+	a.t.Helper()
+
+	id := uuid.New()
+	if err := a.banco.Produtos().Projetar(context.Background(), &dominio.ProdutoCatalogo{
+		ID: id, Sku: "SKU-" + id.String()[:8], Nome: "Produto", Ativo: ativo, DataEvento: time.Now(),
+	}); err != nil {
+		a.t.Fatalf("falha ao projetar produto: %v", err)
+	}
+	return id
+}
+
+func TestCriarNota_NumeroRepetido(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	amb.criarNota("NF-100")
+
+	if w := amb.requisitar(http.MethodPost, "/notas", map[string]string{"numero": "NF-100"}, nil); w.Code != http.StatusConflict {
+		t.Errorf("esperava 409 para numero repetido, obteve %d", w.Code)
+	}
+}
+
+func TestAdicionarItem_ValidaCatalogo(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	nota := amb.criarNota("NF-200")
+	rota := "/notas/" + nota.ID.String() + "/itens"
+
+	casos := []struct {
+		nome    string
+		produto uuid.UUID
+		status  int
+	}{
+		{"fora do catalogo", uuid.New(), http.StatusUnprocessableEntity},
+		{"inativo", amb.produto(false), http.StatusUnprocessableEntity},
+		{"ativo", amb.produto(true), http.StatusCreated},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nome, func(t *testing.T) {
+			w := amb.requisitar(http.MethodPost, rota, map[string]interface{}{
+				"produtoId": caso.produto, "quantidade": 1, "precoUnitario": 10,
+			}, nil)
+			if w.Code != caso.status {
+				t.Errorf("esperava %d, obteve %d: %s", caso.status, w.Code, w.Body)
+			}
+		})
+	}
+
+	w := amb.requisitar(http.MethodGet, "/notas/"+nota.ID.String(), nil, nil)
+	var lida dominio.NotaFiscal
+	json.Unmarshal(w.Body.Bytes(), &lida)
+	if len(lida.Itens) != 1 || lida.Itens[0].Sku == "" {
+		t.Errorf("esperava 1 item com o sku do catalogo, obteve %+v", lida.Itens)
+	}
+}
+
+func TestImprimirNota_IdempotenteEGravaOutboxESaga(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	nota := amb.criarNota("NF-300")
+	rota := "/notas/" + nota.ID.String() + "/imprimir"
+	cab := map[string]string{"Idempotency-Key": "imp-300", "X-Correlation-ID": "corr-300"}
+
+	if w := amb.requisitar(http.MethodPost, rota, nil, cab); w.Code != http.StatusConflict {
+		t.Fatalf("nota sem itens deveria dar 409, obteve %d", w.Code)
+	}
+
+	amb.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/itens", map[string]interface{}{
+		"produtoId": amb.produto(true), "quantidade": 2, "precoUnitario": 5,
+	}, nil)
+
+	w := amb.requisitar(http.MethodPost, rota, nil, cab)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201, obteve %d: %s", w.Code, w.Body)
+	}
+	var sol dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &sol)
+
+	w = amb.requisitar(http.MethodPost, rota, nil, cab)
+	var repetida dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &repetida)
+	if w.Code != http.StatusOK || repetida.ID != sol.ID {
+		t.Errorf("mesma chave deveria devolver a solicitacao %s com 200, obteve %d %s", sol.ID, w.Code, repetida.ID)
+	}
+
+	ctx := context.Background()
+	eventos, _ := amb.banco.Outbox().Listar(ctx, repositorio.FiltroOutbox{Tipo: dominio.EventoImpressaoSolicitada})
+	if len(eventos) != 1 || eventos[0].IDCorrelacao != "corr-300" {
+		t.Errorf("esperava 1 evento de impressao na correlacao corr-300, obteve %+v", eventos)
+	}
+	instancia, err := amb.banco.Sagas().BuscarPorSolicitacao(ctx, sol.ID)
+	if err != nil || instancia.Estado != dominio.EstadoSagaAguardandoEstoque {
+		t.Errorf("esperava saga aguardando o Estoque, obteve %+v (%v)", instancia, err)
+	}
+
+	w = amb.requisitar(http.MethodGet, "/solicitacoes-impressao/"+sol.ID.String(), nil, nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("esperava consultar a solicitacao, obteve %d", w.Code)
+	}
+}
+
+func TestListarAuditoria(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	nota := amb.criarNota("NF-400")
+
+	w := amb.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/itens", map[string]interface{}{
+		"produtoId": amb.produto(true), "quantidade": 1, "precoUnitario": 10,
+	}, map[string]string{"X-Usuario": "ana", "X-Request-ID": "req-400"})
+	if w.Code != http.StatusCreated || w.Header().Get("X-Request-ID") != "req-400" {
+		t.Fatalf("esperava 201 ecoando o X-Request-ID, obteve %d %q", w.Code, w.Header().Get("X-Request-ID"))
+	}
+
+	w = amb.requisitar(http.MethodGet, "/notas/"+nota.ID.String()+"/auditoria", nil, nil)
+	var trilha []dominio.RegistroAuditoria
+	json.Unmarshal(w.Body.Bytes(), &trilha)
+	if w.Code != http.StatusOK || len(trilha) != 2 {
+		t.Fatalf("esperava criacao da nota e do item, obteve %d %s", w.Code, w.Body)
+	}
+	if trilha[0].Ator != manipulador.AtorAnonimo || trilha[0].IDRequisicao == "" {
+		t.Errorf("criacao sem X-Usuario deveria ser anonima com ID gerado, obteve %+v", trilha[0])
+	}
+	if trilha[1].Tabela != "itens_nota" || trilha[1].Ator != "ana" || trilha[1].IDRequisicao != "req-400" {
+		t.Errorf("item deveria ser da ana na req-400, obteve %+v", trilha[1])
+	}
+
+	if w := amb.requisitar(http.MethodGet, "/notas/"+nota.ID.String()+"/auditoria?tabela=outbox", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("tabela desconhecida deveria dar 400, obteve %d", w.Code)
+	}
+	if w := amb.requisitar(http.MethodGet, "/notas/"+uuid.NewString()+"/auditoria", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("nota inexistente deveria dar 404, obteve %d", w.Code)
+	}
+}
+
+func TestEmpresa_IsolaNotas(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	matriz := comEmpresa("matriz")
+	filial := comEmpresa("filial")
+
+	w := amb.requisitar(http.MethodPost, "/notas", map[string]string{"numero": "NF-500"}, matriz)
+	var nota dominio.NotaFiscal
+	json.Unmarshal(w.Body.Bytes(), &nota)
+	if w.Code != http.StatusCreated || nota.EmpresaID != "matriz" {
+		t.Fatalf("esperava nota da matriz, obteve %d %s", w.Code, w.Body)
+	}
+	if w := amb.requisitar(http.MethodPost, "/notas", map[string]string{"numero": "NF-500"}, filial); w.Code != http.StatusCreated {
+		t.Errorf("mesmo numero em outra empresa deveria dar 201, obteve %d", w.Code)
+	}
+
+	if w := amb.requisitar(http.MethodGet, "/notas/"+nota.ID.String(), nil, filial); w.Code != http.StatusNotFound {
+		t.Errorf("filial nao deveria ver a nota da matriz, obteve %d", w.Code)
+	}
+	if w := amb.requisitar(http.MethodGet, "/notas/"+nota.ID.String(), nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("empresa padrao nao deveria ver a nota da matriz, obteve %d", w.Code)
+	}
+	if w := amb.requisitar(http.MethodGet, "/notas/"+nota.ID.String(), nil, matriz); w.Code != http.StatusOK {
+		t.Errorf("matriz deveria ver a propria nota, obteve %d", w.Code)
+	}
+
+	// o cliente nao escolhe a empresa: sem a assinatura do gateway, 403
+	forjado := map[string]string{manipulador.CabecalhoEmpresa: "matriz"}
+	if w := amb.requisitar(http.MethodGet, "/notas/"+nota.ID.String(), nil, forjado); w.Code != http.StatusForbidden {
+		t.Errorf("X-Empresa sem assinatura deveria dar 403, obteve %d", w.Code)
+	}
+	forjado[manipulador.CabecalhoAssinaturaEmpresa] = manipulador.AssinarEmpresa(chaveGateway, "filial")
+	if w := amb.requisitar(http.MethodGet, "/notas/"+nota.ID.String(), nil, forjado); w.Code != http.StatusForbidden {
+		t.Errorf("assinatura de outra empresa deveria dar 403, obteve %d", w.Code)
+	}
+	if w := amb.requisitar(http.MethodGet, "/notas", nil, comEmpresa(manipulador.TodasEmpresas)); w.Code != http.StatusForbidden {
+		t.Errorf("rota de negocio nao deveria aceitar todas as empresas, obteve %d", w.Code)
+	}
+
+	r := gin.New()
+	r.GET("/notas", manipulador.Empresa("", chaveGateway), (&manipulador.Handlers{Banco: amb.banco}).ListarNotas)
+	req := httptest.NewRequest(http.MethodGet, "/notas", nil)
+	sem := httptest.NewRecorder()
+	r.ServeHTTP(sem, req)
+	if sem.Code != http.StatusBadRequest {
+		t.Errorf("sem X-Empresa nem padrao deveria dar 400, obteve %d", sem.Code)
+	}
+
+	// sem chave configurada nenhum X-Empresa e aceito
+	r = gin.New()
+	r.GET("/notas", manipulador.Empresa("padrao", nil), (&manipulador.Handlers{Banco: amb.banco}).ListarNotas)
+	req = httptest.NewRequest(http.MethodGet, "/notas", nil)
+	for k, v := range matriz {
+		req.Header.Set(k, v)
+	}
+	semChave := httptest.NewRecorder()
+	r.ServeHTTP(semChave, req)
+	if semChave.Code != http.StatusForbidden {
+		t.Errorf("sem chave do gateway X-Empresa deveria dar 403, obteve %d", semChave.Code)
+	}
+}
+
+func TestEmpresaAdmin_EscopoExplicito(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	amb.requisitar(http.MethodPost, "/notas", map[string]string{"numero": "NF-510"}, comEmpresa("matriz"))
+	amb.requisitar(http.MethodPost, "/notas", map[string]string{"numero": "NF-511"}, comEmpresa("filial"))
+
+	r := gin.New()
+	r.GET("/admin/notas", manipulador.EmpresaAdmin("", chaveGateway), (&manipulador.Handlers{Banco: amb.banco}).ListarNotas)
+	listar := func(cabecalhos map[string]string) (int, []dominio.NotaFiscal) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/notas", nil)
+		for k, v := range cabecalhos {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var notas []dominio.NotaFiscal
+		json.Unmarshal(w.Body.Bytes(), &notas)
+		return w.Code, notas
+	}
+
+	if codigo, _ := listar(nil); codigo != http.StatusBadRequest {
+		t.Errorf("administracao sem escopo deveria dar 400, obteve %d", codigo)
+	}
+	if codigo, notas := listar(comEmpresa("filial")); codigo != http.StatusOK || len(notas) != 1 || notas[0].Numero != "NF-511" {
+		t.Errorf("esperava so a nota da filial, obteve %d %+v", codigo, notas)
+	}
+	if codigo, notas := listar(comEmpresa(manipulador.TodasEmpresas)); codigo != http.StatusOK || len(notas) != 2 {
+		t.Errorf("escopo de todas as empresas deveria ver as 2 notas, obteve %d %+v", codigo, notas)
+	}
+	if codigo, _ := listar(map[string]string{manipulador.CabecalhoEmpresa: manipulador.TodasEmpresas}); codigo != http.StatusForbidden {
+		t.Errorf("todas as empresas sem assinatura deveria dar 403, obteve %d", codigo)
+	}
+}
+
+func TestPesquisarNotas(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	w := amb.requisitar(http.MethodPost, "/notas", map[string]string{"numero": "NF-600", "destinatario": "Comercial Andrade Ltda"}, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201, obteve %d: %s", w.Code, w.Body)
+	}
+	amb.criarNota("NF-601")
+	amb.requisitar(http.MethodPost, "/notas", map[string]string{"numero": "NF-602", "destinatario": "Andrade Filial"}, comEmpresa("outra"))
+
+	w = amb.requisitar(http.MethodGet, "/notas/busca?q=andr", nil, nil)
+	var resultados []repositorio.ResultadoPesquisa
+	json.Unmarshal(w.Body.Bytes(), &resultados)
+	if w.Code != http.StatusOK || len(resultados) != 1 || resultados[0].Nota.Numero != "NF-600" {
+		t.Fatalf("esperava so a NF-600 da empresa, obteve %d %s", w.Code, w.Body)
+	}
+	if resultados[0].Destaque != "NF-600 Comercial <mark>Andrade</mark> Ltda" {
+		t.Errorf("destaque inesperado: %q", resultados[0].Destaque)
+	}
+
+	if w := amb.requisitar(http.MethodGet, "/notas/busca?q=+-", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("busca sem termos deveria dar 400, obteve %d", w.Code)
+	}
+}
+
+func TestAdicionarItem_BloqueadoComImpressaoPendente(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	nota := amb.criarNota("NF-350")
+	rotaItens := "/notas/" + nota.ID.String() + "/itens"
+	novoItem := func() map[string]interface{} {
+		return map[string]interface{}{"produtoId": amb.produto(true), "quantidade": 1, "precoUnitario": 4}
+	}
+
+	amb.requisitar(http.MethodPost, rotaItens, novoItem(), nil)
+	w := amb.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/imprimir", nil, map[string]string{"Idempotency-Key": "imp-350"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201, obteve %d: %s", w.Code, w.Body)
+	}
+	var sol dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &sol)
+	if len(sol.Itens) != 1 || sol.Itens[0].Sku == "" {
+		t.Fatalf("esperava o item congelado na solicitacao, obteve %+v", sol.Itens)
+	}
+
+	w = amb.requisitar(http.MethodPost, rotaItens, novoItem(), nil)
+	var corpo struct {
+		SolicitacaoID uuid.UUID `json:"solicitacaoId"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &corpo)
+	if w.Code != http.StatusConflict || corpo.SolicitacaoID != sol.ID {
+		t.Errorf("esperava 409 apontando a solicitacao %s, obteve %d: %s", sol.ID, w.Code, w.Body)
+	}
+
+	// com a impressao encerrada a nota volta a aceitar itens
+	amb.banco.Solicitacoes().Falhar(context.Background(), sol.ID, "estoque indisponivel")
+	if w := amb.requisitar(http.MethodPost, rotaItens, novoItem(), nil); w.Code != http.StatusCreated {
+		t.Errorf("esperava 201 apos a falha da impressao, obteve %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestImprimirNota_UmaPendentePorNota(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	nota := amb.criarNota("NF-360")
+	rota := "/notas/" + nota.ID.String() + "/imprimir"
+	amb.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/itens", map[string]interface{}{
+		"produtoId": amb.produto(true), "quantidade": 1, "precoUnitario": 3,
+	}, nil)
+
+	w := amb.requisitar(http.MethodPost, rota, nil, map[string]string{"Idempotency-Key": "imp-360-a"})
+	var primeira dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &primeira)
+
+	w = amb.requisitar(http.MethodPost, rota, nil, map[string]string{"Idempotency-Key": "imp-360-b"})
+	var corpo struct {
+		SolicitacaoID uuid.UUID `json:"solicitacaoId"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &corpo)
+	if w.Code != http.StatusConflict || corpo.SolicitacaoID != primeira.ID {
+		t.Errorf("esperava 409 apontando a solicitacao %s, obteve %d: %s", primeira.ID, w.Code, w.Body)
+	}
+
+	eventos, _ := amb.banco.Outbox().Listar(context.Background(), repositorio.FiltroOutbox{Tipo: dominio.EventoImpressaoSolicitada})
+	if len(eventos) != 1 {
+		t.Errorf("esperava uma unica reserva pedida ao Estoque, obteve %d eventos", len(eventos))
+	}
+}
+
+func TestImprimirNota_ChavePorNotaEOperacao(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	notaA, notaB := amb.criarNota("NF-370"), amb.criarNota("NF-371")
+	for _, nota := range []dominio.NotaFiscal{notaA, notaB} {
+		amb.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/itens", map[string]interface{}{
+			"produtoId": amb.produto(true), "quantidade": 1, "precoUnitario": 2,
+		}, nil)
+	}
+	cab := map[string]string{"Idempotency-Key": "imp-370"}
+
+	w := amb.requisitar(http.MethodPost, "/notas/"+notaA.ID.String()+"/imprimir", nil, cab)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201, obteve %d: %s", w.Code, w.Body)
+	}
+	var sol dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &sol)
+
+	w = amb.requisitar(http.MethodPost, "/notas/"+notaB.ID.String()+"/imprimir", nil, cab)
+	var corpo struct {
+		NotaID uuid.UUID `json:"notaId"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &corpo)
+	if w.Code != http.StatusUnprocessableEntity || corpo.NotaID != notaA.ID {
+		t.Errorf("chave reusada em outra nota deveria dar 422 citando %s, obteve %d: %s", notaA.ID, w.Code, w.Body)
+	}
+
+	w = amb.requisitar(http.MethodPost, "/notas/"+notaA.ID.String()+"/imprimir", nil, cab)
+	var repetida dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &repetida)
+	if w.Code != http.StatusOK || repetida.ID != sol.ID {
+		t.Errorf("mesma chave na mesma nota deveria devolver %s com 200, obteve %d %s", sol.ID, w.Code, repetida.ID)
+	}
+}
+
+func TestReprocessarImpressao_ChaveEmOutraTentativa(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	amb.router.POST("/solicitacoes-impressao/:id/reprocessar", (&manipulador.Handlers{Banco: amb.banco}).ReprocessarImpressao)
+	ctx := repositorio.ComEmpresa(context.Background(), "padrao")
+
+	nota := amb.criarNota("NF-380")
+	amb.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/itens", map[string]interface{}{
+		"produtoId": amb.produto(true), "quantidade": 1, "precoUnitario": 2,
+	}, nil)
+	w := amb.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/imprimir", nil, map[string]string{"Idempotency-Key": "imp-380"})
+	var original dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &original)
+	amb.banco.Solicitacoes().Falhar(ctx, original.ID, "estoque indisponivel")
+
+	cab := map[string]string{"Idempotency-Key": "rep-380"}
+	reprocessar := func(id uuid.UUID) *httptest.ResponseRecorder {
+		return amb.requisitar(http.MethodPost, "/solicitacoes-impressao/"+id.String()+"/reprocessar", nil, cab)
+	}
+	w = reprocessar(original.ID)
+	var segunda dominio.SolicitacaoImpressao
+	json.Unmarshal(w.Body.Bytes(), &segunda)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201 no reprocessamento, obteve %d: %s", w.Code, w.Body)
+	}
+	if w := reprocessar(original.ID); w.Code != http.StatusOK {
+		t.Errorf("repetir o reprocessamento deveria devolver a tentativa com 200, obteve %d", w.Code)
+	}
+
+	// a segunda tentativa falha e o cliente reprocessa com a mesma chave: nao
+	// pode receber de volta a tentativa antiga como se tivesse reprocessado
+	amb.banco.Solicitacoes().Falhar(ctx, segunda.ID, "estoque indisponivel")
+	if w := reprocessar(segunda.ID); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("mesma chave em outra tentativa deveria dar 422, obteve %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestExportarNotas(t *testing.T) {
+	amb := novoAmbienteNotas(t)
+	nota := amb.criarNota("NF-600")
+	for _, item := range []map[string]interface{}{
+		{"produtoId": amb.produto(true), "quantidade": 2, "precoUnitario": 5},
+		{"produtoId": amb.produto(true), "quantidade": 1, "precoUnitario": 7.5},
+	} {
+		if w := amb.requisitar(http.MethodPost, "/notas/"+nota.ID.String()+"/itens", item, nil); w.Code != http.StatusCreated {
+			t.Fatalf("falha ao adicionar item: %d %s", w.Code, w.Body)
+		}
+	}
+	amb.criarNota("NF-601")
+
+	w := amb.requisitar(http.MethodGet, "/notas/exportar?formato=csv", nil, nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("esperava 200 com csv, obteve %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), ".csv") {
+		t.Errorf("esperava anexo .csv, obteve %q", w.Header().Get("Content-Disposition"))
+	}
+	linhas, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("csv invalido: %v", err)
+	}
+	// cabecalho, 2 itens + total da NF-600, total da NF-601, total geral
+	if len(linhas) != 6 || linhas[0][0] != "numero" {
+		t.Fatalf("esperava 6 linhas com cabecalho, obteve %v", linhas)
+	}
+	if linhas[1][9] != "10" || linhas[2][9] != "7.5" {
+		t.Errorf("subtotais inesperados: %v %v", linhas[1], linhas[2])
+	}
+	if linhas[3][0] != "NF-600" || linhas[3][10] != "17.5" {
+		t.Errorf("esperava total 17.5 da NF-600, obteve %v", linhas[3])
+	}
+	if linhas[4][0] != "NF-601" || linhas[4][10] != "0" {
+		t.Errorf("esperava total 0 da NF-601, obteve %v", linhas[4])
+	}
+	if linhas[5][0] != "TOTAL GERAL" || linhas[5][10] != "17.5" {
+		t.Errorf("esperava total geral 17.5, obteve %v", linhas[5])
+	}
+
+	w = amb.requisitar(http.MethodGet, "/notas/exportar?formato=csv&status=FECHADA", nil, nil)
+	if linhas, _ := csv.NewReader(w.Body).ReadAll(); len(linhas) != 2 {
+		t.Errorf("filtro de status deveria deixar so cabecalho e total geral, obteve %v", linhas)
+	}
+
+	w = amb.requisitar(http.MethodGet, "/notas/exportar?formato=xlsx", nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), "spreadsheetml") {
+		t.Fatalf("esperava 200 com xlsx, obteve %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if _, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len())); err != nil {
+		t.Errorf("xlsx invalido: %v", err)
+	}
+
+	if w := amb.requisitar(http.MethodGet, "/notas/exportar?formato=pdf", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("esperava 400 para formato desconhecido, obteve %d", w.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package manipulador
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RepublicadorOutbox envia um evento do outbox na hora (o publicador)
+type RepublicadorOutbox interface {
+	Republicar(ctx context.Context, id int64) (dominio.EventoOutbox, error)
+}
+
+// GET /api/v1/admin/outbox?situacao=&tipo=&agregado=&limite=&offset=
+//
+// situacao: PENDENTE (inclui as que falharam), FALHOU, ESTACIONADO ou PUBLICADO
+func (h *Handlers) ListarOutbox(c *gin.Context) {
+	filtro := repositorio.FiltroOutbox{
+		Situacao: c.Query("situacao"),
+		Tipo:     c.Query("tipo"),
+	}
+
+	switch filtro.Situacao {
+	case "", dominio.SituacaoOutboxPendente, dominio.SituacaoOutboxFalhou,
+		dominio.SituacaoOutboxEstacionado, dominio.SituacaoOutboxPublicado:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "situacao invalida"})
+		return
+	}
+	if v := c.Query("agregado"); v != "" {
+		agregado, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"erro": "agregado invalido"})
+			return
+		}
+		filtro.Agregado = &agregado
+	}
+	filtro.Limite, filtro.Offset = paginacao(c)
+
+	eventos, err := h.Banco.Outbox().Listar(c.Request.Context(), filtro)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao listar outbox"})
+		return
+	}
+
+	c.JSON(http.StatusOK, eventos)
+}
+
+// GET /api/v1/admin/outbox/:id
+func (h *Handlers) BuscarEventoOutbox(c *gin.Context) {
+	evt, ok := h.carregarEventoOutbox(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, evt)
+}
+
+// POST /api/v1/admin/outbox/:id/republicar
+//
+// Publica o evento na hora, mesmo ja publicado ou estacionado
+func (h *Handlers) RepublicarEventoOutbox(c *gin.Context) {
+	if h.Outbox == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"erro": "Publicador nao configurado"})
+		return
+	}
+
+	evt, ok := h.carregarEventoOutbox(c)
+	if !ok {
+		return
+	}
+
+	evt, err := h.Outbox.Republicar(c.Request.Context(), evt.ID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"erro": "Falha ao publicar evento", "detalhe": err.Error(), "evento": evt})
+		return
+	}
+
+	c.JSON(http.StatusOK, evt)
+}
+
+// POST /api/v1/admin/outbox/:id/estacionar
+//
+// Tira um evento nao publicado do ciclo do publicador ate ser republicado
+func (h *Handlers) EstacionarEventoOutbox(c *gin.Context) {
+	var req struct {
+		Motivo string `json:"motivo" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "motivo obrigatorio"})
+		return
+	}
+
+	evt, ok := h.carregarEventoOutbox(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	estacionado, err := h.Banco.Outbox().Estacionar(ctx, evt.ID, req.Motivo, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao estacionar evento"})
+		return
+	}
+
+	if atual, err := h.Banco.Outbox().Buscar(ctx, evt.ID); err == nil {
+		evt = atual
+	}
+	if !estacionado {
+		c.JSON(http.StatusConflict, gin.H{"erro": "Evento ja publicado ou estacionado", "situacao": evt.Situacao()})
+		return
+	}
+
+	c.JSON(http.StatusOK, evt)
+}
+
+// carregarEventoOutbox busca o evento de :id e ja responde 400/404/500
+func (h *Handlers) carregarEventoOutbox(c *gin.Context) (dominio.EventoOutbox, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "ID invalido"})
+		return dominio.EventoOutbox{}, false
+	}
+
+	evt, err := h.Banco.Outbox().Buscar(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"erro": "Evento nao encontrado"})
+			return evt, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar evento"})
+		return evt, false
+	}
+	return evt, true
+}
@@ -0,0 +1,209 @@
+package manipulador
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ProcessadorMensagens reprocessa uma entrega pelo mesmo caminho do consumidor
+type ProcessadorMensagens interface {
+	ProcessarMensagem(msg mensageria.Entrega) error
+}
+
+// GET /api/v1/admin/quarentena?status=&routingKey=&limite=&offset=
+func (h *Handlers) ListarQuarentena(c *gin.Context) {
+	filtro := repositorio.FiltroQuarentena{
+		Status:     c.Query("status"),
+		RoutingKey: c.Query("routingKey"),
+	}
+	filtro.Limite, filtro.Offset = paginacao(c)
+
+	mensagens, err := h.Banco.Quarentena().Listar(c.Request.Context(), filtro)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao listar quarentena"})
+		return
+	}
+
+	c.JSON(http.StatusOK, mensagens)
+}
+
+// GET /api/v1/admin/quarentena/:id
+func (h *Handlers) BuscarQuarentena(c *gin.Context) {
+	registro, ok := h.carregarQuarentena(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, registro)
+}
+
+// PUT /api/v1/admin/quarentena/:id
+//
+// Corrige a mensagem antes do reprocessamento. Campos omitidos ficam como estao.
+func (h *Handlers) EditarQuarentena(c *gin.Context) {
+	var req struct {
+		RoutingKey *string                `json:"routingKey"`
+		Cabecalhos map[string]interface{} `json:"cabecalhos"`
+		Corpo      *string                `json:"corpo"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": err.Error()})
+		return
+	}
+
+	registro, ok := h.carregarQuarentena(c)
+	if !ok {
+		return
+	}
+	if registro.Status != dominio.StatusQuarentena {
+		c.JSON(http.StatusConflict, gin.H{"erro": "Apenas mensagens em QUARENTENA podem ser editadas"})
+		return
+	}
+
+	registro.Editada = true
+	if req.RoutingKey != nil {
+		if *req.RoutingKey == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"erro": "routingKey nao pode ser vazia"})
+			return
+		}
+		registro.RoutingKey = *req.RoutingKey
+	}
+	if req.Cabecalhos != nil {
+		cabecalhos, err := json.Marshal(req.Cabecalhos)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"erro": "cabecalhos invalidos"})
+			return
+		}
+		registro.Cabecalhos = string(cabecalhos)
+	}
+	if req.Corpo != nil {
+		registro.Corpo = *req.Corpo
+	}
+
+	if err := h.Banco.Quarentena().Atualizar(c.Request.Context(), &registro); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao editar mensagem"})
+		return
+	}
+
+	c.JSON(http.StatusOK, registro)
+}
+
+// POST /api/v1/admin/quarentena/:id/reprocessar
+func (h *Handlers) ReprocessarQuarentena(c *gin.Context) {
+	if h.Processador == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"erro": "Reprocessamento nao configurado"})
+		return
+	}
+
+	registro, ok := h.carregarQuarentena(c)
+	if !ok {
+		return
+	}
+	if registro.Status != dominio.StatusQuarentena {
+		c.JSON(http.StatusConflict, gin.H{"erro": "Apenas mensagens em QUARENTENA podem ser reprocessadas"})
+		return
+	}
+
+	var cabecalhos map[string]interface{}
+	if err := json.Unmarshal([]byte(registro.Cabecalhos), &cabecalhos); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"erro": "Cabecalhos gravados sao invalidos"})
+		return
+	}
+
+	entrega := mensageria.Entrega{Mensagem: mensageria.Mensagem{
+		ID:            registro.IDMensagem,
+		RoutingKey:    registro.RoutingKey,
+		CorrelationID: registro.CorrelationID,
+		Cabecalhos:    cabecalhos,
+		Corpo:         []byte(registro.Corpo),
+	}}
+
+	ctx := c.Request.Context()
+	agora := time.Now()
+	if err := h.Processador.ProcessarMensagem(entrega); err != nil {
+		registro.Erro = err.Error()
+		registro.Tentativas++
+		registro.DataUltima = agora
+		if errDB := h.Banco.Quarentena().Atualizar(ctx, &registro); errDB != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"erro": "Reprocessamento falhou, e falha ao registrar a tentativa", "detalhe": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"erro": "Reprocessamento falhou", "detalhe": err.Error()})
+		return
+	}
+
+	if _, err := h.Banco.Quarentena().Resolver(ctx, registro.ID, dominio.StatusQuarentena, dominio.StatusQuarentenaReprocessada, nil, agora); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Mensagem reprocessada, mas falha ao atualizar quarentena"})
+		return
+	}
+
+	if atual, err := h.Banco.Quarentena().Buscar(ctx, registro.ID); err == nil {
+		registro = atual
+	}
+	c.JSON(http.StatusOK, registro)
+}
+
+// POST /api/v1/admin/quarentena/:id/descartar
+func (h *Handlers) DescartarQuarentena(c *gin.Context) {
+	var req struct {
+		Motivo string `json:"motivo" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "motivo obrigatorio"})
+		return
+	}
+
+	registro, ok := h.carregarQuarentena(c)
+	if !ok {
+		return
+	}
+	if registro.Status != dominio.StatusQuarentena {
+		c.JSON(http.StatusConflict, gin.H{"erro": "Apenas mensagens em QUARENTENA podem ser descartadas"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	descartada, err := h.Banco.Quarentena().Resolver(ctx, registro.ID, dominio.StatusQuarentena, dominio.StatusQuarentenaDescartada, &req.Motivo, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao descartar mensagem"})
+		return
+	}
+	// outro administrador pode ter resolvido a mensagem desde a leitura acima
+	if !descartada {
+		c.JSON(http.StatusConflict, gin.H{"erro": "Apenas mensagens em QUARENTENA podem ser descartadas"})
+		return
+	}
+
+	if atual, err := h.Banco.Quarentena().Buscar(ctx, registro.ID); err == nil {
+		registro = atual
+	}
+	c.JSON(http.StatusOK, registro)
+}
+
+// carregarQuarentena busca o registro de :id e ja responde 400/404/500
+func (h *Handlers) carregarQuarentena(c *gin.Context) (dominio.MensagemQuarentena, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "ID invalido"})
+		return dominio.MensagemQuarentena{}, false
+	}
+
+	registro, err := h.Banco.Quarentena().Buscar(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"erro": "Mensagem nao encontrada na quarentena"})
+			return registro, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar quarentena"})
+		return registro, false
+	}
+	return registro, true
+}
@@ -0,0 +1,187 @@
+package manipulador
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+	"servico-faturamento/internal/saga"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// errTentativaConcorrente indica que outra requisicao reprocessou a mesma
+// cadeia entre a validacao e a transacao
+var errTentativaConcorrente = errors.New("solicitacao reprocessada por outra requisicao")
+
+// POST /api/v1/solicitacoes-impressao/:id/reprocessar
+//
+// Cria uma nova tentativa ligada a solicitacao original e reenvia
+// Faturamento.ImpressaoSolicitada. So a ultima tentativa da cadeia pode ser
+// reprocessada, e apenas se estiver FALHOU.
+func (h *Handlers) ReprocessarImpressao(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "ID invalido"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	chaveIdem := c.GetHeader("Idempotency-Key")
+
+	sol, err := h.Banco.Solicitacoes().Buscar(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"erro": "Solicitacao nao encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar solicitacao"})
+		return
+	}
+
+	// o hash cobre o caminho: a mesma chave numa tentativa posterior da
+	// cadeia e outra requisicao, e nao devolve a solicitacao antiga
+	reqIdem := requisicaoIdempotente{chave: chaveIdem, notaID: sol.NotaID, operacao: dominio.OperacaoReprocessar, hash: hashRequisicao(c)}
+	if chaveIdem != "" && h.responderChaveUsada(c, reqIdem) {
+		return
+	}
+
+	raiz, err := h.Banco.Solicitacoes().Buscar(ctx, sol.IDRaiz())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar solicitacao original"})
+		return
+	}
+
+	ultima, err := h.Banco.Solicitacoes().UltimaTentativa(ctx, raiz.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar tentativas"})
+		return
+	}
+
+	if ultima.ID != sol.ID {
+		c.JSON(http.StatusConflict, gin.H{
+			"erro":            "Solicitacao ja foi reprocessada",
+			"ultimaTentativa": ultima.ID,
+		})
+		return
+	}
+
+	if !sol.Reprocessavel() {
+		c.JSON(http.StatusConflict, gin.H{"erro": "Apenas solicitacoes com status FALHOU podem ser reprocessadas"})
+		return
+	}
+
+	nota, err := h.Banco.Notas().Buscar(ctx, sol.NotaID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar nota"})
+		return
+	}
+
+	if nota.Status != dominio.StatusNotaAberta {
+		c.JSON(http.StatusConflict, gin.H{"erro": "Nota nao esta aberta"})
+		return
+	}
+
+	if len(nota.Itens) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"erro": "Nota sem itens nao pode ser impressa"})
+		return
+	}
+
+	// sem X-Correlation-ID a nova tentativa segue na correlacao da original
+	correlacao := c.GetHeader("X-Correlation-ID")
+	if correlacao == "" {
+		correlacao = raiz.IDCorrelacao
+	}
+	ctxEvento := dominio.NovoContextoEvento(correlacao)
+	prazo := h.prazoImpressao()
+
+	var nova dominio.SolicitacaoImpressao
+	err = h.Banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+		// a nova tentativa congela os itens atuais da nota, que pode ter
+		// mudado depois da falha
+		nota, err := notaImprimivel(ctx, r, nota.ID)
+		if err != nil {
+			return err
+		}
+
+		atual, err := r.Solicitacoes().BuscarParaAtualizar(ctx, raiz.ID)
+		if err != nil {
+			return err
+		}
+		if atual.Tentativas != raiz.Tentativas {
+			return errTentativaConcorrente
+		}
+
+		tentativa := atual.Tentativas + 1
+		chave := chaveIdem
+		if chave == "" {
+			chave = fmt.Sprintf("%s:reprocessar:%d", raiz.ID, tentativa)
+		}
+
+		origem := raiz.ID
+		nova = dominio.SolicitacaoImpressao{
+			NotaID:              nota.ID,
+			Status:              dominio.StatusSolicitacaoPendente,
+			ChaveIdempotencia:   chave,
+			IDCorrelacao:        ctxEvento.IDCorrelacao,
+			PrazoExpiracao:      &prazo,
+			SolicitacaoOrigemID: &origem,
+			Tentativa:           tentativa,
+			Tentativas:          1,
+			Itens:               dominio.CongelarItens(nota.Itens),
+		}
+		if err := r.Solicitacoes().Criar(ctx, &nova); err != nil {
+			if errors.Is(err, repositorio.ErrDuplicado) {
+				return errTentativaConcorrente
+			}
+			return err
+		}
+
+		if chaveIdem != "" {
+			if err := registrarChave(ctx, r, reqIdem, &nova); err != nil {
+				return err
+			}
+		}
+
+		if err := r.Solicitacoes().AtualizarTentativas(ctx, raiz.ID, tentativa); err != nil {
+			return fmt.Errorf("falha ao atualizar contador de tentativas: %w", err)
+		}
+
+		eventoOutbox, err := novoEventoImpressao(&nova, ctxEvento)
+		if err != nil {
+			return err
+		}
+
+		if err := r.Outbox().Adicionar(ctx, &eventoOutbox); err != nil {
+			return fmt.Errorf("falha ao criar evento outbox: %w", err)
+		}
+
+		if err := saga.Iniciar(ctx, r, &nova, &eventoOutbox); err != nil {
+			return err
+		}
+
+		log.Printf("[outbox] Reprocessamento %d da solicitacao %s: %s para nota %s (correlacao %s)", tentativa, raiz.ID, eventoOutbox.TipoEvento, nota.ID, ctxEvento.IDCorrelacao)
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, errChaveEmUso) && h.responderChaveUsada(c, reqIdem) {
+			return
+		}
+		if errors.Is(err, errTentativaConcorrente) {
+			c.JSON(http.StatusConflict, gin.H{"erro": "Solicitacao ja foi reprocessada"})
+			return
+		}
+		if responderNotaNaoEditavel(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": fmt.Sprintf("Falha ao processar: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, nova)
+}
@@ -0,0 +1,77 @@
+package manipulador
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GET /api/v1/sagas/:id
+func (h *Handlers) BuscarSaga(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"erro": "ID invalido"})
+		return
+	}
+
+	instancia, err := h.Banco.Sagas().Buscar(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, repositorio.ErrNaoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"erro": "Saga nao encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao buscar saga"})
+		return
+	}
+
+	c.JSON(http.StatusOK, instancia)
+}
+
+// GET /api/v1/sagas?notaId=&solicitacaoId=&correlacao=&estado=&limite=&offset=
+func (h *Handlers) ListarSagas(c *gin.Context) {
+	filtro := repositorio.FiltroSagas{
+		Correlacao: c.Query("correlacao"),
+		Estado:     c.Query("estado"),
+	}
+	for param, destino := range map[string]**uuid.UUID{"notaId": &filtro.NotaID, "solicitacaoId": &filtro.SolicitacaoID} {
+		if v := c.Query(param); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"erro": param + " invalido"})
+				return
+			}
+			*destino = &id
+		}
+	}
+	filtro.Limite, filtro.Offset = paginacao(c)
+
+	sagas, err := h.Banco.Sagas().Listar(c.Request.Context(), filtro)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"erro": "Falha ao listar sagas"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sagas)
+}
+
+// paginacao le ?limite= (padrao 50, maximo 200) e ?offset=
+func paginacao(c *gin.Context) (int, int) {
+	limite, err := strconv.Atoi(c.DefaultQuery("limite", "50"))
+	if err != nil || limite <= 0 {
+		limite = 50
+	}
+	if limite > 200 {
+		limite = 200
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limite, offset
+}
@@ -0,0 +1,25 @@
+package manipulador
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /health/live
+// Liveness: o processo responde. Nao olha dependencias, para o Kubernetes nao
+// reiniciar o pod por uma queda do banco ou do broker.
+func (h *Handlers) Vivo(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GET /health/ready
+// Readiness: 503 enquanto alguma dependencia falha, com o detalhe de cada uma
+func (h *Handlers) Pronto(c *gin.Context) {
+	relatorio := h.Saude.Verificar(c.Request.Context())
+	status := http.StatusOK
+	if !relatorio.Pronto() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, relatorio)
+}
@@ -0,0 +1,220 @@
+package mensageria
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// BrokerAMQP implementa Publicador e Consumidor sobre RabbitMQ. Usa uma conexao
+// e abre um channel para publicacao e um por assinatura.
+type BrokerAMQP struct {
+	conn *amqp.Connection
+
+	mu      sync.Mutex
+	chPub   *amqp.Channel
+	exchPub map[string]bool
+	chCons  map[string]*amqp.Channel // fila -> channel de consumo
+}
+
+// ConectarAMQP abre a conexao com retry, ja que o RabbitMQ pode demorar a subir
+func ConectarAMQP(url string, tentativas int) (*BrokerAMQP, error) {
+	var conn *amqp.Connection
+	var err error
+
+	for tentativa := 1; tentativa <= tentativas; tentativa++ {
+		conn, err = amqp.Dial(url)
+		if err == nil {
+			break
+		}
+		log.Printf("[amqp] tentativa=%d falha ao conectar RabbitMQ: %v", tentativa, err)
+		time.Sleep(3 * time.Second)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("falha ao conectar RabbitMQ apos retries: %w", err)
+	}
+
+	return &BrokerAMQP{conn: conn, exchPub: make(map[string]bool), chCons: make(map[string]*amqp.Channel)}, nil
+}
+
+func (b *BrokerAMQP) Publicar(ctx context.Context, exchange string, msg Mensagem) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.chPub == nil || b.chPub.IsClosed() {
+		ch, err := b.conn.Channel()
+		if err != nil {
+			return fmt.Errorf("falha ao abrir channel: %w", err)
+		}
+		b.chPub = ch
+		b.exchPub = make(map[string]bool)
+	}
+
+	if !b.exchPub[exchange] {
+		if err := b.chPub.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("falha ao declarar exchange: %w", err)
+		}
+		b.exchPub[exchange] = true
+	}
+
+	return b.chPub.PublishWithContext(
+		ctx,
+		exchange,
+		msg.RoutingKey,
+		false,
+		false,
+		amqp.Publishing{
+			MessageId:     msg.ID,
+			CorrelationId: msg.CorrelationID,
+			Type:          msg.Tipo,
+			AppId:         msg.Origem,
+			ContentType:   msg.ContentType,
+			Timestamp:     msg.Timestamp,
+			Headers:       amqp.Table(msg.Cabecalhos),
+			Body:          msg.Corpo,
+		},
+	)
+}
+
+func (b *BrokerAMQP) Consumir(ctx context.Context, a Assinatura) (<-chan Entrega, error) {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir channel: %w", err)
+	}
+
+	if err := ch.ExchangeDeclare(a.Exchange, "topic", true, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("falha ao declarar exchange: %w", err)
+	}
+
+	q, err := ch.QueueDeclare(a.Fila, true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao declarar fila: %w", err)
+	}
+
+	// entregas adiadas esperam o TTL aqui e voltam a fila pelo exchange padrao
+	filaRetentativa := a.Fila + SufixoRetentativa
+	if _, err := ch.QueueDeclare(filaRetentativa, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": q.Name,
+	}); err != nil {
+		return nil, fmt.Errorf("falha ao declarar fila de retentativa: %w", err)
+	}
+	// a entrega adiada so e confirmada depois que o broker aceitou a copia
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("falha ao ativar confirmacoes: %w", err)
+	}
+
+	for _, rk := range a.RoutingKeys {
+		if err := ch.QueueBind(q.Name, rk, a.Exchange, false, nil); err != nil {
+			return nil, fmt.Errorf("falha ao fazer bind %s: %w", rk, err)
+		}
+	}
+
+	prefetch := a.Prefetch
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("falha ao configurar QoS: %w", err)
+	}
+
+	msgs, err := ch.ConsumeWithContext(ctx, q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao registrar consumer: %w", err)
+	}
+
+	b.mu.Lock()
+	b.chCons[a.Fila] = ch
+	b.mu.Unlock()
+
+	saida := make(chan Entrega)
+	go func() {
+		defer close(saida)
+		for d := range msgs {
+			d := d
+			routingKey, adiada := d.Headers[CabecalhoRoutingKeyOriginal].(string)
+			if !adiada {
+				routingKey = d.RoutingKey
+			}
+			saida <- Entrega{
+				Mensagem: Mensagem{
+					ID:            d.MessageId,
+					RoutingKey:    routingKey,
+					CorrelationID: d.CorrelationId,
+					Tipo:          d.Type,
+					Origem:        d.AppId,
+					ContentType:   d.ContentType,
+					Timestamp:     d.Timestamp,
+					Cabecalhos:    map[string]interface{}(d.Headers),
+					Corpo:         d.Body,
+				},
+				Tag:        d.DeliveryTag,
+				Reentregue: d.Redelivered || adiada,
+				confirmar:  func() error { return d.Ack(false) },
+				rejeitar:   func(requeue bool) error { return d.Nack(false, requeue) },
+				adiar: func(espera time.Duration) error {
+					return adiarEntrega(ch, filaRetentativa, d, routingKey, espera)
+				},
+			}
+		}
+	}()
+
+	return saida, nil
+}
+
+// adiarEntrega publica uma copia na fila de retentativa, com a espera como
+// expiracao, e so entao confirma a original. A espera e a mesma para todas as
+// entregas da fila: o RabbitMQ so expira mensagens do inicio da fila.
+func adiarEntrega(ch *amqp.Channel, fila string, d amqp.Delivery, routingKey string, espera time.Duration) error {
+	cabecalhos := amqp.Table{}
+	for k, v := range d.Headers {
+		cabecalhos[k] = v
+	}
+	cabecalhos[CabecalhoRoutingKeyOriginal] = routingKey
+
+	confirmacao, err := ch.PublishWithDeferredConfirmWithContext(context.Background(), "", fila, false, false, amqp.Publishing{
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Type:          d.Type,
+		AppId:         d.AppId,
+		ContentType:   d.ContentType,
+		Timestamp:     d.Timestamp,
+		Headers:       cabecalhos,
+		Body:          d.Body,
+		DeliveryMode:  amqp.Persistent,
+		Expiration:    strconv.FormatInt(espera.Milliseconds(), 10),
+	})
+	if err != nil {
+		return fmt.Errorf("falha ao publicar na fila de retentativa: %w", err)
+	}
+	if !confirmacao.Wait() {
+		return fmt.Errorf("broker recusou a entrega na fila de retentativa")
+	}
+	return d.Ack(false)
+}
+
+func (b *BrokerAMQP) Estado() EstadoBroker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	estado := EstadoBroker{
+		Conexao:     !b.conn.IsClosed(),
+		Assinaturas: make(map[string]bool, len(b.chCons)),
+	}
+	estado.Publicacao = estado.Conexao && (b.chPub == nil || !b.chPub.IsClosed())
+	for fila, ch := range b.chCons {
+		estado.Assinaturas[fila] = !ch.IsClosed()
+	}
+	return estado
+}
+
+// Fechar encerra a conexao e todos os channels
+func (b *BrokerAMQP) Fechar() error {
+	return b.conn.Close()
+}
@@ -0,0 +1,221 @@
+package mensageria
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEntregaResolvida indica Ack/Nack chamado mais de uma vez na mesma entrega
+var ErrEntregaResolvida = errors.New("entrega ja confirmada ou rejeitada")
+
+// BrokerMemoria implementa Publicador e Consumidor em memoria, com exchanges
+// topic e filas duraveis enquanto o processo viver. Serve para rodar a saga
+// inteira em go test sem RabbitMQ. Cada fila entrega uma mensagem por vez
+// (prefetch 1) e so libera a proxima depois do Ack/Nack.
+type BrokerMemoria struct {
+	mu         sync.Mutex
+	filas      map[string]*filaMemoria
+	bindings   map[string][]bindingMemoria
+	publicadas []Mensagem
+	tag        uint64
+}
+
+type bindingMemoria struct {
+	fila   string
+	padrao string
+}
+
+type filaMemoria struct {
+	mu        sync.Mutex
+	pendentes []itemFila
+	sinal     chan struct{}
+}
+
+type itemFila struct {
+	msg        Mensagem
+	reentregue bool
+}
+
+func NovoBrokerMemoria() *BrokerMemoria {
+	return &BrokerMemoria{
+		filas:    make(map[string]*filaMemoria),
+		bindings: make(map[string][]bindingMemoria),
+	}
+}
+
+func (b *BrokerMemoria) Publicar(ctx context.Context, exchange string, msg Mensagem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.publicadas = append(b.publicadas, msg)
+
+	// como no RabbitMQ, cada fila recebe no maximo uma copia e mensagens
+	// sem fila ligada sao descartadas
+	entregue := make(map[string]bool)
+	for _, bind := range b.bindings[exchange] {
+		if entregue[bind.fila] || !CasaRoutingKey(bind.padrao, msg.RoutingKey) {
+			continue
+		}
+		b.filas[bind.fila].enfileirar(itemFila{msg: msg}, false)
+		entregue[bind.fila] = true
+	}
+
+	return nil
+}
+
+func (b *BrokerMemoria) Consumir(ctx context.Context, a Assinatura) (<-chan Entrega, error) {
+	b.mu.Lock()
+	fila, ok := b.filas[a.Fila]
+	if !ok {
+		fila = &filaMemoria{sinal: make(chan struct{}, 1)}
+		b.filas[a.Fila] = fila
+	}
+	for _, rk := range a.RoutingKeys {
+		b.bindings[a.Exchange] = append(b.bindings[a.Exchange], bindingMemoria{fila: a.Fila, padrao: rk})
+	}
+	b.mu.Unlock()
+
+	saida := make(chan Entrega)
+	go b.despachar(ctx, fila, saida)
+	return saida, nil
+}
+
+// Publicadas retorna tudo que passou pelo broker, na ordem de publicacao
+func (b *BrokerMemoria) Publicadas() []Mensagem {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Mensagem(nil), b.publicadas...)
+}
+
+func (b *BrokerMemoria) proximaTag() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tag++
+	return b.tag
+}
+
+func (b *BrokerMemoria) despachar(ctx context.Context, fila *filaMemoria, saida chan<- Entrega) {
+	defer close(saida)
+
+	for {
+		item, ok := fila.retirar(ctx)
+		if !ok {
+			return
+		}
+
+		resolvida := make(chan struct{})
+		var once sync.Once
+		resolver := func(devolver func()) error {
+			err := ErrEntregaResolvida
+			once.Do(func() {
+				if devolver != nil {
+					devolver()
+				}
+				close(resolvida)
+				err = nil
+			})
+			return err
+		}
+		reentrega := itemFila{msg: item.msg, reentregue: true}
+
+		entrega := Entrega{
+			Mensagem:   item.msg,
+			Tag:        b.proximaTag(),
+			Reentregue: item.reentregue,
+			confirmar:  func() error { return resolver(nil) },
+			rejeitar: func(requeue bool) error {
+				if !requeue {
+					return resolver(nil)
+				}
+				return resolver(func() { fila.enfileirar(reentrega, true) })
+			},
+			// como a fila de retentativa do RabbitMQ: volta ao fim da fila
+			adiar: func(espera time.Duration) error {
+				return resolver(func() {
+					time.AfterFunc(espera, func() { fila.enfileirar(reentrega, false) })
+				})
+			},
+		}
+
+		select {
+		case saida <- entrega:
+		case <-ctx.Done():
+			fila.enfileirar(item, true)
+			return
+		}
+
+		select {
+		case <-resolvida:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enfileirar coloca a mensagem no fim da fila, ou no inicio quando e uma reentrega
+func (f *filaMemoria) enfileirar(item itemFila, inicio bool) {
+	f.mu.Lock()
+	if inicio {
+		f.pendentes = append([]itemFila{item}, f.pendentes...)
+	} else {
+		f.pendentes = append(f.pendentes, item)
+	}
+	f.mu.Unlock()
+
+	select {
+	case f.sinal <- struct{}{}:
+	default:
+	}
+}
+
+func (f *filaMemoria) retirar(ctx context.Context) (itemFila, bool) {
+	for {
+		f.mu.Lock()
+		if len(f.pendentes) > 0 {
+			item := f.pendentes[0]
+			f.pendentes = f.pendentes[1:]
+			f.mu.Unlock()
+			return item, true
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-f.sinal:
+		case <-ctx.Done():
+			return itemFila{}, false
+		}
+	}
+}
+
+// CasaRoutingKey aplica as regras de exchange topic: "*" casa exatamente uma
+// palavra e "#" casa zero ou mais
+func CasaRoutingKey(padrao, routingKey string) bool {
+	return casaPalavras(strings.Split(padrao, "."), strings.Split(routingKey, "."))
+}
+
+func casaPalavras(padrao, chave []string) bool {
+	if len(padrao) == 0 {
+		return len(chave) == 0
+	}
+
+	switch padrao[0] {
+	case "#":
+		for i := 0; i <= len(chave); i++ {
+			if casaPalavras(padrao[1:], chave[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(chave) > 0 && casaPalavras(padrao[1:], chave[1:])
+	default:
+		return len(chave) > 0 && padrao[0] == chave[0] && casaPalavras(padrao[1:], chave[1:])
+	}
+}
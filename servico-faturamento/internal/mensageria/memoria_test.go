@@ -0,0 +1,122 @@
+package mensageria_test
+
+import (
+	"context"
+	"servico-faturamento/internal/mensageria"
+	"testing"
+	"time"
+)
+
+func TestCasaRoutingKey(t *testing.T) {
+	casos := []struct {
+		padrao, chave string
+		esperado      bool
+	}{
+		{"Estoque.Reservado", "Estoque.Reservado", true},
+		{"Estoque.Reservado", "Estoque.ReservaRejeitada", false},
+		{"Estoque.*", "Estoque.Reservado", true},
+		{"Estoque.*", "Estoque.Produto.Criado", false},
+		{"Estoque.#", "Estoque.Produto.Criado", true},
+		{"#", "Faturamento.NotaFechada", true},
+		{"*.NotaFechada", "Faturamento.NotaFechada", true},
+	}
+
+	for _, caso := range casos {
+		if obtido := mensageria.CasaRoutingKey(caso.padrao, caso.chave); obtido != caso.esperado {
+			t.Errorf("padrao %q com chave %q: esperava %v, obteve %v", caso.padrao, caso.chave, caso.esperado, obtido)
+		}
+	}
+}
+
+func TestBrokerMemoria_AckENack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := mensageria.NovoBrokerMemoria()
+	entregas, err := broker.Consumir(ctx, mensageria.Assinatura{
+		Exchange:    "ex",
+		Fila:        "fila",
+		RoutingKeys: []string{"A.*"},
+	})
+	if err != nil {
+		t.Fatalf("erro ao consumir: %v", err)
+	}
+
+	broker.Publicar(ctx, "ex", mensageria.Mensagem{ID: "1", RoutingKey: "A.x"})
+	broker.Publicar(ctx, "ex", mensageria.Mensagem{ID: "2", RoutingKey: "B.x"})
+	broker.Publicar(ctx, "ex", mensageria.Mensagem{ID: "3", RoutingKey: "A.y"})
+
+	receber := func() mensageria.Entrega {
+		select {
+		case e := <-entregas:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("timeout aguardando entrega")
+			return mensageria.Entrega{}
+		}
+	}
+
+	primeira := receber()
+	if primeira.ID != "1" || primeira.Reentregue {
+		t.Fatalf("esperava mensagem 1 original, obteve %+v", primeira.Mensagem)
+	}
+	primeira.Nack(true)
+
+	reentregue := receber()
+	if reentregue.ID != "1" || !reentregue.Reentregue {
+		t.Fatalf("esperava reentrega da mensagem 1, obteve %+v", reentregue.Mensagem)
+	}
+	if err := reentregue.Ack(); err != nil {
+		t.Fatalf("erro no ack: %v", err)
+	}
+	if err := reentregue.Ack(); err != mensageria.ErrEntregaResolvida {
+		t.Errorf("esperava ErrEntregaResolvida no segundo ack, obteve %v", err)
+	}
+
+	terceira := receber()
+	if terceira.ID != "3" {
+		t.Fatalf("esperava mensagem 3 (2 nao casa com o binding), obteve %s", terceira.ID)
+	}
+	terceira.Ack()
+
+	if total := len(broker.Publicadas()); total != 3 {
+		t.Errorf("esperava 3 mensagens publicadas, obteve %d", total)
+	}
+}
+
+func TestBrokerMemoria_Adiar(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := mensageria.NovoBrokerMemoria()
+	entregas, _ := broker.Consumir(ctx, mensageria.Assinatura{Exchange: "ex", Fila: "fila", RoutingKeys: []string{"#"}})
+
+	broker.Publicar(ctx, "ex", mensageria.Mensagem{ID: "1", RoutingKey: "A.x"})
+	broker.Publicar(ctx, "ex", mensageria.Mensagem{ID: "2", RoutingKey: "A.y"})
+
+	primeira := <-entregas
+	inicio := time.Now()
+	if err := primeira.Adiar(100 * time.Millisecond); err != nil {
+		t.Fatalf("erro ao adiar: %v", err)
+	}
+	if err := primeira.Nack(true); err != mensageria.ErrEntregaResolvida {
+		t.Errorf("esperava ErrEntregaResolvida depois de adiar, obteve %v", err)
+	}
+
+	// a adiada nao passa na frente: a fila segue enquanto ela espera
+	if segunda := <-entregas; segunda.ID != "2" {
+		t.Fatalf("esperava a mensagem 2 durante a espera, obteve %s", segunda.ID)
+	} else {
+		segunda.Ack()
+	}
+
+	select {
+	case adiada := <-entregas:
+		if adiada.ID != "1" || !adiada.Reentregue || time.Since(inicio) < 100*time.Millisecond {
+			t.Errorf("esperava a mensagem 1 reentregue depois da espera, obteve %+v em %s", adiada.Mensagem, time.Since(inicio))
+		}
+		adiada.Ack()
+	case <-time.After(time.Second):
+		t.Fatal("mensagem adiada nao voltou")
+	}
+}
@@ -0,0 +1,105 @@
+package mensageria
+
+import (
+	"context"
+	"time"
+)
+
+// Exchanges e filas usados entre Faturamento e Estoque
+const (
+	ExchangeFaturamento = "faturamento-eventos"
+	ExchangeEstoque     = "estoque-eventos"
+	FilaFaturamento     = "faturamento-eventos"
+	FilaEstoque         = "estoque-eventos"
+)
+
+// SufixoRetentativa nomeia a fila onde uma entrega adiada espera antes de
+// voltar para a fila de origem
+const SufixoRetentativa = ".retentativa"
+
+// CabecalhoRoutingKeyOriginal guarda a routing key de uma entrega adiada, que
+// volta pela fila de retentativa com a routing key trocada
+const CabecalhoRoutingKeyOriginal = "x-routing-key-original"
+
+// Mensagem e o que trafega no broker, independente do transporte
+type Mensagem struct {
+	ID            string
+	RoutingKey    string
+	CorrelationID string
+	Tipo          string
+	Origem        string
+	ContentType   string
+	Timestamp     time.Time
+	Cabecalhos    map[string]interface{}
+	Corpo         []byte
+}
+
+// Entrega e uma mensagem recebida de uma fila. Quem consome e responsavel por
+// chamar Ack ou Nack exatamente uma vez.
+type Entrega struct {
+	Mensagem
+	Tag        uint64
+	Reentregue bool
+	confirmar  func() error
+	rejeitar   func(requeue bool) error
+	adiar      func(espera time.Duration) error
+}
+
+// Ack confirma o processamento da entrega
+func (e Entrega) Ack() error {
+	if e.confirmar == nil {
+		return nil
+	}
+	return e.confirmar()
+}
+
+// Nack rejeita a entrega; com requeue=true ela volta para a fila
+func (e Entrega) Nack(requeue bool) error {
+	if e.rejeitar == nil {
+		return nil
+	}
+	return e.rejeitar(requeue)
+}
+
+// Adiar tira a entrega da fila e a devolve depois da espera, marcada como
+// reentregue. Diferente de Nack(true), nao volta na hora para o consumidor.
+func (e Entrega) Adiar(espera time.Duration) error {
+	if e.adiar == nil {
+		return nil
+	}
+	return e.adiar(espera)
+}
+
+// Assinatura descreve a fila a consumir e como ela se liga ao exchange
+type Assinatura struct {
+	Exchange    string
+	Fila        string
+	RoutingKeys []string
+	Prefetch    int
+}
+
+// Publicador envia mensagens para um exchange topic
+type Publicador interface {
+	Publicar(ctx context.Context, exchange string, msg Mensagem) error
+}
+
+// Consumidor declara a fila da assinatura e entrega as mensagens no canal
+// retornado, com ack manual
+type Consumidor interface {
+	Consumir(ctx context.Context, assinatura Assinatura) (<-chan Entrega, error)
+}
+
+// EstadoBroker e a situacao da conexao e dos channels, para o readiness
+type EstadoBroker struct {
+	Conexao bool
+	// Publicacao e false se o channel de publicacao caiu; ele ainda nao
+	// aberto (nada publicado) conta como ok
+	Publicacao bool
+	// Assinaturas diz, por fila, se o channel de consumo segue aberto
+	Assinaturas map[string]bool
+}
+
+// Monitoravel e implementado pelos brokers que informam o proprio estado
+type Monitoravel interface {
+	Estado() EstadoBroker
+}
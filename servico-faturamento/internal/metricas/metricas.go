@@ -0,0 +1,110 @@
+// Package metricas expoe as metricas Prometheus do servico em /metrics:
+// latencia e status HTTP por rota, backlog e publicacoes do outbox,
+// processamento do consumidor e desfecho das sagas de impressao.
+package metricas
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Resultados do consumidor por entrega
+const (
+	ResultadoSucesso     = "sucesso"
+	ResultadoRetentativa = "retentativa" // devolvida a fila
+	ResultadoQuarentena  = "quarentena"  // esgotou as tentativas
+)
+
+// registro proprio em vez do global: so entra o que o servico registra aqui
+var registro = prometheus.NewRegistry()
+
+var (
+	duracaoHTTP = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "faturamento",
+		Subsystem: "http",
+		Name:      "duracao_segundos",
+		Help:      "Latencia das requisicoes HTTP por metodo, rota e status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"metodo", "rota", "status"})
+
+	publicacoes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "faturamento",
+		Subsystem: "outbox",
+		Name:      "publicacoes_total",
+		Help:      "Tentativas de publicacao de eventos do outbox por tipo e resultado (sucesso, falha).",
+	}, []string{"tipo_evento", "resultado"})
+
+	duracaoConsumidor = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "faturamento",
+		Subsystem: "consumidor",
+		Name:      "duracao_segundos",
+		Help:      "Tempo de processamento das entregas por routing key e resultado (sucesso, retentativa, quarentena).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"routing_key", "resultado"})
+
+	sagasFinalizadas = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "faturamento",
+		Subsystem: "sagas",
+		Name:      "finalizadas_total",
+		Help:      "Sagas de impressao que chegaram a um estado terminal (CONCLUIDA, FALHOU).",
+	}, []string{"estado"})
+)
+
+func init() {
+	registro.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		duracaoHTTP,
+		publicacoes,
+		duracaoConsumidor,
+		sagasFinalizadas,
+	)
+}
+
+// Handler serve as metricas no formato de exposicao do Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registro, promhttp.HandlerOpts{})
+}
+
+// HTTP mede cada requisicao. A rota e o padrao registrado no gin (com :id),
+// nao o caminho, para nao criar uma serie por nota.
+func HTTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inicio := time.Now()
+		c.Next()
+
+		rota := c.FullPath()
+		if rota == "" {
+			rota = "desconhecida"
+		}
+		duracaoHTTP.WithLabelValues(c.Request.Method, rota, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(inicio).Seconds())
+	}
+}
+
+// Publicacao conta uma tentativa de publicar um evento do outbox no broker
+func Publicacao(tipoEvento string, err error) {
+	resultado := "sucesso"
+	if err != nil {
+		resultado = "falha"
+	}
+	publicacoes.WithLabelValues(tipoEvento, resultado).Inc()
+}
+
+// MensagemConsumida registra o processamento de uma entrega do consumidor
+func MensagemConsumida(routingKey, resultado string, duracao time.Duration) {
+	duracaoConsumidor.WithLabelValues(routingKey, resultado).Observe(duracao.Seconds())
+}
+
+// SagaFinalizada conta uma saga que chegou a CONCLUIDA ou FALHOU. Chamar so
+// depois do commit (Repositorios.AoConfirmar), para retentativas nao contarem
+// a mesma saga duas vezes.
+func SagaFinalizada(estado string) {
+	sagasFinalizadas.WithLabelValues(estado).Inc()
+}
@@ -0,0 +1,84 @@
+package metricas_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/metricas"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func coletar(t *testing.T) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	metricas.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200 em /metrics, obteve %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func esperarSerie(t *testing.T, saida, serie string) {
+	t.Helper()
+	if !strings.Contains(saida, serie+"\n") {
+		t.Errorf("serie ausente: %s", serie)
+	}
+}
+
+func TestHTTP_RotaDoGin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(metricas.HTTP())
+	r.GET("/notas/:id", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	for _, caminho := range []string{"/notas/1", "/notas/2", "/outra"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, caminho, nil))
+	}
+
+	saida := coletar(t)
+	esperarSerie(t, saida, `faturamento_http_duracao_segundos_count{metodo="GET",rota="/notas/:id",status="404"} 2`)
+	esperarSerie(t, saida, `faturamento_http_duracao_segundos_count{metodo="GET",rota="desconhecida",status="404"} 1`)
+}
+
+func TestContadores(t *testing.T) {
+	metricas.Publicacao(dominio.EventoImpressaoSolicitada, nil)
+	metricas.Publicacao(dominio.EventoImpressaoSolicitada, errors.New("broker fora"))
+	metricas.MensagemConsumida(dominio.EventoEstoqueReservado, metricas.ResultadoQuarentena, 20*time.Millisecond)
+	metricas.SagaFinalizada(dominio.EstadoSagaFalhou)
+
+	saida := coletar(t)
+	esperarSerie(t, saida, `faturamento_outbox_publicacoes_total{resultado="sucesso",tipo_evento="`+dominio.EventoImpressaoSolicitada+`"} 1`)
+	esperarSerie(t, saida, `faturamento_outbox_publicacoes_total{resultado="falha",tipo_evento="`+dominio.EventoImpressaoSolicitada+`"} 1`)
+	esperarSerie(t, saida, `faturamento_consumidor_duracao_segundos_count{resultado="quarentena",routing_key="`+dominio.EventoEstoqueReservado+`"} 1`)
+	esperarSerie(t, saida, `faturamento_sagas_finalizadas_total{estado="FALHOU"} 1`)
+}
+
+func TestRegistrarOutbox(t *testing.T) {
+	banco := repositorio.NovoBancoMemoria()
+	if err := metricas.RegistrarOutbox(banco); err != nil {
+		t.Fatalf("falha ao registrar: %v", err)
+	}
+	esperarSerie(t, coletar(t), "faturamento_outbox_pendentes 0")
+
+	evt, _ := dominio.NovoEventoOutbox(dominio.EventoImpressaoSolicitada, uuid.New(), map[string]string{}, dominio.NovoContextoEvento(""))
+	evt.DataOcorrencia = time.Now().Add(-time.Minute)
+	if err := banco.Outbox().Adicionar(context.Background(), &evt); err != nil {
+		t.Fatalf("falha ao adicionar evento: %v", err)
+	}
+
+	saida := coletar(t)
+	esperarSerie(t, saida, "faturamento_outbox_pendentes 1")
+	if !strings.Contains(saida, "faturamento_outbox_pendente_mais_antigo_segundos 6") {
+		t.Errorf("esperava idade de cerca de 60s no pendente mais antigo")
+	}
+}
@@ -0,0 +1,59 @@
+package metricas
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	descPendentes = prometheus.NewDesc(
+		"faturamento_outbox_pendentes",
+		"Eventos do outbox ainda nao publicados (sem os estacionados).",
+		nil, nil,
+	)
+	descIdadePendente = prometheus.NewDesc(
+		"faturamento_outbox_pendente_mais_antigo_segundos",
+		"Idade do evento pendente mais antigo do outbox; 0 sem pendentes.",
+		nil, nil,
+	)
+)
+
+// coletorOutbox le o backlog do outbox a cada coleta, de todas as empresas
+type coletorOutbox struct {
+	repos   repositorio.Repositorios
+	timeout time.Duration
+}
+
+// RegistrarOutbox passa a expor o backlog do outbox lido de repos
+func RegistrarOutbox(repos repositorio.Repositorios) error {
+	return registro.Register(&coletorOutbox{repos: repos, timeout: 5 * time.Second})
+}
+
+func (c *coletorOutbox) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descPendentes
+	ch <- descIdadePendente
+}
+
+func (c *coletorOutbox) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancelar := context.WithTimeout(context.Background(), c.timeout)
+	defer cancelar()
+
+	backlog, err := c.repos.Outbox().Backlog(ctx)
+	if err != nil {
+		// sem as series o alerta de ausencia dispara; o resto da coleta segue
+		log.Printf("[metricas] falha ao ler backlog do outbox: %v", err)
+		return
+	}
+
+	var idade float64
+	if backlog.MaisAntigo != nil {
+		idade = time.Since(*backlog.MaisAntigo).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(descPendentes, prometheus.GaugeValue, float64(backlog.Pendentes))
+	ch <- prometheus.MustNewConstMetric(descIdadePendente, prometheus.GaugeValue, idade)
+}
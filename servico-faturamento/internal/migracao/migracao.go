@@ -0,0 +1,299 @@
+// Package migracao aplica as migrations SQL versionadas do servico. Os
+// arquivos em sql/ sao a unica fonte do schema: NNNN_nome.up.sql aplica a
+// versao NNNN e NNNN_nome.down.sql a desfaz.
+package migracao
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed sql/*.sql
+var arquivosEmbutidos embed.FS
+
+// chaveTrava identifica as migrations do faturamento no pg_advisory_lock;
+// replicas subindo juntas esperam a primeira terminar em vez de disputar DDL
+const chaveTrava int64 = 0x66617475726d6967 // "faturmig"
+
+var padraoArquivo = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migracao e uma versao do schema com o SQL de ida e de volta
+type Migracao struct {
+	Versao int64
+	Nome   string
+	Up     string
+	Down   string
+}
+
+// Estado e uma migration conhecida pelo binario e/ou registrada no banco
+type Estado struct {
+	Versao     int64      `json:"versao"`
+	Nome       string     `json:"nome"`
+	AplicadaEm *time.Time `json:"aplicadaEm,omitempty"`
+}
+
+// Embutidas retorna as migrations compiladas no binario, em ordem
+func Embutidas() ([]Migracao, error) {
+	dir, err := fs.Sub(arquivosEmbutidos, "sql")
+	if err != nil {
+		return nil, err
+	}
+	return Carregar(dir)
+}
+
+// Carregar le NNNN_nome.up.sql/.down.sql da raiz de fsys. Toda versao precisa
+// dos dois arquivos, com o mesmo nome, e as versoes nao podem repetir.
+func Carregar(fsys fs.FS) ([]Migracao, error) {
+	entradas, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("falha ao listar migrations: %w", err)
+	}
+
+	porVersao := make(map[int64]*Migracao)
+	for _, entrada := range entradas {
+		if entrada.IsDir() {
+			continue
+		}
+		partes := padraoArquivo.FindStringSubmatch(entrada.Name())
+		if partes == nil {
+			return nil, fmt.Errorf("nome de migration invalido: %s", entrada.Name())
+		}
+
+		versao, _ := strconv.ParseInt(partes[1], 10, 64)
+		conteudo, err := fs.ReadFile(fsys, entrada.Name())
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler %s: %w", entrada.Name(), err)
+		}
+
+		m, ok := porVersao[versao]
+		if !ok {
+			m = &Migracao{Versao: versao, Nome: partes[2]}
+			porVersao[versao] = m
+		}
+		if m.Nome != partes[2] {
+			return nil, fmt.Errorf("versao %d com nomes diferentes: %s e %s", versao, m.Nome, partes[2])
+		}
+		destino := &m.Down
+		if partes[3] == "up" {
+			destino = &m.Up
+		}
+		if *destino != "" {
+			return nil, fmt.Errorf("versao %d repetida: %s", versao, entrada.Name())
+		}
+		*destino = string(conteudo)
+	}
+
+	migracoes := make([]Migracao, 0, len(porVersao))
+	for _, m := range porVersao {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s precisa de .up.sql e .down.sql", m.Versao, m.Nome)
+		}
+		migracoes = append(migracoes, *m)
+	}
+	sort.Slice(migracoes, func(i, j int) bool { return migracoes[i].Versao < migracoes[j].Versao })
+	return migracoes, nil
+}
+
+// Migrador aplica e reverte migrations registrando cada versao em
+// schema_migrations. Cada versao roda numa transacao propria junto com o
+// registro, entao uma falha deixa o banco na versao anterior.
+type Migrador struct {
+	DB        *sql.DB
+	Migracoes []Migracao
+	// TravaAdvisory serializa execucoes concorrentes com pg_advisory_lock
+	// (so PostgreSQL)
+	TravaAdvisory bool
+}
+
+// Novo monta o Migrador com as migrations embutidas sobre a conexao do GORM
+func Novo(db *gorm.DB) (*Migrador, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	migracoes, err := Embutidas()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrador{
+		DB:            sqlDB,
+		Migracoes:     migracoes,
+		TravaAdvisory: db.Dialector.Name() == "postgres",
+	}, nil
+}
+
+// Aplicar executa, em ordem, todas as versoes ainda nao registradas
+func (m *Migrador) Aplicar(ctx context.Context) ([]Migracao, error) {
+	var aplicadas []Migracao
+
+	err := m.executar(ctx, func(conn *sql.Conn, registradas map[int64]time.Time) error {
+		var maior int64
+		for versao := range registradas {
+			if versao > maior {
+				maior = versao
+			}
+		}
+
+		for _, mig := range m.Migracoes {
+			if _, ok := registradas[mig.Versao]; ok {
+				continue
+			}
+			// versao nova abaixo de uma ja aplicada: alguem criou a migration em
+			// paralelo; renumerar e mais seguro que aplicar fora de ordem
+			if mig.Versao < maior {
+				return fmt.Errorf("migration %04d_%s fora de ordem: banco ja esta na versao %d", mig.Versao, mig.Nome, maior)
+			}
+
+			if err := m.emTransacao(ctx, conn, mig.Up,
+				`INSERT INTO schema_migrations (versao, nome, aplicada_em) VALUES ($1, $2, $3)`,
+				mig.Versao, mig.Nome, time.Now().UTC()); err != nil {
+				return fmt.Errorf("falha ao aplicar %04d_%s: %w", mig.Versao, mig.Nome, err)
+			}
+			log.Printf("[migracao] aplicada %04d_%s", mig.Versao, mig.Nome)
+			aplicadas = append(aplicadas, mig)
+		}
+
+		conhecidas := make(map[int64]bool, len(m.Migracoes))
+		for _, mig := range m.Migracoes {
+			conhecidas[mig.Versao] = true
+		}
+		for versao := range registradas {
+			if !conhecidas[versao] {
+				log.Printf("[migracao] banco tem a versao %d, que este binario nao conhece", versao)
+			}
+		}
+		return nil
+	})
+	return aplicadas, err
+}
+
+// Reverter desfaz as ultimas `passos` versoes aplicadas, da mais nova para a
+// mais antiga
+func (m *Migrador) Reverter(ctx context.Context, passos int) ([]Migracao, error) {
+	var revertidas []Migracao
+
+	err := m.executar(ctx, func(conn *sql.Conn, registradas map[int64]time.Time) error {
+		for i := len(m.Migracoes) - 1; i >= 0 && len(revertidas) < passos; i-- {
+			mig := m.Migracoes[i]
+			if _, ok := registradas[mig.Versao]; !ok {
+				continue
+			}
+
+			if err := m.emTransacao(ctx, conn, mig.Down,
+				`DELETE FROM schema_migrations WHERE versao = $1`, mig.Versao); err != nil {
+				return fmt.Errorf("falha ao reverter %04d_%s: %w", mig.Versao, mig.Nome, err)
+			}
+			log.Printf("[migracao] revertida %04d_%s", mig.Versao, mig.Nome)
+			revertidas = append(revertidas, mig)
+		}
+		return nil
+	})
+	return revertidas, err
+}
+
+// Status lista as migrations do binario e as registradas no banco, em ordem
+func (m *Migrador) Status(ctx context.Context) ([]Estado, error) {
+	var estados []Estado
+
+	err := m.executar(ctx, func(conn *sql.Conn, registradas map[int64]time.Time) error {
+		vistas := make(map[int64]bool)
+		for _, mig := range m.Migracoes {
+			estado := Estado{Versao: mig.Versao, Nome: mig.Nome}
+			if quando, ok := registradas[mig.Versao]; ok {
+				estado.AplicadaEm = &quando
+			}
+			estados = append(estados, estado)
+			vistas[mig.Versao] = true
+		}
+		for versao, quando := range registradas {
+			if !vistas[versao] {
+				quando := quando
+				estados = append(estados, Estado{Versao: versao, Nome: "(desconhecida)", AplicadaEm: &quando})
+			}
+		}
+		sort.Slice(estados, func(i, j int) bool { return estados[i].Versao < estados[j].Versao })
+		return nil
+	})
+	return estados, err
+}
+
+// executar segura uma conexao (e a trava, se houver) durante toda a operacao;
+// pg_advisory_lock vale por sessao, entao trava e DDL precisam da mesma conexao
+func (m *Migrador) executar(ctx context.Context, fn func(*sql.Conn, map[int64]time.Time) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("falha ao obter conexao: %w", err)
+	}
+	defer conn.Close()
+
+	if m.TravaAdvisory {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, chaveTrava); err != nil {
+			return fmt.Errorf("falha ao obter trava de migracao: %w", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, chaveTrava); err != nil {
+				log.Printf("[migracao] falha ao liberar trava: %v", err)
+			}
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		versao BIGINT PRIMARY KEY,
+		nome VARCHAR(200) NOT NULL,
+		aplicada_em TIMESTAMP NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("falha ao criar schema_migrations: %w", err)
+	}
+
+	registradas, err := versoesRegistradas(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, registradas)
+}
+
+// emTransacao roda o SQL da migration e o registro em schema_migrations juntos
+func (m *Migrador) emTransacao(ctx context.Context, conn *sql.Conn, ddl, registro string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, ddl); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, registro, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func versoesRegistradas(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT versao, aplicada_em FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	registradas := make(map[int64]time.Time)
+	for rows.Next() {
+		var versao int64
+		var quando time.Time
+		if err := rows.Scan(&versao, &quando); err != nil {
+			return nil, fmt.Errorf("falha ao ler schema_migrations: %w", err)
+		}
+		registradas[versao] = quando
+	}
+	return registradas, rows.Err()
+}
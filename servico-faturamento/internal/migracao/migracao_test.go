@@ -0,0 +1,239 @@
+package migracao_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/migracao"
+
+	"gorm.io/gorm/schema"
+)
+
+func arquivo(sql string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(sql)}
+}
+
+func novoMigrador(t *testing.T, fsys fstest.MapFS) (*migracao.Migrador, *sql.DB) {
+	t.Helper()
+
+	migracoes, err := migracao.Carregar(fsys)
+	if err != nil {
+		t.Fatalf("falha ao carregar migrations: %v", err)
+	}
+	sqlDB, err := bdteste.Abrir(t).DB()
+	if err != nil {
+		t.Fatalf("falha ao obter conexao: %v", err)
+	}
+	return &migracao.Migrador{DB: sqlDB, Migracoes: migracoes}, sqlDB
+}
+
+func tabelaExiste(t *testing.T, db *sql.DB, nome string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, nome).Scan(&n); err != nil {
+		t.Fatalf("falha ao consultar sqlite_master: %v", err)
+	}
+	return n > 0
+}
+
+func TestMigrador_AplicarEReverter(t *testing.T) {
+	migrador, db := novoMigrador(t, fstest.MapFS{
+		"0001_clientes.up.sql":   arquivo(`CREATE TABLE clientes (id INTEGER PRIMARY KEY, nome TEXT NOT NULL);`),
+		"0001_clientes.down.sql": arquivo(`DROP TABLE clientes;`),
+		"0002_pedidos.up.sql": arquivo(`CREATE TABLE pedidos (id INTEGER PRIMARY KEY, cliente_id INTEGER NOT NULL);
+CREATE INDEX idx_pedidos_cliente ON pedidos(cliente_id);`),
+		"0002_pedidos.down.sql": arquivo(`DROP TABLE pedidos;`),
+	})
+	ctx := context.Background()
+
+	aplicadas, err := migrador.Aplicar(ctx)
+	if err != nil {
+		t.Fatalf("erro ao aplicar: %v", err)
+	}
+	if len(aplicadas) != 2 || aplicadas[0].Versao != 1 || aplicadas[1].Versao != 2 {
+		t.Fatalf("esperava aplicar 0001 e 0002 em ordem, obteve %+v", aplicadas)
+	}
+	if !tabelaExiste(t, db, "clientes") || !tabelaExiste(t, db, "pedidos") {
+		t.Fatal("tabelas das migrations nao foram criadas")
+	}
+
+	// segunda execucao (outra replica, outro deploy) nao reaplica nada
+	aplicadas, err = migrador.Aplicar(ctx)
+	if err != nil {
+		t.Fatalf("erro ao reaplicar: %v", err)
+	}
+	if len(aplicadas) != 0 {
+		t.Errorf("esperava nenhuma migration nova, obteve %d", len(aplicadas))
+	}
+
+	revertidas, err := migrador.Reverter(ctx, 1)
+	if err != nil {
+		t.Fatalf("erro ao reverter: %v", err)
+	}
+	if len(revertidas) != 1 || revertidas[0].Versao != 2 {
+		t.Fatalf("esperava reverter so a 0002, obteve %+v", revertidas)
+	}
+	if tabelaExiste(t, db, "pedidos") || !tabelaExiste(t, db, "clientes") {
+		t.Error("down da 0002 deveria remover so pedidos")
+	}
+
+	estados, err := migrador.Status(ctx)
+	if err != nil {
+		t.Fatalf("erro no status: %v", err)
+	}
+	if len(estados) != 2 || estados[0].AplicadaEm == nil || estados[1].AplicadaEm != nil {
+		t.Errorf("esperava 0001 aplicada e 0002 pendente, obteve %+v", estados)
+	}
+}
+
+func TestMigrador_FalhaNaoRegistraVersao(t *testing.T) {
+	migrador, db := novoMigrador(t, fstest.MapFS{
+		"0001_clientes.up.sql":   arquivo(`CREATE TABLE clientes (id INTEGER PRIMARY KEY);`),
+		"0001_clientes.down.sql": arquivo(`DROP TABLE clientes;`),
+		"0002_quebrada.up.sql": arquivo(`CREATE TABLE pedidos (id INTEGER PRIMARY KEY);
+INSERT INTO tabela_inexistente VALUES (1);`),
+		"0002_quebrada.down.sql": arquivo(`DROP TABLE pedidos;`),
+	})
+	ctx := context.Background()
+
+	if _, err := migrador.Aplicar(ctx); err == nil {
+		t.Fatal("esperava erro na 0002")
+	}
+
+	// a 0001 fica, a 0002 volta inteira: nem a tabela nem o registro
+	if !tabelaExiste(t, db, "clientes") {
+		t.Error("0001 deveria continuar aplicada")
+	}
+	if tabelaExiste(t, db, "pedidos") {
+		t.Error("DDL parcial da 0002 deveria ter sido desfeito")
+	}
+
+	var versoes int
+	db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&versoes)
+	if versoes != 1 {
+		t.Errorf("esperava so a 0001 registrada, obteve %d versoes", versoes)
+	}
+}
+
+func TestMigrador_RecusaVersaoForaDeOrdem(t *testing.T) {
+	migrador, _ := novoMigrador(t, fstest.MapFS{
+		"0001_a.up.sql":   arquivo(`CREATE TABLE a (id INTEGER);`),
+		"0001_a.down.sql": arquivo(`DROP TABLE a;`),
+		"0003_c.up.sql":   arquivo(`CREATE TABLE c (id INTEGER);`),
+		"0003_c.down.sql": arquivo(`DROP TABLE c;`),
+	})
+	ctx := context.Background()
+	if _, err := migrador.Aplicar(ctx); err != nil {
+		t.Fatalf("erro ao aplicar: %v", err)
+	}
+
+	// 0002 chega depois que o banco ja esta na 0003
+	migracoes, err := migracao.Carregar(fstest.MapFS{
+		"0001_a.up.sql":   arquivo(`CREATE TABLE a (id INTEGER);`),
+		"0001_a.down.sql": arquivo(`DROP TABLE a;`),
+		"0002_b.up.sql":   arquivo(`CREATE TABLE b (id INTEGER);`),
+		"0002_b.down.sql": arquivo(`DROP TABLE b;`),
+		"0003_c.up.sql":   arquivo(`CREATE TABLE c (id INTEGER);`),
+		"0003_c.down.sql": arquivo(`DROP TABLE c;`),
+	})
+	if err != nil {
+		t.Fatalf("falha ao carregar migrations: %v", err)
+	}
+	migrador.Migracoes = migracoes
+
+	if _, err := migrador.Aplicar(ctx); err == nil || !strings.Contains(err.Error(), "fora de ordem") {
+		t.Errorf("esperava erro de migration fora de ordem, obteve %v", err)
+	}
+}
+
+func TestCarregar_ArquivosInvalidos(t *testing.T) {
+	casos := []struct {
+		nome string
+		fsys fstest.MapFS
+	}{
+		{"sem down", fstest.MapFS{
+			"0001_a.up.sql": arquivo(`SELECT 1;`),
+		}},
+		{"nome fora do padrao", fstest.MapFS{
+			"0001-a.sql": arquivo(`SELECT 1;`),
+		}},
+		{"versao com dois nomes", fstest.MapFS{
+			"0001_a.up.sql":   arquivo(`SELECT 1;`),
+			"0001_b.down.sql": arquivo(`SELECT 1;`),
+		}},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nome, func(t *testing.T) {
+			if _, err := migracao.Carregar(caso.fsys); err == nil {
+				t.Error("esperava erro")
+			}
+		})
+	}
+}
+
+var (
+	padraoCreate = regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS (\w+) \((.*?)\n\);`)
+	padraoAdd    = regexp.MustCompile(`ALTER TABLE (\w+)\s+ADD COLUMN IF NOT EXISTS (\w+)`)
+)
+
+// As migrations sao a fonte do schema e os modelos GORM so o leem: toda
+// coluna mapeada precisa existir em alguma migration
+func TestEmbutidas_CobremColunasDosModelos(t *testing.T) {
+	migracoes, err := migracao.Embutidas()
+	if err != nil {
+		t.Fatalf("falha ao carregar migrations embutidas: %v", err)
+	}
+	for i, m := range migracoes {
+		if m.Versao != int64(i+1) {
+			t.Fatalf("versoes devem ser sequenciais a partir de 1, encontrou %d na posicao %d", m.Versao, i)
+		}
+	}
+
+	colunas := make(map[string]map[string]bool)
+	for _, m := range migracoes {
+		for _, bloco := range padraoCreate.FindAllStringSubmatch(m.Up, -1) {
+			tabela := bloco[1]
+			if colunas[tabela] == nil {
+				colunas[tabela] = make(map[string]bool)
+			}
+			for _, linha := range strings.Split(bloco[2], "\n") {
+				campos := strings.Fields(linha)
+				if len(campos) == 0 || strings.HasPrefix(campos[0], "--") {
+					continue
+				}
+				colunas[tabela][campos[0]] = true
+			}
+		}
+		for _, add := range padraoAdd.FindAllStringSubmatch(m.Up, -1) {
+			if colunas[add[1]] != nil {
+				colunas[add[1]][add[2]] = true
+			}
+		}
+	}
+
+	cache := &sync.Map{}
+	for _, modelo := range dominio.Modelos() {
+		s, err := schema.Parse(modelo, cache, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatalf("falha ao ler modelo %T: %v", modelo, err)
+		}
+		existentes, ok := colunas[s.Table]
+		if !ok {
+			t.Errorf("tabela %s (%T) nao e criada por nenhuma migration", s.Table, modelo)
+			continue
+		}
+		for _, coluna := range s.DBNames {
+			if !existentes[coluna] {
+				t.Errorf("coluna %s.%s (%T) nao existe nas migrations", s.Table, coluna, modelo)
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS produtos_catalogo;
+DROP TABLE IF EXISTS mensagens_quarentena;
+DROP TABLE IF EXISTS passos_saga_faturamento;
+DROP TABLE IF EXISTS sagas_faturamento;
+DROP TABLE IF EXISTS mensagens_processadas;
+DROP TABLE IF EXISTS eventos_outbox_arquivo;
+DROP TABLE IF EXISTS eventos_outbox;
+DROP TABLE IF EXISTS solicitacoes_impressao;
+DROP TABLE IF EXISTS itens_nota;
+DROP TABLE IF EXISTS notas_fiscais;
@@ -0,0 +1,182 @@
+-- Schema inicial do servico Faturamento (antes em scripts/migrations/faturamento-init.sql).
+-- IF NOT EXISTS em tudo: bancos ja criados pelo script antigo ou pelo AutoMigrate
+-- adotam esta versao sem recriar nada; a 0002 alinha o que o AutoMigrate deixou diferente.
+
+-- Tabela notas_fiscais
+CREATE TABLE IF NOT EXISTS notas_fiscais (
+    id UUID PRIMARY KEY,
+    numero VARCHAR(20) UNIQUE NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('ABERTA', 'FECHADA', 'CANCELADA')),
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_fechada TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_notas_numero ON notas_fiscais(numero);
+CREATE INDEX IF NOT EXISTS idx_notas_status ON notas_fiscais(status);
+CREATE INDEX IF NOT EXISTS idx_notas_data_criacao ON notas_fiscais(data_criacao DESC);
+
+-- Tabela itens_nota
+CREATE TABLE IF NOT EXISTS itens_nota (
+    id UUID PRIMARY KEY,
+    nota_id UUID NOT NULL REFERENCES notas_fiscais(id) ON DELETE CASCADE,
+    produto_id UUID NOT NULL,
+    quantidade INT NOT NULL CHECK (quantidade > 0),
+    preco_unitario DECIMAL(10,2) NOT NULL CHECK (preco_unitario >= 0),
+    sku VARCHAR(50),
+    nome_produto VARCHAR(200)
+);
+
+CREATE INDEX IF NOT EXISTS idx_itens_nota_id ON itens_nota(nota_id);
+CREATE INDEX IF NOT EXISTS idx_itens_produto_id ON itens_nota(produto_id);
+
+-- Tabela solicitacoes_impressao
+CREATE TABLE IF NOT EXISTS solicitacoes_impressao (
+    id UUID PRIMARY KEY,
+    nota_id UUID NOT NULL REFERENCES notas_fiscais(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('PENDENTE', 'CONCLUIDA', 'FALHOU')),
+    mensagem_erro TEXT,
+    chave_idempotencia VARCHAR(100) UNIQUE NOT NULL,
+    id_correlacao VARCHAR(100),
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_conclusao TIMESTAMPTZ,
+    prazo_expiracao TIMESTAMPTZ,
+    -- reprocessamento: tentativa N aponta para a solicitacao original
+    solicitacao_origem_id UUID REFERENCES solicitacoes_impressao(id) ON DELETE CASCADE,
+    tentativa INTEGER NOT NULL DEFAULT 1,
+    tentativas INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_solicitacoes_nota_id ON solicitacoes_impressao(nota_id);
+CREATE INDEX IF NOT EXISTS idx_solicitacoes_status ON solicitacoes_impressao(status);
+CREATE INDEX IF NOT EXISTS idx_solicitacoes_chave ON solicitacoes_impressao(chave_idempotencia);
+CREATE INDEX IF NOT EXISTS idx_solicitacoes_correlacao ON solicitacoes_impressao(id_correlacao);
+CREATE INDEX IF NOT EXISTS idx_solicitacoes_prazo ON solicitacoes_impressao(prazo_expiracao);
+CREATE INDEX IF NOT EXISTS idx_solicitacoes_origem ON solicitacoes_impressao(solicitacao_origem_id);
+
+-- Tabela eventos_outbox
+CREATE TABLE IF NOT EXISTS eventos_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    id_evento UUID,
+    tipo_evento VARCHAR(100) NOT NULL,
+    id_agregado UUID NOT NULL,
+    payload JSONB NOT NULL,
+    data_ocorrencia TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_publicacao TIMESTAMPTZ,
+    -- envelope (estilo CloudEvents)
+    origem VARCHAR(100),
+    versao_schema VARCHAR(20),
+    id_correlacao VARCHAR(100),
+    id_causacao VARCHAR(100),
+    -- controle de publicacao
+    tentativas INTEGER NOT NULL DEFAULT 0,
+    ultimo_erro TEXT,
+    proxima_tentativa TIMESTAMPTZ,
+    data_estacionamento TIMESTAMPTZ,
+    motivo_estacionamento TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_faturamento_outbox_pendentes
+    ON eventos_outbox (data_publicacao)
+    WHERE data_publicacao IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_faturamento_outbox_tipo ON eventos_outbox(tipo_evento);
+CREATE INDEX IF NOT EXISTS idx_faturamento_outbox_id_evento ON eventos_outbox(id_evento);
+CREATE INDEX IF NOT EXISTS idx_faturamento_outbox_correlacao ON eventos_outbox(id_correlacao);
+CREATE INDEX IF NOT EXISTS idx_faturamento_outbox_proxima ON eventos_outbox(proxima_tentativa);
+CREATE INDEX IF NOT EXISTS idx_faturamento_outbox_agregado ON eventos_outbox(id_agregado);
+
+-- Tabela eventos_outbox_arquivo (eventos publicados removidos pela retenção)
+CREATE TABLE IF NOT EXISTS eventos_outbox_arquivo (
+    id BIGINT PRIMARY KEY,
+    id_evento UUID,
+    tipo_evento VARCHAR(100) NOT NULL,
+    id_agregado UUID NOT NULL,
+    payload JSONB NOT NULL,
+    data_ocorrencia TIMESTAMPTZ NOT NULL,
+    data_publicacao TIMESTAMPTZ,
+    origem VARCHAR(100),
+    versao_schema VARCHAR(20),
+    id_correlacao VARCHAR(100),
+    id_causacao VARCHAR(100),
+    data_arquivamento TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_faturamento_outbox_publicados
+    ON eventos_outbox (data_publicacao)
+    WHERE data_publicacao IS NOT NULL;
+
+-- Tabela mensagens_processadas (idempotência RabbitMQ)
+CREATE TABLE IF NOT EXISTS mensagens_processadas (
+    id_mensagem VARCHAR(100) PRIMARY KEY,
+    data_processada TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mensagens_data ON mensagens_processadas(data_processada DESC);
+
+-- Log das sagas de impressão (uma instância por solicitação)
+CREATE TABLE IF NOT EXISTS sagas_faturamento (
+    id UUID PRIMARY KEY,
+    tipo VARCHAR(50) NOT NULL,
+    solicitacao_id UUID NOT NULL REFERENCES solicitacoes_impressao(id) ON DELETE CASCADE,
+    nota_id UUID NOT NULL,
+    id_correlacao VARCHAR(100),
+    estado VARCHAR(30) NOT NULL,
+    passo_atual VARCHAR(100),
+    data_inicio TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_fim TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sagas_faturamento_solicitacao_id ON sagas_faturamento(solicitacao_id);
+CREATE INDEX IF NOT EXISTS idx_sagas_faturamento_nota_id ON sagas_faturamento(nota_id);
+CREATE INDEX IF NOT EXISTS idx_sagas_faturamento_id_correlacao ON sagas_faturamento(id_correlacao);
+CREATE INDEX IF NOT EXISTS idx_sagas_faturamento_estado ON sagas_faturamento(estado);
+CREATE INDEX IF NOT EXISTS idx_sagas_faturamento_data_inicio ON sagas_faturamento(data_inicio);
+
+CREATE TABLE IF NOT EXISTS passos_saga_faturamento (
+    id BIGSERIAL PRIMARY KEY,
+    saga_id UUID NOT NULL REFERENCES sagas_faturamento(id) ON DELETE CASCADE,
+    nome VARCHAR(100) NOT NULL,
+    direcao VARCHAR(10) NOT NULL,
+    id_mensagem VARCHAR(100),
+    id_causacao VARCHAR(100),
+    detalhe TEXT,
+    estado_resultante VARCHAR(30),
+    data TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_passos_saga_faturamento_saga_id ON passos_saga_faturamento(saga_id);
+
+-- Entregas que falharam no consumidor (quarentena de mensagens venenosas)
+CREATE TABLE IF NOT EXISTS mensagens_quarentena (
+    id UUID PRIMARY KEY,
+    id_mensagem VARCHAR(100) NOT NULL,
+    routing_key VARCHAR(100) NOT NULL,
+    correlation_id VARCHAR(100),
+    cabecalhos JSONB NOT NULL,
+    corpo TEXT NOT NULL,
+    erro TEXT NOT NULL,
+    tentativas INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('EM_RETENTATIVA', 'QUARENTENA', 'RECUPERADA', 'REPROCESSADA', 'DESCARTADA')),
+    motivo_descarte TEXT,
+    editada BOOLEAN NOT NULL DEFAULT FALSE,
+    data_primeira TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_ultima TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_resolucao TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quarentena_id_mensagem ON mensagens_quarentena(id_mensagem);
+CREATE INDEX IF NOT EXISTS idx_quarentena_routing_key ON mensagens_quarentena(routing_key);
+CREATE INDEX IF NOT EXISTS idx_quarentena_status ON mensagens_quarentena(status);
+
+-- Catálogo de produtos (projeção dos eventos Estoque.Produto*)
+CREATE TABLE IF NOT EXISTS produtos_catalogo (
+    id UUID PRIMARY KEY,
+    sku VARCHAR(50) NOT NULL,
+    nome VARCHAR(200) NOT NULL,
+    ativo BOOLEAN NOT NULL,
+    data_evento TIMESTAMPTZ NOT NULL,
+    id_evento VARCHAR(100)
+);
+
+CREATE INDEX IF NOT EXISTS idx_produtos_catalogo_sku ON produtos_catalogo(sku);
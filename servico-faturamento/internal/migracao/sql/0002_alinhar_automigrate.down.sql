@@ -0,0 +1 @@
+-- Nada a desfazer: a 0002 so leva o banco ao schema que a 0001 ja define.
@@ -0,0 +1,27 @@
+-- Bancos criados pelo AutoMigrate ficaram sem as CHECK constraints e com
+-- numero/chave_idempotencia como TEXT. Recria as constraints com os nomes que
+-- o PostgreSQL gera para a 0001, entao num banco novo isto nao muda nada.
+-- Se alguma linha violar o schema a migracao falha e nada e aplicado.
+
+ALTER TABLE notas_fiscais
+    ALTER COLUMN numero TYPE VARCHAR(20),
+    ALTER COLUMN status TYPE VARCHAR(20),
+    DROP CONSTRAINT IF EXISTS notas_fiscais_status_check,
+    ADD CONSTRAINT notas_fiscais_status_check CHECK (status IN ('ABERTA', 'FECHADA', 'CANCELADA'));
+
+ALTER TABLE itens_nota
+    DROP CONSTRAINT IF EXISTS itens_nota_quantidade_check,
+    ADD CONSTRAINT itens_nota_quantidade_check CHECK (quantidade > 0),
+    DROP CONSTRAINT IF EXISTS itens_nota_preco_unitario_check,
+    ADD CONSTRAINT itens_nota_preco_unitario_check CHECK (preco_unitario >= 0);
+
+ALTER TABLE solicitacoes_impressao
+    ALTER COLUMN status TYPE VARCHAR(20),
+    ALTER COLUMN chave_idempotencia TYPE VARCHAR(100),
+    ALTER COLUMN chave_idempotencia SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS solicitacoes_impressao_status_check,
+    ADD CONSTRAINT solicitacoes_impressao_status_check CHECK (status IN ('PENDENTE', 'CONCLUIDA', 'FALHOU'));
+
+ALTER TABLE mensagens_quarentena
+    DROP CONSTRAINT IF EXISTS mensagens_quarentena_status_check,
+    ADD CONSTRAINT mensagens_quarentena_status_check CHECK (status IN ('EM_RETENTATIVA', 'QUARENTENA', 'RECUPERADA', 'REPROCESSADA', 'DESCARTADA'));
@@ -0,0 +1,30 @@
+-- Trilha de auditoria das escritas em notas_fiscais, itens_nota e
+-- solicitacoes_impressao, gravada pelo servico na mesma transacao da escrita.
+CREATE TABLE IF NOT EXISTS auditoria (
+    id BIGSERIAL PRIMARY KEY,
+    tabela VARCHAR(50) NOT NULL,
+    registro_id UUID NOT NULL,
+    -- sem FK: a trilha sobrevive a remocao da nota
+    nota_id UUID NOT NULL,
+    operacao VARCHAR(10) NOT NULL CHECK (operacao IN ('INSERT', 'UPDATE', 'DELETE')),
+    ator VARCHAR(100) NOT NULL,
+    id_requisicao VARCHAR(100),
+    -- campo -> {"antes": ..., "depois": ...}
+    alteracoes JSONB NOT NULL,
+    data_registro TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_auditoria_nota ON auditoria(nota_id);
+CREATE INDEX IF NOT EXISTS idx_auditoria_registro ON auditoria(registro_id);
+
+-- A trilha so cresce: UPDATE e DELETE falham mesmo para o dono da tabela
+CREATE OR REPLACE FUNCTION auditoria_somente_insercao() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'auditoria nao pode ser alterada nem removida';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS auditoria_somente_insercao ON auditoria;
+CREATE TRIGGER auditoria_somente_insercao
+    BEFORE UPDATE OR DELETE ON auditoria
+    FOR EACH ROW EXECUTE FUNCTION auditoria_somente_insercao();
@@ -0,0 +1,18 @@
+-- Falha se duas empresas tiverem o mesmo numero de nota ou chave de
+-- idempotencia: resolva os conflitos antes de reverter.
+DROP INDEX IF EXISTS idx_notas_empresa_numero;
+DROP INDEX IF EXISTS idx_solicitacoes_empresa_chave;
+ALTER TABLE notas_fiscais ADD CONSTRAINT notas_fiscais_numero_key UNIQUE (numero);
+ALTER TABLE solicitacoes_impressao ADD CONSTRAINT solicitacoes_impressao_chave_idempotencia_key UNIQUE (chave_idempotencia);
+
+ALTER TABLE notas_fiscais DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE itens_nota DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE solicitacoes_impressao DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE eventos_outbox DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE eventos_outbox_arquivo DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE sagas_faturamento DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE passos_saga_faturamento DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE auditoria DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE mensagens_processadas DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE mensagens_quarentena DROP COLUMN IF EXISTS empresa_id;
+ALTER TABLE produtos_catalogo DROP COLUMN IF EXISTS empresa_id;
@@ -0,0 +1,51 @@
+-- Multiempresa: toda tabela ganha empresa_id. As linhas que ja existiam ficam
+-- na empresa 'padrao' (configure EMPRESA_PADRAO=padrao para continuar
+-- atendendo requisicoes sem X-Empresa); o DEFAULT sai em seguida para que
+-- nenhuma escrita nova caia numa empresa por engano.
+
+ALTER TABLE notas_fiscais ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT 'padrao';
+ALTER TABLE notas_fiscais ALTER COLUMN empresa_id DROP DEFAULT;
+
+ALTER TABLE itens_nota ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT 'padrao';
+ALTER TABLE itens_nota ALTER COLUMN empresa_id DROP DEFAULT;
+
+ALTER TABLE solicitacoes_impressao ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT 'padrao';
+ALTER TABLE solicitacoes_impressao ALTER COLUMN empresa_id DROP DEFAULT;
+
+ALTER TABLE eventos_outbox ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT 'padrao';
+ALTER TABLE eventos_outbox ALTER COLUMN empresa_id DROP DEFAULT;
+
+ALTER TABLE eventos_outbox_arquivo ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT 'padrao';
+ALTER TABLE eventos_outbox_arquivo ALTER COLUMN empresa_id DROP DEFAULT;
+
+ALTER TABLE sagas_faturamento ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT 'padrao';
+ALTER TABLE sagas_faturamento ALTER COLUMN empresa_id DROP DEFAULT;
+
+ALTER TABLE passos_saga_faturamento ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT 'padrao';
+ALTER TABLE passos_saga_faturamento ALTER COLUMN empresa_id DROP DEFAULT;
+
+ALTER TABLE auditoria ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT 'padrao';
+ALTER TABLE auditoria ALTER COLUMN empresa_id DROP DEFAULT;
+
+-- Mensagens recebidas podem vir sem empresa (produtores que ainda nao mandam
+-- o cabecalho): '' e um valor valido aqui, e no catalogo significa produto
+-- compartilhado por todas as empresas
+ALTER TABLE mensagens_processadas ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE mensagens_quarentena ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE produtos_catalogo ADD COLUMN IF NOT EXISTS empresa_id VARCHAR(50) NOT NULL DEFAULT '';
+
+-- Numero da nota e chave de idempotencia passam a ser unicos por empresa.
+-- Remove a constraint da 0001 e a que o AutoMigrate criava.
+ALTER TABLE notas_fiscais DROP CONSTRAINT IF EXISTS notas_fiscais_numero_key;
+ALTER TABLE notas_fiscais DROP CONSTRAINT IF EXISTS uni_notas_fiscais_numero;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notas_empresa_numero ON notas_fiscais(empresa_id, numero);
+
+ALTER TABLE solicitacoes_impressao DROP CONSTRAINT IF EXISTS solicitacoes_impressao_chave_idempotencia_key;
+ALTER TABLE solicitacoes_impressao DROP CONSTRAINT IF EXISTS uni_solicitacoes_impressao_chave_idempotencia;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_solicitacoes_empresa_chave ON solicitacoes_impressao(empresa_id, chave_idempotencia);
+
+CREATE INDEX IF NOT EXISTS idx_notas_empresa_status ON notas_fiscais(empresa_id, status);
+CREATE INDEX IF NOT EXISTS idx_sagas_faturamento_empresa_id ON sagas_faturamento(empresa_id);
+CREATE INDEX IF NOT EXISTS idx_faturamento_outbox_empresa ON eventos_outbox(empresa_id);
+CREATE INDEX IF NOT EXISTS idx_quarentena_empresa ON mensagens_quarentena(empresa_id);
+CREATE INDEX IF NOT EXISTS idx_auditoria_empresa_nota ON auditoria(empresa_id, nota_id);
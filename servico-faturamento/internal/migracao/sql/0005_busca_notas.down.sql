@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS itens_nota_atualizar_busca ON itens_nota;
+DROP TRIGGER IF EXISTS notas_fiscais_atualizar_busca ON notas_fiscais;
+DROP FUNCTION IF EXISTS itens_nota_atualizar_busca();
+DROP FUNCTION IF EXISTS notas_fiscais_atualizar_busca();
+DROP FUNCTION IF EXISTS notas_fiscais_documento_busca(UUID, TEXT, TEXT);
+DROP FUNCTION IF EXISTS notas_fiscais_texto_itens(UUID);
+
+DROP INDEX IF EXISTS idx_notas_busca;
+ALTER TABLE notas_fiscais DROP COLUMN IF EXISTS busca;
+ALTER TABLE notas_fiscais DROP COLUMN IF EXISTS destinatario;
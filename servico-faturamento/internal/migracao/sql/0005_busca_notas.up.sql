@@ -0,0 +1,58 @@
+-- Busca textual de notas (GET /api/v1/notas/busca). notas_fiscais.busca junta
+-- numero (peso A), destinatario (B) e SKU/nome dos itens (C) num tsvector
+-- mantido por triggers. Configuracao 'simple': sem stemming, que atrapalha
+-- nomes de pessoas e codigos; a busca casa prefixos de cada termo.
+
+ALTER TABLE notas_fiscais ADD COLUMN IF NOT EXISTS destinatario VARCHAR(200) NOT NULL DEFAULT '';
+ALTER TABLE notas_fiscais ADD COLUMN IF NOT EXISTS busca TSVECTOR;
+
+-- Texto pesquisavel dos itens da nota, tambem usado no destaque
+CREATE OR REPLACE FUNCTION notas_fiscais_texto_itens(p_nota_id UUID) RETURNS TEXT AS $$
+    SELECT COALESCE(string_agg(concat_ws(' ', i.sku, i.nome_produto), ' ' ORDER BY i.id), '')
+    FROM itens_nota i
+    WHERE i.nota_id = p_nota_id
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION notas_fiscais_documento_busca(p_nota_id UUID, p_numero TEXT, p_destinatario TEXT) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('simple', COALESCE(p_numero, '')), 'A')
+        || setweight(to_tsvector('simple', COALESCE(p_destinatario, '')), 'B')
+        || setweight(to_tsvector('simple', notas_fiscais_texto_itens(p_nota_id)), 'C')
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION notas_fiscais_atualizar_busca() RETURNS trigger AS $$
+BEGIN
+    NEW.busca := notas_fiscais_documento_busca(NEW.id, NEW.numero, NEW.destinatario);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notas_fiscais_atualizar_busca ON notas_fiscais;
+CREATE TRIGGER notas_fiscais_atualizar_busca
+    BEFORE INSERT OR UPDATE OF numero, destinatario ON notas_fiscais
+    FOR EACH ROW EXECUTE FUNCTION notas_fiscais_atualizar_busca();
+
+-- Item novo, alterado ou removido refaz o documento da nota
+CREATE OR REPLACE FUNCTION itens_nota_atualizar_busca() RETURNS trigger AS $$
+DECLARE
+    v_nota_id UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        v_nota_id := OLD.nota_id;
+    ELSE
+        v_nota_id := NEW.nota_id;
+    END IF;
+    UPDATE notas_fiscais
+    SET busca = notas_fiscais_documento_busca(id, numero, destinatario)
+    WHERE id = v_nota_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS itens_nota_atualizar_busca ON itens_nota;
+CREATE TRIGGER itens_nota_atualizar_busca
+    AFTER INSERT OR UPDATE OF sku, nome_produto, nota_id OR DELETE ON itens_nota
+    FOR EACH ROW EXECUTE FUNCTION itens_nota_atualizar_busca();
+
+UPDATE notas_fiscais SET busca = notas_fiscais_documento_busca(id, numero, destinatario);
+
+CREATE INDEX IF NOT EXISTS idx_notas_busca ON notas_fiscais USING GIN (busca);
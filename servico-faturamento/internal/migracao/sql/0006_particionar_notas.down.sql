@@ -0,0 +1,44 @@
+-- Volta notas_fiscais e itens_nota para tabelas simples, com as notas
+-- arquivadas junto das correntes. Falha se houver itens ou solicitacoes de
+-- notas que nao existem (a FK volta).
+
+CREATE TABLE notas_fiscais_simples (LIKE notas_fiscais INCLUDING DEFAULTS INCLUDING CONSTRAINTS);
+INSERT INTO notas_fiscais_simples SELECT * FROM notas_fiscais;
+CREATE TABLE itens_nota_simples (LIKE itens_nota INCLUDING DEFAULTS INCLUDING CONSTRAINTS);
+INSERT INTO itens_nota_simples SELECT * FROM itens_nota;
+
+DROP TABLE itens_nota;
+DROP TABLE notas_fiscais;
+DROP TABLE IF EXISTS numeros_notas_fiscais;
+DROP FUNCTION IF EXISTS notas_fiscais_reservar_numero();
+DROP FUNCTION IF EXISTS faturamento_criar_particoes(DATE, BOOLEAN);
+
+ALTER TABLE notas_fiscais_simples RENAME TO notas_fiscais;
+ALTER TABLE itens_nota_simples RENAME TO itens_nota;
+ALTER TABLE notas_fiscais DROP COLUMN arquivada;
+ALTER TABLE itens_nota DROP COLUMN arquivada;
+ALTER TABLE itens_nota DROP COLUMN data_nota;
+
+ALTER TABLE notas_fiscais ADD CONSTRAINT notas_fiscais_pkey PRIMARY KEY (id);
+ALTER TABLE itens_nota ADD CONSTRAINT itens_nota_pkey PRIMARY KEY (id);
+ALTER TABLE itens_nota ADD CONSTRAINT itens_nota_nota_id_fkey
+    FOREIGN KEY (nota_id) REFERENCES notas_fiscais(id) ON DELETE CASCADE;
+ALTER TABLE solicitacoes_impressao ADD CONSTRAINT solicitacoes_impressao_nota_id_fkey
+    FOREIGN KEY (nota_id) REFERENCES notas_fiscais(id) ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notas_empresa_numero ON notas_fiscais(empresa_id, numero);
+CREATE INDEX IF NOT EXISTS idx_notas_numero ON notas_fiscais(numero);
+CREATE INDEX IF NOT EXISTS idx_notas_status ON notas_fiscais(status);
+CREATE INDEX IF NOT EXISTS idx_notas_data_criacao ON notas_fiscais(data_criacao DESC);
+CREATE INDEX IF NOT EXISTS idx_notas_empresa_status ON notas_fiscais(empresa_id, status);
+CREATE INDEX IF NOT EXISTS idx_notas_busca ON notas_fiscais USING GIN (busca);
+CREATE INDEX IF NOT EXISTS idx_itens_nota_id ON itens_nota(nota_id);
+CREATE INDEX IF NOT EXISTS idx_itens_produto_id ON itens_nota(produto_id);
+
+CREATE TRIGGER notas_fiscais_atualizar_busca
+    BEFORE INSERT OR UPDATE OF numero, destinatario ON notas_fiscais
+    FOR EACH ROW EXECUTE FUNCTION notas_fiscais_atualizar_busca();
+
+CREATE TRIGGER itens_nota_atualizar_busca
+    AFTER INSERT OR UPDATE OF sku, nome_produto, nota_id OR DELETE ON itens_nota
+    FOR EACH ROW EXECUTE FUNCTION itens_nota_atualizar_busca();
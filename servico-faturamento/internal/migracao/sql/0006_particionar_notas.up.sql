@@ -0,0 +1,111 @@
+-- Particiona notas_fiscais e itens_nota por mes de emissao. A chave e
+-- (arquivada, mes): notas_fiscais_2025_01 guarda as notas correntes de
+-- janeiro e notas_fiscais_arquivo_2025_01 as arquivadas (ver o comando
+-- `servico-faturamento arquivar`). Listagens filtram arquivada = false e so
+-- leem as particoes correntes; busca por id enxerga todas.
+--
+-- Restricoes que a particao impoe:
+-- - PK e indices unicos precisam conter a chave de particao, entao a
+--   unicidade de (empresa_id, numero) passa para numeros_notas_fiscais,
+--   mantida por trigger;
+-- - nao ha FK para tabela particionada sem a chave inteira: itens_nota e
+--   solicitacoes_impressao perdem a FK para notas_fiscais (o servico so cria
+--   itens e solicitacoes de notas que acabou de ler).
+-- Os limites de mes seguem o fuso da sessao do banco.
+
+ALTER TABLE notas_fiscais ADD COLUMN IF NOT EXISTS arquivada BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE itens_nota ADD COLUMN IF NOT EXISTS arquivada BOOLEAN NOT NULL DEFAULT false;
+-- data de emissao da nota, repetida no item para particiona-lo junto
+ALTER TABLE itens_nota ADD COLUMN IF NOT EXISTS data_nota TIMESTAMPTZ;
+UPDATE itens_nota i SET data_nota = n.data_criacao FROM notas_fiscais n WHERE n.id = i.nota_id;
+ALTER TABLE itens_nota ALTER COLUMN data_nota SET NOT NULL;
+
+ALTER TABLE notas_fiscais RENAME TO notas_fiscais_legado;
+ALTER TABLE itens_nota RENAME TO itens_nota_legado;
+
+CREATE TABLE notas_fiscais (LIKE notas_fiscais_legado INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+    PARTITION BY RANGE (arquivada, data_criacao);
+ALTER TABLE notas_fiscais ADD CONSTRAINT pk_notas_fiscais PRIMARY KEY (id, arquivada, data_criacao);
+
+CREATE TABLE itens_nota (LIKE itens_nota_legado INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+    PARTITION BY RANGE (arquivada, data_nota);
+ALTER TABLE itens_nota ADD CONSTRAINT pk_itens_nota PRIMARY KEY (id, arquivada, data_nota);
+
+-- Cria, se faltarem, as particoes de notas e itens do mes de p_mes. O servico
+-- chama na subida (mes corrente e seguintes) e o arquivamento antes de mover.
+CREATE OR REPLACE FUNCTION faturamento_criar_particoes(p_mes DATE, p_arquivada BOOLEAN) RETURNS void AS $$
+DECLARE
+    v_inicio DATE := date_trunc('month', p_mes)::date;
+    v_fim DATE := (date_trunc('month', p_mes) + INTERVAL '1 month')::date;
+    v_sufixo TEXT := CASE WHEN p_arquivada THEN 'arquivo_' ELSE '' END || to_char(v_inicio, 'YYYY_MM');
+    v_tabela TEXT;
+BEGIN
+    FOREACH v_tabela IN ARRAY ARRAY['notas_fiscais', 'itens_nota'] LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF %I FOR VALUES FROM (%L, %L) TO (%L, %L)',
+            v_tabela || '_' || v_sufixo, v_tabela, p_arquivada, v_inicio, p_arquivada, v_fim);
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT faturamento_criar_particoes(mes::date, false)
+FROM generate_series(
+    date_trunc('month', LEAST(COALESCE((SELECT MIN(data_criacao) FROM notas_fiscais_legado), NOW()), NOW())),
+    date_trunc('month', NOW()) + INTERVAL '3 months',
+    INTERVAL '1 month') AS mes;
+
+INSERT INTO notas_fiscais SELECT * FROM notas_fiscais_legado;
+INSERT INTO itens_nota SELECT * FROM itens_nota_legado;
+
+-- leva junto as FKs de itens e solicitacoes e os triggers da busca
+DROP TABLE itens_nota_legado;
+DROP TABLE notas_fiscais_legado CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_notas_numero ON notas_fiscais(numero);
+CREATE INDEX IF NOT EXISTS idx_notas_status ON notas_fiscais(status);
+CREATE INDEX IF NOT EXISTS idx_notas_data_criacao ON notas_fiscais(data_criacao DESC);
+CREATE INDEX IF NOT EXISTS idx_notas_empresa_status ON notas_fiscais(empresa_id, status);
+CREATE INDEX IF NOT EXISTS idx_notas_busca ON notas_fiscais USING GIN (busca);
+CREATE INDEX IF NOT EXISTS idx_itens_nota_id ON itens_nota(nota_id);
+CREATE INDEX IF NOT EXISTS idx_itens_produto_id ON itens_nota(produto_id);
+
+-- Numero unico por empresa. O arquivamento move a nota de particao (DELETE +
+-- INSERT), e o INSERT refaz a reserva: o numero ja reservado pela propria
+-- nota nao e conflito.
+CREATE TABLE IF NOT EXISTS numeros_notas_fiscais (
+    empresa_id VARCHAR(50) NOT NULL,
+    numero VARCHAR(20) NOT NULL,
+    nota_id UUID NOT NULL,
+    PRIMARY KEY (empresa_id, numero)
+);
+
+INSERT INTO numeros_notas_fiscais (empresa_id, numero, nota_id)
+SELECT empresa_id, numero, id FROM notas_fiscais;
+
+CREATE OR REPLACE FUNCTION notas_fiscais_reservar_numero() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO numeros_notas_fiscais (empresa_id, numero, nota_id)
+    VALUES (NEW.empresa_id, NEW.numero, NEW.id)
+    ON CONFLICT (empresa_id, numero) DO NOTHING;
+    IF NOT FOUND AND NOT EXISTS (
+        SELECT 1 FROM numeros_notas_fiscais
+        WHERE empresa_id = NEW.empresa_id AND numero = NEW.numero AND nota_id = NEW.id
+    ) THEN
+        RAISE EXCEPTION 'numero % ja existe na empresa %', NEW.numero, NEW.empresa_id
+            USING ERRCODE = 'unique_violation', CONSTRAINT = 'idx_notas_empresa_numero';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notas_fiscais_reservar_numero
+    BEFORE INSERT ON notas_fiscais
+    FOR EACH ROW EXECUTE FUNCTION notas_fiscais_reservar_numero();
+
+CREATE TRIGGER notas_fiscais_atualizar_busca
+    BEFORE INSERT OR UPDATE OF numero, destinatario ON notas_fiscais
+    FOR EACH ROW EXECUTE FUNCTION notas_fiscais_atualizar_busca();
+
+CREATE TRIGGER itens_nota_atualizar_busca
+    AFTER INSERT OR UPDATE OF sku, nome_produto, nota_id OR DELETE ON itens_nota
+    FOR EACH ROW EXECUTE FUNCTION itens_nota_atualizar_busca();
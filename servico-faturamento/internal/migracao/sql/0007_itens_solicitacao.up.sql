@@ -0,0 +1,22 @@
+-- Itens congelados no pedido de impressao: o Estoque reserva esta lista e a
+-- nota fecha com ela, mesmo que alguem tente mexer na nota no meio da saga.
+CREATE TABLE IF NOT EXISTS itens_solicitacao_impressao (
+    id UUID PRIMARY KEY,
+    empresa_id VARCHAR(50) NOT NULL,
+    solicitacao_id UUID NOT NULL REFERENCES solicitacoes_impressao(id) ON DELETE CASCADE,
+    item_id UUID NOT NULL,
+    produto_id UUID NOT NULL,
+    quantidade INTEGER NOT NULL CHECK (quantidade > 0),
+    preco_unitario DECIMAL(10,2) NOT NULL CHECK (preco_unitario >= 0),
+    sku VARCHAR(50),
+    nome_produto VARCHAR(200)
+);
+
+CREATE INDEX IF NOT EXISTS idx_itens_solicitacao_impressao_solicitacao_id ON itens_solicitacao_impressao(solicitacao_id);
+
+-- solicitacoes ainda pendentes congelam os itens que a nota tem agora
+INSERT INTO itens_solicitacao_impressao (id, empresa_id, solicitacao_id, item_id, produto_id, quantidade, preco_unitario, sku, nome_produto)
+SELECT gen_random_uuid(), s.empresa_id, s.id, i.id, i.produto_id, i.quantidade, i.preco_unitario, i.sku, i.nome_produto
+FROM solicitacoes_impressao s
+JOIN itens_nota i ON i.nota_id = s.nota_id
+WHERE s.status = 'PENDENTE';
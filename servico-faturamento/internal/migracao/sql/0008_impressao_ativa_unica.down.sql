@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_solicitacoes_nota_pendente;
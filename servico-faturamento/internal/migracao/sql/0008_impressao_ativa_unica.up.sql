@@ -0,0 +1,23 @@
+-- No maximo uma solicitacao de impressao PENDENTE por nota: uma segunda
+-- reservaria o estoque de novo. Pendentes duplicadas que ja existam ficam so
+-- com a mais recente; as outras falham, com a saga, antes de o indice ser
+-- criado.
+WITH substituidas AS (
+    UPDATE solicitacoes_impressao s
+    SET status = 'FALHOU',
+        mensagem_erro = 'Substituida por outra solicitacao pendente da mesma nota'
+    WHERE s.status = 'PENDENTE'
+      AND EXISTS (
+          SELECT 1 FROM solicitacoes_impressao o
+          WHERE o.nota_id = s.nota_id
+            AND o.status = 'PENDENTE'
+            AND (o.data_criacao, o.id) > (s.data_criacao, s.id)
+      )
+    RETURNING s.id
+)
+UPDATE sagas_faturamento
+SET estado = 'FALHOU', data_atualizacao = NOW(), data_fim = NOW()
+WHERE solicitacao_id IN (SELECT id FROM substituidas);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_solicitacoes_nota_pendente
+    ON solicitacoes_impressao(nota_id) WHERE status = 'PENDENTE';
@@ -0,0 +1,3 @@
+-- falha se uma chave expirada ja foi reusada em outra solicitacao
+CREATE UNIQUE INDEX IF NOT EXISTS idx_solicitacoes_empresa_chave ON solicitacoes_impressao(empresa_id, chave_idempotencia);
+DROP TABLE IF EXISTS chaves_idempotencia;
@@ -0,0 +1,25 @@
+-- Idempotency-Key passa a ter tabela propria: cada chave fica ligada ao alvo
+-- da primeira requisicao (nota e operacao), e a retencao remove as antigas
+-- para o indice nao crescer para sempre. Em solicitacoes_impressao a chave
+-- vira so informativa e deixa de ser unica, ja que uma chave expirada pode
+-- voltar a ser usada.
+CREATE TABLE IF NOT EXISTS chaves_idempotencia (
+    empresa_id VARCHAR(50) NOT NULL,
+    chave VARCHAR(100) NOT NULL,
+    nota_id UUID NOT NULL,
+    operacao VARCHAR(20) NOT NULL CHECK (operacao IN ('IMPRIMIR', 'REPROCESSAR')),
+    solicitacao_id UUID NOT NULL REFERENCES solicitacoes_impressao(id) ON DELETE CASCADE,
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (empresa_id, chave)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chaves_idempotencia_data ON chaves_idempotencia(data_criacao);
+
+INSERT INTO chaves_idempotencia (empresa_id, chave, nota_id, operacao, solicitacao_id, data_criacao)
+SELECT empresa_id, chave_idempotencia, nota_id,
+       CASE WHEN solicitacao_origem_id IS NULL THEN 'IMPRIMIR' ELSE 'REPROCESSAR' END,
+       id, data_criacao
+FROM solicitacoes_impressao
+ON CONFLICT DO NOTHING;
+
+DROP INDEX IF EXISTS idx_solicitacoes_empresa_chave;
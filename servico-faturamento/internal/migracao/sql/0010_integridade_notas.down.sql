@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS notas_fiscais_apagar_dependentes ON notas_fiscais;
+DROP TRIGGER IF EXISTS solicitacoes_impressao_exigir_nota ON solicitacoes_impressao;
+DROP TRIGGER IF EXISTS itens_nota_exigir_nota ON itens_nota;
+DROP FUNCTION IF EXISTS notas_fiscais_apagar_dependentes();
+DROP FUNCTION IF EXISTS faturamento_exigir_nota();
@@ -0,0 +1,42 @@
+-- Integridade entre notas_fiscais e seus dependentes. A 0006 tirou as FKs de
+-- itens_nota e solicitacoes_impressao (nao ha FK para tabela particionada
+-- sem a chave inteira); os triggers abaixo fazem o papel delas:
+-- - item ou solicitacao so entra com a nota existente, travada como a FK
+--   trava (FOR KEY SHARE) para um DELETE concorrente esperar;
+-- - apagar a nota apaga itens e solicitacoes, como o ON DELETE CASCADE.
+-- O arquivamento muda a nota de particao com DELETE + INSERT; o AFTER DELETE
+-- so roda no fim do comando, quando a nota ja esta na outra particao, e por
+-- isso so cascateia se ela nao existir mais.
+
+CREATE OR REPLACE FUNCTION faturamento_exigir_nota() RETURNS trigger AS $$
+BEGIN
+    PERFORM 1 FROM notas_fiscais WHERE id = NEW.nota_id FOR KEY SHARE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'nota % nao existe', NEW.nota_id
+            USING ERRCODE = 'foreign_key_violation', CONSTRAINT = TG_TABLE_NAME || '_nota_id_fkey';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER itens_nota_exigir_nota
+    BEFORE INSERT OR UPDATE OF nota_id ON itens_nota
+    FOR EACH ROW EXECUTE FUNCTION faturamento_exigir_nota();
+
+CREATE TRIGGER solicitacoes_impressao_exigir_nota
+    BEFORE INSERT OR UPDATE OF nota_id ON solicitacoes_impressao
+    FOR EACH ROW EXECUTE FUNCTION faturamento_exigir_nota();
+
+CREATE OR REPLACE FUNCTION notas_fiscais_apagar_dependentes() RETURNS trigger AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM notas_fiscais WHERE id = OLD.id) THEN
+        DELETE FROM itens_nota WHERE nota_id = OLD.id;
+        DELETE FROM solicitacoes_impressao WHERE nota_id = OLD.id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notas_fiscais_apagar_dependentes
+    AFTER DELETE ON notas_fiscais
+    FOR EACH ROW EXECUTE FUNCTION notas_fiscais_apagar_dependentes();
@@ -0,0 +1,4 @@
+-- hash_requisicao distingue requisicoes no mesmo alvo da chave (o reprocessar
+-- de tentativas diferentes da mesma nota); as chaves ja gravadas ficam sem
+-- hash e aceitam qualquer requisicao do alvo.
+ALTER TABLE chaves_idempotencia ADD COLUMN IF NOT EXISTS hash_requisicao VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,76 @@
+package publicador_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/publicador"
+	"servico-faturamento/internal/repositorio"
+)
+
+// brokerTravado segura a primeira publicacao ate o teste liberar
+type brokerTravado struct {
+	*mensageria.BrokerMemoria
+	publicando chan struct{}
+	liberar    chan struct{}
+}
+
+func (b *brokerTravado) Publicar(ctx context.Context, exchange string, msg mensageria.Mensagem) error {
+	select {
+	case b.publicando <- struct{}{}:
+		<-b.liberar
+	default:
+	}
+	return b.BrokerMemoria.Publicar(ctx, exchange, msg)
+}
+
+// Dois publicadores no PostgreSQL de TESTE_POSTGRES_DSN: o lote travado por
+// um e pulado pelo outro
+func TestPublicarPendentes_PostgresInstanciasConcorrentes(t *testing.T) {
+	banco := repositorio.NovoBancoPostgres(bdteste.AbrirPostgres(t))
+	travado := &brokerTravado{BrokerMemoria: mensageria.NovoBrokerMemoria(), publicando: make(chan struct{}), liberar: make(chan struct{})}
+	primeiro := &publicador.PublicadorOutbox{Banco: banco, Broker: travado}
+	outroBroker := mensageria.NovoBrokerMemoria()
+	segundo := &publicador.PublicadorOutbox{Banco: banco, Broker: outroBroker}
+	evt := novoEvento(t, primeiro)
+
+	resultado := make(chan int, 1)
+	go func() {
+		n, err := primeiro.PublicarPendentes(context.Background())
+		if err != nil {
+			t.Errorf("erro no primeiro publicador: %v", err)
+		}
+		resultado <- n
+	}()
+
+	select {
+	case <-travado.publicando:
+	case <-time.After(5 * time.Second):
+		t.Fatal("primeiro publicador nao chegou ao broker")
+	}
+
+	if n, err := segundo.PublicarPendentes(context.Background()); err != nil || n != 0 {
+		t.Errorf("segundo publicador deveria pular o lote travado, publicou %d (%v)", n, err)
+	}
+	close(travado.liberar)
+
+	if n := <-resultado; n != 1 {
+		t.Errorf("esperava 1 evento do primeiro publicador, obteve %d", n)
+	}
+	if len(outroBroker.Publicadas()) != 0 || len(travado.Publicadas()) != 1 {
+		t.Errorf("evento publicado mais de uma vez: %d e %d", len(travado.Publicadas()), len(outroBroker.Publicadas()))
+	}
+
+	evt, _ = banco.Outbox().Buscar(context.Background(), evt.ID)
+	if evt.DataPublicacao == nil || evt.Tentativas != 1 {
+		t.Errorf("esperava uma publicacao registrada, obteve %+v", evt)
+	}
+
+	// depois de publicado nenhum dos dois o envia de novo
+	if n, _ := segundo.PublicarPendentes(context.Background()); n != 0 {
+		t.Errorf("evento ja publicado foi reenviado")
+	}
+}
@@ -0,0 +1,165 @@
+package publicador_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/publicador"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+)
+
+// brokerInstavel repassa para o broker em memoria ou falha, conforme o teste
+type brokerInstavel struct {
+	*mensageria.BrokerMemoria
+	falhar     bool
+	tentativas int
+}
+
+func (b *brokerInstavel) Publicar(ctx context.Context, exchange string, msg mensageria.Mensagem) error {
+	b.tentativas++
+	if b.falhar {
+		return errors.New("canal fechado")
+	}
+	return b.BrokerMemoria.Publicar(ctx, exchange, msg)
+}
+
+func novoEvento(t *testing.T, p *publicador.PublicadorOutbox) dominio.EventoOutbox {
+	t.Helper()
+	evt, err := dominio.NovoEventoOutbox(dominio.EventoNotaFechada, uuid.New(), map[string]string{"ok": "sim"}, dominio.NovoContextoEvento(""))
+	if err != nil {
+		t.Fatalf("falha ao montar evento: %v", err)
+	}
+	if err := p.Banco.Outbox().Adicionar(context.Background(), &evt); err != nil {
+		t.Fatalf("falha ao gravar evento: %v", err)
+	}
+	return evt
+}
+
+func TestPublicarPendentes_FalhaRegistraTentativaEBackoff(t *testing.T) {
+	broker := &brokerInstavel{BrokerMemoria: mensageria.NovoBrokerMemoria(), falhar: true}
+	p := &publicador.PublicadorOutbox{Banco: repositorio.NovoBancoPostgres(bdteste.Abrir(t)), Broker: broker, BackoffBase: time.Minute}
+	evt := novoEvento(t, p)
+
+	antes := time.Now()
+	if n, err := p.PublicarPendentes(context.Background()); err != nil || n != 0 {
+		t.Fatalf("esperava nenhuma publicacao, obteve %d (%v)", n, err)
+	}
+
+	evt, _ = p.Banco.Outbox().Buscar(context.Background(), evt.ID)
+	if evt.Tentativas != 1 || evt.UltimoErro == nil || *evt.UltimoErro != "canal fechado" {
+		t.Errorf("esperava falha registrada, obteve %+v", evt)
+	}
+	if evt.ProximaTentativa == nil || evt.ProximaTentativa.Before(antes.Add(time.Minute)) {
+		t.Errorf("esperava proxima tentativa adiada pelo backoff, obteve %v", evt.ProximaTentativa)
+	}
+	if evt.Situacao() != dominio.SituacaoOutboxFalhou {
+		t.Errorf("esperava situacao FALHOU, obteve %s", evt.Situacao())
+	}
+
+	// dentro do backoff o evento nao e tentado de novo
+	broker.falhar = false
+	if n, _ := p.PublicarPendentes(context.Background()); n != 0 {
+		t.Errorf("evento em backoff nao deveria ser publicado, publicou %d", n)
+	}
+
+	// a cada falha a espera dobra
+	broker.falhar = true
+	antes = time.Now()
+	if _, err := p.Republicar(context.Background(), evt.ID); err == nil {
+		t.Fatal("esperava erro ao republicar com o broker fora")
+	}
+	evt, _ = p.Banco.Outbox().Buscar(context.Background(), evt.ID)
+	if evt.Tentativas != 2 || evt.ProximaTentativa == nil || evt.ProximaTentativa.Before(antes.Add(2*time.Minute)) {
+		t.Errorf("esperava segunda falha com backoff de 2m, obteve %+v", evt)
+	}
+
+	broker.falhar = false
+	evt, err := p.Republicar(context.Background(), evt.ID)
+	if err != nil {
+		t.Fatalf("erro ao republicar: %v", err)
+	}
+	if evt.DataPublicacao == nil || evt.ProximaTentativa != nil || evt.Tentativas != 3 {
+		t.Errorf("esperava evento publicado na terceira tentativa, obteve %+v", evt)
+	}
+	if len(broker.Publicadas()) != 1 {
+		t.Errorf("esperava 1 mensagem no broker, obteve %d", len(broker.Publicadas()))
+	}
+}
+
+func TestPublicarPendentes_IgnoraEstacionado(t *testing.T) {
+	broker := &brokerInstavel{BrokerMemoria: mensageria.NovoBrokerMemoria()}
+	p := &publicador.PublicadorOutbox{Banco: repositorio.NovoBancoPostgres(bdteste.Abrir(t)), Broker: broker}
+	estacionado := novoEvento(t, p)
+	normal := novoEvento(t, p)
+
+	p.Banco.Outbox().Estacionar(context.Background(), estacionado.ID, "payload invalido para o consumidor", time.Now())
+
+	if n, err := p.PublicarPendentes(context.Background()); err != nil || n != 1 {
+		t.Fatalf("esperava so o evento normal publicado, obteve %d (%v)", n, err)
+	}
+	if msgs := broker.Publicadas(); len(msgs) != 1 || msgs[0].ID != normal.IDEvento.String() {
+		t.Errorf("esperava apenas o evento %s no broker, obteve %+v", normal.IDEvento, msgs)
+	}
+
+	// republicar tira do estacionamento
+	evt, err := p.Republicar(context.Background(), estacionado.ID)
+	if err != nil {
+		t.Fatalf("erro ao republicar: %v", err)
+	}
+	evt, _ = p.Banco.Outbox().Buscar(context.Background(), estacionado.ID)
+	if evt.DataEstacionamento != nil || evt.Situacao() != dominio.SituacaoOutboxPublicado {
+		t.Errorf("esperava evento publicado e fora do estacionamento, obteve %+v", evt)
+	}
+}
+
+// bancoSemRegistro falha ao gravar o resultado das publicacoes
+type bancoSemRegistro struct{ repositorio.Banco }
+
+func (b bancoSemRegistro) Transacao(ctx context.Context, fn func(r repositorio.Repositorios) error) error {
+	return b.Banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+		return fn(repositoriosSemRegistro{r})
+	})
+}
+
+type repositoriosSemRegistro struct{ repositorio.Repositorios }
+
+func (r repositoriosSemRegistro) Outbox() repositorio.Outbox {
+	return outboxSemRegistro{r.Repositorios.Outbox()}
+}
+
+type outboxSemRegistro struct{ repositorio.Outbox }
+
+func (outboxSemRegistro) RegistrarFalha(context.Context, *dominio.EventoOutbox) error {
+	return errors.New("conexao perdida")
+}
+
+func (outboxSemRegistro) RegistrarPublicacao(context.Context, *dominio.EventoOutbox) error {
+	return errors.New("conexao perdida")
+}
+
+func TestPublicarPendentes_ErroDoBancoEncerraLote(t *testing.T) {
+	for _, falhar := range []bool{true, false} {
+		broker := &brokerInstavel{BrokerMemoria: mensageria.NovoBrokerMemoria(), falhar: falhar}
+		banco := repositorio.NovoBancoPostgres(bdteste.Abrir(t))
+		p := &publicador.PublicadorOutbox{Banco: bancoSemRegistro{banco}, Broker: broker}
+		primeiro := novoEvento(t, p)
+		novoEvento(t, p)
+
+		if n, err := p.PublicarPendentes(context.Background()); err == nil || n != 0 {
+			t.Errorf("esperava erro do banco sem publicacoes contadas, obteve %d (%v)", n, err)
+		}
+		if broker.tentativas != 1 {
+			t.Errorf("lote deveria parar no primeiro erro do banco, tentou enviar %d eventos", broker.tentativas)
+		}
+		if evt, _ := banco.Outbox().Buscar(context.Background(), primeiro.ID); evt.Tentativas != 0 || evt.DataPublicacao != nil {
+			t.Errorf("resultado nao gravado deveria deixar o evento pendente, obteve %+v", evt)
+		}
+	}
+}
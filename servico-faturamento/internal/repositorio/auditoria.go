@@ -0,0 +1,25 @@
+package repositorio
+
+import (
+	"context"
+
+	"servico-faturamento/internal/dominio"
+)
+
+type chaveAutoria struct{}
+
+// ComAutoria anota no contexto quem esta escrevendo; os repositorios gravam
+// essa autoria na trilha de auditoria
+func ComAutoria(ctx context.Context, autoria dominio.Autoria) context.Context {
+	return context.WithValue(ctx, chaveAutoria{}, autoria)
+}
+
+// AutoriaDe devolve a autoria anotada no contexto; sem anotacao a escrita e
+// atribuida a dominio.AtorSistema
+func AutoriaDe(ctx context.Context) dominio.Autoria {
+	autoria, _ := ctx.Value(chaveAutoria{}).(dominio.Autoria)
+	if autoria.Ator == "" {
+		autoria.Ator = dominio.AtorSistema
+	}
+	return autoria
+}
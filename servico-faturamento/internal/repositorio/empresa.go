@@ -0,0 +1,26 @@
+package repositorio
+
+import "context"
+
+type chaveEmpresa struct{}
+
+// ComEmpresa restringe os repositorios a empresa (tenant): toda consulta
+// filtra por ela e todo registro criado sem empresa recebe esta. Sem
+// ComEmpresa o escopo e o banco inteiro, como nos jobs internos (publicador,
+// varredor de timeout) e na administracao.
+func ComEmpresa(ctx context.Context, empresa string) context.Context {
+	return context.WithValue(ctx, chaveEmpresa{}, empresa)
+}
+
+// EmpresaDe devolve a empresa anotada no contexto, ou "" se nao ha
+func EmpresaDe(ctx context.Context) string {
+	empresa, _ := ctx.Value(chaveEmpresa{}).(string)
+	return empresa
+}
+
+// carimbar preenche a empresa de um registro novo com a do contexto
+func carimbar(ctx context.Context, empresa *string) {
+	if *empresa == "" {
+		*empresa = EmpresaDe(ctx)
+	}
+}
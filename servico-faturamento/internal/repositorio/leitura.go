@@ -0,0 +1,17 @@
+package repositorio
+
+import "context"
+
+type chavePrimario struct{}
+
+// FixarPrimario marca o contexto para que Banco.Leitura use o primario. Serve
+// para quem acabou de escrever ler o proprio dado antes de a replica alcanca-lo.
+func FixarPrimario(ctx context.Context) context.Context {
+	return context.WithValue(ctx, chavePrimario{}, true)
+}
+
+// PrimarioFixado indica se o contexto passou por FixarPrimario
+func PrimarioFixado(ctx context.Context) bool {
+	fixado, _ := ctx.Value(chavePrimario{}).(bool)
+	return fixado
+}
@@ -0,0 +1,1154 @@
+package repositorio
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+
+	"github.com/google/uuid"
+)
+
+// BancoMemoria implementa Banco guardando tudo em slices, para testar
+// handlers e consumidor sem banco. Transacoes sao serializadas e trabalham
+// sobre uma copia dos dados, que so substitui o original se fn nao falhar;
+// por isso os metodos ...ParaAtualizar nao precisam travar nada.
+type BancoMemoria struct {
+	mu    sync.Mutex
+	dados *dadosMemoria
+}
+
+type dadosMemoria struct {
+	notas        []dominio.NotaFiscal // sem Itens; montados na leitura
+	itens        []dominio.ItemNota
+	solicitacoes []dominio.SolicitacaoImpressao
+	chaves       []dominio.ChaveIdempotencia
+	outbox       []dominio.EventoOutbox
+	mensagens    []dominio.MensagemProcessada
+	sagas        []dominio.SagaFaturamento
+	passos       []dominio.PassoSaga
+	produtos     []dominio.ProdutoCatalogo
+	quarentena   []dominio.MensagemQuarentena
+	auditoria    []dominio.RegistroAuditoria
+	arquivo      []dominio.EventoOutboxArquivado
+
+	seqOutbox    int64
+	seqPassos    int64
+	seqAuditoria int64
+}
+
+func NovoBancoMemoria() *BancoMemoria {
+	return &BancoMemoria{dados: &dadosMemoria{}}
+}
+
+func (d *dadosMemoria) clonar() *dadosMemoria {
+	c := *d
+	c.notas = append([]dominio.NotaFiscal(nil), d.notas...)
+	c.itens = append([]dominio.ItemNota(nil), d.itens...)
+	c.solicitacoes = append([]dominio.SolicitacaoImpressao(nil), d.solicitacoes...)
+	c.chaves = append([]dominio.ChaveIdempotencia(nil), d.chaves...)
+	c.outbox = append([]dominio.EventoOutbox(nil), d.outbox...)
+	c.mensagens = append([]dominio.MensagemProcessada(nil), d.mensagens...)
+	c.sagas = append([]dominio.SagaFaturamento(nil), d.sagas...)
+	c.passos = append([]dominio.PassoSaga(nil), d.passos...)
+	c.produtos = append([]dominio.ProdutoCatalogo(nil), d.produtos...)
+	c.quarentena = append([]dominio.MensagemQuarentena(nil), d.quarentena...)
+	c.auditoria = append([]dominio.RegistroAuditoria(nil), d.auditoria...)
+	c.arquivo = append([]dominio.EventoOutboxArquivado(nil), d.arquivo...)
+	return &c
+}
+
+// escopoMemoria e o banco inteiro (tx nil, trava a cada operacao) ou a copia
+// de uma transacao em andamento (ja protegida pela trava da transacao)
+type escopoMemoria struct {
+	banco       *BancoMemoria
+	tx          *dadosMemoria
+	aoConfirmar *[]func()
+}
+
+func (e escopoMemoria) abrir() (*dadosMemoria, func()) {
+	if e.tx != nil {
+		return e.tx, func() {}
+	}
+	e.banco.mu.Lock()
+	return e.banco.dados, e.banco.mu.Unlock
+}
+
+func (b *BancoMemoria) Transacao(ctx context.Context, fn func(r Repositorios) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var confirmadas []func()
+	if err := b.aplicar(fn, &confirmadas); err != nil {
+		return err
+	}
+	// fora da trava: fn pode voltar a usar o banco
+	for _, f := range confirmadas {
+		f()
+	}
+	return nil
+}
+
+func (b *BancoMemoria) aplicar(fn func(r Repositorios) error, confirmadas *[]func()) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	copia := b.dados.clonar()
+	if err := fn(escopoMemoria{banco: b, tx: copia, aoConfirmar: confirmadas}); err != nil {
+		return err
+	}
+	b.dados = copia
+	return nil
+}
+
+// Leitura devolve o proprio banco: em memoria nao ha replicas
+func (b *BancoMemoria) Leitura(ctx context.Context) Repositorios { return b }
+
+func (b *BancoMemoria) escopo() escopoMemoria { return escopoMemoria{banco: b} }
+
+func (b *BancoMemoria) Notas() Notas                    { return b.escopo().Notas() }
+func (b *BancoMemoria) Solicitacoes() Solicitacoes      { return b.escopo().Solicitacoes() }
+func (b *BancoMemoria) Idempotencia() Idempotencia      { return b.escopo().Idempotencia() }
+func (b *BancoMemoria) Outbox() Outbox                  { return b.escopo().Outbox() }
+func (b *BancoMemoria) Mensagens() MensagensProcessadas { return b.escopo().Mensagens() }
+func (b *BancoMemoria) Sagas() Sagas                    { return b.escopo().Sagas() }
+func (b *BancoMemoria) Produtos() Produtos              { return b.escopo().Produtos() }
+func (b *BancoMemoria) Quarentena() Quarentena          { return b.escopo().Quarentena() }
+func (b *BancoMemoria) Auditoria() Auditoria            { return b.escopo().Auditoria() }
+func (b *BancoMemoria) AoConfirmar(fn func())           { fn() }
+
+func (e escopoMemoria) Notas() Notas                    { return notasMemoria{e} }
+func (e escopoMemoria) Solicitacoes() Solicitacoes      { return solicitacoesMemoria{e} }
+func (e escopoMemoria) Idempotencia() Idempotencia      { return idempotenciaMemoria{e} }
+func (e escopoMemoria) Outbox() Outbox                  { return outboxMemoria{e} }
+func (e escopoMemoria) Mensagens() MensagensProcessadas { return mensagensMemoria{e} }
+func (e escopoMemoria) Sagas() Sagas                    { return sagasMemoria{e} }
+func (e escopoMemoria) Produtos() Produtos              { return produtosMemoria{e} }
+func (e escopoMemoria) Quarentena() Quarentena          { return quarentenaMemoria{e} }
+func (e escopoMemoria) Auditoria() Auditoria            { return auditoriaMemoria{e} }
+
+func (e escopoMemoria) AoConfirmar(fn func()) {
+	if e.aoConfirmar == nil {
+		fn()
+		return
+	}
+	*e.aoConfirmar = append(*e.aoConfirmar, fn)
+}
+
+func paginarMemoria[T any](itens []T, limite, offset int) []T {
+	if offset >= len(itens) {
+		return nil
+	}
+	itens = itens[offset:]
+	if limite > 0 && limite < len(itens) {
+		itens = itens[:limite]
+	}
+	return itens
+}
+
+// removerMemoria tira de itens ate limite registros que casam com remover,
+// na ordem em que estao, e devolve o que sobrou e quantos sairam
+func removerMemoria[T any](itens []T, limite int, remover func(T) bool) ([]T, int64) {
+	var removidos int64
+	restantes := itens[:0:0]
+	for _, item := range itens {
+		if removidos < int64(limite) && remover(item) {
+			removidos++
+			continue
+		}
+		restantes = append(restantes, item)
+	}
+	return restantes, removidos
+}
+
+// daEmpresa diz se o registro e visivel no escopo do contexto, como o filtro
+// de sessao no Postgres
+func daEmpresa(ctx context.Context, empresa string) bool {
+	atual := EmpresaDe(ctx)
+	return atual == "" || atual == empresa
+}
+
+// auditar guarda na trilha a diferenca entre antes e depois, como o
+// auditar do Postgres
+func (d *dadosMemoria) auditar(ctx context.Context, antes, depois dominio.Auditavel) error {
+	registro, mudou, err := dominio.NovoRegistroAuditoria(antes, depois, AutoriaDe(ctx), time.Now())
+	if err != nil || !mudou {
+		return err
+	}
+	carimbar(ctx, &registro.EmpresaID)
+	d.seqAuditoria++
+	registro.ID = d.seqAuditoria
+	d.auditoria = append(d.auditoria, registro)
+	return nil
+}
+
+// --- notas ---
+
+type notasMemoria struct{ escopoMemoria }
+
+func (r notasMemoria) Criar(ctx context.Context, nota *dominio.NotaFiscal) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &nota.EmpresaID)
+	nota.BeforeCreate(nil)
+	for _, n := range d.notas {
+		if n.ID == nota.ID || (n.EmpresaID == nota.EmpresaID && n.Numero == nota.Numero) {
+			return ErrDuplicado
+		}
+	}
+
+	gravada := *nota
+	gravada.Itens = nil
+	d.notas = append(d.notas, gravada)
+	if err := d.auditar(ctx, nil, &gravada); err != nil {
+		return err
+	}
+	for i := range nota.Itens {
+		nota.Itens[i].NotaID = nota.ID
+		nota.Itens[i].EmpresaID = nota.EmpresaID
+		nota.Itens[i].DataNota = nota.DataCriacao
+		nota.Itens[i].BeforeCreate(nil)
+		d.itens = append(d.itens, nota.Itens[i])
+		if err := d.auditar(ctx, nil, &nota.Itens[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dadosMemoria) nota(id uuid.UUID) (dominio.NotaFiscal, bool) {
+	for _, n := range d.notas {
+		if n.ID == id {
+			n.Itens = d.itensDa(id)
+			return n, true
+		}
+	}
+	return dominio.NotaFiscal{}, false
+}
+
+func (d *dadosMemoria) itensDa(notaID uuid.UUID) []dominio.ItemNota {
+	var itens []dominio.ItemNota
+	for _, item := range d.itens {
+		if item.NotaID == notaID {
+			itens = append(itens, item)
+		}
+	}
+	return itens
+}
+
+func (r notasMemoria) Buscar(ctx context.Context, id uuid.UUID) (dominio.NotaFiscal, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	nota, ok := d.nota(id)
+	if !ok || !daEmpresa(ctx, nota.EmpresaID) {
+		return nota, ErrNaoEncontrado
+	}
+	return nota, nil
+}
+
+func (r notasMemoria) BuscarParaAtualizar(ctx context.Context, id uuid.UUID) (dominio.NotaFiscal, error) {
+	return r.Buscar(ctx, id)
+}
+
+func (r notasMemoria) Listar(ctx context.Context, filtro FiltroNotas) ([]dominio.NotaFiscal, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var notas []dominio.NotaFiscal
+	for _, n := range d.notas {
+		if !daEmpresa(ctx, n.EmpresaID) || (filtro.Status != "" && n.Status != filtro.Status) || (n.Arquivada && !filtro.Arquivadas) {
+			continue
+		}
+		n.Itens = d.itensDa(n.ID)
+		notas = append(notas, n)
+	}
+	return notas, nil
+}
+
+func (r notasMemoria) Percorrer(ctx context.Context, filtro FiltroNotas, fn func(dominio.NotaFiscal) error) error {
+	notas, err := r.Listar(ctx, filtro)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(notas, func(i, j int) bool {
+		if !notas[i].DataCriacao.Equal(notas[j].DataCriacao) {
+			return notas[i].DataCriacao.Before(notas[j].DataCriacao)
+		}
+		return notas[i].ID.String() < notas[j].ID.String()
+	})
+	// fn roda fora do lock: pode usar o proprio banco
+	for _, nota := range notas {
+		if err := fn(nota); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pesosPesquisa imitam os de ts_rank para numero (A), destinatario (B) e
+// itens (C)
+var pesosPesquisa = [3]float64{1.0, 0.4, 0.2}
+
+func (r notasMemoria) Pesquisar(ctx context.Context, filtro FiltroPesquisa) ([]ResultadoPesquisa, error) {
+	termos := TermosPesquisa(filtro.Texto)
+	if len(termos) == 0 {
+		return nil, nil
+	}
+
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var resultados []ResultadoPesquisa
+	for _, n := range d.notas {
+		if !daEmpresa(ctx, n.EmpresaID) {
+			continue
+		}
+		n.Itens = d.itensDa(n.ID)
+		campos := camposPesquisaveis(n)
+
+		relevancia := 0.0
+		for _, termo := range termos {
+			melhor := 0.0
+			for i, campo := range campos {
+				if casaPrefixo(campo, termo) && pesosPesquisa[i] > melhor {
+					melhor = pesosPesquisa[i]
+				}
+			}
+			if melhor == 0 {
+				relevancia = 0
+				break
+			}
+			relevancia += melhor
+		}
+		if relevancia == 0 {
+			continue
+		}
+
+		// diferente do ts_headline, destaca o texto inteiro sem recortar trechos
+		destaque := make([]string, 0, len(campos))
+		for _, campo := range campos {
+			if campo != "" {
+				destaque = append(destaque, destacar(campo, termos))
+			}
+		}
+		resultados = append(resultados, ResultadoPesquisa{Nota: n, Relevancia: relevancia, Destaque: strings.Join(destaque, " ")})
+	}
+
+	sort.SliceStable(resultados, func(i, j int) bool {
+		if resultados[i].Relevancia != resultados[j].Relevancia {
+			return resultados[i].Relevancia > resultados[j].Relevancia
+		}
+		return resultados[i].Nota.DataCriacao.After(resultados[j].Nota.DataCriacao)
+	})
+	return paginarMemoria(resultados, filtro.Limite, filtro.Offset), nil
+}
+
+// camposPesquisaveis segue notas_fiscais_documento_busca: numero,
+// destinatario e SKU/nome dos itens
+func camposPesquisaveis(nota dominio.NotaFiscal) [3]string {
+	itens := make([]string, 0, len(nota.Itens)*2)
+	for _, item := range nota.Itens {
+		for _, parte := range []string{item.Sku, item.NomeProduto} {
+			if parte != "" {
+				itens = append(itens, parte)
+			}
+		}
+	}
+	return [3]string{nota.Numero, nota.Destinatario, strings.Join(itens, " ")}
+}
+
+func casaPrefixo(campo, termo string) bool {
+	for _, palavra := range TermosPesquisa(campo) {
+		if strings.HasPrefix(palavra, termo) {
+			return true
+		}
+	}
+	return false
+}
+
+// destacar envolve as palavras que comecam por algum termo
+func destacar(texto string, termos []string) string {
+	var b strings.Builder
+	palavra := []rune{}
+	escrever := func() {
+		if len(palavra) == 0 {
+			return
+		}
+		casou := false
+		for _, termo := range termos {
+			if strings.HasPrefix(strings.ToLower(string(palavra)), termo) {
+				casou = true
+				break
+			}
+		}
+		if casou {
+			b.WriteString(InicioDestaque + string(palavra) + FimDestaque)
+		} else {
+			b.WriteString(string(palavra))
+		}
+		palavra = palavra[:0]
+	}
+	for _, r := range texto {
+		if separaTermos(r) {
+			escrever()
+			b.WriteRune(r)
+			continue
+		}
+		palavra = append(palavra, r)
+	}
+	escrever()
+	return b.String()
+}
+
+func (r notasMemoria) Atualizar(ctx context.Context, nota *dominio.NotaFiscal) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for i := range d.notas {
+		if d.notas[i].ID == nota.ID && daEmpresa(ctx, d.notas[i].EmpresaID) {
+			antes := d.notas[i]
+			d.notas[i].Status = nota.Status
+			d.notas[i].DataFechada = nota.DataFechada
+			return d.auditar(ctx, &antes, &d.notas[i])
+		}
+	}
+	return nil
+}
+
+func (r notasMemoria) AdicionarItem(ctx context.Context, item *dominio.ItemNota) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	nota, ok := d.nota(item.NotaID)
+	if !ok || !daEmpresa(ctx, nota.EmpresaID) {
+		return ErrNaoEncontrado
+	}
+	carimbar(ctx, &item.EmpresaID)
+	item.DataNota, item.Arquivada = nota.DataCriacao, nota.Arquivada
+	item.BeforeCreate(nil)
+	d.itens = append(d.itens, *item)
+	return d.auditar(ctx, nil, item)
+}
+
+func (r notasMemoria) Itens(ctx context.Context, notaID uuid.UUID) ([]dominio.ItemNota, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var itens []dominio.ItemNota
+	for _, item := range d.itensDa(notaID) {
+		if daEmpresa(ctx, item.EmpresaID) {
+			itens = append(itens, item)
+		}
+	}
+	return itens, nil
+}
+
+// GarantirParticoes nao faz nada: em memoria nao ha particoes
+func (r notasMemoria) GarantirParticoes(ctx context.Context, mes time.Time) error { return nil }
+
+func (r notasMemoria) PrepararArquivamento(ctx context.Context, corte time.Time) error { return nil }
+
+func (r notasMemoria) Arquivar(ctx context.Context, corte time.Time, limite int) (int64, int64, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var candidatas []int
+	for i, n := range d.notas {
+		if daEmpresa(ctx, n.EmpresaID) && !n.Arquivada && n.Status == dominio.StatusNotaFechada && n.DataCriacao.Before(corte) {
+			candidatas = append(candidatas, i)
+		}
+	}
+	sort.SliceStable(candidatas, func(a, b int) bool {
+		return d.notas[candidatas[a]].DataCriacao.Before(d.notas[candidatas[b]].DataCriacao)
+	})
+	candidatas = paginarMemoria(candidatas, limite, 0)
+
+	var notas, itens int64
+	for _, i := range candidatas {
+		d.notas[i].Arquivada = true
+		notas++
+		for j := range d.itens {
+			if d.itens[j].NotaID == d.notas[i].ID && !d.itens[j].Arquivada {
+				d.itens[j].Arquivada = true
+				itens++
+			}
+		}
+	}
+	return notas, itens, nil
+}
+
+// --- solicitacoes ---
+
+type solicitacoesMemoria struct{ escopoMemoria }
+
+func (r solicitacoesMemoria) Criar(ctx context.Context, sol *dominio.SolicitacaoImpressao) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &sol.EmpresaID)
+	sol.BeforeCreate(nil)
+	for _, s := range d.solicitacoes {
+		if s.ID == sol.ID {
+			return ErrDuplicado
+		}
+		// uma pendente por nota, como idx_solicitacoes_nota_pendente
+		if s.NotaID == sol.NotaID && s.Status == dominio.StatusSolicitacaoPendente && sol.Status == dominio.StatusSolicitacaoPendente {
+			return ErrDuplicado
+		}
+	}
+
+	for i := range sol.Itens {
+		sol.Itens[i].BeforeCreate(nil)
+		sol.Itens[i].SolicitacaoID = sol.ID
+		sol.Itens[i].EmpresaID = sol.EmpresaID
+	}
+
+	gravada := *sol
+	gravada.Retentativas = nil
+	gravada.Itens = append([]dominio.ItemSolicitacao(nil), sol.Itens...)
+	d.solicitacoes = append(d.solicitacoes, gravada)
+	return d.auditar(ctx, nil, &gravada)
+}
+
+// filtrar devolve as solicitacoes da empresa que passam em ok, na ordem de criacao
+func (d *dadosMemoria) filtrarSolicitacoes(ctx context.Context, ok func(dominio.SolicitacaoImpressao) bool) []dominio.SolicitacaoImpressao {
+	var resultado []dominio.SolicitacaoImpressao
+	for _, s := range d.solicitacoes {
+		if daEmpresa(ctx, s.EmpresaID) && ok(s) {
+			resultado = append(resultado, s)
+		}
+	}
+	return resultado
+}
+
+func (r solicitacoesMemoria) primeira(ctx context.Context, ok func(dominio.SolicitacaoImpressao) bool) (dominio.SolicitacaoImpressao, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	encontradas := d.filtrarSolicitacoes(ctx, ok)
+	if len(encontradas) == 0 {
+		return dominio.SolicitacaoImpressao{}, ErrNaoEncontrado
+	}
+	return encontradas[0], nil
+}
+
+func (r solicitacoesMemoria) Buscar(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	return r.primeira(ctx, func(s dominio.SolicitacaoImpressao) bool { return s.ID == id })
+}
+
+func (r solicitacoesMemoria) BuscarComRetentativas(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	encontradas := d.filtrarSolicitacoes(ctx, func(s dominio.SolicitacaoImpressao) bool { return s.ID == id })
+	if len(encontradas) == 0 {
+		return dominio.SolicitacaoImpressao{}, ErrNaoEncontrado
+	}
+	sol := encontradas[0]
+	sol.Retentativas = d.filtrarSolicitacoes(ctx, func(s dominio.SolicitacaoImpressao) bool {
+		return s.SolicitacaoOrigemID != nil && *s.SolicitacaoOrigemID == id
+	})
+	sort.SliceStable(sol.Retentativas, func(i, j int) bool {
+		return sol.Retentativas[i].Tentativa < sol.Retentativas[j].Tentativa
+	})
+	return sol, nil
+}
+
+func (r solicitacoesMemoria) BuscarPorChave(ctx context.Context, chave string) (dominio.SolicitacaoImpressao, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	comChave := d.filtrarSolicitacoes(ctx, func(s dominio.SolicitacaoImpressao) bool { return s.ChaveIdempotencia == chave })
+	if len(comChave) == 0 {
+		return dominio.SolicitacaoImpressao{}, ErrNaoEncontrado
+	}
+	maisRecentesPrimeiro(comChave)
+	return comChave[0], nil
+}
+
+func (r solicitacoesMemoria) BuscarParaAtualizar(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	return r.Buscar(ctx, id)
+}
+
+func (r solicitacoesMemoria) UltimaTentativa(ctx context.Context, raizID uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	cadeia := d.filtrarSolicitacoes(ctx, func(s dominio.SolicitacaoImpressao) bool {
+		return s.ID == raizID || (s.SolicitacaoOrigemID != nil && *s.SolicitacaoOrigemID == raizID)
+	})
+	if len(cadeia) == 0 {
+		return dominio.SolicitacaoImpressao{}, ErrNaoEncontrado
+	}
+	sort.SliceStable(cadeia, func(i, j int) bool { return cadeia[i].Tentativa > cadeia[j].Tentativa })
+	return cadeia[0], nil
+}
+
+// maisRecentesPrimeiro ordena por data de criacao decrescente; empates ficam
+// com a criada por ultimo na frente
+func maisRecentesPrimeiro(sols []dominio.SolicitacaoImpressao) {
+	for i, j := 0, len(sols)-1; i < j; i, j = i+1, j-1 {
+		sols[i], sols[j] = sols[j], sols[i]
+	}
+	sort.SliceStable(sols, func(i, j int) bool { return sols[i].DataCriacao.After(sols[j].DataCriacao) })
+}
+
+func (r solicitacoesMemoria) MaisRecente(ctx context.Context, notaID uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	daNota := d.filtrarSolicitacoes(ctx, func(s dominio.SolicitacaoImpressao) bool { return s.NotaID == notaID })
+	if len(daNota) == 0 {
+		return dominio.SolicitacaoImpressao{}, ErrNaoEncontrado
+	}
+	maisRecentesPrimeiro(daNota)
+	return daNota[0], nil
+}
+
+func (r solicitacoesMemoria) PendentesParaAtualizar(ctx context.Context, notaID uuid.UUID) ([]dominio.SolicitacaoImpressao, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	pendentes := d.filtrarSolicitacoes(ctx, func(s dominio.SolicitacaoImpressao) bool {
+		return s.NotaID == notaID && s.Status == dominio.StatusSolicitacaoPendente
+	})
+	maisRecentesPrimeiro(pendentes)
+	return pendentes, nil
+}
+
+func (r solicitacoesMemoria) VencidasParaAtualizar(ctx context.Context, agora time.Time, limite int) ([]dominio.SolicitacaoImpressao, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	vencidas := d.filtrarSolicitacoes(ctx, func(s dominio.SolicitacaoImpressao) bool {
+		return s.Status == dominio.StatusSolicitacaoPendente && s.PrazoExpiracao != nil && s.PrazoExpiracao.Before(agora)
+	})
+	sort.SliceStable(vencidas, func(i, j int) bool { return vencidas[i].PrazoExpiracao.Before(*vencidas[j].PrazoExpiracao) })
+	return paginarMemoria(vencidas, limite, 0), nil
+}
+
+func (r solicitacoesMemoria) alterar(ctx context.Context, ok func(dominio.SolicitacaoImpressao) bool, fn func(*dominio.SolicitacaoImpressao)) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for i := range d.solicitacoes {
+		if daEmpresa(ctx, d.solicitacoes[i].EmpresaID) && ok(d.solicitacoes[i]) {
+			antes := d.solicitacoes[i]
+			fn(&d.solicitacoes[i])
+			if err := d.auditar(ctx, &antes, &d.solicitacoes[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r solicitacoesMemoria) ConcluirPendentes(ctx context.Context, notaID uuid.UUID, quando time.Time) error {
+	return r.alterar(ctx, func(s dominio.SolicitacaoImpressao) bool {
+		return s.NotaID == notaID && s.Status == dominio.StatusSolicitacaoPendente
+	}, func(s *dominio.SolicitacaoImpressao) {
+		s.Status = dominio.StatusSolicitacaoConcluida
+		s.DataConclusao = &quando
+	})
+}
+
+func (r solicitacoesMemoria) Falhar(ctx context.Context, id uuid.UUID, motivo string) error {
+	return r.alterar(ctx, func(s dominio.SolicitacaoImpressao) bool { return s.ID == id }, func(s *dominio.SolicitacaoImpressao) {
+		s.Status = dominio.StatusSolicitacaoFalhou
+		s.MensagemErro = &motivo
+	})
+}
+
+func (r solicitacoesMemoria) AtualizarTentativas(ctx context.Context, raizID uuid.UUID, tentativas int) error {
+	return r.alterar(ctx, func(s dominio.SolicitacaoImpressao) bool { return s.ID == raizID }, func(s *dominio.SolicitacaoImpressao) {
+		s.Tentativas = tentativas
+	})
+}
+
+// --- idempotencia ---
+
+type idempotenciaMemoria struct{ escopoMemoria }
+
+func (r idempotenciaMemoria) Registrar(ctx context.Context, chave *dominio.ChaveIdempotencia) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &chave.EmpresaID)
+	chave.BeforeCreate(nil)
+	for _, c := range d.chaves {
+		if c.EmpresaID == chave.EmpresaID && c.Chave == chave.Chave {
+			return ErrDuplicado
+		}
+	}
+	d.chaves = append(d.chaves, *chave)
+	return nil
+}
+
+func (r idempotenciaMemoria) Buscar(ctx context.Context, chave string) (dominio.ChaveIdempotencia, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for _, c := range d.chaves {
+		if daEmpresa(ctx, c.EmpresaID) && c.Chave == chave {
+			return c, nil
+		}
+	}
+	return dominio.ChaveIdempotencia{}, ErrNaoEncontrado
+}
+
+func (r idempotenciaMemoria) RemoverAntigas(ctx context.Context, antes time.Time, limite int) (int64, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var removidas int64
+	d.chaves, removidas = removerMemoria(d.chaves, limite, func(c dominio.ChaveIdempotencia) bool {
+		return daEmpresa(ctx, c.EmpresaID) && c.DataCriacao.Before(antes)
+	})
+	return removidas, nil
+}
+
+// --- outbox ---
+
+type outboxMemoria struct{ escopoMemoria }
+
+func (r outboxMemoria) Adicionar(ctx context.Context, evt *dominio.EventoOutbox) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &evt.EmpresaID)
+	evt.BeforeCreate(nil)
+	d.seqOutbox++
+	evt.ID = d.seqOutbox
+	d.outbox = append(d.outbox, *evt)
+	return nil
+}
+
+func (r outboxMemoria) Buscar(ctx context.Context, id int64) (dominio.EventoOutbox, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for _, evt := range d.outbox {
+		if evt.ID == id && daEmpresa(ctx, evt.EmpresaID) {
+			return evt, nil
+		}
+	}
+	return dominio.EventoOutbox{}, ErrNaoEncontrado
+}
+
+func (r outboxMemoria) BuscarParaAtualizar(ctx context.Context, id int64) (dominio.EventoOutbox, error) {
+	return r.Buscar(ctx, id)
+}
+
+func (r outboxMemoria) Listar(ctx context.Context, filtro FiltroOutbox) ([]dominio.EventoOutbox, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var eventos []dominio.EventoOutbox
+	for _, evt := range d.outbox {
+		if !daEmpresa(ctx, evt.EmpresaID) {
+			continue
+		}
+		situacao := evt.Situacao()
+		switch filtro.Situacao {
+		case "":
+		case dominio.SituacaoOutboxPendente:
+			// pendente inclui os que ja falharam e aguardam nova tentativa
+			if situacao != dominio.SituacaoOutboxPendente && situacao != dominio.SituacaoOutboxFalhou {
+				continue
+			}
+		default:
+			if situacao != filtro.Situacao {
+				continue
+			}
+		}
+		if filtro.Tipo != "" && evt.TipoEvento != filtro.Tipo {
+			continue
+		}
+		if filtro.Agregado != nil && evt.IdAgregado != *filtro.Agregado {
+			continue
+		}
+		eventos = append(eventos, evt)
+	}
+	return paginarMemoria(eventos, filtro.Limite, filtro.Offset), nil
+}
+
+func (r outboxMemoria) ProntosParaPublicar(ctx context.Context, agora time.Time, limite int) ([]dominio.EventoOutbox, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var eventos []dominio.EventoOutbox
+	for _, evt := range d.outbox {
+		if !daEmpresa(ctx, evt.EmpresaID) || evt.DataPublicacao != nil || evt.DataEstacionamento != nil {
+			continue
+		}
+		if evt.ProximaTentativa != nil && evt.ProximaTentativa.After(agora) {
+			continue
+		}
+		eventos = append(eventos, evt)
+	}
+	return paginarMemoria(eventos, limite, 0), nil
+}
+
+func (r outboxMemoria) alterar(ctx context.Context, id int64, fn func(*dominio.EventoOutbox) bool) bool {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for i := range d.outbox {
+		if d.outbox[i].ID == id && daEmpresa(ctx, d.outbox[i].EmpresaID) {
+			return fn(&d.outbox[i])
+		}
+	}
+	return false
+}
+
+func (r outboxMemoria) RegistrarFalha(ctx context.Context, evt *dominio.EventoOutbox) error {
+	r.alterar(ctx, evt.ID, func(e *dominio.EventoOutbox) bool {
+		e.Tentativas = evt.Tentativas
+		e.UltimoErro = evt.UltimoErro
+		e.ProximaTentativa = evt.ProximaTentativa
+		return true
+	})
+	return nil
+}
+
+func (r outboxMemoria) RegistrarPublicacao(ctx context.Context, evt *dominio.EventoOutbox) error {
+	r.alterar(ctx, evt.ID, func(e *dominio.EventoOutbox) bool {
+		e.DataPublicacao = evt.DataPublicacao
+		e.Tentativas = evt.Tentativas
+		e.ProximaTentativa = nil
+		e.DataEstacionamento = nil
+		e.MotivoEstacionamento = nil
+		return true
+	})
+	return nil
+}
+
+func (r outboxMemoria) Estacionar(ctx context.Context, id int64, motivo string, quando time.Time) (bool, error) {
+	return r.alterar(ctx, id, func(e *dominio.EventoOutbox) bool {
+		if e.DataPublicacao != nil || e.DataEstacionamento != nil {
+			return false
+		}
+		e.DataEstacionamento = &quando
+		e.MotivoEstacionamento = &motivo
+		return true
+	}), nil
+}
+
+func (r outboxMemoria) Backlog(ctx context.Context) (Backlog, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var backlog Backlog
+	for _, evt := range d.outbox {
+		if !daEmpresa(ctx, evt.EmpresaID) || evt.DataPublicacao != nil || evt.DataEstacionamento != nil {
+			continue
+		}
+		backlog.Pendentes++
+		if backlog.MaisAntigo == nil {
+			ocorrencia := evt.DataOcorrencia
+			backlog.MaisAntigo = &ocorrencia
+		}
+	}
+	return backlog, nil
+}
+
+func (r outboxMemoria) RemoverPublicados(ctx context.Context, antes time.Time, limite int, arquivar bool) (int64, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	agora := time.Now()
+	var removidos int64
+	d.outbox, removidos = removerMemoria(d.outbox, limite, func(evt dominio.EventoOutbox) bool {
+		if !daEmpresa(ctx, evt.EmpresaID) || evt.DataPublicacao == nil || !evt.DataPublicacao.Before(antes) {
+			return false
+		}
+		if arquivar {
+			d.arquivo = append(d.arquivo, dominio.NovoEventoOutboxArquivado(evt, agora))
+		}
+		return true
+	})
+	return removidos, nil
+}
+
+// --- mensagens processadas ---
+
+type mensagensMemoria struct{ escopoMemoria }
+
+func (r mensagensMemoria) Processada(ctx context.Context, idMensagem string) (bool, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for _, m := range d.mensagens {
+		if m.IDMensagem == idMensagem {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r mensagensMemoria) Registrar(ctx context.Context, msg *dominio.MensagemProcessada) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &msg.EmpresaID)
+	for _, m := range d.mensagens {
+		if m.IDMensagem == msg.IDMensagem {
+			return ErrDuplicado
+		}
+	}
+	d.mensagens = append(d.mensagens, *msg)
+	return nil
+}
+
+func (r mensagensMemoria) RemoverAntigas(ctx context.Context, antes time.Time, limite int) (int64, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var removidas int64
+	d.mensagens, removidas = removerMemoria(d.mensagens, limite, func(m dominio.MensagemProcessada) bool {
+		return daEmpresa(ctx, m.EmpresaID) && m.DataProcessada.Before(antes)
+	})
+	return removidas, nil
+}
+
+// --- sagas ---
+
+type sagasMemoria struct{ escopoMemoria }
+
+func (r sagasMemoria) Criar(ctx context.Context, instancia *dominio.SagaFaturamento) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &instancia.EmpresaID)
+	instancia.BeforeCreate(nil)
+	for _, s := range d.sagas {
+		if s.ID == instancia.ID || s.SolicitacaoID == instancia.SolicitacaoID {
+			return ErrDuplicado
+		}
+	}
+
+	gravada := *instancia
+	gravada.Passos = nil
+	d.sagas = append(d.sagas, gravada)
+	return nil
+}
+
+func (r sagasMemoria) Buscar(ctx context.Context, id uuid.UUID) (dominio.SagaFaturamento, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for _, s := range d.sagas {
+		if s.ID != id || !daEmpresa(ctx, s.EmpresaID) {
+			continue
+		}
+		for _, p := range d.passos {
+			if p.SagaID == id {
+				s.Passos = append(s.Passos, p)
+			}
+		}
+		sort.SliceStable(s.Passos, func(i, j int) bool { return s.Passos[i].Data.Before(s.Passos[j].Data) })
+		return s, nil
+	}
+	return dominio.SagaFaturamento{}, ErrNaoEncontrado
+}
+
+func (r sagasMemoria) BuscarPorSolicitacao(ctx context.Context, solicitacaoID uuid.UUID) (dominio.SagaFaturamento, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for _, s := range d.sagas {
+		if s.SolicitacaoID == solicitacaoID && daEmpresa(ctx, s.EmpresaID) {
+			return s, nil
+		}
+	}
+	return dominio.SagaFaturamento{}, ErrNaoEncontrado
+}
+
+func (r sagasMemoria) Listar(ctx context.Context, filtro FiltroSagas) ([]dominio.SagaFaturamento, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var sagas []dominio.SagaFaturamento
+	for _, s := range d.sagas {
+		switch {
+		case !daEmpresa(ctx, s.EmpresaID),
+			filtro.NotaID != nil && s.NotaID != *filtro.NotaID,
+			filtro.SolicitacaoID != nil && s.SolicitacaoID != *filtro.SolicitacaoID,
+			filtro.Correlacao != "" && s.IDCorrelacao != filtro.Correlacao,
+			filtro.Estado != "" && s.Estado != filtro.Estado:
+			continue
+		}
+		sagas = append(sagas, s)
+	}
+	sort.SliceStable(sagas, func(i, j int) bool { return sagas[i].DataInicio.After(sagas[j].DataInicio) })
+	return paginarMemoria(sagas, filtro.Limite, filtro.Offset), nil
+}
+
+func (r sagasMemoria) Atualizar(ctx context.Context, instancia *dominio.SagaFaturamento) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for i := range d.sagas {
+		if d.sagas[i].ID == instancia.ID && daEmpresa(ctx, d.sagas[i].EmpresaID) {
+			d.sagas[i].Estado = instancia.Estado
+			d.sagas[i].PassoAtual = instancia.PassoAtual
+			d.sagas[i].DataAtualizacao = instancia.DataAtualizacao
+			d.sagas[i].DataFim = instancia.DataFim
+		}
+	}
+	return nil
+}
+
+func (r sagasMemoria) AdicionarPasso(ctx context.Context, passo *dominio.PassoSaga) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &passo.EmpresaID)
+	d.seqPassos++
+	passo.ID = d.seqPassos
+	d.passos = append(d.passos, *passo)
+	return nil
+}
+
+// --- catalogo de produtos ---
+
+type produtosMemoria struct{ escopoMemoria }
+
+func (r produtosMemoria) Buscar(ctx context.Context, id uuid.UUID) (dominio.ProdutoCatalogo, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for _, p := range d.produtos {
+		// produtos sem empresa valem para todas
+		if p.ID == id && (p.EmpresaID == "" || daEmpresa(ctx, p.EmpresaID)) {
+			return p, nil
+		}
+	}
+	return dominio.ProdutoCatalogo{}, ErrNaoEncontrado
+}
+
+func (r produtosMemoria) Projetar(ctx context.Context, produto *dominio.ProdutoCatalogo) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &produto.EmpresaID)
+	for i := range d.produtos {
+		if d.produtos[i].ID == produto.ID {
+			if !d.produtos[i].DataEvento.After(produto.DataEvento) {
+				d.produtos[i] = *produto
+			}
+			return nil
+		}
+	}
+	d.produtos = append(d.produtos, *produto)
+	return nil
+}
+
+// --- quarentena ---
+
+type quarentenaMemoria struct{ escopoMemoria }
+
+func (r quarentenaMemoria) Criar(ctx context.Context, msg *dominio.MensagemQuarentena) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	carimbar(ctx, &msg.EmpresaID)
+	msg.BeforeCreate(nil)
+	for _, m := range d.quarentena {
+		if m.ID == msg.ID || m.IDMensagem == msg.IDMensagem {
+			return ErrDuplicado
+		}
+	}
+	d.quarentena = append(d.quarentena, *msg)
+	return nil
+}
+
+func (r quarentenaMemoria) buscar(ctx context.Context, ok func(dominio.MensagemQuarentena) bool) (dominio.MensagemQuarentena, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for _, m := range d.quarentena {
+		if daEmpresa(ctx, m.EmpresaID) && ok(m) {
+			return m, nil
+		}
+	}
+	return dominio.MensagemQuarentena{}, ErrNaoEncontrado
+}
+
+func (r quarentenaMemoria) Buscar(ctx context.Context, id uuid.UUID) (dominio.MensagemQuarentena, error) {
+	return r.buscar(ctx, func(m dominio.MensagemQuarentena) bool { return m.ID == id })
+}
+
+func (r quarentenaMemoria) BuscarPorMensagem(ctx context.Context, idMensagem string) (dominio.MensagemQuarentena, error) {
+	return r.buscar(ctx, func(m dominio.MensagemQuarentena) bool { return m.IDMensagem == idMensagem })
+}
+
+func (r quarentenaMemoria) Listar(ctx context.Context, filtro FiltroQuarentena) ([]dominio.MensagemQuarentena, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var mensagens []dominio.MensagemQuarentena
+	for _, m := range d.quarentena {
+		if !daEmpresa(ctx, m.EmpresaID) || (filtro.Status != "" && m.Status != filtro.Status) || (filtro.RoutingKey != "" && m.RoutingKey != filtro.RoutingKey) {
+			continue
+		}
+		mensagens = append(mensagens, m)
+	}
+	sort.SliceStable(mensagens, func(i, j int) bool { return mensagens[i].DataUltima.After(mensagens[j].DataUltima) })
+	return paginarMemoria(mensagens, filtro.Limite, filtro.Offset), nil
+}
+
+func (r quarentenaMemoria) Atualizar(ctx context.Context, msg *dominio.MensagemQuarentena) error {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for i := range d.quarentena {
+		if d.quarentena[i].ID == msg.ID && daEmpresa(ctx, d.quarentena[i].EmpresaID) {
+			d.quarentena[i] = *msg
+		}
+	}
+	return nil
+}
+
+func (r quarentenaMemoria) Resolver(ctx context.Context, id uuid.UUID, de, para string, motivo *string, quando time.Time) (bool, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	for i := range d.quarentena {
+		m := &d.quarentena[i]
+		if m.ID != id || m.Status != de || !daEmpresa(ctx, m.EmpresaID) {
+			continue
+		}
+		m.Status = para
+		m.DataResolucao = &quando
+		if motivo != nil {
+			m.MotivoDescarte = motivo
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// --- auditoria ---
+
+type auditoriaMemoria struct{ escopoMemoria }
+
+func (r auditoriaMemoria) Listar(ctx context.Context, notaID uuid.UUID, filtro FiltroAuditoria) ([]dominio.RegistroAuditoria, error) {
+	d, fechar := r.abrir()
+	defer fechar()
+
+	var registros []dominio.RegistroAuditoria
+	for _, registro := range d.auditoria {
+		if registro.NotaID != notaID || !daEmpresa(ctx, registro.EmpresaID) || (filtro.Tabela != "" && registro.Tabela != filtro.Tabela) {
+			continue
+		}
+		registros = append(registros, registro)
+	}
+	return paginarMemoria(registros, filtro.Limite, filtro.Offset), nil
+}
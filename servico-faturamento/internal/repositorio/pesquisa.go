@@ -0,0 +1,51 @@
+package repositorio
+
+import (
+	"strings"
+	"unicode"
+
+	"servico-faturamento/internal/dominio"
+)
+
+// Marcadores dos termos encontrados em ResultadoPesquisa.Destaque
+const (
+	InicioDestaque = "<mark>"
+	FimDestaque    = "</mark>"
+)
+
+// FiltroPesquisa e a busca textual de notas: numero, destinatario e SKU/nome
+// dos itens. Cada termo casa como prefixo de uma palavra e todos precisam
+// casar.
+type FiltroPesquisa struct {
+	Texto  string
+	Limite int
+	Offset int
+}
+
+// ResultadoPesquisa e uma nota encontrada, com a relevancia (maior primeiro)
+// e o texto pesquisavel com os termos entre InicioDestaque e FimDestaque
+type ResultadoPesquisa struct {
+	Nota       dominio.NotaFiscal `json:"nota"`
+	Relevancia float64            `json:"relevancia"`
+	Destaque   string             `json:"destaque"`
+}
+
+// TermosPesquisa quebra o texto em termos de letras e digitos, em minusculas.
+// Pontuacao separa termos ("NF-100" vira "nf" e "100"), o que tambem impede
+// operadores de tsquery vindos do usuario.
+func TermosPesquisa(texto string) []string {
+	return strings.FieldsFunc(strings.ToLower(texto), separaTermos)
+}
+
+func separaTermos(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// consultaPrefixos monta a tsquery "termo1:* & termo2:*"
+func consultaPrefixos(termos []string) string {
+	prefixos := make([]string, len(termos))
+	for i, termo := range termos {
+		prefixos[i] = termo + ":*"
+	}
+	return strings.Join(prefixos, " & ")
+}
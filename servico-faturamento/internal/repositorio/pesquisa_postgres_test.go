@@ -0,0 +1,84 @@
+package repositorio_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+)
+
+// Busca textual da 0005 no PostgreSQL de TESTE_POSTGRES_DSN: ts_rank pelos
+// pesos, ts_headline no destaque e os triggers que mantem o tsvector
+func TestNotas_PesquisarPostgres(t *testing.T) {
+	db := bdteste.AbrirPostgres(t)
+	if err := db.Exec("SELECT faturamento_criar_particoes(?, false)", time.Now().Format("2006-01-02")).Error; err != nil {
+		t.Fatalf("falha ao criar particao: %v", err)
+	}
+	banco := repositorio.NovoBancoPostgres(db)
+	ctx := context.Background()
+	joao, outra := notasDePesquisa(t, banco)
+
+	pesquisar := func(texto string) []repositorio.ResultadoPesquisa {
+		t.Helper()
+		resultados, err := banco.Notas().Pesquisar(ctx, repositorio.FiltroPesquisa{Texto: texto})
+		if err != nil {
+			t.Fatalf("erro ao pesquisar %q: %v", texto, err)
+		}
+		return resultados
+	}
+	destacado := func(palavra string) string {
+		return repositorio.InicioDestaque + palavra + repositorio.FimDestaque
+	}
+
+	resultados := pesquisar("silv")
+	if len(resultados) != 2 {
+		t.Fatalf("esperava as duas notas, obteve %+v", resultados)
+	}
+	// destinatario (peso B) rende mais que item (peso C)
+	if resultados[0].Nota.ID != joao.ID || resultados[0].Relevancia <= resultados[1].Relevancia {
+		t.Errorf("destinatario deveria pesar mais que item, obteve %+v", resultados)
+	}
+	if !strings.Contains(resultados[0].Destaque, destacado("Silva")) {
+		t.Errorf("destaque do destinatario inesperado: %q", resultados[0].Destaque)
+	}
+	if !strings.Contains(resultados[1].Destaque, destacado("Silvertape")) || len(resultados[1].Nota.Itens) != 1 {
+		t.Errorf("destaque do item inesperado: %+v", resultados[1])
+	}
+	if resultados := pesquisar("joa silv"); len(resultados) != 1 || resultados[0].Nota.ID != joao.ID {
+		t.Errorf("todos os termos precisam casar, obteve %+v", resultados)
+	}
+
+	// item novo refaz o documento da nota
+	item := dominio.ItemNota{NotaID: joao.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 1, Sku: "SKU-CAN", NomeProduto: "Caneta"}
+	if err := banco.Notas().AdicionarItem(ctx, &item); err != nil {
+		t.Fatalf("falha ao adicionar item: %v", err)
+	}
+	if resultados := pesquisar("caneta"); len(resultados) != 1 || resultados[0].Nota.ID != joao.ID {
+		t.Errorf("item novo deveria entrar na busca, obteve %+v", resultados)
+	}
+
+	// destinatario alterado tambem
+	if err := db.Exec("UPDATE notas_fiscais SET destinatario = 'Pedro Alves' WHERE id = ?", joao.ID).Error; err != nil {
+		t.Fatalf("falha ao alterar destinatario: %v", err)
+	}
+	if resultados := pesquisar("pedro"); len(resultados) != 1 || resultados[0].Nota.ID != joao.ID {
+		t.Errorf("destinatario novo deveria entrar na busca, obteve %+v", resultados)
+	}
+	if resultados := pesquisar("silv"); len(resultados) != 1 || resultados[0].Nota.ID != outra.ID {
+		t.Errorf("destinatario antigo deveria sair da busca, obteve %+v", resultados)
+	}
+
+	// item removido sai do documento
+	if err := db.Exec("DELETE FROM itens_nota WHERE nota_id = ?", outra.ID).Error; err != nil {
+		t.Fatalf("falha ao remover item: %v", err)
+	}
+	if resultados := pesquisar("silv"); len(resultados) != 0 {
+		t.Errorf("item removido deveria sair da busca, obteve %+v", resultados)
+	}
+}
@@ -0,0 +1,852 @@
+package repositorio
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BancoPostgres implementa Banco com GORM. Usa recursos do PostgreSQL (FOR
+// UPDATE, SKIP LOCKED, ON CONFLICT), que o SQLite dos testes ignora ou aceita.
+// Escritas e transacoes vao sempre para db; Leitura distribui entre as replicas.
+type BancoPostgres struct {
+	db       *gorm.DB
+	replicas []*gorm.DB
+	proxima  *atomic.Uint64
+	// aoConfirmar acumula os AoConfirmar de uma transacao; nil fora dela
+	aoConfirmar *[]func()
+}
+
+func NovoBancoPostgres(db *gorm.DB, replicas ...*gorm.DB) *BancoPostgres {
+	return &BancoPostgres{db: db, replicas: replicas, proxima: new(atomic.Uint64)}
+}
+
+func (b *BancoPostgres) Transacao(ctx context.Context, fn func(r Repositorios) error) error {
+	var confirmadas []func()
+	err := b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&BancoPostgres{db: tx, aoConfirmar: &confirmadas})
+	})
+	if err != nil {
+		return err
+	}
+	// aninhada (savepoint): espera o commit da transacao de fora
+	for _, f := range confirmadas {
+		b.AoConfirmar(f)
+	}
+	return nil
+}
+
+func (b *BancoPostgres) AoConfirmar(fn func()) {
+	if b.aoConfirmar == nil {
+		fn()
+		return
+	}
+	*b.aoConfirmar = append(*b.aoConfirmar, fn)
+}
+
+func (b *BancoPostgres) Leitura(ctx context.Context) Repositorios {
+	if len(b.replicas) == 0 || PrimarioFixado(ctx) {
+		return b
+	}
+	// round-robin simples; o pool de cada replica cuida das conexoes
+	i := b.proxima.Add(1) % uint64(len(b.replicas))
+	return &BancoPostgres{db: b.replicas[i]}
+}
+
+func (b *BancoPostgres) Notas() Notas                    { return notasPostgres{b.db} }
+func (b *BancoPostgres) Solicitacoes() Solicitacoes      { return solicitacoesPostgres{b.db} }
+func (b *BancoPostgres) Idempotencia() Idempotencia      { return idempotenciaPostgres{b.db} }
+func (b *BancoPostgres) Outbox() Outbox                  { return outboxPostgres{b.db} }
+func (b *BancoPostgres) Mensagens() MensagensProcessadas { return mensagensPostgres{b.db} }
+func (b *BancoPostgres) Sagas() Sagas                    { return sagasPostgres{b.db} }
+func (b *BancoPostgres) Produtos() Produtos              { return produtosPostgres{b.db} }
+func (b *BancoPostgres) Quarentena() Quarentena          { return quarentenaPostgres{b.db} }
+func (b *BancoPostgres) Auditoria() Auditoria            { return auditoriaPostgres{b.db} }
+
+// traduzir troca os erros do GORM pelos do pacote
+func traduzir(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNaoEncontrado
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicado
+	}
+	return err
+}
+
+func paginar(query *gorm.DB, limite, offset int) *gorm.DB {
+	if limite > 0 {
+		query = query.Limit(limite)
+	}
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+	return query
+}
+
+// sessao abre a consulta no contexto, restrita a empresa anotada nele (ver
+// ComEmpresa). Creates nao passam por aqui: usam carimbar.
+func sessao(ctx context.Context, db *gorm.DB) *gorm.DB {
+	db = db.WithContext(ctx)
+	if empresa := EmpresaDe(ctx); empresa != "" {
+		db = db.Where("empresa_id = ?", empresa)
+	}
+	return db
+}
+
+func travar(query *gorm.DB) *gorm.DB {
+	return query.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// auditar grava na trilha a diferenca entre antes e depois (antes nil e uma
+// insercao), com a autoria anotada no contexto
+func auditar(ctx context.Context, db *gorm.DB, antes, depois dominio.Auditavel) error {
+	registro, mudou, err := dominio.NovoRegistroAuditoria(antes, depois, AutoriaDe(ctx), time.Now())
+	if err != nil || !mudou {
+		return err
+	}
+	carimbar(ctx, &registro.EmpresaID)
+	return db.WithContext(ctx).Create(&registro).Error
+}
+
+// atualizarAuditando aplica valores as linhas que atendem a condicao e audita
+// cada uma, comparando o que estava gravado com o que ficou. Roda numa
+// transacao propria, ou num savepoint se ja estiver dentro de uma.
+func atualizarAuditando[T any, P interface {
+	*T
+	dominio.Auditavel
+}](ctx context.Context, db *gorm.DB, valores map[string]interface{}, condicao string, args ...interface{}) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var antes []T
+		if err := travar(sessao(ctx, tx)).Where(condicao, args...).Find(&antes).Error; err != nil {
+			return err
+		}
+		if len(antes) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, len(antes))
+		for i := range antes {
+			ids[i], _, _ = P(&antes[i]).ReferenciaAuditoria()
+		}
+		if err := tx.Model(P(new(T))).Where("id IN ?", ids).Updates(valores).Error; err != nil {
+			return err
+		}
+
+		var depois []T
+		if err := tx.Where("id IN ?", ids).Find(&depois).Error; err != nil {
+			return err
+		}
+		atuais := make(map[uuid.UUID]P, len(depois))
+		for i := range depois {
+			id, _, _ := P(&depois[i]).ReferenciaAuditoria()
+			atuais[id] = &depois[i]
+		}
+		for i := range antes {
+			if atual, ok := atuais[ids[i]]; ok {
+				if err := auditar(ctx, tx, P(&antes[i]), atual); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// --- notas ---
+
+type notasPostgres struct{ db *gorm.DB }
+
+func (r notasPostgres) Criar(ctx context.Context, nota *dominio.NotaFiscal) error {
+	carimbar(ctx, &nota.EmpresaID)
+	nota.BeforeCreate(nil)
+	for i := range nota.Itens {
+		nota.Itens[i].EmpresaID = nota.EmpresaID
+		nota.Itens[i].DataNota = nota.DataCriacao
+	}
+	return traduzir(r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(nota).Error; err != nil {
+			return err
+		}
+		if err := auditar(ctx, tx, nil, nota); err != nil {
+			return err
+		}
+		for i := range nota.Itens {
+			if err := auditar(ctx, tx, nil, &nota.Itens[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func (r notasPostgres) Buscar(ctx context.Context, id uuid.UUID) (dominio.NotaFiscal, error) {
+	var nota dominio.NotaFiscal
+	err := sessao(ctx, r.db).Preload("Itens").First(&nota, "id = ?", id).Error
+	return nota, traduzir(err)
+}
+
+func (r notasPostgres) BuscarParaAtualizar(ctx context.Context, id uuid.UUID) (dominio.NotaFiscal, error) {
+	var nota dominio.NotaFiscal
+	err := travar(sessao(ctx, r.db)).Preload("Itens").First(&nota, "id = ?", id).Error
+	return nota, traduzir(err)
+}
+
+func (r notasPostgres) Listar(ctx context.Context, filtro FiltroNotas) ([]dominio.NotaFiscal, error) {
+	var notas []dominio.NotaFiscal
+	err := filtrarNotas(sessao(ctx, r.db).Preload("Itens"), filtro).Find(&notas).Error
+	return notas, traduzir(err)
+}
+
+// lotePercorrer e quantas notas Percorrer carrega, com os itens, por consulta
+const lotePercorrer = 500
+
+func (r notasPostgres) Percorrer(ctx context.Context, filtro FiltroNotas, fn func(dominio.NotaFiscal) error) error {
+	var ultima *dominio.NotaFiscal
+	for {
+		query := filtrarNotas(sessao(ctx, r.db).Preload("Itens"), filtro)
+		if ultima != nil {
+			// paginacao por chave: o offset releria todas as notas anteriores
+			query = query.Where("data_criacao > ? OR (data_criacao = ? AND id > ?)", ultima.DataCriacao, ultima.DataCriacao, ultima.ID)
+		}
+		var lote []dominio.NotaFiscal
+		if err := query.Order("data_criacao, id").Limit(lotePercorrer).Find(&lote).Error; err != nil {
+			return traduzir(err)
+		}
+		for _, nota := range lote {
+			if err := fn(nota); err != nil {
+				return err
+			}
+		}
+		if len(lote) < lotePercorrer {
+			return nil
+		}
+		ultima = &lote[len(lote)-1]
+	}
+}
+
+func filtrarNotas(query *gorm.DB, filtro FiltroNotas) *gorm.DB {
+	if !filtro.Arquivadas {
+		// so as particoes correntes
+		query = query.Where("arquivada = ?", false)
+	}
+	if filtro.Status != "" {
+		query = query.Where("status = ?", filtro.Status)
+	}
+	return query
+}
+
+// opcoesDestaque limita o destaque a tres trechos em notas com muitos itens
+var opcoesDestaque = `StartSel=` + InicioDestaque + `, StopSel=` + FimDestaque + `, MaxFragments=3, FragmentDelimiter=" ... "`
+
+func (r notasPostgres) Pesquisar(ctx context.Context, filtro FiltroPesquisa) ([]ResultadoPesquisa, error) {
+	termos := TermosPesquisa(filtro.Texto)
+	if len(termos) == 0 {
+		return nil, nil
+	}
+	consulta := consultaPrefixos(termos)
+
+	// a pagina sai do indice GIN; o destaque, mais caro, so e montado para ela
+	pagina := sessao(ctx, r.db).Model(&dominio.NotaFiscal{}).
+		Select("id, numero, destinatario, data_criacao, ts_rank(busca, to_tsquery('simple', ?)) AS relevancia", consulta).
+		Where("busca @@ to_tsquery('simple', ?)", consulta).
+		Order("relevancia DESC, data_criacao DESC")
+	var linhas []struct {
+		ID         uuid.UUID
+		Relevancia float64
+		Destaque   string
+	}
+	err := r.db.WithContext(ctx).
+		Table("(?) AS p", paginar(pagina, filtro.Limite, filtro.Offset)).
+		Select("p.id, p.relevancia, ts_headline('simple', concat_ws(' ', p.numero, p.destinatario, notas_fiscais_texto_itens(p.id)), to_tsquery('simple', ?), ?) AS destaque", consulta, opcoesDestaque).
+		Order("p.relevancia DESC, p.data_criacao DESC").
+		Scan(&linhas).Error
+	if err != nil || len(linhas) == 0 {
+		return nil, traduzir(err)
+	}
+
+	ids := make([]uuid.UUID, len(linhas))
+	for i, linha := range linhas {
+		ids[i] = linha.ID
+	}
+	var notas []dominio.NotaFiscal
+	if err := sessao(ctx, r.db).Preload("Itens").Where("id IN ?", ids).Find(&notas).Error; err != nil {
+		return nil, traduzir(err)
+	}
+	porID := make(map[uuid.UUID]dominio.NotaFiscal, len(notas))
+	for _, nota := range notas {
+		porID[nota.ID] = nota
+	}
+
+	resultados := make([]ResultadoPesquisa, 0, len(linhas))
+	for _, linha := range linhas {
+		if nota, ok := porID[linha.ID]; ok {
+			resultados = append(resultados, ResultadoPesquisa{Nota: nota, Relevancia: linha.Relevancia, Destaque: linha.Destaque})
+		}
+	}
+	return resultados, nil
+}
+
+func (r notasPostgres) Atualizar(ctx context.Context, nota *dominio.NotaFiscal) error {
+	return traduzir(atualizarAuditando[dominio.NotaFiscal](ctx, r.db, map[string]interface{}{
+		"status":       nota.Status,
+		"data_fechada": nota.DataFechada,
+	}, "id = ?", nota.ID))
+}
+
+func (r notasPostgres) AdicionarItem(ctx context.Context, item *dominio.ItemNota) error {
+	carimbar(ctx, &item.EmpresaID)
+	return traduzir(r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// sem FK para a tabela particionada: a nota precisa existir, e o
+		// item vai para a mesma particao dela
+		var nota dominio.NotaFiscal
+		if err := sessao(ctx, tx).Select("data_criacao", "arquivada").First(&nota, "id = ?", item.NotaID).Error; err != nil {
+			return err
+		}
+		item.DataNota, item.Arquivada = nota.DataCriacao, nota.Arquivada
+		if err := tx.Create(item).Error; err != nil {
+			return err
+		}
+		return auditar(ctx, tx, nil, item)
+	}))
+}
+
+func (r notasPostgres) Itens(ctx context.Context, notaID uuid.UUID) ([]dominio.ItemNota, error) {
+	var itens []dominio.ItemNota
+	err := sessao(ctx, r.db).Where("nota_id = ?", notaID).Find(&itens).Error
+	return itens, traduzir(err)
+}
+
+// particionado diz se as tabelas sao as particionadas da migration 0006. So
+// existem no PostgreSQL; no SQLite dos testes apenas a coluna arquivada muda.
+func (r notasPostgres) particionado() bool {
+	return r.db.Dialector.Name() == "postgres"
+}
+
+func (r notasPostgres) criarParticoes(ctx context.Context, mes time.Time, arquivo bool) error {
+	return traduzir(r.db.WithContext(ctx).Exec("SELECT faturamento_criar_particoes(?, ?)", mes.Format("2006-01-02"), arquivo).Error)
+}
+
+func (r notasPostgres) GarantirParticoes(ctx context.Context, mes time.Time) error {
+	if !r.particionado() {
+		return nil
+	}
+	return r.criarParticoes(ctx, mes, false)
+}
+
+// arquivaveis sao as notas FECHADA ainda correntes emitidas antes de corte
+func arquivaveis(ctx context.Context, db *gorm.DB, corte time.Time) *gorm.DB {
+	return sessao(ctx, db).Model(&dominio.NotaFiscal{}).
+		Where("arquivada = ? AND status = ? AND data_criacao < ?", false, dominio.StatusNotaFechada, corte)
+}
+
+func (r notasPostgres) PrepararArquivamento(ctx context.Context, corte time.Time) error {
+	if !r.particionado() {
+		return nil
+	}
+	var meses []time.Time
+	if err := arquivaveis(ctx, r.db, corte).Distinct().Pluck("date_trunc('month', data_criacao)", &meses).Error; err != nil {
+		return traduzir(err)
+	}
+	for _, mes := range meses {
+		if err := r.criarParticoes(ctx, mes, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Arquivar nao entra na trilha de auditoria: a nota nao muda, so o lugar onde
+// e guardada. Mudar arquivada faz o PostgreSQL levar a linha para a particao
+// de arquivo.
+func (r notasPostgres) Arquivar(ctx context.Context, corte time.Time, limite int) (int64, int64, error) {
+	var ids []uuid.UUID
+	if err := arquivaveis(ctx, r.db, corte).Order("data_criacao").Limit(limite).Pluck("id", &ids).Error; err != nil {
+		return 0, 0, traduzir(err)
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+
+	itens := sessao(ctx, r.db).Model(&dominio.ItemNota{}).
+		Where("nota_id IN ? AND arquivada = ?", ids, false).
+		Update("arquivada", true)
+	if itens.Error != nil {
+		return 0, 0, traduzir(itens.Error)
+	}
+	notas := sessao(ctx, r.db).Model(&dominio.NotaFiscal{}).
+		Where("id IN ? AND arquivada = ?", ids, false).
+		Update("arquivada", true)
+	return notas.RowsAffected, itens.RowsAffected, traduzir(notas.Error)
+}
+
+// --- solicitacoes ---
+
+type solicitacoesPostgres struct{ db *gorm.DB }
+
+func (r solicitacoesPostgres) Criar(ctx context.Context, sol *dominio.SolicitacaoImpressao) error {
+	carimbar(ctx, &sol.EmpresaID)
+	for i := range sol.Itens {
+		sol.Itens[i].EmpresaID = sol.EmpresaID
+	}
+	return traduzir(r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(sol).Error; err != nil {
+			return err
+		}
+		return auditar(ctx, tx, nil, sol)
+	}))
+}
+
+func (r solicitacoesPostgres) Buscar(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	var sol dominio.SolicitacaoImpressao
+	err := sessao(ctx, r.db).Preload("Itens").First(&sol, "id = ?", id).Error
+	return sol, traduzir(err)
+}
+
+func (r solicitacoesPostgres) BuscarComRetentativas(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	var sol dominio.SolicitacaoImpressao
+	err := sessao(ctx, r.db).Preload("Itens").Preload("Retentativas", func(db *gorm.DB) *gorm.DB {
+		return db.Order("tentativa")
+	}).Preload("Retentativas.Itens").First(&sol, "id = ?", id).Error
+	return sol, traduzir(err)
+}
+
+func (r solicitacoesPostgres) BuscarPorChave(ctx context.Context, chave string) (dominio.SolicitacaoImpressao, error) {
+	var sol dominio.SolicitacaoImpressao
+	err := sessao(ctx, r.db).Preload("Itens").Where("chave_idempotencia = ?", chave).Order("data_criacao DESC").First(&sol).Error
+	return sol, traduzir(err)
+}
+
+func (r solicitacoesPostgres) BuscarParaAtualizar(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	var sol dominio.SolicitacaoImpressao
+	err := travar(sessao(ctx, r.db)).Preload("Itens").First(&sol, "id = ?", id).Error
+	return sol, traduzir(err)
+}
+
+func (r solicitacoesPostgres) UltimaTentativa(ctx context.Context, raizID uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	var sol dominio.SolicitacaoImpressao
+	err := sessao(ctx, r.db).
+		Where("id = ? OR solicitacao_origem_id = ?", raizID, raizID).
+		Order("tentativa DESC").
+		First(&sol).Error
+	return sol, traduzir(err)
+}
+
+func (r solicitacoesPostgres) MaisRecente(ctx context.Context, notaID uuid.UUID) (dominio.SolicitacaoImpressao, error) {
+	var sol dominio.SolicitacaoImpressao
+	err := sessao(ctx, r.db).
+		Where("nota_id = ?", notaID).
+		Order("data_criacao DESC").
+		First(&sol).Error
+	return sol, traduzir(err)
+}
+
+func (r solicitacoesPostgres) PendentesParaAtualizar(ctx context.Context, notaID uuid.UUID) ([]dominio.SolicitacaoImpressao, error) {
+	var pendentes []dominio.SolicitacaoImpressao
+	err := travar(sessao(ctx, r.db)).Preload("Itens").
+		Where("nota_id = ? AND status = ?", notaID, dominio.StatusSolicitacaoPendente).
+		Order("data_criacao DESC").
+		Find(&pendentes).Error
+	return pendentes, traduzir(err)
+}
+
+func (r solicitacoesPostgres) VencidasParaAtualizar(ctx context.Context, agora time.Time, limite int) ([]dominio.SolicitacaoImpressao, error) {
+	var vencidas []dominio.SolicitacaoImpressao
+	err := sessao(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Preload("Itens").
+		Where("status = ? AND prazo_expiracao IS NOT NULL AND prazo_expiracao < ?", dominio.StatusSolicitacaoPendente, agora).
+		Order("prazo_expiracao").
+		Limit(limite).
+		Find(&vencidas).Error
+	return vencidas, traduzir(err)
+}
+
+func (r solicitacoesPostgres) ConcluirPendentes(ctx context.Context, notaID uuid.UUID, quando time.Time) error {
+	return traduzir(atualizarAuditando[dominio.SolicitacaoImpressao](ctx, r.db, map[string]interface{}{
+		"status":         dominio.StatusSolicitacaoConcluida,
+		"data_conclusao": quando,
+	}, "nota_id = ? AND status = ?", notaID, dominio.StatusSolicitacaoPendente))
+}
+
+func (r solicitacoesPostgres) Falhar(ctx context.Context, id uuid.UUID, motivo string) error {
+	return traduzir(atualizarAuditando[dominio.SolicitacaoImpressao](ctx, r.db, map[string]interface{}{
+		"status":        dominio.StatusSolicitacaoFalhou,
+		"mensagem_erro": motivo,
+	}, "id = ?", id))
+}
+
+func (r solicitacoesPostgres) AtualizarTentativas(ctx context.Context, raizID uuid.UUID, tentativas int) error {
+	return traduzir(atualizarAuditando[dominio.SolicitacaoImpressao](ctx, r.db, map[string]interface{}{
+		"tentativas": tentativas,
+	}, "id = ?", raizID))
+}
+
+// --- idempotencia ---
+
+type idempotenciaPostgres struct{ db *gorm.DB }
+
+func (r idempotenciaPostgres) Registrar(ctx context.Context, chave *dominio.ChaveIdempotencia) error {
+	carimbar(ctx, &chave.EmpresaID)
+	return traduzir(r.db.WithContext(ctx).Create(chave).Error)
+}
+
+func (r idempotenciaPostgres) Buscar(ctx context.Context, chave string) (dominio.ChaveIdempotencia, error) {
+	var registro dominio.ChaveIdempotencia
+	err := sessao(ctx, r.db).First(&registro, "chave = ?", chave).Error
+	return registro, traduzir(err)
+}
+
+func (r idempotenciaPostgres) RemoverAntigas(ctx context.Context, antes time.Time, limite int) (int64, error) {
+	lote := sessao(ctx, r.db).Model(&dominio.ChaveIdempotencia{}).
+		Select("empresa_id, chave").
+		Where("data_criacao < ?", antes).
+		Limit(limite)
+
+	res := r.db.WithContext(ctx).Where("(empresa_id, chave) IN (?)", lote).Delete(&dominio.ChaveIdempotencia{})
+	return res.RowsAffected, traduzir(res.Error)
+}
+
+// --- outbox ---
+
+type outboxPostgres struct{ db *gorm.DB }
+
+func (r outboxPostgres) Adicionar(ctx context.Context, evt *dominio.EventoOutbox) error {
+	carimbar(ctx, &evt.EmpresaID)
+	return traduzir(r.db.WithContext(ctx).Create(evt).Error)
+}
+
+func (r outboxPostgres) Buscar(ctx context.Context, id int64) (dominio.EventoOutbox, error) {
+	var evt dominio.EventoOutbox
+	err := sessao(ctx, r.db).First(&evt, "id = ?", id).Error
+	return evt, traduzir(err)
+}
+
+func (r outboxPostgres) BuscarParaAtualizar(ctx context.Context, id int64) (dominio.EventoOutbox, error) {
+	var evt dominio.EventoOutbox
+	err := travar(sessao(ctx, r.db)).First(&evt, "id = ?", id).Error
+	return evt, traduzir(err)
+}
+
+func (r outboxPostgres) Listar(ctx context.Context, filtro FiltroOutbox) ([]dominio.EventoOutbox, error) {
+	query := sessao(ctx, r.db).Model(&dominio.EventoOutbox{})
+
+	switch filtro.Situacao {
+	case dominio.SituacaoOutboxPendente:
+		query = query.Where("data_publicacao IS NULL AND data_estacionamento IS NULL")
+	case dominio.SituacaoOutboxFalhou:
+		query = query.Where("data_publicacao IS NULL AND data_estacionamento IS NULL AND tentativas > 0")
+	case dominio.SituacaoOutboxEstacionado:
+		query = query.Where("data_publicacao IS NULL AND data_estacionamento IS NOT NULL")
+	case dominio.SituacaoOutboxPublicado:
+		query = query.Where("data_publicacao IS NOT NULL")
+	}
+	if filtro.Tipo != "" {
+		query = query.Where("tipo_evento = ?", filtro.Tipo)
+	}
+	if filtro.Agregado != nil {
+		query = query.Where("id_agregado = ?", *filtro.Agregado)
+	}
+
+	var eventos []dominio.EventoOutbox
+	err := paginar(query.Order("id"), filtro.Limite, filtro.Offset).Find(&eventos).Error
+	return eventos, traduzir(err)
+}
+
+func (r outboxPostgres) ProntosParaPublicar(ctx context.Context, agora time.Time, limite int) ([]dominio.EventoOutbox, error) {
+	var eventos []dominio.EventoOutbox
+	err := sessao(ctx, r.db).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("data_publicacao IS NULL AND data_estacionamento IS NULL").
+		Where("proxima_tentativa IS NULL OR proxima_tentativa <= ?", agora).
+		Order("id").
+		Limit(limite).
+		Find(&eventos).Error
+	return eventos, traduzir(err)
+}
+
+func (r outboxPostgres) RegistrarFalha(ctx context.Context, evt *dominio.EventoOutbox) error {
+	return traduzir(sessao(ctx, r.db).Model(&dominio.EventoOutbox{}).
+		Where("id = ?", evt.ID).
+		Updates(map[string]interface{}{
+			"tentativas":        evt.Tentativas,
+			"ultimo_erro":       evt.UltimoErro,
+			"proxima_tentativa": evt.ProximaTentativa,
+		}).Error)
+}
+
+func (r outboxPostgres) RegistrarPublicacao(ctx context.Context, evt *dominio.EventoOutbox) error {
+	return traduzir(sessao(ctx, r.db).Model(&dominio.EventoOutbox{}).
+		Where("id = ?", evt.ID).
+		Updates(map[string]interface{}{
+			"data_publicacao":       evt.DataPublicacao,
+			"tentativas":            evt.Tentativas,
+			"proxima_tentativa":     nil,
+			"data_estacionamento":   nil,
+			"motivo_estacionamento": nil,
+		}).Error)
+}
+
+func (r outboxPostgres) Estacionar(ctx context.Context, id int64, motivo string, quando time.Time) (bool, error) {
+	// condicional para nao estacionar algo que o publicador acabou de enviar
+	res := sessao(ctx, r.db).Model(&dominio.EventoOutbox{}).
+		Where("id = ? AND data_publicacao IS NULL AND data_estacionamento IS NULL", id).
+		Updates(map[string]interface{}{
+			"data_estacionamento":   quando,
+			"motivo_estacionamento": motivo,
+		})
+	return res.RowsAffected > 0, traduzir(res.Error)
+}
+
+func (r outboxPostgres) Backlog(ctx context.Context) (Backlog, error) {
+	var backlog Backlog
+	pendentes := func() *gorm.DB {
+		return sessao(ctx, r.db).Model(&dominio.EventoOutbox{}).
+			Where("data_publicacao IS NULL AND data_estacionamento IS NULL")
+	}
+	if err := pendentes().Count(&backlog.Pendentes).Error; err != nil || backlog.Pendentes == 0 {
+		return backlog, traduzir(err)
+	}
+
+	var maisAntigo dominio.EventoOutbox
+	if err := pendentes().Order("id").First(&maisAntigo).Error; err != nil {
+		return backlog, traduzir(err)
+	}
+	backlog.MaisAntigo = &maisAntigo.DataOcorrencia
+	return backlog, nil
+}
+
+func (r outboxPostgres) RemoverPublicados(ctx context.Context, antes time.Time, limite int, arquivar bool) (int64, error) {
+	var lote []dominio.EventoOutbox
+	err := sessao(ctx, r.db).
+		Where("data_publicacao IS NOT NULL AND data_publicacao < ?", antes).
+		Order("id").
+		Limit(limite).
+		Find(&lote).Error
+	if err != nil || len(lote) == 0 {
+		return 0, traduzir(err)
+	}
+
+	ids := make([]int64, len(lote))
+	for i, evt := range lote {
+		ids[i] = evt.ID
+	}
+
+	if arquivar {
+		agora := time.Now()
+		arquivo := make([]dominio.EventoOutboxArquivado, len(lote))
+		for i, evt := range lote {
+			arquivo[i] = dominio.NovoEventoOutboxArquivado(evt, agora)
+		}
+		if err := r.db.WithContext(ctx).Create(&arquivo).Error; err != nil {
+			return 0, traduzir(err)
+		}
+	}
+
+	res := r.db.WithContext(ctx).Where("id IN ?", ids).Delete(&dominio.EventoOutbox{})
+	return res.RowsAffected, traduzir(res.Error)
+}
+
+// --- mensagens processadas ---
+
+type mensagensPostgres struct{ db *gorm.DB }
+
+func (r mensagensPostgres) Processada(ctx context.Context, idMensagem string) (bool, error) {
+	// IDs de mensagem sao unicos entre empresas: a deduplicacao nao filtra
+	var n int64
+	err := r.db.WithContext(ctx).Model(&dominio.MensagemProcessada{}).
+		Where("id_mensagem = ?", idMensagem).
+		Count(&n).Error
+	return n > 0, traduzir(err)
+}
+
+func (r mensagensPostgres) Registrar(ctx context.Context, msg *dominio.MensagemProcessada) error {
+	carimbar(ctx, &msg.EmpresaID)
+	return traduzir(r.db.WithContext(ctx).Create(msg).Error)
+}
+
+func (r mensagensPostgres) RemoverAntigas(ctx context.Context, antes time.Time, limite int) (int64, error) {
+	lote := sessao(ctx, r.db).Model(&dominio.MensagemProcessada{}).
+		Select("id_mensagem").
+		Where("data_processada < ?", antes).
+		Limit(limite)
+
+	res := r.db.WithContext(ctx).Where("id_mensagem IN (?)", lote).Delete(&dominio.MensagemProcessada{})
+	return res.RowsAffected, traduzir(res.Error)
+}
+
+// --- sagas ---
+
+type sagasPostgres struct{ db *gorm.DB }
+
+func (r sagasPostgres) Criar(ctx context.Context, instancia *dominio.SagaFaturamento) error {
+	carimbar(ctx, &instancia.EmpresaID)
+	return traduzir(r.db.WithContext(ctx).Create(instancia).Error)
+}
+
+func (r sagasPostgres) Buscar(ctx context.Context, id uuid.UUID) (dominio.SagaFaturamento, error) {
+	var instancia dominio.SagaFaturamento
+	err := sessao(ctx, r.db).Preload("Passos", func(db *gorm.DB) *gorm.DB {
+		return db.Order("data, id")
+	}).First(&instancia, "id = ?", id).Error
+	return instancia, traduzir(err)
+}
+
+func (r sagasPostgres) BuscarPorSolicitacao(ctx context.Context, solicitacaoID uuid.UUID) (dominio.SagaFaturamento, error) {
+	var instancia dominio.SagaFaturamento
+	err := sessao(ctx, r.db).Where("solicitacao_id = ?", solicitacaoID).First(&instancia).Error
+	return instancia, traduzir(err)
+}
+
+func (r sagasPostgres) Listar(ctx context.Context, filtro FiltroSagas) ([]dominio.SagaFaturamento, error) {
+	query := sessao(ctx, r.db).Model(&dominio.SagaFaturamento{})
+	if filtro.NotaID != nil {
+		query = query.Where("nota_id = ?", *filtro.NotaID)
+	}
+	if filtro.SolicitacaoID != nil {
+		query = query.Where("solicitacao_id = ?", *filtro.SolicitacaoID)
+	}
+	if filtro.Correlacao != "" {
+		query = query.Where("id_correlacao = ?", filtro.Correlacao)
+	}
+	if filtro.Estado != "" {
+		query = query.Where("estado = ?", filtro.Estado)
+	}
+
+	var sagas []dominio.SagaFaturamento
+	err := paginar(query.Order("data_inicio DESC"), filtro.Limite, filtro.Offset).Find(&sagas).Error
+	return sagas, traduzir(err)
+}
+
+func (r sagasPostgres) Atualizar(ctx context.Context, instancia *dominio.SagaFaturamento) error {
+	return traduzir(sessao(ctx, r.db).Model(&dominio.SagaFaturamento{}).
+		Where("id = ?", instancia.ID).
+		Updates(map[string]interface{}{
+			"estado":           instancia.Estado,
+			"passo_atual":      instancia.PassoAtual,
+			"data_atualizacao": instancia.DataAtualizacao,
+			"data_fim":         instancia.DataFim,
+		}).Error)
+}
+
+func (r sagasPostgres) AdicionarPasso(ctx context.Context, passo *dominio.PassoSaga) error {
+	carimbar(ctx, &passo.EmpresaID)
+	return traduzir(r.db.WithContext(ctx).Create(passo).Error)
+}
+
+// --- catalogo de produtos ---
+
+type produtosPostgres struct{ db *gorm.DB }
+
+func (r produtosPostgres) Buscar(ctx context.Context, id uuid.UUID) (dominio.ProdutoCatalogo, error) {
+	var produto dominio.ProdutoCatalogo
+	query := r.db.WithContext(ctx)
+	if empresa := EmpresaDe(ctx); empresa != "" {
+		// produtos sem empresa valem para todas
+		query = query.Where("empresa_id IN (?, '')", empresa)
+	}
+	err := query.First(&produto, "id = ?", id).Error
+	return produto, traduzir(err)
+}
+
+func (r produtosPostgres) Projetar(ctx context.Context, produto *dominio.ProdutoCatalogo) error {
+	carimbar(ctx, &produto.EmpresaID)
+	return traduzir(r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"empresa_id", "sku", "nome", "ativo", "data_evento", "id_evento"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "produtos_catalogo.data_evento <= excluded.data_evento"},
+		}},
+	}).Create(produto).Error)
+}
+
+// --- quarentena ---
+
+type quarentenaPostgres struct{ db *gorm.DB }
+
+func (r quarentenaPostgres) Criar(ctx context.Context, msg *dominio.MensagemQuarentena) error {
+	carimbar(ctx, &msg.EmpresaID)
+	return traduzir(r.db.WithContext(ctx).Create(msg).Error)
+}
+
+func (r quarentenaPostgres) Buscar(ctx context.Context, id uuid.UUID) (dominio.MensagemQuarentena, error) {
+	var msg dominio.MensagemQuarentena
+	err := sessao(ctx, r.db).First(&msg, "id = ?", id).Error
+	return msg, traduzir(err)
+}
+
+func (r quarentenaPostgres) BuscarPorMensagem(ctx context.Context, idMensagem string) (dominio.MensagemQuarentena, error) {
+	var msg dominio.MensagemQuarentena
+	err := sessao(ctx, r.db).Where("id_mensagem = ?", idMensagem).First(&msg).Error
+	return msg, traduzir(err)
+}
+
+func (r quarentenaPostgres) Listar(ctx context.Context, filtro FiltroQuarentena) ([]dominio.MensagemQuarentena, error) {
+	query := sessao(ctx, r.db).Model(&dominio.MensagemQuarentena{})
+	if filtro.Status != "" {
+		query = query.Where("status = ?", filtro.Status)
+	}
+	if filtro.RoutingKey != "" {
+		query = query.Where("routing_key = ?", filtro.RoutingKey)
+	}
+
+	var mensagens []dominio.MensagemQuarentena
+	err := paginar(query.Order("data_ultima DESC"), filtro.Limite, filtro.Offset).Find(&mensagens).Error
+	return mensagens, traduzir(err)
+}
+
+func (r quarentenaPostgres) Atualizar(ctx context.Context, msg *dominio.MensagemQuarentena) error {
+	return traduzir(sessao(ctx, r.db).Model(&dominio.MensagemQuarentena{}).
+		Where("id = ?", msg.ID).
+		Updates(map[string]interface{}{
+			"routing_key":     msg.RoutingKey,
+			"cabecalhos":      msg.Cabecalhos,
+			"corpo":           msg.Corpo,
+			"erro":            msg.Erro,
+			"tentativas":      msg.Tentativas,
+			"status":          msg.Status,
+			"motivo_descarte": msg.MotivoDescarte,
+			"editada":         msg.Editada,
+			"data_ultima":     msg.DataUltima,
+			"data_resolucao":  msg.DataResolucao,
+		}).Error)
+}
+
+func (r quarentenaPostgres) Resolver(ctx context.Context, id uuid.UUID, de, para string, motivo *string, quando time.Time) (bool, error) {
+	alteracoes := map[string]interface{}{
+		"status":         para,
+		"data_resolucao": quando,
+	}
+	if motivo != nil {
+		alteracoes["motivo_descarte"] = *motivo
+	}
+
+	res := sessao(ctx, r.db).Model(&dominio.MensagemQuarentena{}).
+		Where("id = ? AND status = ?", id, de).
+		Updates(alteracoes)
+	return res.RowsAffected > 0, traduzir(res.Error)
+}
+
+// --- auditoria ---
+
+type auditoriaPostgres struct{ db *gorm.DB }
+
+func (r auditoriaPostgres) Listar(ctx context.Context, notaID uuid.UUID, filtro FiltroAuditoria) ([]dominio.RegistroAuditoria, error) {
+	query := sessao(ctx, r.db).Where("nota_id = ?", notaID)
+	if filtro.Tabela != "" {
+		query = query.Where("tabela = ?", filtro.Tabela)
+	}
+
+	var registros []dominio.RegistroAuditoria
+	err := paginar(query.Order("id"), filtro.Limite, filtro.Offset).Find(&registros).Error
+	return registros, traduzir(err)
+}
@@ -0,0 +1,240 @@
+// Package repositorio isola o acesso a dados do servico. Handlers, consumidor,
+// saga e publicador falam so com estas interfaces: BancoPostgres as implementa
+// com GORM e BancoMemoria guarda tudo em memoria, para testar sem banco.
+package repositorio
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNaoEncontrado indica que nenhum registro corresponde a busca
+	ErrNaoEncontrado = errors.New("registro nao encontrado")
+	// ErrDuplicado indica violacao de uma chave unica (numero da nota,
+	// chave de idempotencia, mensagem da quarentena...)
+	ErrDuplicado = errors.New("registro duplicado")
+)
+
+// Repositorios agrupa os repositorios de um mesmo escopo: o banco inteiro ou
+// uma transacao aberta por Banco.Transacao
+type Repositorios interface {
+	Notas() Notas
+	Solicitacoes() Solicitacoes
+	Idempotencia() Idempotencia
+	Outbox() Outbox
+	Mensagens() MensagensProcessadas
+	Sagas() Sagas
+	Produtos() Produtos
+	Quarentena() Quarentena
+	Auditoria() Auditoria
+	// AoConfirmar agenda fn para depois do commit da transacao do escopo, para
+	// efeitos fora do banco que nao podem valer se ela for desfeita (metricas,
+	// por exemplo). Fora de transacao fn roda na hora.
+	AoConfirmar(fn func())
+}
+
+// Banco da acesso aos repositorios fora de transacao e abre transacoes para
+// escritas que precisam ser atomicas, como mudar um estado e gravar o evento
+// no outbox. Se fn retornar erro nada do que fez dentro dela e persistido.
+type Banco interface {
+	Repositorios
+	Transacao(ctx context.Context, fn func(r Repositorios) error) error
+	// Leitura devolve os repositorios para consultas que toleram atraso de
+	// replicacao: uma replica, se configurada, ou o proprio primario quando
+	// nao ha replicas ou o contexto foi fixado com FixarPrimario
+	Leitura(ctx context.Context) Repositorios
+}
+
+// Os metodos ...ParaAtualizar travam as linhas lidas (SELECT FOR UPDATE) ate o
+// fim da transacao; fora de uma transacao se comportam como a busca comum.
+
+// FiltroNotas restringe ListarNotas; campos vazios nao filtram
+type FiltroNotas struct {
+	Status string
+	// Arquivadas inclui as notas ja arquivadas, que por padrao ficam de fora
+	Arquivadas bool
+}
+
+// Notas guarda notas fiscais e seus itens. Buscas de nota trazem os itens.
+type Notas interface {
+	Criar(ctx context.Context, nota *dominio.NotaFiscal) error
+	Buscar(ctx context.Context, id uuid.UUID) (dominio.NotaFiscal, error)
+	BuscarParaAtualizar(ctx context.Context, id uuid.UUID) (dominio.NotaFiscal, error)
+	Listar(ctx context.Context, filtro FiltroNotas) ([]dominio.NotaFiscal, error)
+	// Percorrer entrega a fn as notas do filtro, mais antigas primeiro, sem
+	// carregar todas de uma vez; para no primeiro erro de fn e o retorna
+	Percorrer(ctx context.Context, filtro FiltroNotas, fn func(dominio.NotaFiscal) error) error
+	// Pesquisar faz a busca textual, mais relevantes primeiro; sem termos
+	// retorna vazio
+	Pesquisar(ctx context.Context, filtro FiltroPesquisa) ([]ResultadoPesquisa, error)
+	// Atualizar grava status e data de fechamento; os itens nao sao tocados
+	Atualizar(ctx context.Context, nota *dominio.NotaFiscal) error
+	AdicionarItem(ctx context.Context, item *dominio.ItemNota) error
+	Itens(ctx context.Context, notaID uuid.UUID) ([]dominio.ItemNota, error)
+	// GarantirParticoes cria, se faltarem, as particoes correntes de notas e
+	// itens do mes; sem particionamento (SQLite, memoria) nao faz nada
+	GarantirParticoes(ctx context.Context, mes time.Time) error
+	// PrepararArquivamento cria as particoes de arquivo dos meses com notas a
+	// arquivar antes de corte. DDL: fora da transacao dos lotes.
+	PrepararArquivamento(ctx context.Context, corte time.Time) error
+	// Arquivar marca como arquivadas ate limite notas FECHADA emitidas antes
+	// de corte, com seus itens, e retorna quantas de cada foram movidas
+	Arquivar(ctx context.Context, corte time.Time, limite int) (notas, itens int64, err error)
+}
+
+// Solicitacoes guarda as solicitacoes de impressao, suas retentativas e os
+// itens congelados em cada pedido. Buscas de solicitacao trazem os itens.
+type Solicitacoes interface {
+	// Criar retorna ErrDuplicado se a nota ja tem uma solicitacao PENDENTE.
+	// A chave de idempotencia nao e unica aqui: ver Idempotencia.
+	Criar(ctx context.Context, sol *dominio.SolicitacaoImpressao) error
+	Buscar(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error)
+	// BuscarComRetentativas preenche Retentativas em ordem de tentativa
+	BuscarComRetentativas(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error)
+	// BuscarPorChave retorna a mais recente com a chave
+	BuscarPorChave(ctx context.Context, chave string) (dominio.SolicitacaoImpressao, error)
+	BuscarParaAtualizar(ctx context.Context, id uuid.UUID) (dominio.SolicitacaoImpressao, error)
+	// UltimaTentativa retorna a tentativa mais recente da cadeia de raizID
+	UltimaTentativa(ctx context.Context, raizID uuid.UUID) (dominio.SolicitacaoImpressao, error)
+	// MaisRecente retorna a ultima solicitacao criada para a nota
+	MaisRecente(ctx context.Context, notaID uuid.UUID) (dominio.SolicitacaoImpressao, error)
+	// PendentesParaAtualizar retorna as pendentes da nota, mais recente primeiro
+	PendentesParaAtualizar(ctx context.Context, notaID uuid.UUID) ([]dominio.SolicitacaoImpressao, error)
+	// VencidasParaAtualizar retorna pendentes com prazo antes de agora, pulando
+	// as que outra transacao ja travou (SKIP LOCKED)
+	VencidasParaAtualizar(ctx context.Context, agora time.Time, limite int) ([]dominio.SolicitacaoImpressao, error)
+	ConcluirPendentes(ctx context.Context, notaID uuid.UUID, quando time.Time) error
+	Falhar(ctx context.Context, id uuid.UUID, motivo string) error
+	AtualizarTentativas(ctx context.Context, raizID uuid.UUID, tentativas int) error
+}
+
+// Idempotencia guarda as Idempotency-Key ja usadas, uma vez por empresa
+type Idempotencia interface {
+	// Registrar retorna ErrDuplicado se a empresa ja usou a chave
+	Registrar(ctx context.Context, chave *dominio.ChaveIdempotencia) error
+	Buscar(ctx context.Context, chave string) (dominio.ChaveIdempotencia, error)
+	// RemoverAntigas apaga ate limite chaves criadas antes de `antes`
+	RemoverAntigas(ctx context.Context, antes time.Time, limite int) (int64, error)
+}
+
+// FiltroOutbox restringe ListarOutbox. Situacao usa dominio.SituacaoOutbox*.
+type FiltroOutbox struct {
+	Situacao string
+	Tipo     string
+	Agregado *uuid.UUID
+	Limite   int
+	Offset   int
+}
+
+// Backlog resume os eventos que o publicador ainda deve enviar (nem
+// publicados nem estacionados)
+type Backlog struct {
+	Pendentes  int64
+	MaisAntigo *time.Time // DataOcorrencia do pendente mais antigo; nil sem pendentes
+}
+
+// Outbox guarda os eventos a publicar e o resultado de cada tentativa
+type Outbox interface {
+	Adicionar(ctx context.Context, evt *dominio.EventoOutbox) error
+	Buscar(ctx context.Context, id int64) (dominio.EventoOutbox, error)
+	// BuscarParaAtualizar trava o evento ate o fim da transacao
+	BuscarParaAtualizar(ctx context.Context, id int64) (dominio.EventoOutbox, error)
+	Listar(ctx context.Context, filtro FiltroOutbox) ([]dominio.EventoOutbox, error)
+	// ProntosParaPublicar ignora publicados, estacionados e os que aguardam
+	// backoff. Em transacao trava o lote e pula as linhas que outra transacao
+	// ja travou, para duas instancias nao publicarem o mesmo evento.
+	ProntosParaPublicar(ctx context.Context, agora time.Time, limite int) ([]dominio.EventoOutbox, error)
+	// RegistrarFalha grava Tentativas, UltimoErro e ProximaTentativa do evento
+	RegistrarFalha(ctx context.Context, evt *dominio.EventoOutbox) error
+	// RegistrarPublicacao grava DataPublicacao e Tentativas e tira o evento
+	// do backoff e do estacionamento
+	RegistrarPublicacao(ctx context.Context, evt *dominio.EventoOutbox) error
+	// Estacionar retorna false se o evento ja foi publicado ou estacionado
+	Estacionar(ctx context.Context, id int64, motivo string, quando time.Time) (bool, error)
+	Backlog(ctx context.Context) (Backlog, error)
+	// RemoverPublicados apaga ate limite eventos publicados antes de `antes`,
+	// copiando-os para eventos_outbox_arquivo quando arquivar; em transacao a
+	// copia e a remocao vao juntas
+	RemoverPublicados(ctx context.Context, antes time.Time, limite int, arquivar bool) (int64, error)
+}
+
+// MensagensProcessadas deduplica as mensagens consumidas
+type MensagensProcessadas interface {
+	Processada(ctx context.Context, idMensagem string) (bool, error)
+	Registrar(ctx context.Context, msg *dominio.MensagemProcessada) error
+	// RemoverAntigas apaga ate limite mensagens processadas antes de `antes`
+	RemoverAntigas(ctx context.Context, antes time.Time, limite int) (int64, error)
+}
+
+// FiltroSagas restringe ListarSagas; campos vazios nao filtram
+type FiltroSagas struct {
+	NotaID        *uuid.UUID
+	SolicitacaoID *uuid.UUID
+	Correlacao    string
+	Estado        string
+	Limite        int
+	Offset        int
+}
+
+// Sagas guarda o log das sagas de impressao
+type Sagas interface {
+	Criar(ctx context.Context, instancia *dominio.SagaFaturamento) error
+	// Buscar preenche Passos em ordem cronologica
+	Buscar(ctx context.Context, id uuid.UUID) (dominio.SagaFaturamento, error)
+	BuscarPorSolicitacao(ctx context.Context, solicitacaoID uuid.UUID) (dominio.SagaFaturamento, error)
+	Listar(ctx context.Context, filtro FiltroSagas) ([]dominio.SagaFaturamento, error)
+	// Atualizar grava estado, passo atual e datas
+	Atualizar(ctx context.Context, instancia *dominio.SagaFaturamento) error
+	AdicionarPasso(ctx context.Context, passo *dominio.PassoSaga) error
+}
+
+// Produtos guarda a projecao local do catalogo do Estoque
+type Produtos interface {
+	Buscar(ctx context.Context, id uuid.UUID) (dominio.ProdutoCatalogo, error)
+	// Projetar insere ou atualiza o produto, a menos que o gravado venha de um
+	// evento mais novo
+	Projetar(ctx context.Context, produto *dominio.ProdutoCatalogo) error
+}
+
+// FiltroQuarentena restringe ListarQuarentena; campos vazios nao filtram
+type FiltroQuarentena struct {
+	Status     string
+	RoutingKey string
+	Limite     int
+	Offset     int
+}
+
+// Quarentena guarda as entregas que falharam no consumidor
+type Quarentena interface {
+	Criar(ctx context.Context, msg *dominio.MensagemQuarentena) error
+	Buscar(ctx context.Context, id uuid.UUID) (dominio.MensagemQuarentena, error)
+	BuscarPorMensagem(ctx context.Context, idMensagem string) (dominio.MensagemQuarentena, error)
+	Listar(ctx context.Context, filtro FiltroQuarentena) ([]dominio.MensagemQuarentena, error)
+	// Atualizar grava todos os campos do registro
+	Atualizar(ctx context.Context, msg *dominio.MensagemQuarentena) error
+	// Resolver move o registro de `de` para `para` e retorna false se ele ja
+	// nao estava em `de`
+	Resolver(ctx context.Context, id uuid.UUID, de, para string, motivo *string, quando time.Time) (bool, error)
+}
+
+// FiltroAuditoria restringe a trilha de uma nota; campos vazios nao filtram
+type FiltroAuditoria struct {
+	Tabela string
+	Limite int
+	Offset int
+}
+
+// Auditoria le a trilha das escritas em notas, itens e solicitacoes. Os
+// registros sao gravados pelos proprios repositorios Notas e Solicitacoes, na
+// mesma transacao da escrita, com a autoria de ComAutoria.
+type Auditoria interface {
+	// Listar retorna a trilha da nota, de seus itens e de suas solicitacoes,
+	// em ordem cronologica
+	Listar(ctx context.Context, notaID uuid.UUID, filtro FiltroAuditoria) ([]dominio.RegistroAuditoria, error)
+}
@@ -0,0 +1,702 @@
+package repositorio_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+)
+
+// cadaBanco roda o mesmo caso contra as duas implementacoes: a de memoria
+// precisa se comportar como a do banco para os testes que a usam valerem
+func cadaBanco(t *testing.T, caso func(t *testing.T, banco repositorio.Banco)) {
+	t.Run("postgres", func(t *testing.T) {
+		caso(t, repositorio.NovoBancoPostgres(bdteste.Abrir(t)))
+	})
+	t.Run("memoria", func(t *testing.T) {
+		caso(t, repositorio.NovoBancoMemoria())
+	})
+}
+
+func criarNota(t *testing.T, banco repositorio.Banco, numero string, itens int) dominio.NotaFiscal {
+	t.Helper()
+	ctx := context.Background()
+
+	nota := dominio.NotaFiscal{Numero: numero, Status: dominio.StatusNotaAberta}
+	if err := banco.Notas().Criar(ctx, &nota); err != nil {
+		t.Fatalf("falha ao criar nota: %v", err)
+	}
+	for i := 0; i < itens; i++ {
+		item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: i + 1, PrecoUnitario: 10}
+		if err := banco.Notas().AdicionarItem(ctx, &item); err != nil {
+			t.Fatalf("falha ao adicionar item: %v", err)
+		}
+	}
+	return nota
+}
+
+func TestNotas(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		nota := criarNota(t, banco, "NF-1", 2)
+		criarNota(t, banco, "NF-2", 0)
+
+		duplicada := dominio.NotaFiscal{Numero: "NF-1", Status: dominio.StatusNotaAberta}
+		if err := banco.Notas().Criar(ctx, &duplicada); !errors.Is(err, repositorio.ErrDuplicado) {
+			t.Errorf("esperava ErrDuplicado para numero repetido, obteve %v", err)
+		}
+
+		lida, err := banco.Notas().Buscar(ctx, nota.ID)
+		if err != nil || len(lida.Itens) != 2 {
+			t.Fatalf("esperava nota com 2 itens, obteve %+v (%v)", lida, err)
+		}
+		if _, err := banco.Notas().Buscar(ctx, uuid.New()); !errors.Is(err, repositorio.ErrNaoEncontrado) {
+			t.Errorf("esperava ErrNaoEncontrado, obteve %v", err)
+		}
+
+		if err := lida.Fechar(); err != nil {
+			t.Fatalf("falha ao fechar: %v", err)
+		}
+		if err := banco.Notas().Atualizar(ctx, &lida); err != nil {
+			t.Fatalf("falha ao atualizar: %v", err)
+		}
+
+		fechadas, err := banco.Notas().Listar(ctx, repositorio.FiltroNotas{Status: dominio.StatusNotaFechada})
+		if err != nil || len(fechadas) != 1 || fechadas[0].ID != nota.ID || fechadas[0].DataFechada == nil {
+			t.Errorf("esperava so NF-1 fechada, obteve %+v (%v)", fechadas, err)
+		}
+		if todas, _ := banco.Notas().Listar(ctx, repositorio.FiltroNotas{}); len(todas) != 2 {
+			t.Errorf("esperava 2 notas sem filtro, obteve %d", len(todas))
+		}
+	})
+}
+
+func TestNotas_Percorrer(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		base := time.Now().Add(-time.Hour).Truncate(time.Second)
+		// mais de um lote, com datas repetidas para o desempate por id
+		const total = 501
+		for i := 0; i < total; i++ {
+			nota := dominio.NotaFiscal{Numero: fmt.Sprintf("NF-P%03d", i), Status: dominio.StatusNotaAberta, DataCriacao: base.Add(time.Duration(i%3) * time.Second)}
+			if err := banco.Notas().Criar(ctx, &nota); err != nil {
+				t.Fatalf("falha ao criar nota: %v", err)
+			}
+		}
+		comItens := criarNota(t, banco, "NF-ITENS", 2)
+
+		vistas := map[uuid.UUID]bool{}
+		var anterior time.Time
+		err := banco.Notas().Percorrer(ctx, repositorio.FiltroNotas{}, func(n dominio.NotaFiscal) error {
+			if vistas[n.ID] {
+				t.Errorf("nota %s entregue duas vezes", n.Numero)
+			}
+			vistas[n.ID] = true
+			if n.DataCriacao.Before(anterior) {
+				t.Errorf("nota %s fora de ordem", n.Numero)
+			}
+			anterior = n.DataCriacao
+			if n.ID == comItens.ID && len(n.Itens) != 2 {
+				t.Errorf("esperava os 2 itens de NF-ITENS, obteve %d", len(n.Itens))
+			}
+			return nil
+		})
+		if err != nil || len(vistas) != total+1 {
+			t.Fatalf("esperava %d notas, obteve %d (%v)", total+1, len(vistas), err)
+		}
+
+		parar := errors.New("parar")
+		entregues := 0
+		err = banco.Notas().Percorrer(ctx, repositorio.FiltroNotas{}, func(dominio.NotaFiscal) error {
+			entregues++
+			return parar
+		})
+		if !errors.Is(err, parar) || entregues != 1 {
+			t.Errorf("esperava parar no primeiro erro, obteve %v apos %d notas", err, entregues)
+		}
+	})
+}
+
+func TestTransacao_ErroDesfazTudo(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		nota := criarNota(t, banco, "NF-TX", 1)
+		falha := errors.New("falha no meio")
+
+		err := banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+			sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-tx"}
+			if err := r.Solicitacoes().Criar(ctx, &sol); err != nil {
+				return err
+			}
+			evt, _ := dominio.NovoEventoOutbox(dominio.EventoImpressaoSolicitada, nota.ID, map[string]string{}, dominio.NovoContextoEvento(""))
+			if err := r.Outbox().Adicionar(ctx, &evt); err != nil {
+				return err
+			}
+			return falha
+		})
+		if !errors.Is(err, falha) {
+			t.Fatalf("esperava o erro da funcao, obteve %v", err)
+		}
+
+		if _, err := banco.Solicitacoes().BuscarPorChave(ctx, "k-tx"); !errors.Is(err, repositorio.ErrNaoEncontrado) {
+			t.Errorf("solicitacao deveria ter sido desfeita, obteve %v", err)
+		}
+		if eventos, _ := banco.Outbox().Listar(ctx, repositorio.FiltroOutbox{}); len(eventos) != 0 {
+			t.Errorf("evento deveria ter sido desfeito, obteve %d", len(eventos))
+		}
+	})
+}
+
+func TestTransacao_AoConfirmar(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		var rodadas []string
+
+		banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+			r.AoConfirmar(func() { rodadas = append(rodadas, "desfeita") })
+			return errors.New("falha")
+		})
+		err := banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+			r.AoConfirmar(func() { rodadas = append(rodadas, "confirmada") })
+			if len(rodadas) != 0 {
+				t.Error("AoConfirmar rodou antes do commit")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("falha na transacao: %v", err)
+		}
+		banco.AoConfirmar(func() { rodadas = append(rodadas, "fora") })
+
+		if len(rodadas) != 2 || rodadas[0] != "confirmada" || rodadas[1] != "fora" {
+			t.Errorf("esperava so a confirmada e a de fora de transacao, obteve %v", rodadas)
+		}
+	})
+}
+
+func TestSolicitacoes(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		nota := criarNota(t, banco, "NF-SOL", 1)
+		agora := time.Now()
+
+		vencida := agora.Add(-time.Minute)
+		antiga := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-1", PrazoExpiracao: &vencida, DataCriacao: agora.Add(-time.Hour)}
+		if err := banco.Solicitacoes().Criar(ctx, &antiga); err != nil {
+			t.Fatalf("falha ao criar: %v", err)
+		}
+
+		segunda := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-2"}
+		if err := banco.Solicitacoes().Criar(ctx, &segunda); !errors.Is(err, repositorio.ErrDuplicado) {
+			t.Errorf("esperava ErrDuplicado para segunda pendente da nota, obteve %v", err)
+		}
+
+		pendentes, _ := banco.Solicitacoes().PendentesParaAtualizar(ctx, nota.ID)
+		if len(pendentes) != 1 || pendentes[0].ID != antiga.ID {
+			t.Errorf("esperava so a pendente original, obteve %+v", pendentes)
+		}
+
+		vencidas, _ := banco.Solicitacoes().VencidasParaAtualizar(ctx, agora, 10)
+		if len(vencidas) != 1 || vencidas[0].ID != antiga.ID {
+			t.Errorf("esperava so a solicitacao vencida, obteve %+v", vencidas)
+		}
+
+		// cadeia de retentativas
+		banco.Solicitacoes().Falhar(ctx, antiga.ID, "sem estoque")
+		origem := antiga.ID
+		retentativa := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-1:2", SolicitacaoOrigemID: &origem, Tentativa: 2, DataCriacao: agora}
+		if err := banco.Solicitacoes().Criar(ctx, &retentativa); err != nil {
+			t.Fatalf("pendente anterior falhou, a retentativa deveria ser criada: %v", err)
+		}
+		banco.Solicitacoes().AtualizarTentativas(ctx, antiga.ID, 2)
+
+		if ultima, _ := banco.Solicitacoes().MaisRecente(ctx, nota.ID); ultima.ID != retentativa.ID {
+			t.Errorf("esperava a mais recente %s, obteve %s", retentativa.ID, ultima.ID)
+		}
+
+		if ultima, _ := banco.Solicitacoes().UltimaTentativa(ctx, antiga.ID); ultima.ID != retentativa.ID {
+			t.Errorf("esperava a retentativa como ultima tentativa, obteve %s", ultima.ID)
+		}
+		raiz, err := banco.Solicitacoes().BuscarComRetentativas(ctx, antiga.ID)
+		if err != nil || raiz.Status != dominio.StatusSolicitacaoFalhou || raiz.Tentativas != 2 || len(raiz.Retentativas) != 1 {
+			t.Errorf("raiz inesperada: %+v (%v)", raiz, err)
+		}
+
+		banco.Solicitacoes().ConcluirPendentes(ctx, nota.ID, agora)
+		if concluida, _ := banco.Solicitacoes().Buscar(ctx, retentativa.ID); concluida.Status != dominio.StatusSolicitacaoConcluida || concluida.DataConclusao == nil {
+			t.Errorf("esperava pendente concluida, obteve %+v", concluida)
+		}
+		if falhou, _ := banco.Solicitacoes().Buscar(ctx, antiga.ID); falhou.Status != dominio.StatusSolicitacaoFalhou {
+			t.Errorf("solicitacao FALHOU nao deveria ser concluida, obteve %s", falhou.Status)
+		}
+	})
+}
+
+func TestIdempotencia(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := repositorio.ComEmpresa(context.Background(), "matriz")
+		nota := criarNota(t, banco, "NF-CHAVE", 1)
+		sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-chave"}
+		banco.Solicitacoes().Criar(ctx, &sol)
+
+		chave := dominio.ChaveIdempotencia{Chave: "k-chave", NotaID: nota.ID, Operacao: dominio.OperacaoReprocessar, HashRequisicao: "h1", SolicitacaoID: sol.ID}
+		if err := banco.Idempotencia().Registrar(ctx, &chave); err != nil {
+			t.Fatalf("falha ao registrar: %v", err)
+		}
+		repetida := dominio.ChaveIdempotencia{Chave: "k-chave", NotaID: uuid.New(), Operacao: dominio.OperacaoImprimir, SolicitacaoID: sol.ID}
+		if err := banco.Idempotencia().Registrar(ctx, &repetida); !errors.Is(err, repositorio.ErrDuplicado) {
+			t.Errorf("esperava ErrDuplicado para chave repetida, obteve %v", err)
+		}
+
+		lida, err := banco.Idempotencia().Buscar(ctx, "k-chave")
+		if err != nil || lida.SolicitacaoID != sol.ID || lida.EmpresaID != "matriz" || !lida.MesmoAlvo(nota.ID, dominio.OperacaoReprocessar) || !lida.MesmaRequisicao("h1") {
+			t.Errorf("chave inesperada: %+v (%v)", lida, err)
+		}
+		if lida.MesmoAlvo(nota.ID, dominio.OperacaoImprimir) {
+			t.Error("outra operacao nao deveria ser o mesmo alvo")
+		}
+		if lida.MesmaRequisicao("h2") {
+			t.Error("outro hash nao deveria ser a mesma requisicao")
+		}
+
+		// a mesma chave e livre em outra empresa
+		filial := repositorio.ComEmpresa(context.Background(), "filial")
+		if _, err := banco.Idempotencia().Buscar(filial, "k-chave"); !errors.Is(err, repositorio.ErrNaoEncontrado) {
+			t.Errorf("chave de outra empresa nao deveria aparecer, obteve %v", err)
+		}
+		outra := dominio.ChaveIdempotencia{Chave: "k-chave", NotaID: nota.ID, Operacao: dominio.OperacaoImprimir, SolicitacaoID: sol.ID}
+		if err := banco.Idempotencia().Registrar(filial, &outra); err != nil {
+			t.Errorf("esperava registrar a chave em outra empresa, obteve %v", err)
+		}
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		agora := time.Now()
+
+		var eventos []dominio.EventoOutbox
+		for i := 0; i < 3; i++ {
+			evt, _ := dominio.NovoEventoOutbox(dominio.EventoNotaFechada, uuid.New(), map[string]int{"i": i}, dominio.NovoContextoEvento(""))
+			if err := banco.Outbox().Adicionar(ctx, &evt); err != nil {
+				t.Fatalf("falha ao adicionar: %v", err)
+			}
+			eventos = append(eventos, evt)
+		}
+
+		// um em backoff, um estacionado, um pronto
+		erro := "canal fechado"
+		proxima := agora.Add(time.Minute)
+		eventos[0].Tentativas, eventos[0].UltimoErro, eventos[0].ProximaTentativa = 1, &erro, &proxima
+		banco.Outbox().RegistrarFalha(ctx, &eventos[0])
+
+		if ok, _ := banco.Outbox().Estacionar(ctx, eventos[1].ID, "payload invalido", agora); !ok {
+			t.Error("esperava estacionar o evento")
+		}
+		if ok, _ := banco.Outbox().Estacionar(ctx, eventos[1].ID, "de novo", agora); ok {
+			t.Error("evento ja estacionado nao deveria ser estacionado de novo")
+		}
+
+		prontos, _ := banco.Outbox().ProntosParaPublicar(ctx, agora, 10)
+		if len(prontos) != 1 || prontos[0].ID != eventos[2].ID {
+			t.Errorf("esperava so o terceiro evento pronto, obteve %+v", prontos)
+		}
+
+		falhos, _ := banco.Outbox().Listar(ctx, repositorio.FiltroOutbox{Situacao: dominio.SituacaoOutboxFalhou})
+		pendentes, _ := banco.Outbox().Listar(ctx, repositorio.FiltroOutbox{Situacao: dominio.SituacaoOutboxPendente})
+		if len(falhos) != 1 || len(pendentes) != 2 {
+			t.Errorf("esperava 1 falho e 2 pendentes, obteve %d e %d", len(falhos), len(pendentes))
+		}
+		backlog, err := banco.Outbox().Backlog(ctx)
+		if err != nil || backlog.Pendentes != 2 || backlog.MaisAntigo == nil || backlog.MaisAntigo.Sub(eventos[0].DataOcorrencia).Abs() > time.Millisecond {
+			t.Errorf("backlog deveria contar o falho e o pronto, a partir do primeiro: %+v (%v)", backlog, err)
+		}
+
+		eventos[1].Tentativas = 1
+		eventos[1].DataPublicacao = &agora
+		banco.Outbox().RegistrarPublicacao(ctx, &eventos[1])
+		publicado, _ := banco.Outbox().Buscar(ctx, eventos[1].ID)
+		if publicado.Situacao() != dominio.SituacaoOutboxPublicado || publicado.MotivoEstacionamento != nil {
+			t.Errorf("publicacao deveria tirar o evento do estacionamento, obteve %+v", publicado)
+		}
+
+		pagina, _ := banco.Outbox().Listar(ctx, repositorio.FiltroOutbox{Limite: 1, Offset: 1})
+		if len(pagina) != 1 || pagina[0].ID != eventos[1].ID {
+			t.Errorf("paginacao inesperada: %+v", pagina)
+		}
+	})
+}
+
+func TestMensagensProcessadas(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+
+		if ok, _ := banco.Mensagens().Processada(ctx, "msg-1"); ok {
+			t.Error("mensagem nova nao deveria constar como processada")
+		}
+		banco.Mensagens().Registrar(ctx, &dominio.MensagemProcessada{IDMensagem: "msg-1", DataProcessada: time.Now()})
+		if ok, _ := banco.Mensagens().Processada(ctx, "msg-1"); !ok {
+			t.Error("esperava mensagem processada")
+		}
+		if err := banco.Mensagens().Registrar(ctx, &dominio.MensagemProcessada{IDMensagem: "msg-1", DataProcessada: time.Now()}); !errors.Is(err, repositorio.ErrDuplicado) {
+			t.Errorf("esperava ErrDuplicado, obteve %v", err)
+		}
+	})
+}
+
+func TestRemocaoDeAntigos(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		agora := time.Now()
+		antigo := agora.Add(-48 * time.Hour)
+		corte := agora.Add(-24 * time.Hour)
+
+		var publicados []dominio.EventoOutbox
+		for i := 0; i < 3; i++ {
+			evt, _ := dominio.NovoEventoOutbox(dominio.EventoNotaFechada, uuid.New(), map[string]int{"i": i}, dominio.NovoContextoEvento(""))
+			banco.Outbox().Adicionar(ctx, &evt)
+			evt.Tentativas, evt.DataPublicacao = 1, &antigo
+			banco.Outbox().RegistrarPublicacao(ctx, &evt)
+			publicados = append(publicados, evt)
+		}
+		pendente, _ := dominio.NovoEventoOutbox(dominio.EventoNotaFechada, uuid.New(), map[string]int{}, dominio.NovoContextoEvento(""))
+		banco.Outbox().Adicionar(ctx, &pendente)
+
+		if n, err := banco.Outbox().RemoverPublicados(ctx, corte, 2, true); err != nil || n != 2 {
+			t.Errorf("esperava remover um lote de 2 eventos, obteve %d (%v)", n, err)
+		}
+		if n, _ := banco.Outbox().RemoverPublicados(ctx, corte, 2, false); n != 1 {
+			t.Errorf("esperava remover o evento publicado restante, obteve %d", n)
+		}
+		if _, err := banco.Outbox().Buscar(ctx, pendente.ID); err != nil {
+			t.Errorf("evento pendente nao deveria ser removido: %v", err)
+		}
+
+		banco.Mensagens().Registrar(ctx, &dominio.MensagemProcessada{IDMensagem: "antiga", DataProcessada: antigo})
+		banco.Mensagens().Registrar(ctx, &dominio.MensagemProcessada{IDMensagem: "recente", DataProcessada: agora})
+		if n, err := banco.Mensagens().RemoverAntigas(ctx, corte, 10); err != nil || n != 1 {
+			t.Errorf("esperava remover 1 mensagem, obteve %d (%v)", n, err)
+		}
+		if ok, _ := banco.Mensagens().Processada(ctx, "recente"); !ok {
+			t.Error("mensagem recente nao deveria ser removida")
+		}
+
+		// a mesma chave em duas empresas: so a antiga sai
+		filial := repositorio.ComEmpresa(context.Background(), "filial")
+		banco.Idempotencia().Registrar(ctx, &dominio.ChaveIdempotencia{Chave: "k", NotaID: uuid.New(), Operacao: dominio.OperacaoImprimir, SolicitacaoID: uuid.New(), DataCriacao: antigo})
+		banco.Idempotencia().Registrar(filial, &dominio.ChaveIdempotencia{Chave: "k", NotaID: uuid.New(), Operacao: dominio.OperacaoImprimir, SolicitacaoID: uuid.New(), DataCriacao: agora})
+		if n, err := banco.Idempotencia().RemoverAntigas(ctx, corte, 10); err != nil || n != 1 {
+			t.Errorf("esperava remover 1 chave, obteve %d (%v)", n, err)
+		}
+		if _, err := banco.Idempotencia().Buscar(filial, "k"); err != nil {
+			t.Errorf("chave recente de outra empresa nao deveria ser removida: %v", err)
+		}
+	})
+}
+
+func TestNotas_Arquivar(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		agora := time.Now()
+
+		var fechadas []dominio.NotaFiscal
+		for _, numero := range []string{"NF-A1", "NF-A2"} {
+			nota := dominio.NotaFiscal{Numero: numero, Status: dominio.StatusNotaFechada, DataCriacao: agora.AddDate(-2, 0, 0),
+				Itens: []dominio.ItemNota{{ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 1}}}
+			if err := banco.Notas().Criar(ctx, &nota); err != nil {
+				t.Fatalf("falha ao criar nota: %v", err)
+			}
+			fechadas = append(fechadas, nota)
+		}
+		aberta := dominio.NotaFiscal{Numero: "NF-A3", Status: dominio.StatusNotaAberta, DataCriacao: agora.AddDate(-2, 0, 0)}
+		banco.Notas().Criar(ctx, &aberta)
+
+		corte := agora.AddDate(-1, 0, 0)
+		if err := banco.Notas().PrepararArquivamento(ctx, corte); err != nil {
+			t.Fatalf("falha ao preparar: %v", err)
+		}
+		if notas, itens, err := banco.Notas().Arquivar(ctx, corte, 1); err != nil || notas != 1 || itens != 1 {
+			t.Errorf("esperava um lote de 1 nota e 1 item, obteve %d e %d (%v)", notas, itens, err)
+		}
+		if notas, _, _ := banco.Notas().Arquivar(ctx, corte, 10); notas != 1 {
+			t.Errorf("esperava arquivar a outra fechada, obteve %d", notas)
+		}
+
+		nota, _ := banco.Notas().Buscar(ctx, fechadas[0].ID)
+		if !nota.Arquivada || len(nota.Itens) != 1 || !nota.Itens[0].Arquivada {
+			t.Errorf("esperava nota e item arquivados, obteve %+v", nota)
+		}
+		if nota, _ := banco.Notas().Buscar(ctx, aberta.ID); nota.Arquivada {
+			t.Error("nota aberta nao deveria ser arquivada")
+		}
+	})
+}
+
+func TestProdutos_EventoAntigoNaoSobrescreve(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		id := uuid.New()
+		agora := time.Now()
+
+		banco.Produtos().Projetar(ctx, &dominio.ProdutoCatalogo{ID: id, Sku: "SKU-2", Nome: "Novo", Ativo: false, DataEvento: agora})
+		banco.Produtos().Projetar(ctx, &dominio.ProdutoCatalogo{ID: id, Sku: "SKU-1", Nome: "Velho", Ativo: true, DataEvento: agora.Add(-time.Minute)})
+
+		produto, err := banco.Produtos().Buscar(ctx, id)
+		if err != nil || produto.Sku != "SKU-2" || produto.Ativo {
+			t.Errorf("evento antigo sobrescreveu o catalogo: %+v (%v)", produto, err)
+		}
+	})
+}
+
+func TestQuarentena_ResolverCondicional(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+
+		msg := dominio.MensagemQuarentena{IDMensagem: "m-1", RoutingKey: "Estoque.Reservado", Cabecalhos: "{}", Status: dominio.StatusQuarentena, Tentativas: 3}
+		if err := banco.Quarentena().Criar(ctx, &msg); err != nil {
+			t.Fatalf("falha ao criar: %v", err)
+		}
+		repetida := dominio.MensagemQuarentena{IDMensagem: "m-1", RoutingKey: "x", Cabecalhos: "{}"}
+		if err := banco.Quarentena().Criar(ctx, &repetida); !errors.Is(err, repositorio.ErrDuplicado) {
+			t.Errorf("esperava ErrDuplicado, obteve %v", err)
+		}
+
+		motivo := "duplicada no Estoque"
+		if ok, _ := banco.Quarentena().Resolver(ctx, msg.ID, dominio.StatusQuarentena, dominio.StatusQuarentenaDescartada, &motivo, time.Now()); !ok {
+			t.Fatal("esperava descartar a mensagem")
+		}
+		if ok, _ := banco.Quarentena().Resolver(ctx, msg.ID, dominio.StatusQuarentena, dominio.StatusQuarentenaReprocessada, nil, time.Now()); ok {
+			t.Error("mensagem ja descartada nao deveria ser resolvida de novo")
+		}
+
+		lida, _ := banco.Quarentena().BuscarPorMensagem(ctx, "m-1")
+		if lida.Status != dominio.StatusQuarentenaDescartada || lida.MotivoDescarte == nil || *lida.MotivoDescarte != motivo {
+			t.Errorf("registro inesperado: %+v", lida)
+		}
+	})
+}
+
+func TestSagas(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := context.Background()
+		solicitacao := uuid.New()
+
+		instancia := dominio.SagaFaturamento{SolicitacaoID: solicitacao, NotaID: uuid.New(), IDCorrelacao: "corr-1", Estado: dominio.EstadoSagaAguardandoEstoque}
+		if err := banco.Sagas().Criar(ctx, &instancia); err != nil {
+			t.Fatalf("falha ao criar saga: %v", err)
+		}
+		outra := dominio.SagaFaturamento{SolicitacaoID: solicitacao, NotaID: instancia.NotaID, Estado: dominio.EstadoSagaAguardandoEstoque}
+		if err := banco.Sagas().Criar(ctx, &outra); !errors.Is(err, repositorio.ErrDuplicado) {
+			t.Errorf("esperava uma saga por solicitacao, obteve %v", err)
+		}
+
+		inicio := time.Now()
+		for i, nome := range []string{"Faturamento.ImpressaoSolicitada", "Estoque.Reservado"} {
+			passo := dominio.PassoSaga{SagaID: instancia.ID, Nome: nome, Direcao: "ENVIADO", EstadoResultante: instancia.Estado, Data: inicio.Add(time.Duration(i) * time.Second)}
+			if err := banco.Sagas().AdicionarPasso(ctx, &passo); err != nil {
+				t.Fatalf("falha ao adicionar passo: %v", err)
+			}
+		}
+
+		instancia.Estado = dominio.EstadoSagaConcluida
+		instancia.DataFim = &inicio
+		banco.Sagas().Atualizar(ctx, &instancia)
+
+		lida, err := banco.Sagas().Buscar(ctx, instancia.ID)
+		if err != nil || lida.Estado != dominio.EstadoSagaConcluida || len(lida.Passos) != 2 || lida.Passos[0].Nome != "Faturamento.ImpressaoSolicitada" {
+			t.Errorf("saga inesperada: %+v (%v)", lida, err)
+		}
+
+		sagas, _ := banco.Sagas().Listar(ctx, repositorio.FiltroSagas{Correlacao: "corr-1", Estado: dominio.EstadoSagaConcluida})
+		if len(sagas) != 1 {
+			t.Errorf("esperava 1 saga pelo filtro, obteve %d", len(sagas))
+		}
+	})
+}
+
+func TestLeitura_ReplicaSalvoPrimarioFixado(t *testing.T) {
+	ctx := context.Background()
+	primario := repositorio.NovoBancoPostgres(bdteste.Abrir(t))
+	replica := repositorio.NovoBancoPostgres(bdteste.Abrir(t))
+	banco := repositorio.NovoBancoPostgres(bdteste.Abrir(t), bdteste.Abrir(t))
+
+	// bancos separados simulam uma replica que ainda nao recebeu a escrita
+	nota := criarNota(t, primario, "NF-R", 0)
+	if _, err := replica.Leitura(ctx).Notas().Buscar(ctx, nota.ID); !errors.Is(err, repositorio.ErrNaoEncontrado) {
+		t.Fatalf("bancos deveriam ser independentes, obteve %v", err)
+	}
+
+	escrita := criarNota(t, banco, "NF-P", 0)
+	if _, err := banco.Leitura(ctx).Notas().Buscar(ctx, escrita.ID); !errors.Is(err, repositorio.ErrNaoEncontrado) {
+		t.Errorf("leitura deveria ir para a replica, obteve %v", err)
+	}
+	if _, err := banco.Leitura(repositorio.FixarPrimario(ctx)).Notas().Buscar(ctx, escrita.ID); err != nil {
+		t.Errorf("leitura com primario fixado deveria achar a escrita, obteve %v", err)
+	}
+}
+
+func TestAuditoria(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		ctx := repositorio.ComAutoria(context.Background(), dominio.Autoria{Ator: "ana", IDRequisicao: "req-1"})
+		nota := criarNota(t, banco, "NF-AUD", 0)
+
+		item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 10}
+		if err := banco.Notas().AdicionarItem(ctx, &item); err != nil {
+			t.Fatalf("falha ao adicionar item: %v", err)
+		}
+		sol := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k-aud"}
+		if err := banco.Solicitacoes().Criar(ctx, &sol); err != nil {
+			t.Fatalf("falha ao criar solicitacao: %v", err)
+		}
+		if err := banco.Solicitacoes().Falhar(ctx, sol.ID, "sem estoque"); err != nil {
+			t.Fatalf("falha ao falhar solicitacao: %v", err)
+		}
+
+		// escrita desfeita nao deixa rastro na trilha
+		banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+			r.Solicitacoes().AtualizarTentativas(ctx, sol.ID, 9)
+			return errors.New("desfaz")
+		})
+
+		trilha, err := banco.Auditoria().Listar(ctx, nota.ID, repositorio.FiltroAuditoria{})
+		if err != nil || len(trilha) != 4 {
+			t.Fatalf("esperava 4 registros, obteve %+v (%v)", trilha, err)
+		}
+		esperado := []struct{ tabela, operacao, ator string }{
+			{"notas_fiscais", dominio.OperacaoAuditoriaInsercao, dominio.AtorSistema},
+			{"itens_nota", dominio.OperacaoAuditoriaInsercao, "ana"},
+			{"solicitacoes_impressao", dominio.OperacaoAuditoriaInsercao, "ana"},
+			{"solicitacoes_impressao", dominio.OperacaoAuditoriaAlteracao, "ana"},
+		}
+		for i, e := range esperado {
+			if trilha[i].Tabela != e.tabela || trilha[i].Operacao != e.operacao || trilha[i].Ator != e.ator {
+				t.Errorf("registro %d: esperava %+v, obteve %+v", i, e, trilha[i])
+			}
+		}
+		var campos map[string]dominio.AlteracaoCampo
+		json.Unmarshal([]byte(trilha[3].Alteracoes), &campos)
+		if len(campos) != 2 || campos["status"].Depois != dominio.StatusSolicitacaoFalhou || campos["mensagemErro"].Depois != "sem estoque" {
+			t.Errorf("esperava status e mensagemErro alterados, obteve %+v", campos)
+		}
+		if trilha[3].RegistroID != sol.ID || trilha[3].IDRequisicao != "req-1" {
+			t.Errorf("registro deveria apontar a solicitacao e a requisicao, obteve %+v", trilha[3])
+		}
+
+		itens, _ := banco.Auditoria().Listar(ctx, nota.ID, repositorio.FiltroAuditoria{Tabela: "itens_nota"})
+		if len(itens) != 1 || itens[0].RegistroID != item.ID {
+			t.Errorf("filtro por tabela deveria trazer so o item, obteve %+v", itens)
+		}
+	})
+}
+
+func TestEmpresa_Isolamento(t *testing.T) {
+	cadaBanco(t, func(t *testing.T, banco repositorio.Banco) {
+		matriz := repositorio.ComEmpresa(context.Background(), "matriz")
+		filial := repositorio.ComEmpresa(context.Background(), "filial")
+
+		daMatriz := dominio.NotaFiscal{Numero: "NF-1", Status: dominio.StatusNotaAberta}
+		if err := banco.Notas().Criar(matriz, &daMatriz); err != nil || daMatriz.EmpresaID != "matriz" {
+			t.Fatalf("nota deveria ser da matriz, obteve %q (%v)", daMatriz.EmpresaID, err)
+		}
+		daFilial := dominio.NotaFiscal{Numero: "NF-1", Status: dominio.StatusNotaAberta}
+		if err := banco.Notas().Criar(filial, &daFilial); err != nil {
+			t.Fatalf("mesmo numero em outra empresa deveria ser aceito: %v", err)
+		}
+		repetida := dominio.NotaFiscal{Numero: "NF-1", Status: dominio.StatusNotaAberta}
+		if err := banco.Notas().Criar(matriz, &repetida); !errors.Is(err, repositorio.ErrDuplicado) {
+			t.Errorf("numero repetido na mesma empresa deveria ser ErrDuplicado, obteve %v", err)
+		}
+
+		item := dominio.ItemNota{NotaID: daMatriz.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 10}
+		if err := banco.Notas().AdicionarItem(matriz, &item); err != nil || item.EmpresaID != "matriz" {
+			t.Errorf("item deveria herdar a empresa, obteve %q (%v)", item.EmpresaID, err)
+		}
+
+		if _, err := banco.Notas().Buscar(filial, daMatriz.ID); !errors.Is(err, repositorio.ErrNaoEncontrado) {
+			t.Errorf("filial nao deveria ver a nota da matriz, obteve %v", err)
+		}
+		notas, _ := banco.Notas().Listar(filial, repositorio.FiltroNotas{})
+		if len(notas) != 1 || notas[0].ID != daFilial.ID {
+			t.Errorf("filial deveria listar so a propria nota, obteve %+v", notas)
+		}
+		if todas, _ := banco.Notas().Listar(context.Background(), repositorio.FiltroNotas{}); len(todas) != 2 {
+			t.Errorf("sem empresa o escopo e o banco inteiro, obteve %d notas", len(todas))
+		}
+
+		compartilhado, exclusivo := uuid.New(), uuid.New()
+		banco.Produtos().Projetar(context.Background(), &dominio.ProdutoCatalogo{ID: compartilhado, Sku: "SKU-C", Nome: "Comum", Ativo: true, DataEvento: time.Now()})
+		banco.Produtos().Projetar(matriz, &dominio.ProdutoCatalogo{ID: exclusivo, Sku: "SKU-M", Nome: "Matriz", Ativo: true, DataEvento: time.Now()})
+		if _, err := banco.Produtos().Buscar(filial, compartilhado); err != nil {
+			t.Errorf("produto sem empresa deveria valer para todas: %v", err)
+		}
+		if _, err := banco.Produtos().Buscar(filial, exclusivo); !errors.Is(err, repositorio.ErrNaoEncontrado) {
+			t.Errorf("filial nao deveria ver produto da matriz, obteve %v", err)
+		}
+	})
+}
+
+// a implementacao Postgres usa tsvector e ts_headline, que o SQLite dos
+// testes nao tem; aqui vale o contrato pela implementacao em memoria
+// notasDePesquisa cria uma nota com "Silva" no destinatario e outra com
+// "Silvertape" num item
+func notasDePesquisa(t *testing.T, banco repositorio.Banco) (joao, outra dominio.NotaFiscal) {
+	t.Helper()
+	ctx := context.Background()
+
+	joao = dominio.NotaFiscal{Numero: "NF-10", Destinatario: "Joao da Silva", Status: dominio.StatusNotaAberta}
+	if err := banco.Notas().Criar(ctx, &joao); err != nil {
+		t.Fatalf("falha ao criar nota: %v", err)
+	}
+	outra = dominio.NotaFiscal{Numero: "NF-11", Destinatario: "Maria Souza", Status: dominio.StatusNotaAberta}
+	if err := banco.Notas().Criar(ctx, &outra); err != nil {
+		t.Fatalf("falha ao criar nota: %v", err)
+	}
+	item := dominio.ItemNota{NotaID: outra.ID, ProdutoID: uuid.New(), Quantidade: 1, PrecoUnitario: 1, Sku: "SKU-SILV", NomeProduto: "Silvertape"}
+	if err := banco.Notas().AdicionarItem(ctx, &item); err != nil {
+		t.Fatalf("falha ao adicionar item: %v", err)
+	}
+	return joao, outra
+}
+
+func TestNotas_Pesquisar(t *testing.T) {
+	banco := repositorio.NovoBancoMemoria()
+	ctx := context.Background()
+
+	joao, outra := notasDePesquisa(t, banco)
+
+	resultados, err := banco.Notas().Pesquisar(ctx, repositorio.FiltroPesquisa{Texto: "silv"})
+	if err != nil || len(resultados) != 2 {
+		t.Fatalf("esperava as duas notas, obteve %+v (%v)", resultados, err)
+	}
+	if resultados[0].Nota.ID != joao.ID || resultados[0].Relevancia <= resultados[1].Relevancia {
+		t.Errorf("destinatario deveria pesar mais que item, obteve %+v", resultados)
+	}
+	if resultados[0].Destaque != "NF-10 Joao da <mark>Silva</mark>" {
+		t.Errorf("destaque inesperado: %q", resultados[0].Destaque)
+	}
+	if len(resultados[1].Nota.Itens) != 1 {
+		t.Errorf("resultado deveria trazer os itens, obteve %+v", resultados[1].Nota)
+	}
+
+	if resultados, _ := banco.Notas().Pesquisar(ctx, repositorio.FiltroPesquisa{Texto: "joa silv"}); len(resultados) != 1 {
+		t.Errorf("todos os termos precisam casar, obteve %d notas", len(resultados))
+	}
+	if resultados, _ := banco.Notas().Pesquisar(ctx, repositorio.FiltroPesquisa{Texto: "nf-11"}); len(resultados) != 1 || resultados[0].Nota.ID != outra.ID {
+		t.Errorf("numero deveria ser encontrado pelos fragmentos, obteve %+v", resultados)
+	}
+	if pagina, _ := banco.Notas().Pesquisar(ctx, repositorio.FiltroPesquisa{Texto: "silv", Limite: 1, Offset: 1}); len(pagina) != 1 || pagina[0].Nota.ID != outra.ID {
+		t.Errorf("paginacao inesperada: %+v", pagina)
+	}
+	if vazio, _ := banco.Notas().Pesquisar(ctx, repositorio.FiltroPesquisa{Texto: "&|!"}); len(vazio) != 0 {
+		t.Errorf("texto sem termos nao deveria trazer nada, obteve %+v", vazio)
+	}
+}
@@ -0,0 +1,206 @@
+package retencao
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"servico-faturamento/internal/config"
+	"servico-faturamento/internal/repositorio"
+)
+
+// Config define por quanto tempo cada tabela guarda registros ja resolvidos
+type Config struct {
+	TTLOutbox    time.Duration // eventos ja publicados
+	TTLMensagens time.Duration // entradas de deduplicacao do consumidor
+	TTLChaves    time.Duration // Idempotency-Key; depois disso a chave pode ser reusada
+	TamanhoLote  int
+	Intervalo    time.Duration
+	Arquivar     bool // copia eventos para eventos_outbox_arquivo antes de apagar
+}
+
+// ConfigDoAmbiente le RETENCAO_* com defaults conservadores. O TTL de
+// mensagens precisa ser maior que o tempo maximo de reentrega do broker,
+// senao uma mensagem duplicada volta a ser processada.
+func ConfigDoAmbiente() Config {
+	return Config{
+		TTLOutbox:    config.DuracaoEnv("RETENCAO_OUTBOX_TTL", 7*24*time.Hour),
+		TTLMensagens: config.DuracaoEnv("RETENCAO_MENSAGENS_TTL", 14*24*time.Hour),
+		TTLChaves:    config.DuracaoEnv("RETENCAO_CHAVES_TTL", 7*24*time.Hour),
+		TamanhoLote:  config.InteiroEnv("RETENCAO_LOTE", 500),
+		Intervalo:    config.DuracaoEnv("RETENCAO_INTERVALO", time.Hour),
+		Arquivar:     os.Getenv("RETENCAO_ARQUIVAR") == "true",
+	}
+}
+
+// Resultado resume o que uma execucao removeu
+type Resultado struct {
+	OutboxRemovidos    int64         `json:"outboxRemovidos"`
+	OutboxArquivados   int64         `json:"outboxArquivados"`
+	MensagensRemovidas int64         `json:"mensagensRemovidas"`
+	ChavesRemovidas    int64         `json:"chavesRemovidas"`
+	Duracao            time.Duration `json:"duracao"`
+}
+
+// Metricas acumula os resultados desde que o processo subiu
+type Metricas struct {
+	Execucoes          int64      `json:"execucoes"`
+	Falhas             int64      `json:"falhas"`
+	OutboxRemovidos    int64      `json:"outboxRemovidos"`
+	OutboxArquivados   int64      `json:"outboxArquivados"`
+	MensagensRemovidas int64      `json:"mensagensRemovidas"`
+	ChavesRemovidas    int64      `json:"chavesRemovidas"`
+	UltimaExecucao     *time.Time `json:"ultimaExecucao,omitempty"`
+	UltimoErro         string     `json:"ultimoErro,omitempty"`
+	UltimoResultado    Resultado  `json:"ultimoResultado"`
+}
+
+type JobRetencao struct {
+	Banco  repositorio.Banco
+	Config Config
+
+	mu       sync.Mutex
+	metricas Metricas
+}
+
+func IniciarRetencao(banco repositorio.Banco, cfg Config) *JobRetencao {
+	job := &JobRetencao{Banco: banco, Config: cfg}
+
+	log.Printf("[retencao] outbox=%s mensagens=%s chaves=%s lote=%d intervalo=%s arquivar=%v",
+		cfg.TTLOutbox, cfg.TTLMensagens, cfg.TTLChaves, cfg.TamanhoLote, cfg.Intervalo, cfg.Arquivar)
+	go job.processar(context.Background())
+	return job
+}
+
+func (j *JobRetencao) processar(ctx context.Context) {
+	for {
+		if _, err := j.Executar(ctx); err != nil {
+			log.Printf("[retencao] erro na execucao: %v", err)
+		}
+		time.Sleep(j.Config.Intervalo)
+	}
+}
+
+// Executar roda um ciclo completo de limpeza, lote a lote
+func (j *JobRetencao) Executar(ctx context.Context) (Resultado, error) {
+	inicio := time.Now()
+	var res Resultado
+
+	removidos, arquivados, err := j.limparOutbox(ctx, inicio.Add(-j.Config.TTLOutbox))
+	res.OutboxRemovidos, res.OutboxArquivados = removidos, arquivados
+	if err == nil {
+		res.MensagensRemovidas, err = j.limparMensagens(ctx, inicio.Add(-j.Config.TTLMensagens))
+	}
+	if err == nil {
+		res.ChavesRemovidas, err = j.limparChaves(ctx, inicio.Add(-j.Config.TTLChaves))
+	}
+	res.Duracao = time.Since(inicio)
+
+	j.registrar(inicio, res, err)
+	if err != nil {
+		return res, err
+	}
+
+	if res.OutboxRemovidos > 0 || res.MensagensRemovidas > 0 || res.ChavesRemovidas > 0 {
+		log.Printf("[retencao] outbox removidos=%d arquivados=%d mensagens removidas=%d chaves removidas=%d em %s",
+			res.OutboxRemovidos, res.OutboxArquivados, res.MensagensRemovidas, res.ChavesRemovidas, res.Duracao)
+	}
+	return res, nil
+}
+
+// Metricas retorna uma copia dos contadores acumulados
+func (j *JobRetencao) Metricas() Metricas {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.metricas
+}
+
+func (j *JobRetencao) registrar(inicio time.Time, res Resultado, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.metricas.Execucoes++
+	j.metricas.OutboxRemovidos += res.OutboxRemovidos
+	j.metricas.OutboxArquivados += res.OutboxArquivados
+	j.metricas.MensagensRemovidas += res.MensagensRemovidas
+	j.metricas.ChavesRemovidas += res.ChavesRemovidas
+	j.metricas.UltimaExecucao = &inicio
+	j.metricas.UltimoResultado = res
+	j.metricas.UltimoErro = ""
+	if err != nil {
+		j.metricas.Falhas++
+		j.metricas.UltimoErro = err.Error()
+	}
+}
+
+func (j *JobRetencao) limparOutbox(ctx context.Context, limite time.Time) (int64, int64, error) {
+	var removidos, arquivados int64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return removidos, arquivados, err
+		}
+
+		// a copia para o arquivo e a remocao do lote vao juntas
+		var lote int64
+		err := j.Banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+			var err error
+			lote, err = r.Outbox().RemoverPublicados(ctx, limite, j.Config.TamanhoLote, j.Config.Arquivar)
+			return err
+		})
+		if err != nil {
+			return removidos, arquivados, fmt.Errorf("falha ao remover eventos publicados: %w", err)
+		}
+
+		removidos += lote
+		if j.Config.Arquivar {
+			arquivados += lote
+		}
+		if lote < int64(j.Config.TamanhoLote) {
+			return removidos, arquivados, nil
+		}
+	}
+}
+
+func (j *JobRetencao) limparMensagens(ctx context.Context, limite time.Time) (int64, error) {
+	return removerEmLotes(ctx, j.Config.TamanhoLote, func() (int64, error) {
+		n, err := j.Banco.Mensagens().RemoverAntigas(ctx, limite, j.Config.TamanhoLote)
+		if err != nil {
+			return n, fmt.Errorf("falha ao remover mensagens processadas: %w", err)
+		}
+		return n, nil
+	})
+}
+
+func (j *JobRetencao) limparChaves(ctx context.Context, limite time.Time) (int64, error) {
+	return removerEmLotes(ctx, j.Config.TamanhoLote, func() (int64, error) {
+		n, err := j.Banco.Idempotencia().RemoverAntigas(ctx, limite, j.Config.TamanhoLote)
+		if err != nil {
+			return n, fmt.Errorf("falha ao remover chaves de idempotencia: %w", err)
+		}
+		return n, nil
+	})
+}
+
+// removerEmLotes chama remover ate um lote vir incompleto
+func removerEmLotes(ctx context.Context, tamanhoLote int, remover func() (int64, error)) (int64, error) {
+	var removidos int64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return removidos, err
+		}
+
+		n, err := remover()
+		removidos += n
+		if err != nil {
+			return removidos, err
+		}
+		if n < int64(tamanhoLote) {
+			return removidos, nil
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package retencao_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+	"servico-faturamento/internal/retencao"
+
+	"github.com/google/uuid"
+)
+
+func TestJobRetencao_Executar(t *testing.T) {
+	db := bdteste.Abrir(t)
+
+	agora := time.Now()
+	antigo := agora.Add(-30 * 24 * time.Hour)
+	recente := agora.Add(-time.Hour)
+
+	// 5 publicados antigos, 1 publicado recente, 1 antigo ainda pendente
+	for i := 0; i < 5; i++ {
+		db.Create(&dominio.EventoOutbox{TipoEvento: "T", IdAgregado: uuid.New(), Payload: "{}", DataOcorrencia: antigo, DataPublicacao: &antigo})
+	}
+	db.Create(&dominio.EventoOutbox{TipoEvento: "T", IdAgregado: uuid.New(), Payload: "{}", DataOcorrencia: recente, DataPublicacao: &recente})
+	db.Create(&dominio.EventoOutbox{TipoEvento: "T", IdAgregado: uuid.New(), Payload: "{}", DataOcorrencia: antigo})
+
+	for i := 0; i < 3; i++ {
+		db.Create(&dominio.MensagemProcessada{IDMensagem: fmt.Sprintf("antiga-%d", i), DataProcessada: antigo})
+	}
+	db.Create(&dominio.MensagemProcessada{IDMensagem: "recente", DataProcessada: recente})
+
+	for i, quando := range []time.Time{antigo, antigo, recente} {
+		db.Create(&dominio.ChaveIdempotencia{EmpresaID: "padrao", Chave: fmt.Sprintf("chave-%d", i), NotaID: uuid.New(), Operacao: dominio.OperacaoImprimir, SolicitacaoID: uuid.New(), DataCriacao: quando})
+	}
+
+	job := &retencao.JobRetencao{Banco: repositorio.NovoBancoPostgres(db), Config: retencao.Config{
+		TTLOutbox:    7 * 24 * time.Hour,
+		TTLMensagens: 7 * 24 * time.Hour,
+		TTLChaves:    7 * 24 * time.Hour,
+		TamanhoLote:  2,
+		Arquivar:     true,
+	}}
+
+	res, err := job.Executar(context.Background())
+	if err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	if res.OutboxRemovidos != 5 || res.OutboxArquivados != 5 {
+		t.Errorf("esperava 5 eventos removidos e arquivados, obteve %d e %d", res.OutboxRemovidos, res.OutboxArquivados)
+	}
+	if res.MensagensRemovidas != 3 {
+		t.Errorf("esperava 3 mensagens removidas, obteve %d", res.MensagensRemovidas)
+	}
+	if res.ChavesRemovidas != 2 {
+		t.Errorf("esperava 2 chaves de idempotencia removidas, obteve %d", res.ChavesRemovidas)
+	}
+
+	var restantesOutbox, arquivo, restantesMsg, restantesChaves int64
+	db.Model(&dominio.EventoOutbox{}).Count(&restantesOutbox)
+	db.Model(&dominio.EventoOutboxArquivado{}).Count(&arquivo)
+	db.Model(&dominio.MensagemProcessada{}).Count(&restantesMsg)
+	db.Model(&dominio.ChaveIdempotencia{}).Count(&restantesChaves)
+
+	if restantesOutbox != 2 {
+		t.Errorf("esperava manter evento recente e pendente, restaram %d", restantesOutbox)
+	}
+	if arquivo != 5 {
+		t.Errorf("esperava 5 eventos no arquivo, obteve %d", arquivo)
+	}
+	if restantesMsg != 1 {
+		t.Errorf("esperava manter 1 mensagem recente, restaram %d", restantesMsg)
+	}
+	if restantesChaves != 1 {
+		t.Errorf("esperava manter 1 chave recente, restaram %d", restantesChaves)
+	}
+
+	m := job.Metricas()
+	if m.Execucoes != 1 || m.OutboxRemovidos != 5 || m.MensagensRemovidas != 3 {
+		t.Errorf("metricas inesperadas: %+v", m)
+	}
+}
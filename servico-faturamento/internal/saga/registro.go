@@ -0,0 +1,98 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/metricas"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+)
+
+// Iniciar cria a instancia da saga para a solicitacao recem criada, com o
+// primeiro evento enviado ao Estoque
+func Iniciar(ctx context.Context, r repositorio.Repositorios, sol *dominio.SolicitacaoImpressao, enviado *dominio.EventoOutbox) error {
+	instancia := dominio.SagaFaturamento{
+		SolicitacaoID: sol.ID,
+		NotaID:        sol.NotaID,
+		IDCorrelacao:  sol.IDCorrelacao,
+		Estado:        dominio.EstadoSagaAguardandoEstoque,
+		PassoAtual:    enviado.TipoEvento,
+	}
+	if err := r.Sagas().Criar(ctx, &instancia); err != nil {
+		return fmt.Errorf("falha ao criar saga: %w", err)
+	}
+
+	passo := dominio.PassoEnviado(enviado, "")
+	passo.SagaID = instancia.ID
+	passo.EstadoResultante = instancia.Estado
+	passo.Data = instancia.DataInicio
+	if err := r.Sagas().AdicionarPasso(ctx, &passo); err != nil {
+		return fmt.Errorf("falha ao registrar passo da saga: %w", err)
+	}
+	return nil
+}
+
+// RegistrarPasso anexa um passo a saga da solicitacao e, se informado, muda o
+// estado atual. Solicitacoes anteriores ao log ganham a instancia na hora.
+func RegistrarPasso(ctx context.Context, r repositorio.Repositorios, solicitacaoID uuid.UUID, passo dominio.PassoSaga, novoEstado string) error {
+	instancia, err := carregarOuCriar(ctx, r, solicitacaoID)
+	if err != nil {
+		return err
+	}
+
+	agora := time.Now()
+	if novoEstado != "" {
+		instancia.Estado = novoEstado
+	}
+	instancia.PassoAtual = passo.Nome
+	instancia.DataAtualizacao = agora
+	if instancia.Terminal() && instancia.DataFim == nil {
+		instancia.DataFim = &agora
+		estado := instancia.Estado
+		r.AoConfirmar(func() { metricas.SagaFinalizada(estado) })
+	}
+
+	if err := r.Sagas().Atualizar(ctx, instancia); err != nil {
+		return fmt.Errorf("falha ao atualizar saga: %w", err)
+	}
+
+	passo.SagaID = instancia.ID
+	passo.EstadoResultante = instancia.Estado
+	passo.Data = agora
+	if err := r.Sagas().AdicionarPasso(ctx, &passo); err != nil {
+		return fmt.Errorf("falha ao registrar passo da saga: %w", err)
+	}
+	return nil
+}
+
+func carregarOuCriar(ctx context.Context, r repositorio.Repositorios, solicitacaoID uuid.UUID) (*dominio.SagaFaturamento, error) {
+	instancia, err := r.Sagas().BuscarPorSolicitacao(ctx, solicitacaoID)
+	if err == nil {
+		return &instancia, nil
+	}
+	if !errors.Is(err, repositorio.ErrNaoEncontrado) {
+		return nil, fmt.Errorf("falha ao buscar saga: %w", err)
+	}
+
+	sol, err := r.Solicitacoes().Buscar(ctx, solicitacaoID)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao buscar solicitacao da saga: %w", err)
+	}
+
+	instancia = dominio.SagaFaturamento{
+		SolicitacaoID: sol.ID,
+		NotaID:        sol.NotaID,
+		IDCorrelacao:  sol.IDCorrelacao,
+		Estado:        dominio.EstadoSagaAguardandoEstoque,
+		DataInicio:    sol.DataCriacao,
+	}
+	if err := r.Sagas().Criar(ctx, &instancia); err != nil {
+		return nil, fmt.Errorf("falha ao criar saga: %w", err)
+	}
+	return &instancia, nil
+}
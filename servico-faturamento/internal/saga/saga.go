@@ -0,0 +1,131 @@
+// Package saga concentra as transicoes da saga de impressao que precisam
+// acontecer junto com a escrita do evento no outbox.
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+
+	"github.com/google/uuid"
+)
+
+// FalharSolicitacao move a solicitacao para FALHOU e grava
+// Faturamento.ImpressaoFalhou na transacao recebida
+func FalharSolicitacao(ctx context.Context, r repositorio.Repositorios, sol *dominio.SolicitacaoImpressao, motivo string, ctxEvento dominio.ContextoEvento) error {
+	return falhar(ctx, r, sol, motivo, nil, ctxEvento)
+}
+
+// FalharPorDivergencia falha a solicitacao com o relatorio da conciliacao
+// entre a reserva e os itens da nota
+func FalharPorDivergencia(ctx context.Context, r repositorio.Repositorios, sol *dominio.SolicitacaoImpressao, divergencias []dominio.DivergenciaReserva, ctxEvento dominio.ContextoEvento) error {
+	return falhar(ctx, r, sol, dominio.RelatorioDivergencias(divergencias), divergencias, ctxEvento)
+}
+
+func falhar(ctx context.Context, r repositorio.Repositorios, sol *dominio.SolicitacaoImpressao, motivo string, divergencias []dominio.DivergenciaReserva, ctxEvento dominio.ContextoEvento) error {
+	if err := r.Solicitacoes().Falhar(ctx, sol.ID, motivo); err != nil {
+		return fmt.Errorf("falha ao atualizar solicitacao: %w", err)
+	}
+	sol.Status = dominio.StatusSolicitacaoFalhou
+	sol.MensagemErro = &motivo
+
+	evento, err := dominio.NovoEventoOutbox(dominio.EventoImpressaoFalhou, sol.NotaID, dominio.PayloadImpressaoFalhou{
+		SolicitacaoID: sol.ID.String(),
+		NotaID:        sol.NotaID.String(),
+		Motivo:        motivo,
+		DataFalha:     time.Now(),
+		Divergencias:  divergencias,
+	}, ctxEvento)
+	if err != nil {
+		return err
+	}
+	if err := r.Outbox().Adicionar(ctx, &evento); err != nil {
+		return fmt.Errorf("falha ao criar evento outbox: %w", err)
+	}
+
+	return RegistrarPasso(ctx, r, sol.ID, dominio.PassoEnviado(&evento, motivo), dominio.EstadoSagaFalhou)
+}
+
+// FalharPendentes aplica FalharSolicitacao a todas as solicitacoes pendentes da nota
+func FalharPendentes(ctx context.Context, r repositorio.Repositorios, notaID uuid.UUID, motivo string, ctxEvento dominio.ContextoEvento) error {
+	pendentes, err := r.Solicitacoes().PendentesParaAtualizar(ctx, notaID)
+	if err != nil {
+		return fmt.Errorf("falha ao buscar solicitacoes: %w", err)
+	}
+
+	for i := range pendentes {
+		if err := FalharSolicitacao(ctx, r, &pendentes[i], motivo, ctxEvento); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FecharNota fecha a nota, conclui as solicitacoes pendentes e grava
+// Faturamento.NotaFechada, encerrando a saga de sol como CONCLUIDA
+func FecharNota(ctx context.Context, r repositorio.Repositorios, nota *dominio.NotaFiscal, sol *dominio.SolicitacaoImpressao, ctxEvento dominio.ContextoEvento) error {
+	if err := nota.Fechar(); err != nil {
+		return fmt.Errorf("falha ao fechar nota: %w", err)
+	}
+	if err := r.Notas().Atualizar(ctx, nota); err != nil {
+		return fmt.Errorf("falha ao salvar nota: %w", err)
+	}
+	if err := r.Solicitacoes().ConcluirPendentes(ctx, nota.ID, time.Now()); err != nil {
+		return fmt.Errorf("falha ao atualizar solicitacao: %w", err)
+	}
+
+	// evento de dominio na mesma transacao do fechamento
+	evento, err := dominio.NovoEventoOutbox(
+		dominio.EventoNotaFechada,
+		nota.ID,
+		dominio.NovoPayloadNotaFechada(nota, sol.ID),
+		ctxEvento,
+	)
+	if err != nil {
+		return err
+	}
+	if err := r.Outbox().Adicionar(ctx, &evento); err != nil {
+		return fmt.Errorf("falha ao criar evento outbox: %w", err)
+	}
+	return RegistrarPasso(ctx, r, sol.ID, dominio.PassoEnviado(&evento, ""), dominio.EstadoSagaConcluida)
+}
+
+// LiberarReserva grava o evento de compensacao Faturamento.LiberarReserva
+// para o Estoque devolver os itens reservados
+func LiberarReserva(ctx context.Context, r repositorio.Repositorios, notaID, solicitacaoID uuid.UUID, motivo string, itens []dominio.ItemReserva, ctxEvento dominio.ContextoEvento) error {
+	payload := dominio.PayloadLiberarReserva{
+		NotaID: notaID.String(),
+		Motivo: motivo,
+		Itens:  itens,
+	}
+	if solicitacaoID != uuid.Nil {
+		payload.SolicitacaoID = solicitacaoID.String()
+	}
+	if payload.Itens == nil {
+		payload.Itens = []dominio.ItemReserva{}
+	}
+
+	evento, err := dominio.NovoEventoOutbox(dominio.EventoLiberarReserva, notaID, payload, ctxEvento)
+	if err != nil {
+		return err
+	}
+	if err := r.Outbox().Adicionar(ctx, &evento); err != nil {
+		return fmt.Errorf("falha ao criar evento de compensacao: %w", err)
+	}
+
+	if solicitacaoID == uuid.Nil {
+		return nil
+	}
+	// a solicitacao pode nao existir aqui (nota apagada): o Estoque ainda
+	// precisa do id para achar a reserva, mas nao ha saga para o passo
+	if _, err := r.Solicitacoes().Buscar(ctx, solicitacaoID); errors.Is(err, repositorio.ErrNaoEncontrado) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("falha ao buscar solicitacao: %w", err)
+	}
+	return RegistrarPasso(ctx, r, solicitacaoID, dominio.PassoEnviado(&evento, motivo), "")
+}
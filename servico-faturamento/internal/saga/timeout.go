@@ -0,0 +1,82 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+)
+
+// VarredorTimeout expira solicitacoes PENDENTE cujo prazo passou, para o
+// usuario nao esperar para sempre quando o Estoque cai ou perde a mensagem
+type VarredorTimeout struct {
+	Banco       repositorio.Banco
+	Intervalo   time.Duration
+	TamanhoLote int
+}
+
+func IniciarVarredor(banco repositorio.Banco, intervalo time.Duration) *VarredorTimeout {
+	v := &VarredorTimeout{Banco: banco, Intervalo: intervalo, TamanhoLote: 50}
+
+	log.Printf("[saga] varredor de timeout iniciado, intervalo=%s", intervalo)
+	go v.processar(context.Background())
+	return v
+}
+
+func (v *VarredorTimeout) processar(ctx context.Context) {
+	for {
+		if _, err := v.Expirar(ctx, time.Now()); err != nil {
+			log.Printf("[saga] erro ao expirar solicitacoes: %v", err)
+		}
+		time.Sleep(v.Intervalo)
+	}
+}
+
+// Expirar marca como FALHOU as solicitacoes vencidas ate "agora" e grava a
+// compensacao. Retorna quantas foram expiradas.
+func (v *VarredorTimeout) Expirar(ctx context.Context, agora time.Time) (int, error) {
+	expiradas := 0
+
+	err := v.Banco.Transacao(ctx, func(r repositorio.Repositorios) error {
+		// SKIP LOCKED: outra replica ou o consumidor pode estar resolvendo a mesma linha
+		vencidas, err := r.Solicitacoes().VencidasParaAtualizar(ctx, agora, v.TamanhoLote)
+		if err != nil {
+			return fmt.Errorf("falha ao buscar solicitacoes vencidas: %w", err)
+		}
+
+		for i := range vencidas {
+			sol := &vencidas[i]
+			ctxEvento := dominio.NovoContextoEvento(sol.IDCorrelacao)
+			// a varredura cobre todas as empresas; cada expiracao escreve na da solicitacao
+			ctx := repositorio.ComEmpresa(ctx, sol.EmpresaID)
+			ctx = repositorio.ComAutoria(ctx, dominio.Autoria{Ator: "varredor-timeout", IDRequisicao: sol.IDCorrelacao})
+
+			if err := FalharSolicitacao(ctx, r, sol, dominio.MotivoTimeoutImpressao, ctxEvento); err != nil {
+				return err
+			}
+
+			// o Estoque pode ter reservado e a resposta se perdido: pede a
+			// devolucao do que foi pedido a ele, os itens congelados na
+			// solicitacao; as anteriores ao congelamento usam os da nota
+			itens := sol.ItensNota()
+			if len(itens) == 0 {
+				if itens, err = r.Notas().Itens(ctx, sol.NotaID); err != nil {
+					return fmt.Errorf("falha ao buscar itens: %w", err)
+				}
+			}
+			if err := LiberarReserva(ctx, r, sol.NotaID, sol.ID, dominio.MotivoTimeoutImpressao, dominio.ItensReserva(itens), ctxEvento); err != nil {
+				return err
+			}
+
+			log.Printf("[saga] solicitacao %s da nota %s expirada (correlacao %s)", sol.ID, sol.NotaID, sol.IDCorrelacao)
+		}
+
+		expiradas = len(vencidas)
+		return nil
+	})
+
+	return expiradas, err
+}
@@ -0,0 +1,92 @@
+package saga_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/repositorio"
+	"servico-faturamento/internal/saga"
+
+	"github.com/google/uuid"
+)
+
+func TestVarredorTimeout_Expirar(t *testing.T) {
+	db := bdteste.Abrir(t)
+
+	nota := dominio.NotaFiscal{Numero: "NF-TIMEOUT"}
+	db.Create(&nota)
+	db.Create(&dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 2, PrecoUnitario: 10})
+
+	agora := time.Now()
+	vencido := agora.Add(-time.Minute)
+	noPrazo := agora.Add(time.Minute)
+
+	expirada := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k1", IDCorrelacao: "corr-1", PrazoExpiracao: &vencido}
+	valida := dominio.SolicitacaoImpressao{NotaID: nota.ID, ChaveIdempotencia: "k2", PrazoExpiracao: &noPrazo}
+	db.Create(&expirada)
+	db.Create(&valida)
+
+	v := &saga.VarredorTimeout{Banco: repositorio.NovoBancoPostgres(db), TamanhoLote: 10}
+	total, err := v.Expirar(context.Background(), agora)
+	if err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+	if total != 1 {
+		t.Fatalf("esperava 1 solicitacao expirada, obteve %d", total)
+	}
+
+	db.First(&expirada, "id = ?", expirada.ID)
+	if expirada.Status != dominio.StatusSolicitacaoFalhou || expirada.MensagemErro == nil || *expirada.MensagemErro != dominio.MotivoTimeoutImpressao {
+		t.Errorf("esperava FALHOU por timeout, obteve %s %v", expirada.Status, expirada.MensagemErro)
+	}
+	db.First(&valida, "id = ?", valida.ID)
+	if valida.Status != dominio.StatusSolicitacaoPendente {
+		t.Errorf("solicitacao dentro do prazo nao deveria mudar, obteve %s", valida.Status)
+	}
+
+	var eventos []dominio.EventoOutbox
+	db.Order("id").Find(&eventos)
+	if len(eventos) != 2 || eventos[0].TipoEvento != dominio.EventoImpressaoFalhou || eventos[1].TipoEvento != dominio.EventoLiberarReserva {
+		t.Fatalf("esperava ImpressaoFalhou e LiberarReserva no outbox, obteve %+v", eventos)
+	}
+	if eventos[1].IDCorrelacao != "corr-1" {
+		t.Errorf("esperava compensacao na correlacao da saga, obteve %s", eventos[1].IDCorrelacao)
+	}
+}
+
+func TestVarredorTimeout_LiberaItensCongelados(t *testing.T) {
+	db := bdteste.Abrir(t)
+
+	nota := dominio.NotaFiscal{Numero: "NF-TIMEOUT-CONGELADA"}
+	db.Create(&nota)
+	item := dominio.ItemNota{NotaID: nota.ID, ProdutoID: uuid.New(), Quantidade: 2, PrecoUnitario: 10}
+	db.Create(&item)
+
+	// o pedido foi de 2; depois a nota mudou para 7
+	vencido := time.Now().Add(-time.Minute)
+	sol := dominio.SolicitacaoImpressao{
+		NotaID: nota.ID, ChaveIdempotencia: "k-congelada", PrazoExpiracao: &vencido,
+		Itens: dominio.CongelarItens([]dominio.ItemNota{item}),
+	}
+	db.Create(&sol)
+	db.Model(&item).Update("quantidade", 7)
+
+	v := &saga.VarredorTimeout{Banco: repositorio.NovoBancoPostgres(db), TamanhoLote: 10}
+	if _, err := v.Expirar(context.Background(), time.Now()); err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+
+	var liberacao dominio.EventoOutbox
+	if err := db.Where("tipo_evento = ?", dominio.EventoLiberarReserva).First(&liberacao).Error; err != nil {
+		t.Fatalf("esperava compensacao no outbox: %v", err)
+	}
+	var payload dominio.PayloadLiberarReserva
+	json.Unmarshal([]byte(liberacao.Payload), &payload)
+	if payload.SolicitacaoID != sol.ID.String() || len(payload.Itens) != 1 || payload.Itens[0].Quantidade != 2 {
+		t.Errorf("esperava liberar os itens congelados da solicitacao, obteve %+v", payload)
+	}
+}
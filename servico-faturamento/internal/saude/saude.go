@@ -0,0 +1,183 @@
+package saude
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"servico-faturamento/internal/config"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/repositorio"
+)
+
+// Status de cada verificacao e do relatorio. Degradado funciona mas merece
+// atencao e nao tira o pod do balanceamento; so Falha tira.
+const (
+	StatusOk        = "ok"
+	StatusDegradado = "degradado"
+	StatusFalha     = "falha"
+)
+
+// Limites a partir dos quais o readiness falha; zero desliga o limite
+type Limites struct {
+	IdadeMaximaBacklog time.Duration // evento pendente mais antigo do outbox
+	PendentesMaximo    int           // eventos pendentes no outbox
+	Timeout            time.Duration // tempo total das verificacoes
+}
+
+// LimitesDoAmbiente le SAUDE_*. O backlog padrao tolera alguns minutos de
+// backoff do publicador antes de acusar falha.
+func LimitesDoAmbiente() Limites {
+	return Limites{
+		IdadeMaximaBacklog: config.DuracaoEnv("SAUDE_OUTBOX_IDADE_MAXIMA", 5*time.Minute),
+		PendentesMaximo:    config.InteiroEnv("SAUDE_OUTBOX_PENDENTES_MAXIMO", 0),
+		Timeout:            config.DuracaoEnv("SAUDE_TIMEOUT", 2*time.Second),
+	}
+}
+
+// Verificacao e o resultado de um componente
+type Verificacao struct {
+	Status   string                 `json:"status"`
+	Erro     string                 `json:"erro,omitempty"`
+	Detalhes map[string]interface{} `json:"detalhes,omitempty"`
+}
+
+// Relatorio e a resposta do readiness: o pior status entre as verificacoes
+type Relatorio struct {
+	Status       string                 `json:"status"`
+	Verificacoes map[string]Verificacao `json:"verificacoes"`
+}
+
+// Pronto diz se o pod deve receber trafego
+func (r Relatorio) Pronto() bool {
+	return r.Status != StatusFalha
+}
+
+// Verificador checa banco, broker e outbox. Broker nil pula as verificacoes
+// de publicacao e consumo.
+type Verificador struct {
+	DB      *sql.DB
+	Banco   repositorio.Banco
+	Broker  mensageria.Monitoravel
+	Filas   []string // filas que precisam ter consumidor ativo
+	Limites Limites
+}
+
+func (v *Verificador) Verificar(ctx context.Context) Relatorio {
+	if v.Limites.Timeout > 0 {
+		var cancelar context.CancelFunc
+		ctx, cancelar = context.WithTimeout(ctx, v.Limites.Timeout)
+		defer cancelar()
+	}
+
+	relatorio := Relatorio{Status: StatusOk, Verificacoes: map[string]Verificacao{}}
+	registrar := func(nome string, verificacao Verificacao) {
+		relatorio.Verificacoes[nome] = verificacao
+		if gravidade(verificacao.Status) > gravidade(relatorio.Status) {
+			relatorio.Status = verificacao.Status
+		}
+	}
+
+	registrar("banco", v.verificarBanco(ctx))
+	registrar("outbox", v.verificarOutbox(ctx))
+	if v.Broker != nil {
+		estado := v.Broker.Estado()
+		registrar("publicacao", verificarPublicacao(estado))
+		registrar("consumo", v.verificarConsumo(estado))
+	}
+	return relatorio
+}
+
+func gravidade(status string) int {
+	switch status {
+	case StatusFalha:
+		return 2
+	case StatusDegradado:
+		return 1
+	}
+	return 0
+}
+
+func (v *Verificador) verificarBanco(ctx context.Context) Verificacao {
+	stats := v.DB.Stats()
+	verificacao := Verificacao{Status: StatusOk, Detalhes: map[string]interface{}{
+		"conexoesAbertas": stats.OpenConnections,
+		"emUso":           stats.InUse,
+		"ociosas":         stats.Idle,
+		"maximoAbertas":   stats.MaxOpenConnections,
+		"esperas":         stats.WaitCount,
+		"tempoEspera":     stats.WaitDuration.String(),
+	}}
+
+	inicio := time.Now()
+	err := v.DB.PingContext(ctx)
+	verificacao.Detalhes["ping"] = time.Since(inicio).String()
+	switch {
+	case err != nil:
+		verificacao.Status = StatusFalha
+		verificacao.Erro = err.Error()
+	case stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections:
+		verificacao.Status = StatusDegradado
+		verificacao.Erro = "pool de conexoes esgotado"
+	}
+	return verificacao
+}
+
+func (v *Verificador) verificarOutbox(ctx context.Context) Verificacao {
+	// sem empresa no contexto: o backlog e o do banco inteiro
+	backlog, err := v.Banco.Outbox().Backlog(ctx)
+	if err != nil {
+		return Verificacao{Status: StatusFalha, Erro: err.Error()}
+	}
+
+	verificacao := Verificacao{Status: StatusOk, Detalhes: map[string]interface{}{
+		"pendentes": backlog.Pendentes,
+	}}
+	if backlog.MaisAntigo == nil {
+		return verificacao
+	}
+
+	idade := time.Since(*backlog.MaisAntigo)
+	verificacao.Detalhes["idadeMaisAntigo"] = idade.Round(time.Second).String()
+	switch {
+	case v.Limites.IdadeMaximaBacklog > 0 && idade > v.Limites.IdadeMaximaBacklog:
+		verificacao.Status = StatusFalha
+		verificacao.Erro = fmt.Sprintf("evento pendente ha mais de %s", v.Limites.IdadeMaximaBacklog)
+	case v.Limites.PendentesMaximo > 0 && backlog.Pendentes > int64(v.Limites.PendentesMaximo):
+		verificacao.Status = StatusFalha
+		verificacao.Erro = fmt.Sprintf("mais de %d eventos pendentes", v.Limites.PendentesMaximo)
+	}
+	return verificacao
+}
+
+func verificarPublicacao(estado mensageria.EstadoBroker) Verificacao {
+	verificacao := Verificacao{Status: StatusOk, Detalhes: map[string]interface{}{
+		"conexao": estado.Conexao,
+		"channel": estado.Publicacao,
+	}}
+	switch {
+	case !estado.Conexao:
+		verificacao.Status = StatusFalha
+		verificacao.Erro = "conexao com o broker fechada"
+	case !estado.Publicacao:
+		// o publicador reabre o channel na proxima publicacao
+		verificacao.Status = StatusDegradado
+		verificacao.Erro = "channel de publicacao fechado"
+	}
+	return verificacao
+}
+
+func (v *Verificador) verificarConsumo(estado mensageria.EstadoBroker) Verificacao {
+	filas := map[string]interface{}{}
+	verificacao := Verificacao{Status: StatusOk, Detalhes: filas}
+	for _, fila := range v.Filas {
+		aberto := estado.Assinaturas[fila]
+		filas[fila] = aberto
+		if !aberto && verificacao.Status == StatusOk {
+			verificacao.Status = StatusFalha
+			verificacao.Erro = "sem consumidor ativo na fila " + fila
+		}
+	}
+	return verificacao
+}
@@ -0,0 +1,98 @@
+package saude_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"servico-faturamento/internal/bdteste"
+	"servico-faturamento/internal/dominio"
+	"servico-faturamento/internal/mensageria"
+	"servico-faturamento/internal/repositorio"
+	"servico-faturamento/internal/saude"
+
+	"github.com/google/uuid"
+)
+
+type brokerFixo mensageria.EstadoBroker
+
+func (b brokerFixo) Estado() mensageria.EstadoBroker {
+	return mensageria.EstadoBroker(b)
+}
+
+func novoVerificador(t *testing.T, broker brokerFixo) (*saude.Verificador, repositorio.Banco) {
+	t.Helper()
+
+	db := bdteste.Abrir(t)
+	sqlDB, _ := db.DB()
+	banco := repositorio.NovoBancoPostgres(db)
+	return &saude.Verificador{
+		DB:      sqlDB,
+		Banco:   banco,
+		Broker:  broker,
+		Filas:   []string{mensageria.FilaFaturamento},
+		Limites: saude.Limites{IdadeMaximaBacklog: time.Minute, Timeout: time.Second},
+	}, banco
+}
+
+func brokerSaudavel() brokerFixo {
+	return brokerFixo{Conexao: true, Publicacao: true, Assinaturas: map[string]bool{mensageria.FilaFaturamento: true}}
+}
+
+func TestVerificar(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("tudo ok", func(t *testing.T) {
+		v, banco := novoVerificador(t, brokerSaudavel())
+		banco.Outbox().Adicionar(ctx, &dominio.EventoOutbox{TipoEvento: "X", IdAgregado: uuid.New(), Payload: "{}"})
+
+		relatorio := v.Verificar(ctx)
+		if relatorio.Status != saude.StatusOk || !relatorio.Pronto() {
+			t.Fatalf("esperava ok, obteve %+v", relatorio)
+		}
+		if len(relatorio.Verificacoes) != 4 || relatorio.Verificacoes["outbox"].Detalhes["pendentes"] != int64(1) {
+			t.Errorf("esperava 4 verificacoes com 1 evento pendente, obteve %+v", relatorio.Verificacoes)
+		}
+	})
+
+	t.Run("backlog antigo", func(t *testing.T) {
+		v, banco := novoVerificador(t, brokerSaudavel())
+		banco.Outbox().Adicionar(ctx, &dominio.EventoOutbox{TipoEvento: "X", IdAgregado: uuid.New(), Payload: "{}", DataOcorrencia: time.Now().Add(-time.Hour)})
+
+		relatorio := v.Verificar(ctx)
+		if relatorio.Pronto() || relatorio.Verificacoes["outbox"].Status != saude.StatusFalha {
+			t.Errorf("backlog acima do limite deveria falhar, obteve %+v", relatorio)
+		}
+	})
+
+	t.Run("channel de publicacao fechado", func(t *testing.T) {
+		broker := brokerSaudavel()
+		broker.Publicacao = false
+		v, _ := novoVerificador(t, broker)
+
+		relatorio := v.Verificar(ctx)
+		if relatorio.Status != saude.StatusDegradado || !relatorio.Pronto() {
+			t.Errorf("channel reaberto sob demanda deveria so degradar, obteve %+v", relatorio)
+		}
+	})
+
+	t.Run("consumidor parado", func(t *testing.T) {
+		broker := brokerSaudavel()
+		broker.Assinaturas = map[string]bool{mensageria.FilaFaturamento: false}
+		v, _ := novoVerificador(t, broker)
+
+		if relatorio := v.Verificar(ctx); relatorio.Pronto() || relatorio.Verificacoes["consumo"].Status != saude.StatusFalha {
+			t.Errorf("fila sem consumidor deveria falhar, obteve %+v", relatorio)
+		}
+	})
+
+	t.Run("banco fora do ar", func(t *testing.T) {
+		v, _ := novoVerificador(t, brokerSaudavel())
+		v.DB.Close()
+
+		relatorio := v.Verificar(ctx)
+		if relatorio.Pronto() || relatorio.Verificacoes["banco"].Erro == "" {
+			t.Errorf("banco fechado deveria falhar com o erro, obteve %+v", relatorio)
+		}
+	})
+}